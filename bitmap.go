@@ -0,0 +1,256 @@
+package ext4
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+// Bitmap is the block or inode allocation-bitmap of one block-group. Bit (n)
+// is set if the group's (n)th cluster (block, without bigalloc) or inode is in
+// use.
+type Bitmap struct {
+	data   []byte
+	length uint64
+}
+
+func newBitmap(length uint64) *Bitmap {
+	return &Bitmap{
+		data:   make([]byte, (length+7)/8),
+		length: length,
+	}
+}
+
+func (bm *Bitmap) String() string {
+	return fmt.Sprintf("Bitmap<LENGTH=(%d) SET=(%d)>", bm.length, bm.CountSet())
+}
+
+// Len returns the number of clusters or inodes that the bitmap covers.
+func (bm *Bitmap) Len() uint64 {
+	return bm.length
+}
+
+// IsSet returns whether bit (n) is set. Anything past the end is reported as
+// in use.
+func (bm *Bitmap) IsSet(n uint64) bool {
+	if n >= bm.length {
+		return true
+	}
+
+	return bm.data[n/8]&(1<<(n%8)) != 0
+}
+
+func (bm *Bitmap) set(n uint64) {
+	bm.data[n/8] |= 1 << (n % 8)
+}
+
+// CountSet returns the number of bits that are set.
+func (bm *Bitmap) CountSet() (count uint64) {
+	for i, b := range bm.data {
+		// The last byte can have bits past the end.
+		if uint64(i) == bm.length/8 {
+			b &= byte(1<<(bm.length%8)) - 1
+		}
+
+		count += uint64(bits.OnesCount8(b))
+	}
+
+	return count
+}
+
+// CountClear returns the number of bits that are clear (free).
+func (bm *Bitmap) CountClear() uint64 {
+	return bm.length - bm.CountSet()
+}
+
+// readBitmap reads the first `length` bits of the bitmap in the given block.
+func readBitmap(sb *Superblock, structure string, block uint64, length uint64) (bm *Bitmap, err error) {
+	blockSize := uint64(sb.BlockSize())
+
+	if (length+7)/8 > blockSize {
+		return nil, newErrCorrupt(structure, block, "(%d) bits don't fit in one block", length)
+	} else if block == 0 || block >= sb.BlockCount() {
+		return nil, newErrCorrupt(structure, 0, "block (%d) is outside of the filesystem", block)
+	}
+
+	data, err := sb.ReadPhysicalBlock(block, blockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	bm = newBitmap(length)
+	copy(bm.data, data)
+
+	return bm, nil
+}
+
+// BlockBitmap returns the block-bitmap of the given block-group. Bit (n) is
+// the cluster that starts at block `GroupFirstBlock(group) + n *
+// ClusterRatio()`; without bigalloc, that's just the block. If the group's
+// bitmap was never initialized (`BgdFlagBitmapNotInitialized`), it's built the
+// way the kernel would: only the clusters with the group's own metadata are in
+// use.
+func (bgdl *BlockGroupDescriptorList) BlockBitmap(group int) (bm *Bitmap, err error) {
+	bgd, err := bgdl.Get(group)
+	if err != nil {
+		return nil, err
+	}
+
+	first := bgdl.sb.GroupFirstBlock(uint64(group))
+	blocks := bgdl.sb.GroupBlockCount(uint64(group))
+	ratio := bgdl.sb.ClusterRatio()
+
+	length := bgdl.sb.GroupClusterCount(uint64(group))
+
+	if bgd.IsBitmapNotInitialized() == false {
+		return readBitmap(bgdl.sb, "block bitmap", bgd.BlockBitmapBlock(), length)
+	}
+
+	bm = newBitmap(length)
+
+	// With flex_bg, the bitmaps and inode-table are usually in another group
+	// (which then can't be uninitialized itself).
+	for _, br := range groupMetadata(bgdl.sb, bgd, uint64(group)) {
+		for block := br.start; block < br.start+br.length; block++ {
+			if block >= first && block < first+blocks {
+				bm.set((block - first) / ratio)
+			}
+		}
+	}
+
+	return bm, nil
+}
+
+// InodeBitmap returns the inode-bitmap of the given block-group. Bit (n) is
+// the group's (n)th inode. If the group's inode-table and bitmap were never
+// initialized (`BgdFlagInodeTableAndBitmapNotInitialized`), no inodes are in
+// use.
+func (bgdl *BlockGroupDescriptorList) InodeBitmap(group int) (bm *Bitmap, err error) {
+	bgd, err := bgdl.Get(group)
+	if err != nil {
+		return nil, err
+	}
+
+	return bgd.inodeBitmap()
+}
+
+func (bgd *BlockGroupDescriptor) inodeBitmap() (bm *Bitmap, err error) {
+	length := uint64(bgd.sb.Data().SInodesPerGroup)
+
+	if bgd.IsInodeTableAndBitmapNotInitialized() == true {
+		return newBitmap(length), nil
+	}
+
+	return readBitmap(bgd.sb, "inode bitmap", bgd.InodeBitmapBlock(), length)
+}
+
+// FreeExtent is a run of free blocks.
+type FreeExtent struct {
+	Start  uint64
+	Length uint64
+}
+
+func (fe FreeExtent) String() string {
+	return fmt.Sprintf("FreeExtent<START=(%d) LENGTH=(%d)>", fe.Start, fe.Length)
+}
+
+// FreeSpaceMap has every run of free blocks in the filesystem, according to
+// the block-bitmaps as of when it was built. Runs that cross from one group
+// into the next are merged. With bigalloc, space is free a whole cluster at a
+// time, so the runs start and end on cluster boundaries (or at the end of the
+// filesystem).
+type FreeSpaceMap struct {
+	extents      []FreeExtent
+	freeBlocks   uint64
+	freeClusters uint64
+}
+
+// NewFreeSpaceMapWithSuperblock reads the block-bitmap of every group of the
+// filesystem of `sb` and returns a `FreeSpaceMap`.
+func NewFreeSpaceMapWithSuperblock(sb *Superblock) (fsm *FreeSpaceMap, err error) {
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
+	if err != nil {
+		return nil, err
+	}
+
+	fsm = &FreeSpaceMap{
+		extents: make([]FreeExtent, 0),
+	}
+
+	for i := 0; i < bgdl.Count(); i++ {
+		bm, err := bgdl.BlockBitmap(i)
+		if err != nil {
+			return nil, err
+		}
+
+		first := sb.GroupFirstBlock(uint64(i))
+		last := first + sb.GroupBlockCount(uint64(i))
+		ratio := sb.ClusterRatio()
+
+		for n := uint64(0); n < bm.Len(); n++ {
+			if bm.IsSet(n) == true {
+				continue
+			}
+
+			// The last cluster of the filesystem can be short.
+			start := first + n*ratio
+
+			length := ratio
+			if start+length > last {
+				length = last - start
+			}
+
+			fsm.add(start, length)
+		}
+	}
+
+	return fsm, nil
+}
+
+// add marks the given free cluster, extending the last run if it's contiguous
+// with it. Clusters have to be added in order.
+func (fsm *FreeSpaceMap) add(start, length uint64) {
+	fsm.freeBlocks += length
+	fsm.freeClusters++
+
+	if len(fsm.extents) > 0 {
+		last := &fsm.extents[len(fsm.extents)-1]
+		if last.Start+last.Length == start {
+			last.Length += length
+			return
+		}
+	}
+
+	fsm.extents = append(fsm.extents, FreeExtent{Start: start, Length: length})
+}
+
+// Extents returns the runs of free blocks, in order.
+func (fsm *FreeSpaceMap) Extents() []FreeExtent {
+	return fsm.extents
+}
+
+// FreeBlocks returns the total number of free blocks.
+func (fsm *FreeSpaceMap) FreeBlocks() uint64 {
+	return fsm.freeBlocks
+}
+
+// FreeClusters returns the total number of free clusters. Without bigalloc,
+// this is the same as `FreeBlocks`.
+func (fsm *FreeSpaceMap) FreeClusters() uint64 {
+	return fsm.freeClusters
+}
+
+// IsFree returns whether the given block is free.
+func (fsm *FreeSpaceMap) IsFree(block uint64) bool {
+	i := sort.Search(len(fsm.extents), func(i int) bool {
+		return fsm.extents[i].Start > block
+	})
+
+	if i == 0 {
+		return false
+	}
+
+	fe := fsm.extents[i-1]
+
+	return block < fe.Start+fe.Length
+}
@@ -0,0 +1,277 @@
+package ext4
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+// getTestBitmapsFilesystem returns the superblock and descriptors of an image
+// with four groups of (512) blocks. Groups (1) and (2) have uninitialized
+// block-bitmaps, groups (1) through (3) have uninitialized inode-bitmaps, and
+// the last group is one block short. Inode (13) is allocated but isn't in any
+// directory.
+func getTestBitmapsFilesystem() (f *os.File, sb *Superblock, bgdl *BlockGroupDescriptorList) {
+	filepath := path.Join(assetsPath, "bitmaps.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	sb, err = NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	bgdl, err = NewBlockGroupDescriptorListWithSuperblock(sb)
+	log.PanicIf(err)
+
+	return f, sb, bgdl
+}
+
+func TestBlockGroupDescriptorList_BlockBitmap(t *testing.T) {
+	f, sb, bgdl := getTestBitmapsFilesystem()
+	defer f.Close()
+
+	// These match what `dumpe2fs` reports.
+	expected := []struct {
+		length uint64
+		free   uint64
+	}{
+		{512, 102},
+		{512, 255},
+		{512, 512},
+		{511, 254},
+	}
+
+	for i, e := range expected {
+		bm, err := bgdl.BlockBitmap(i)
+		log.PanicIf(err)
+
+		if bm.Len() != e.length || bm.CountClear() != e.free {
+			t.Fatalf("Block-bitmap for group (%d) not correct: %s", i, bm)
+		}
+	}
+
+	// Group (1) is uninitialized. Its superblock copy, descriptors, and
+	// reserved descriptors are in use, and everything after them is free.
+
+	bm, err := bgdl.BlockBitmap(1)
+	log.PanicIf(err)
+
+	first := sb.GroupFirstBlock(1)
+
+	if bm.IsSet(513-first) != true || bm.IsSet(769-first) != true {
+		t.Fatalf("Metadata of group (1) should be in use.")
+	} else if bm.IsSet(770-first) != false || bm.IsSet(1024-first) != false {
+		t.Fatalf("Rest of group (1) should be free.")
+	} else if bm.IsSet(512) != true {
+		t.Fatalf("Bits past the end should be in use.")
+	}
+
+	_, err = bgdl.BlockBitmap(4)
+	if errors.Is(err, ErrNotFound) == false {
+		t.Fatalf("Expected not-found for a group past the end: %v", err)
+	}
+}
+
+func TestBlockGroupDescriptorList_InodeBitmap(t *testing.T) {
+	f, _, bgdl := getTestBitmapsFilesystem()
+	defer f.Close()
+
+	bm, err := bgdl.InodeBitmap(0)
+	log.PanicIf(err)
+
+	// Inodes (1) through (13) are in use.
+	if bm.String() != "Bitmap<LENGTH=(32) SET=(13)>" {
+		t.Fatalf("Inode-bitmap for group (0) not correct: %s", bm)
+	} else if bm.IsSet(12) != true || bm.IsSet(13) != false {
+		t.Fatalf("Inode-bitmap for group (0) not correct at the boundary.")
+	}
+
+	for i := 1; i < 4; i++ {
+		bm, err := bgdl.InodeBitmap(i)
+		log.PanicIf(err)
+
+		if bm.CountSet() != 0 {
+			t.Fatalf("Inode-bitmap for group (%d) should be empty: %s", i, bm)
+		}
+	}
+}
+
+func TestBlockGroupDescriptorList_BlockBitmap_Corrupt(t *testing.T) {
+	f, _, bgdl := getTestBitmapsFilesystem()
+	defer f.Close()
+
+	bgd, err := bgdl.Get(0)
+	log.PanicIf(err)
+
+	bgd.Data().BgBlockBitmapLo = 100000
+
+	_, err = bgdl.BlockBitmap(0)
+
+	var ec *ErrCorrupt
+	if errors.As(err, &ec) == false {
+		t.Fatalf("Expected a corruption error: %v", err)
+	}
+}
+
+func TestNewFreeSpaceMapWithSuperblock(t *testing.T) {
+	f, sb, _ := getTestBitmapsFilesystem()
+	defer f.Close()
+
+	fsm, err := NewFreeSpaceMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	if fsm.FreeBlocks() != uint64(sb.Data().SFreeBlocksCountLo) {
+		t.Fatalf("Free blocks not correct: (%d)", fsm.FreeBlocks())
+	}
+
+	// The free runs of groups (1) and (2) run into each other.
+	expected := []FreeExtent{
+		{Start: 411, Length: 102},
+		{Start: 770, Length: 767},
+		{Start: 1794, Length: 254},
+	}
+
+	extents := fsm.Extents()
+	if len(extents) != len(expected) {
+		t.Fatalf("Extents not correct: %v", extents)
+	}
+
+	for i, fe := range extents {
+		if fe != expected[i] {
+			t.Fatalf("Extent (%d) not correct: %s", i, fe)
+		}
+	}
+
+	free := map[uint64]bool{
+		0:    false,
+		410:  false,
+		411:  true,
+		512:  true,
+		513:  false,
+		1024: true,
+		1536: true,
+		1793: false,
+		2047: true,
+		2048: false,
+	}
+
+	for block, isFree := range free {
+		if fsm.IsFree(block) != isFree {
+			t.Fatalf("Block (%d) should be free [%v].", block, isFree)
+		}
+	}
+}
+
+func ExampleFreeSpaceMap_Extents() {
+	filepath := path.Join(assetsPath, "bitmaps.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	fsm, err := NewFreeSpaceMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	for _, fe := range fsm.Extents() {
+		fmt.Println(fe)
+	}
+
+	fmt.Printf("(%d) free\n", fsm.FreeBlocks())
+
+	// Output:
+	// FreeExtent<START=(411) LENGTH=(102)>
+	// FreeExtent<START=(770) LENGTH=(767)>
+	// FreeExtent<START=(1794) LENGTH=(254)>
+	// (1123) free
+}
+
+func TestBlockGroupDescriptorList_BlockBitmap_Bigalloc(t *testing.T) {
+	filepath := path.Join(assetsPath, "bigalloc.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
+	log.PanicIf(err)
+
+	// One bit per cluster, and the descriptors count clusters.
+
+	lengths := []uint64{256, 256, 237}
+
+	for i, length := range lengths {
+		bm, err := bgdl.BlockBitmap(i)
+		log.PanicIf(err)
+
+		bgd, err := bgdl.Get(i)
+		log.PanicIf(err)
+
+		if bm.Len() != length || bm.CountClear() != uint64(bgd.FreeClusterCount()) {
+			t.Fatalf("Block-bitmap for group (%d) not correct: %s FREE=(%d)", i, bm, bgd.FreeClusterCount())
+		}
+	}
+
+	// Group (1) is uninitialized. Its superblock copy and descriptors (blocks
+	// (1024) and (1025)) are both in its first cluster.
+
+	bm, err := bgdl.BlockBitmap(1)
+	log.PanicIf(err)
+
+	if bm.IsSet(0) != true || bm.IsSet(1) != false {
+		t.Fatalf("Uninitialized block-bitmap not correct: %s", bm)
+	}
+}
+
+func TestNewFreeSpaceMapWithSuperblock_Bigalloc(t *testing.T) {
+	filepath := path.Join(assetsPath, "bigalloc.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	fsm, err := NewFreeSpaceMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	if fsm.FreeBlocks() != sb.FreeBlockCount() || fsm.FreeClusters() != 229+255+237 {
+		t.Fatalf("Free space not correct: BLOCKS=(%d) CLUSTERS=(%d)", fsm.FreeBlocks(), fsm.FreeClusters())
+	}
+
+	// Whole clusters, except at the end of the filesystem.
+	expected := []FreeExtent{
+		{Start: 108, Length: 916},
+		{Start: 1028, Length: 1968},
+	}
+
+	extents := fsm.Extents()
+	if len(extents) != len(expected) {
+		t.Fatalf("Extents not correct: %v", extents)
+	}
+
+	for i, fe := range extents {
+		if fe != expected[i] {
+			t.Fatalf("Extent (%d) not correct: %s", i, fe)
+		}
+	}
+
+	// The file's last block is (100), but the rest of its cluster is taken
+	// too.
+	if fsm.IsFree(101) != false || fsm.IsFree(107) != false || fsm.IsFree(108) != true {
+		t.Fatalf("Partly-used cluster not correct.")
+	}
+}
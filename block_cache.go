@@ -0,0 +1,137 @@
+package ext4
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+const (
+	// DefaultBlockCacheSize is the number of blocks that the cache installed by
+	// `NewSuperblockWithReaderAt` will hold.
+	DefaultBlockCacheSize = 1024
+)
+
+// BlockCacheStats describes how well a cache is doing.
+type BlockCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+
+	// Blocks is the number of blocks currently held.
+	Blocks int
+}
+
+func (bcs BlockCacheStats) String() string {
+	return fmt.Sprintf("BlockCacheStats<HITS=(%d) MISSES=(%d) EVICTIONS=(%d) BLOCKS=(%d)>", bcs.Hits, bcs.Misses, bcs.Evictions, bcs.Blocks)
+}
+
+// BlockCache is consulted by `Superblock.ReadPhysicalBlock` before going to the
+// underlying reader. Implementations must be safe for concurrent use. The
+// data passed to `Put` and returned by `Get` is always a complete block and
+// must not be modified by either side.
+type BlockCache interface {
+	Get(absoluteBlockNumber uint64) (data []byte, found bool)
+	Put(absoluteBlockNumber uint64, data []byte)
+	Stats() BlockCacheStats
+}
+
+type lruBlockCacheEntry struct {
+	absoluteBlockNumber uint64
+	data                []byte
+}
+
+// LruBlockCache is a `BlockCache` that holds a fixed number of blocks and
+// evicts the least-recently-used one when full.
+type LruBlockCache struct {
+	maxBlocks int
+
+	index map[uint64]*list.Element
+	order *list.List
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+
+	mutex sync.Mutex
+}
+
+// NewLruBlockCache returns a cache that will hold at most `maxBlocks` blocks.
+func NewLruBlockCache(maxBlocks int) *LruBlockCache {
+	if maxBlocks < 1 {
+		maxBlocks = 1
+	}
+
+	return &LruBlockCache{
+		maxBlocks: maxBlocks,
+		index:     make(map[uint64]*list.Element),
+		order:     list.New(),
+	}
+}
+
+// Get returns the cached block and marks it as most-recently used.
+func (lbc *LruBlockCache) Get(absoluteBlockNumber uint64) (data []byte, found bool) {
+	lbc.mutex.Lock()
+	defer lbc.mutex.Unlock()
+
+	e, found := lbc.index[absoluteBlockNumber]
+	if found == false {
+		lbc.misses++
+		return nil, false
+	}
+
+	lbc.hits++
+	lbc.order.MoveToFront(e)
+
+	return e.Value.(*lruBlockCacheEntry).data, true
+}
+
+// Put stores the block, evicting the least-recently-used one if we're full.
+func (lbc *LruBlockCache) Put(absoluteBlockNumber uint64, data []byte) {
+	lbc.mutex.Lock()
+	defer lbc.mutex.Unlock()
+
+	if e, found := lbc.index[absoluteBlockNumber]; found == true {
+		e.Value.(*lruBlockCacheEntry).data = data
+		lbc.order.MoveToFront(e)
+
+		return
+	}
+
+	for lbc.order.Len() >= lbc.maxBlocks {
+		oldest := lbc.order.Back()
+		lbce := lbc.order.Remove(oldest).(*lruBlockCacheEntry)
+
+		delete(lbc.index, lbce.absoluteBlockNumber)
+		lbc.evictions++
+	}
+
+	lbce := &lruBlockCacheEntry{
+		absoluteBlockNumber: absoluteBlockNumber,
+		data:                data,
+	}
+
+	lbc.index[absoluteBlockNumber] = lbc.order.PushFront(lbce)
+}
+
+// Stats returns a snapshot of the counters.
+func (lbc *LruBlockCache) Stats() BlockCacheStats {
+	lbc.mutex.Lock()
+	defer lbc.mutex.Unlock()
+
+	return BlockCacheStats{
+		Hits:      lbc.hits,
+		Misses:    lbc.misses,
+		Evictions: lbc.evictions,
+		Blocks:    lbc.order.Len(),
+	}
+}
+
+// Purge drops all cached blocks. The counters are left alone.
+func (lbc *LruBlockCache) Purge() {
+	lbc.mutex.Lock()
+	defer lbc.mutex.Unlock()
+
+	lbc.index = make(map[uint64]*list.Element)
+	lbc.order.Init()
+}
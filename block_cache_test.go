@@ -0,0 +1,91 @@
+package ext4
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestLruBlockCache_Eviction(t *testing.T) {
+	lbc := NewLruBlockCache(2)
+
+	lbc.Put(1, []byte{1})
+	lbc.Put(2, []byte{2})
+
+	// Touch (1) so that (2) becomes the oldest.
+	_, found := lbc.Get(1)
+	if found == false {
+		t.Fatalf("Block (1) should be cached.")
+	}
+
+	lbc.Put(3, []byte{3})
+
+	if _, found := lbc.Get(2); found == true {
+		t.Fatalf("Block (2) should have been evicted.")
+	} else if data, found := lbc.Get(1); found == false || data[0] != 1 {
+		t.Fatalf("Block (1) should still be cached.")
+	} else if data, found := lbc.Get(3); found == false || data[0] != 3 {
+		t.Fatalf("Block (3) should be cached.")
+	}
+
+	stats := lbc.Stats()
+
+	if stats.String() != "BlockCacheStats<HITS=(3) MISSES=(1) EVICTIONS=(1) BLOCKS=(2)>" {
+		t.Fatalf("Stats not correct: %s", stats)
+	}
+
+	lbc.Purge()
+
+	if lbc.Stats().Blocks != 0 {
+		t.Fatalf("Purge did not drop blocks.")
+	}
+}
+
+func TestSuperblock_ReadPhysicalBlock_Cached(t *testing.T) {
+	filepath := path.Join(assetsPath, "tiny.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	lbc := NewLruBlockCache(10)
+	sb.SetBlockCache(lbc)
+
+	first, err := sb.ReadPhysicalBlock(2, 16)
+	log.PanicIf(err)
+
+	// The caller owns what we return; scribbling on it mustn't affect the
+	// cache.
+	first[0] ^= 0xff
+
+	second, err := sb.ReadPhysicalBlock(2, 16)
+	log.PanicIf(err)
+
+	if first[0] == second[0] {
+		t.Fatalf("Cached block was modified through a returned slice.")
+	}
+
+	stats := lbc.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("Stats not correct: %s", stats)
+	}
+
+	// Disable and make sure that we still read the same thing.
+
+	sb.SetBlockCache(nil)
+
+	third, err := sb.ReadPhysicalBlock(2, 16)
+	log.PanicIf(err)
+
+	if third[0] != second[0] {
+		t.Fatalf("Uncached read does not match cached read.")
+	} else if lbc.Stats().Hits != 1 {
+		t.Fatalf("Disabled cache was still consulted.")
+	}
+}
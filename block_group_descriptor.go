@@ -1,13 +1,12 @@
 package ext4
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"strconv"
 
 	"encoding/binary"
-
-	"github.com/dsoprea/go-logging"
 )
 
 const (
@@ -52,16 +51,12 @@ type BlockGroupDescriptor struct {
 }
 
 func NewBlockGroupDescriptorWithReader(r io.Reader, sb *Superblock) (bgd *BlockGroupDescriptor, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
-
 	bgdd := new(BlockGroupDescriptorData)
 
 	err = binary.Read(r, binary.LittleEndian, bgdd)
-	log.PanicIf(err)
+	if err != nil {
+		return nil, err
+	}
 
 	bgd = &BlockGroupDescriptor{
 		data: bgdd,
@@ -71,6 +66,21 @@ func NewBlockGroupDescriptorWithReader(r io.Reader, sb *Superblock) (bgd *BlockG
 	return bgd, nil
 }
 
+// NewBlockGroupDescriptorWithBytes parses a descriptor of `sb.DescriptorSize()`
+// bytes. Without the 64bit feature, descriptors only have the low halves of
+// the fields and the rest is left zeroed.
+func NewBlockGroupDescriptorWithBytes(raw []byte, sb *Superblock) (bgd *BlockGroupDescriptor, err error) {
+	descriptorSize := sb.DescriptorSize()
+	if len(raw) < descriptorSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	padded := make([]byte, BlockGroupDescriptorSize)
+	copy(padded, raw[:descriptorSize])
+
+	return NewBlockGroupDescriptorWithReader(bytes.NewBuffer(padded), sb)
+}
+
 func (bgd *BlockGroupDescriptor) Data() *BlockGroupDescriptorData {
 	return bgd.data
 }
@@ -124,6 +134,14 @@ func (bgd *BlockGroupDescriptor) InodeTableBlock() uint64 {
 	}
 }
 
+func (bgd *BlockGroupDescriptor) BlockBitmapBlock() uint64 {
+	if bgd.sb.Is64Bit() == true {
+		return (uint64(bgd.data.BgBlockBitmapHi) << 32) | uint64(bgd.data.BgBlockBitmapLo)
+	} else {
+		return uint64(bgd.data.BgBlockBitmapLo)
+	}
+}
+
 func (bgd *BlockGroupDescriptor) InodeBitmapBlock() uint64 {
 	if bgd.sb.Is64Bit() == true {
 		return (uint64(bgd.data.BgInodeBitmapHi) << 32) | uint64(bgd.data.BgInodeBitmapLo)
@@ -131,3 +149,55 @@ func (bgd *BlockGroupDescriptor) InodeBitmapBlock() uint64 {
 		return uint64(bgd.data.BgInodeBitmapLo)
 	}
 }
+
+// FreeClusterCount returns the number of free clusters in the group according
+// to the descriptor. Without bigalloc, clusters are blocks.
+func (bgd *BlockGroupDescriptor) FreeClusterCount() uint32 {
+	if bgd.sb.Is64Bit() == true {
+		return (uint32(bgd.data.BgFreeBlocksCountHi) << 16) | uint32(bgd.data.BgFreeBlocksCountLo)
+	} else {
+		return uint32(bgd.data.BgFreeBlocksCountLo)
+	}
+}
+
+// FreeInodeCount returns the number of free inodes in the group according to
+// the descriptor.
+func (bgd *BlockGroupDescriptor) FreeInodeCount() uint32 {
+	if bgd.sb.Is64Bit() == true {
+		return (uint32(bgd.data.BgFreeInodesCountHi) << 16) | uint32(bgd.data.BgFreeInodesCountLo)
+	} else {
+		return uint32(bgd.data.BgFreeInodesCountLo)
+	}
+}
+
+// ItableUnused returns the number of inodes at the end of the group's
+// inode-table that have never been used.
+func (bgd *BlockGroupDescriptor) ItableUnused() uint32 {
+	if bgd.sb.Is64Bit() == true {
+		return (uint32(bgd.data.BgItableUnusedHi) << 16) | uint32(bgd.data.BgItableUnusedLo)
+	} else {
+		return uint32(bgd.data.BgItableUnusedLo)
+	}
+}
+
+// usedInodeLimit returns how many inodes at the start of the group's
+// inode-table can be in use. The unused count is only maintained (and only
+// trusted by the kernel) when the descriptors have checksums.
+func (bgd *BlockGroupDescriptor) usedInodeLimit() uint64 {
+	inodesPerGroup := uint64(bgd.sb.Data().SInodesPerGroup)
+
+	if bgd.IsInodeTableAndBitmapNotInitialized() == true {
+		return 0
+	}
+
+	if bgd.sb.HasReadonlyCompatibleFeature(SbFeatureRoCompatGdtCsum) == false && bgd.sb.HasReadonlyCompatibleFeature(SbFeatureRoCompatMetadataCsum) == false {
+		return inodesPerGroup
+	}
+
+	unused := uint64(bgd.ItableUnused())
+	if unused > inodesPerGroup {
+		return 0
+	}
+
+	return inodesPerGroup - unused
+}
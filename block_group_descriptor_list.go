@@ -1,9 +1,8 @@
 package ext4
 
 import (
+	"fmt"
 	"io"
-
-	"github.com/dsoprea/go-logging"
 )
 
 type BlockGroupDescriptorList struct {
@@ -11,34 +10,49 @@ type BlockGroupDescriptorList struct {
 	bgds []*BlockGroupDescriptor
 }
 
-// NewBlockGroupDescriptorListWithReadSeeker returns a
+// NewBlockGroupDescriptorListWithSuperblock returns a
 // `BlockGroupDescriptorsList`, which has all block-group-descriptors in a big
 // slice. Filesystems with the flex_bg capability flag (most) will group all of
 // the BGD data together right at the top.
-func NewBlockGroupDescriptorListWithReadSeeker(rs io.ReadSeeker, sb *Superblock) (bgdl *BlockGroupDescriptorList, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
+//
+// This is the first thing that reads beyond the superblock, so it's where the
+// superblock's `SupportPolicy` is enforced.
+func NewBlockGroupDescriptorListWithSuperblock(sb *Superblock) (bgdl *BlockGroupDescriptorList, err error) {
+	err = sb.checkBeyondSuperblock()
+	if err != nil {
+		return nil, err
+	}
 
-	// QUESTION(dustin): This whole group is replicated/backed-up along with the superblock?
+	// The table is backed-up along with the superblock (or, with meta_bg, a
+	// block at a time in the groups that it describes). We read the copy that
+	// goes with `sb`, so a backup superblock brings its own descriptors. See
+	// `groupDescriptorCopies`.
 
-	// currentBlock initially points at the block with the first BGD.
-	initialBlock := uint64(sb.Data().SFirstDataBlock) + 1
-	initialOffset := initialBlock * uint64(sb.BlockSize())
+	blockGroupsCount := sb.BlockGroupCount()
 
-	_, err = rs.Seek(int64(initialOffset), io.SeekStart)
-	log.PanicIf(err)
+	// Read the table a block at a time (through the block cache). We grow the
+	// list as we go rather than trusting the group-count for an allocation up
+	// front; a corrupt count then just runs us into the end of the image.
+	blockSize := uint64(sb.BlockSize())
+	descriptorSize := uint64(sb.DescriptorSize())
+	descriptorsPerBlock := blockSize / descriptorSize
 
-	blockGroupsCount := sb.BlockGroupCount()
-	bgds := make([]*BlockGroupDescriptor, blockGroupsCount)
+	bgds := make([]*BlockGroupDescriptor, 0)
 
-	for i := uint64(0); i < blockGroupsCount; i++ {
-		bgd, err := NewBlockGroupDescriptorWithReader(rs, sb)
-		log.PanicIf(err)
+	for i := uint64(0); uint64(len(bgds)) < blockGroupsCount; i++ {
+		data, err := sb.ReadPhysicalBlock(sb.groupDescriptorBlock(i), blockSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for j := uint64(0); j < descriptorsPerBlock && uint64(len(bgds)) < blockGroupsCount; j++ {
+			bgd, err := NewBlockGroupDescriptorWithBytes(data[j*descriptorSize:], sb)
+			if err != nil {
+				return nil, err
+			}
 
-		bgds[i] = bgd
+			bgds = append(bgds, bgd)
+		}
 	}
 
 	bgdl = &BlockGroupDescriptorList{
@@ -49,13 +63,38 @@ func NewBlockGroupDescriptorListWithReadSeeker(rs io.ReadSeeker, sb *Superblock)
 	return bgdl, nil
 }
 
+// NewBlockGroupDescriptorListWithReadSeeker returns the block-group-descriptors
+// of the filesystem described by `sb`.
+//
+// Deprecated: Use `NewBlockGroupDescriptorListWithSuperblock`. `rs` isn't used;
+// the descriptors are read through `sb`.
+func NewBlockGroupDescriptorListWithReadSeeker(rs io.ReadSeeker, sb *Superblock) (bgdl *BlockGroupDescriptorList, err error) {
+	return NewBlockGroupDescriptorListWithSuperblock(sb)
+}
+
 func (bgdl *BlockGroupDescriptorList) GetWithAbsoluteInode(n int) (bgd *BlockGroupDescriptor, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
+	if n < 1 || n > int(bgdl.sb.Data().SInodesCount) {
+		return nil, fmt.Errorf("inode (%d): %w", n, ErrNotFound)
+	}
 
 	blockGroupNumber := bgdl.sb.BlockGroupNumberWithAbsoluteInodeNumber(n)
+	if blockGroupNumber >= len(bgdl.bgds) {
+		return nil, fmt.Errorf("block-group (%d) for inode (%d): %w", blockGroupNumber, n, ErrNotFound)
+	}
+
 	return bgdl.bgds[blockGroupNumber], nil
 }
+
+// Count returns the number of block-groups.
+func (bgdl *BlockGroupDescriptorList) Count() int {
+	return len(bgdl.bgds)
+}
+
+// Get returns the descriptor for the given block-group.
+func (bgdl *BlockGroupDescriptorList) Get(group int) (bgd *BlockGroupDescriptor, err error) {
+	if group < 0 || group >= len(bgdl.bgds) {
+		return nil, fmt.Errorf("block-group (%d): %w", group, ErrNotFound)
+	}
+
+	return bgdl.bgds[group], nil
+}
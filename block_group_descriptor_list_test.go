@@ -1,7 +1,7 @@
 package ext4
 
 import (
-	"io"
+	"errors"
 	"os"
 	"path"
 	"testing"
@@ -9,7 +9,7 @@ import (
 	"github.com/dsoprea/go-logging"
 )
 
-func TestNewBlockGroupDescriptorListWithReadSeeker(t *testing.T) {
+func TestNewBlockGroupDescriptorListWithSuperblock(t *testing.T) {
 	filepath := path.Join(assetsPath, "tiny.ext4")
 
 	f, err := os.Open(filepath)
@@ -17,14 +17,10 @@ func TestNewBlockGroupDescriptorListWithReadSeeker(t *testing.T) {
 
 	defer f.Close()
 
-	// Skip over the boot-code at the front of the filesystem.
-	_, err = f.Seek(Superblock0Offset, io.SeekStart)
+	sb, err := NewSuperblockWithReaderAt(f)
 	log.PanicIf(err)
 
-	sb, err := NewSuperblockWithReader(f)
-	log.PanicIf(err)
-
-	bgdl, err := NewBlockGroupDescriptorListWithReadSeeker(f, sb)
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
 	log.PanicIf(err)
 
 	if len(bgdl.bgds) != 1 {
@@ -33,3 +29,27 @@ func TestNewBlockGroupDescriptorListWithReadSeeker(t *testing.T) {
 		t.Fatalf("BGD checksum is not correct: [%04x]", bgdl.bgds[0].Data().BgChecksum)
 	}
 }
+
+func TestBlockGroupDescriptorList_GetWithAbsoluteInode_NotFound(t *testing.T) {
+	filepath := path.Join(assetsPath, "tiny.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
+	log.PanicIf(err)
+
+	invalid := []int{0, int(sb.Data().SInodesCount) + 1}
+
+	for _, inodeNumber := range invalid {
+		_, err = bgdl.GetWithAbsoluteInode(inodeNumber)
+		if errors.Is(err, ErrNotFound) == false {
+			t.Fatalf("Expected ErrNotFound for inode (%d): %v", inodeNumber, err)
+		}
+	}
+}
@@ -17,10 +17,7 @@ func TestNewBlockGroupDescriptorWithReader(t *testing.T) {
 
 	defer f.Close()
 
-	_, err = f.Seek(Superblock0Offset, io.SeekStart)
-	log.PanicIf(err)
-
-	sb, err := NewSuperblockWithReader(f)
+	sb, err := NewSuperblockWithReaderAt(f)
 	log.PanicIf(err)
 
 	bgdOffset := int64(sb.BlockSize() * (sb.Data().SFirstDataBlock + 1))
@@ -0,0 +1,400 @@
+package ext4
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// BlockClass is what a block is used for.
+type BlockClass int
+
+// Block classes.
+const (
+	BlockClassUnknown BlockClass = iota
+	BlockClassSuperblock
+	BlockClassGroupDescriptors
+	BlockClassReservedGroupDescriptors
+	BlockClassBlockBitmap
+	BlockClassInodeBitmap
+	BlockClassInodeTable
+	BlockClassExtentTree
+	BlockClassDirectory
+	BlockClassData
+	BlockClassJournal
+)
+
+var (
+	BlockClassLookup = map[BlockClass]string{
+		BlockClassUnknown:                  "unknown",
+		BlockClassSuperblock:               "superblock",
+		BlockClassGroupDescriptors:         "group descriptors",
+		BlockClassReservedGroupDescriptors: "reserved group descriptors",
+		BlockClassBlockBitmap:              "block bitmap",
+		BlockClassInodeBitmap:              "inode bitmap",
+		BlockClassInodeTable:               "inode table",
+		BlockClassExtentTree:               "extent tree",
+		BlockClassDirectory:                "directory",
+		BlockClassData:                     "data",
+		BlockClassJournal:                  "journal",
+	}
+)
+
+func (bc BlockClass) String() string {
+	if name, found := BlockClassLookup[bc]; found == true {
+		return name
+	}
+
+	return fmt.Sprintf("BlockClass(%d)", int(bc))
+}
+
+// BlockInfo describes what a block is.
+type BlockInfo struct {
+	Block uint64
+	Class BlockClass
+
+	// Group is the block-group that a superblock copy, bitmap, or inode-table
+	// block belongs to. For group descriptors, it's the group of the first
+	// descriptor in the block.
+	Group uint64
+
+	// FirstInode and InodeCount are the inodes in an inode-table block.
+	FirstInode int
+	InodeCount int
+
+	// Inode is the inode that owns an extent-tree, directory, data, or journal
+	// block. LogicalBlock is where a directory, data, or journal block is in
+	// that inode.
+	Inode        int
+	LogicalBlock uint64
+}
+
+func (bi BlockInfo) String() string {
+	switch bi.Class {
+	case BlockClassSuperblock, BlockClassGroupDescriptors, BlockClassReservedGroupDescriptors, BlockClassBlockBitmap, BlockClassInodeBitmap:
+		return fmt.Sprintf("BlockInfo<BLOCK=(%d) CLASS=[%s] GROUP=(%d)>", bi.Block, bi.Class, bi.Group)
+	case BlockClassInodeTable:
+		return fmt.Sprintf("BlockInfo<BLOCK=(%d) CLASS=[%s] GROUP=(%d) INODES=(%d)-(%d)>", bi.Block, bi.Class, bi.Group, bi.FirstInode, bi.FirstInode+bi.InodeCount-1)
+	case BlockClassExtentTree:
+		return fmt.Sprintf("BlockInfo<BLOCK=(%d) CLASS=[%s] INODE=(%d)>", bi.Block, bi.Class, bi.Inode)
+	case BlockClassDirectory, BlockClassData, BlockClassJournal:
+		return fmt.Sprintf("BlockInfo<BLOCK=(%d) CLASS=[%s] INODE=(%d) LOGICAL-BLOCK=(%d)>", bi.Block, bi.Class, bi.Inode, bi.LogicalBlock)
+	}
+
+	return fmt.Sprintf("BlockInfo<BLOCK=(%d) CLASS=[%s]>", bi.Block, bi.Class)
+}
+
+// blockRange is a run of blocks that are all used for the same thing. `info`
+// describes the first one.
+type blockRange struct {
+	start  uint64
+	length uint64
+	info   BlockInfo
+}
+
+// describe returns the info for the given block in the range.
+func (br blockRange) describe(block uint64, inodesPerBlock int, descriptorsPerBlock uint64) BlockInfo {
+	bi := br.info
+	bi.Block = block
+
+	offset := block - br.start
+
+	switch bi.Class {
+	case BlockClassGroupDescriptors:
+		bi.Group += offset * descriptorsPerBlock
+	case BlockClassInodeTable:
+		bi.FirstInode += int(offset) * inodesPerBlock
+	case BlockClassDirectory, BlockClassData, BlockClassJournal:
+		bi.LogicalBlock += offset
+	}
+
+	return bi
+}
+
+// findRange returns the range that the given block is in. The ranges have to
+// be sorted and can't overlap.
+func findRange(ranges []blockRange, block uint64) (br blockRange, found bool) {
+	i := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].start > block
+	})
+
+	if i == 0 {
+		return blockRange{}, false
+	}
+
+	br = ranges[i-1]
+	if block >= br.start+br.length {
+		return blockRange{}, false
+	}
+
+	return br, true
+}
+
+func sortRanges(ranges []blockRange) {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start < ranges[j].start
+	})
+}
+
+// BlockMap tells what each block of a filesystem is used for: which part of
+// the group metadata it is, or which inode it belongs to.
+//
+// The group metadata is found from the descriptors up front. The first lookup
+// of any other block reads every inode-table to find out which inode owns it,
+// so the owners are as of that moment. Only inodes with extents are looked at;
+// blocks mapped through the old block-map scheme come back as unknown, as do
+// free blocks.
+type BlockMap struct {
+	sb   *Superblock
+	bgdl *BlockGroupDescriptorList
+
+	inodesPerBlock      int
+	descriptorsPerBlock uint64
+
+	metadata []blockRange
+
+	ownersOnce sync.Once
+	owners     []blockRange
+	ownersErr  error
+}
+
+// NewBlockMapWithSuperblock returns a `BlockMap` for the filesystem of `sb`.
+func NewBlockMapWithSuperblock(sb *Superblock) (bm *BlockMap, err error) {
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize := uint64(sb.BlockSize())
+
+	bm = &BlockMap{
+		sb:                  sb,
+		bgdl:                bgdl,
+		inodesPerBlock:      int(blockSize / uint64(sb.InodeSize())),
+		descriptorsPerBlock: blockSize / uint64(sb.DescriptorSize()),
+	}
+
+	bm.metadata = bm.findMetadata()
+
+	return bm, nil
+}
+
+// findMetadata returns the group metadata of every group.
+func (bm *BlockMap) findMetadata() (ranges []blockRange) {
+	ranges = make([]blockRange, 0)
+
+	for i := 0; i < bm.bgdl.Count(); i++ {
+		bgd, _ := bm.bgdl.Get(i)
+		ranges = append(ranges, groupMetadata(bm.sb, bgd, uint64(i))...)
+	}
+
+	sortRanges(ranges)
+
+	return ranges
+}
+
+// groupMetadata returns the metadata of the given group: its superblock copy
+// and group descriptors (if it has them), and its bitmaps and inode-table,
+// wherever they are.
+func groupMetadata(sb *Superblock, bgd *BlockGroupDescriptor, group uint64) (ranges []blockRange) {
+	sbd := sb.Data()
+
+	blockSize := uint64(sb.BlockSize())
+	inodesPerBlock := blockSize / uint64(sb.InodeSize())
+	descriptorsPerBlock := blockSize / uint64(sb.DescriptorSize())
+
+	// With meta_bg, the table after the superblock copies only covers the
+	// groups before the first meta-group; each meta-group's descriptors are
+	// in its own first, second, and last groups.
+	gdtBlocks := sb.GroupDescriptorBlockCount()
+	hasMetaBg := sb.HasIncompatibleFeature(SbFeatureIncompatMetaBg)
+
+	if hasMetaBg == true && uint64(sbd.SFirstMetaBg) < gdtBlocks {
+		gdtBlocks = uint64(sbd.SFirstMetaBg)
+	}
+
+	inodeTableBlocks := (uint64(sbd.SInodesPerGroup) + inodesPerBlock - 1) / inodesPerBlock
+
+	ranges = make([]blockRange, 0)
+
+	add := func(start, length uint64, info BlockInfo) {
+		if length > 0 {
+			ranges = append(ranges, blockRange{start: start, length: length, info: info})
+		}
+	}
+
+	first := sb.GroupFirstBlock(group)
+
+	// The primary superblock is always at the same offset, whatever the first
+	// data-block is (with bigalloc and 1k blocks, it's in the middle of the
+	// first cluster).
+	superblockBlock := first
+	if group == 0 {
+		superblockBlock = uint64(Superblock0Offset) / blockSize
+	}
+
+	hasSuperblock := sb.HasSuperblockBackup(group)
+
+	metaGroup := group / descriptorsPerBlock
+	inMetaGroup := hasMetaBg == true && metaGroup >= uint64(sbd.SFirstMetaBg)
+
+	if hasSuperblock == true {
+		add(superblockBlock, 1, BlockInfo{Class: BlockClassSuperblock, Group: group})
+
+		if inMetaGroup == false {
+			add(superblockBlock+1, gdtBlocks, BlockInfo{Class: BlockClassGroupDescriptors})
+			add(superblockBlock+1+gdtBlocks, uint64(sbd.SReservedGdtBlocks), BlockInfo{Class: BlockClassReservedGroupDescriptors, Group: group})
+		}
+	}
+
+	if inMetaGroup == true {
+		index := group % descriptorsPerBlock
+
+		if index == 0 || index == 1 || index == descriptorsPerBlock-1 {
+			block := first
+			if hasSuperblock == true {
+				block = superblockBlock + 1
+			}
+
+			add(block, 1, BlockInfo{Class: BlockClassGroupDescriptors, Group: metaGroup * descriptorsPerBlock})
+		}
+	}
+
+	add(bgd.BlockBitmapBlock(), 1, BlockInfo{Class: BlockClassBlockBitmap, Group: group})
+	add(bgd.InodeBitmapBlock(), 1, BlockInfo{Class: BlockClassInodeBitmap, Group: group})
+
+	add(bgd.InodeTableBlock(), inodeTableBlocks, BlockInfo{
+		Class:      BlockClassInodeTable,
+		Group:      group,
+		FirstInode: int(group)*int(sbd.SInodesPerGroup) + 1,
+		InodeCount: int(inodesPerBlock),
+	})
+
+	return ranges
+}
+
+// findOwners reads the inode-tables and returns the blocks of every inode
+// that's in use.
+func (bm *BlockMap) findOwners() (ranges []blockRange, err error) {
+	sbd := bm.sb.Data()
+	inodeSize := int(bm.sb.InodeSize())
+	blockSize := uint64(bm.sb.BlockSize())
+
+	ranges = make([]blockRange, 0)
+
+	for i := 0; i < bm.bgdl.Count(); i++ {
+		bgd, _ := bm.bgdl.Get(i)
+
+		if bgd.IsInodeTableAndBitmapNotInitialized() == true {
+			continue
+		}
+
+		inodeTable := bgd.InodeTableBlock()
+
+		for j := 0; j < int(sbd.SInodesPerGroup); j += bm.inodesPerBlock {
+			data, err := bm.sb.ReadPhysicalBlock(inodeTable+uint64(j/bm.inodesPerBlock), blockSize)
+			if err != nil {
+				return nil, err
+			}
+
+			for k := 0; k < bm.inodesPerBlock && j+k < int(sbd.SInodesPerGroup); k++ {
+				inodeNumber := i*int(sbd.SInodesPerGroup) + j + k + 1
+
+				inode, err := NewInodeWithBytes(bgd, inodeNumber, data[k*inodeSize:(k+1)*inodeSize])
+				if err != nil {
+					return nil, err
+				}
+
+				ranges = append(ranges, bm.inodeBlocks(inode)...)
+			}
+		}
+	}
+
+	sortRanges(ranges)
+
+	return ranges, nil
+}
+
+// inodeBlocks returns the blocks of the given inode, or nothing if it isn't
+// in use or doesn't have extents. An inode whose extent-tree can't be read is
+// skipped, since one bad inode shouldn't keep us from describing the rest.
+func (bm *BlockMap) inodeBlocks(inode *Inode) (ranges []blockRange) {
+	if inode.IsInUse() == false {
+		return nil
+	} else if inode.Flag(InodeFlagExtents) == false || inode.Flag(InodeFlagInlineData) == true {
+		return nil
+	}
+
+	leaves, treeBlocks, err := NewExtentNavigatorWithInode(inode).Extents()
+	if err != nil {
+		return nil
+	}
+
+	class := BlockClassData
+	if inode.Number() == int(bm.sb.Data().SJournalInum) {
+		class = BlockClassJournal
+	} else if inode.IsDirectory() == true {
+		class = BlockClassDirectory
+	}
+
+	ranges = make([]blockRange, 0, len(leaves)+len(treeBlocks))
+
+	for _, block := range treeBlocks {
+		ranges = append(ranges, blockRange{
+			start:  block,
+			length: 1,
+			info:   BlockInfo{Class: BlockClassExtentTree, Inode: inode.Number()},
+		})
+	}
+
+	for _, leaf := range leaves {
+		ranges = append(ranges, blockRange{
+			start:  leaf.StartPhysicalBlock(),
+			length: leaf.Length(),
+			info: BlockInfo{
+				Class:        class,
+				Inode:        inode.Number(),
+				LogicalBlock: uint64(leaf.EeFirstLogicalBlock),
+			},
+		})
+	}
+
+	return ranges
+}
+
+// Classify returns what the given block is used for. A block beyond the end
+// of the filesystem is an error wrapping `ErrNotFound`.
+func (bm *BlockMap) Classify(block uint64) (bi BlockInfo, err error) {
+	if block >= bm.sb.BlockCount() {
+		return BlockInfo{}, fmt.Errorf("block (%d) is beyond the block-count (%d): %w", block, bm.sb.BlockCount(), ErrNotFound)
+	}
+
+	if br, found := findRange(bm.metadata, block); found == true {
+		return br.describe(block, bm.inodesPerBlock, bm.descriptorsPerBlock), nil
+	}
+
+	bm.ownersOnce.Do(func() {
+		bm.owners, bm.ownersErr = bm.findOwners()
+	})
+
+	if bm.ownersErr != nil {
+		return BlockInfo{}, bm.ownersErr
+	}
+
+	if br, found := findRange(bm.owners, block); found == true {
+		return br.describe(block, bm.inodesPerBlock, bm.descriptorsPerBlock), nil
+	}
+
+	return BlockInfo{Block: block, Class: BlockClassUnknown}, nil
+}
+
+// Superblock returns the superblock that the map was built from.
+func (bm *BlockMap) Superblock() *Superblock {
+	return bm.sb
+}
+
+// BlockGroupDescriptors returns the block-group descriptors that the map was
+// built from.
+func (bm *BlockMap) BlockGroupDescriptors() *BlockGroupDescriptorList {
+	return bm.bgdl
+}
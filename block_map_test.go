@@ -0,0 +1,93 @@
+package ext4
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestBlockMap_Classify(t *testing.T) {
+	filepath := path.Join(assetsPath, "tiny.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	bm, err := NewBlockMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	expected := map[uint64]string{
+		0:    "BlockInfo<BLOCK=(0) CLASS=[unknown]>",
+		1:    "BlockInfo<BLOCK=(1) CLASS=[superblock] GROUP=(0)>",
+		2:    "BlockInfo<BLOCK=(2) CLASS=[group descriptors] GROUP=(0)>",
+		4:    "BlockInfo<BLOCK=(4) CLASS=[reserved group descriptors] GROUP=(0)>",
+		6:    "BlockInfo<BLOCK=(6) CLASS=[block bitmap] GROUP=(0)>",
+		7:    "BlockInfo<BLOCK=(7) CLASS=[directory] INODE=(2) LOGICAL-BLOCK=(0)>",
+		10:   "BlockInfo<BLOCK=(10) CLASS=[directory] INODE=(11) LOGICAL-BLOCK=(2)>",
+		20:   "BlockInfo<BLOCK=(20) CLASS=[extent tree] INODE=(12)>",
+		21:   "BlockInfo<BLOCK=(21) CLASS=[data] INODE=(12) LOGICAL-BLOCK=(488)>",
+		22:   "BlockInfo<BLOCK=(22) CLASS=[inode bitmap] GROUP=(0)>",
+		38:   "BlockInfo<BLOCK=(38) CLASS=[inode table] GROUP=(0) INODES=(1)-(8)>",
+		39:   "BlockInfo<BLOCK=(39) CLASS=[inode table] GROUP=(0) INODES=(9)-(16)>",
+		53:   "BlockInfo<BLOCK=(53) CLASS=[inode table] GROUP=(0) INODES=(121)-(128)>",
+		1000: "BlockInfo<BLOCK=(1000) CLASS=[data] INODE=(12) LOGICAL-BLOCK=(23)>",
+		450:  "BlockInfo<BLOCK=(450) CLASS=[unknown]>",
+	}
+
+	for block, description := range expected {
+		bi, err := bm.Classify(block)
+		log.PanicIf(err)
+
+		if bi.String() != description {
+			t.Fatalf("Block (%d) not classified correctly: %s", block, bi)
+		}
+	}
+
+	_, err = bm.Classify(sb.BlockCount())
+	if errors.Is(err, ErrNotFound) == false {
+		t.Fatalf("Expected not-found for a block past the end: %v", err)
+	}
+}
+
+func TestBlockMap_Classify_Bigalloc(t *testing.T) {
+	filepath := path.Join(assetsPath, "bigalloc.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	bm, err := NewBlockMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	// The first data-block is (0), but the superblock is still at (1) and the
+	// descriptors right after it.
+	expected := map[uint64]string{
+		0:    "BlockInfo<BLOCK=(0) CLASS=[unknown]>",
+		1:    "BlockInfo<BLOCK=(1) CLASS=[superblock] GROUP=(0)>",
+		2:    "BlockInfo<BLOCK=(2) CLASS=[group descriptors] GROUP=(0)>",
+		3:    "BlockInfo<BLOCK=(3) CLASS=[block bitmap] GROUP=(0)>",
+		1024: "BlockInfo<BLOCK=(1024) CLASS=[superblock] GROUP=(1)>",
+		1025: "BlockInfo<BLOCK=(1025) CLASS=[group descriptors] GROUP=(0)>",
+		100:  "BlockInfo<BLOCK=(100) CLASS=[data] INODE=(12) LOGICAL-BLOCK=(48)>",
+	}
+
+	for block, description := range expected {
+		bi, err := bm.Classify(block)
+		log.PanicIf(err)
+
+		if bi.String() != description {
+			t.Fatalf("Block (%d) not classified correctly: %s", block, bi)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package ext4
+
+import (
+	"hash/crc32"
+)
+
+var (
+	castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// crc32c continues a checksum the way that the kernel's crc32c() does, which
+// (unlike `crc32.Update`) doesn't invert the value on the way in or out.
+func crc32c(crc uint32, data []byte) uint32 {
+	return ^crc32.Update(^crc, castagnoliTable, data)
+}
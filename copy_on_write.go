@@ -0,0 +1,141 @@
+package ext4
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// CopyOnWrite is an `io.ReaderAt` and `io.WriterAt` over an image that keeps
+// everything written to it in memory and never modifies the image. Reads see
+// the writes. This lets an image be repaired (e.g. by replaying its journal)
+// and then read with the rest of the package while the original stays as it
+// was, which matters when it's evidence or read-only media.
+//
+// It's safe for concurrent use as long as the underlying reader is.
+type CopyOnWrite struct {
+	ra        io.ReaderAt
+	blockSize int64
+
+	blocks map[int64][]byte
+	mutex  sync.RWMutex
+}
+
+// NewCopyOnWriteWithReaderAt returns a `CopyOnWrite` over `ra` that tracks
+// changes in units of `blockSize` bytes. This would normally be the block-size
+// of the filesystem.
+func NewCopyOnWriteWithReaderAt(ra io.ReaderAt, blockSize int) (cow *CopyOnWrite, err error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("block-size not valid: (%d)", blockSize)
+	}
+
+	cow = &CopyOnWrite{
+		ra:        ra,
+		blockSize: int64(blockSize),
+		blocks:    make(map[int64][]byte),
+	}
+
+	return cow, nil
+}
+
+// ReadAt reads from the changed blocks where there are any and from the
+// underlying image everywhere else.
+func (cow *CopyOnWrite) ReadAt(p []byte, offset int64) (n int, err error) {
+	if offset < 0 {
+		return 0, fmt.Errorf("offset not valid: (%d)", offset)
+	}
+
+	cow.mutex.RLock()
+	defer cow.mutex.RUnlock()
+
+	for n < len(p) {
+		current := offset + int64(n)
+		block := current / cow.blockSize
+		blockOffset := current % cow.blockSize
+
+		chunk := p[n:]
+		if int64(len(chunk)) > cow.blockSize-blockOffset {
+			chunk = chunk[:cow.blockSize-blockOffset]
+		}
+
+		if data, found := cow.blocks[block]; found == true {
+			n += copy(chunk, data[blockOffset:])
+			continue
+		}
+
+		m, err := cow.ra.ReadAt(chunk, current)
+		n += m
+
+		if m < len(chunk) {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// WriteAt stores the data in memory. Partially-written blocks are filled in
+// from the image first. Writing past the end of the image is allowed; the
+// missing part of the block reads as zeros.
+func (cow *CopyOnWrite) WriteAt(p []byte, offset int64) (n int, err error) {
+	if offset < 0 {
+		return 0, fmt.Errorf("offset not valid: (%d)", offset)
+	}
+
+	cow.mutex.Lock()
+	defer cow.mutex.Unlock()
+
+	for n < len(p) {
+		current := offset + int64(n)
+		block := current / cow.blockSize
+		blockOffset := current % cow.blockSize
+
+		data, found := cow.blocks[block]
+		if found == false {
+			data = make([]byte, cow.blockSize)
+
+			// Anything short of the whole block is past the end of the image.
+			_, err := cow.ra.ReadAt(data, block*cow.blockSize)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return n, fmt.Errorf("read of block (%d) failed: %w", block, err)
+			}
+
+			cow.blocks[block] = data
+		}
+
+		n += copy(data[blockOffset:], p[n:])
+	}
+
+	return n, nil
+}
+
+// ChangedBlocks returns the (sorted) numbers of the blocks that have been
+// written to.
+func (cow *CopyOnWrite) ChangedBlocks() []int64 {
+	cow.mutex.RLock()
+	defer cow.mutex.RUnlock()
+
+	blocks := make([]int64, 0, len(cow.blocks))
+	for block := range cow.blocks {
+		blocks = append(blocks, block)
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i] < blocks[j]
+	})
+
+	return blocks
+}
+
+// Reset throws away all of the changes.
+func (cow *CopyOnWrite) Reset() {
+	cow.mutex.Lock()
+	defer cow.mutex.Unlock()
+
+	cow.blocks = make(map[int64][]byte)
+}
@@ -0,0 +1,94 @@
+package ext4
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestCopyOnWrite_WriteAt(t *testing.T) {
+	original := bytes.Repeat([]byte{0x11}, 4096)
+
+	image := make([]byte, len(original))
+	copy(image, original)
+
+	cow, err := NewCopyOnWriteWithReaderAt(bytes.NewReader(image), 1024)
+	log.PanicIf(err)
+
+	// Straddle the boundary between the second and third blocks.
+
+	update := bytes.Repeat([]byte{0x22}, 100)
+
+	n, err := cow.WriteAt(update, 2000)
+	log.PanicIf(err)
+
+	if n != len(update) {
+		t.Fatalf("Write count not correct: (%d)", n)
+	} else if bytes.Equal(image, original) == false {
+		t.Fatalf("Image was modified.")
+	} else if reflect.DeepEqual(cow.ChangedBlocks(), []int64{1, 2}) == false {
+		t.Fatalf("Changed blocks not correct: %v", cow.ChangedBlocks())
+	}
+
+	expected := make([]byte, len(original))
+	copy(expected, original)
+	copy(expected[2000:], update)
+
+	actual := make([]byte, len(original))
+
+	n, err = cow.ReadAt(actual, 0)
+	log.PanicIf(err)
+
+	if n != len(actual) {
+		t.Fatalf("Read count not correct: (%d)", n)
+	} else if bytes.Equal(actual, expected) == false {
+		t.Fatalf("Data not correct.")
+	}
+
+	cow.Reset()
+
+	_, err = cow.ReadAt(actual, 0)
+	log.PanicIf(err)
+
+	if bytes.Equal(actual, original) == false {
+		t.Fatalf("Data not correct after reset.")
+	} else if len(cow.ChangedBlocks()) != 0 {
+		t.Fatalf("Changed blocks not cleared: %v", cow.ChangedBlocks())
+	}
+}
+
+func TestCopyOnWrite_ReadAt_PastEnd(t *testing.T) {
+	image := bytes.Repeat([]byte{0x11}, 1536)
+
+	cow, err := NewCopyOnWriteWithReaderAt(bytes.NewReader(image), 1024)
+	log.PanicIf(err)
+
+	buffer := make([]byte, 1024)
+
+	n, err := cow.ReadAt(buffer, 1024)
+	if n != 512 || (err != io.EOF && err != io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected short read: (%d) %v", n, err)
+	}
+
+	// Once the block has been written, it's all there.
+
+	_, err = cow.WriteAt([]byte{0x22}, 2047)
+	log.PanicIf(err)
+
+	n, err = cow.ReadAt(buffer, 1024)
+	log.PanicIf(err)
+
+	if n != 1024 || buffer[511] != 0x11 || buffer[512] != 0 || buffer[1023] != 0x22 {
+		t.Fatalf("Data not correct.")
+	}
+}
+
+func TestNewCopyOnWriteWithReaderAt_BlockSize(t *testing.T) {
+	_, err := NewCopyOnWriteWithReaderAt(bytes.NewReader(nil), 0)
+	if err == nil {
+		t.Fatalf("Expected an error for a zero block-size.")
+	}
+}
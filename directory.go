@@ -3,7 +3,7 @@ package ext4
 import (
 	"fmt"
 
-	"github.com/dsoprea/go-logging"
+	"encoding/binary"
 )
 
 const (
@@ -92,10 +92,12 @@ func (de *DirectoryEntry) IsSymbolicLink() bool {
 	return de.data.FileType == FileTypeSymbolicLink
 }
 
+// TypeName returns a description of the type. Types that we don't recognize
+// come back as "invalid".
 func (de *DirectoryEntry) TypeName() string {
 	name, found := FileTypeLookup[de.data.FileType]
 	if found == false {
-		log.Panicf("invalid type (%d) for inode (%d)", de.data.FileType, de.data.Inode)
+		return "invalid"
 	}
 
 	return name
@@ -104,3 +106,41 @@ func (de *DirectoryEntry) TypeName() string {
 func (de *DirectoryEntry) String() string {
 	return fmt.Sprintf("DirectoryEntry<NAME=[%s] INODE=(%d) TYPE=[%s]-(%d)>", de.Name(), de.data.Inode, de.TypeName(), de.data.FileType)
 }
+
+// ParseDirectoryBlock parses all of the entries in one block of a (linear)
+// directory, including unused ones (whose inode is zero) and the checksum
+// tail. This is for directory data that didn't come from the filesystem
+// directly (e.g. from the journal); otherwise use `DirectoryBrowser`.
+func ParseDirectoryBlock(data []byte) (entries []*DirectoryEntry, err error) {
+	entries = make([]*DirectoryEntry, 0)
+
+	for offset := 0; offset < len(data); {
+		if offset+8 > len(data) {
+			return nil, newErrCorrupt("directory entry", 0, "record at offset (%d) runs past the block", offset)
+		}
+
+		raw := &Ext4DirEntry2{
+			Inode:    binary.LittleEndian.Uint32(data[offset:]),
+			RecLen:   binary.LittleEndian.Uint16(data[offset+4:]),
+			NameLen:  data[offset+6],
+			FileType: data[offset+7],
+		}
+
+		if raw.RecLen < 8 || raw.RecLen%4 != 0 {
+			return nil, newErrCorrupt("directory entry", 0, "record-length not valid: (%d)", raw.RecLen)
+		} else if offset+int(raw.RecLen) > len(data) {
+			return nil, newErrCorrupt("directory entry", 0, "record of length (%d) at offset (%d) crosses a block boundary", raw.RecLen, offset)
+		} else if int(raw.NameLen)+8 > int(raw.RecLen) {
+			return nil, newErrCorrupt("directory entry", 0, "name-length (%d) does not fit in record-length (%d)", raw.NameLen, raw.RecLen)
+		}
+
+		raw.Name = make([]byte, raw.NameLen)
+		copy(raw.Name, data[offset+8:])
+
+		entries = append(entries, &DirectoryEntry{data: raw})
+
+		offset += int(raw.RecLen)
+	}
+
+	return entries, nil
+}
@@ -2,53 +2,82 @@ package ext4
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 
 	"encoding/binary"
-
-	"github.com/dsoprea/go-logging"
 )
 
 // DirectoryBrowser provides high-level directory navigation.
 type DirectoryBrowser struct {
 	inodeReader *InodeReader
 
-	dataSize uint64
-	dataRead uint64
+	dataSize  uint64
+	dataRead  uint64
+	blockSize uint64
 }
 
-func NewDirectoryBrowser(rs io.ReadSeeker, inode *Inode) *DirectoryBrowser {
-	en := NewExtentNavigatorWithReadSeeker(rs, inode)
+// NewDirectoryBrowser returns a browser for the given directory inode. Returns
+// `ErrNotDirectory` if the inode is something else.
+func NewDirectoryBrowser(inode *Inode) (db *DirectoryBrowser, err error) {
+	if inode.IsDirectory() == false {
+		return nil, fmt.Errorf("%s: %w", inode, ErrNotDirectory)
+	} else if inode.Flag(InodeFlagEncrypt) == true {
+		// The names would just be ciphertext.
+		return nil, &ErrUnsupportedFeature{Feature: "encrypt"}
+	}
+
+	en := NewExtentNavigatorWithInode(inode)
 	ir := NewInodeReader(en)
 
-	return &DirectoryBrowser{
+	db = &DirectoryBrowser{
 		inodeReader: ir,
 		dataSize:    inode.Size(),
+		blockSize:   uint64(inode.BlockGroupDescriptor().Superblock().BlockSize()),
 	}
+
+	return db, nil
 }
 
 // Next parses the next directory entry from the underlying inode data reader.
 // Returns `io.EOF` when done. This will also return the "." and ".." entries.
 func (db *DirectoryBrowser) Next() (de *DirectoryEntry, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
-
 	// TODO(dustin): !! We should be seeing an extra `ext4_dir_entry_tail` as the last entry, which has a similar structure to a normal entry but provides a checksum. However, it doesn't appear to be present.
 
 	if db.dataRead >= db.dataSize {
 		return nil, io.EOF
 	}
 
+	// We've established that there's more data, so running out from here on is
+	// never a clean EOF.
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
 	raw := new(Ext4DirEntry2)
 
 	err = binary.Read(db.inodeReader, binary.LittleEndian, &raw.Inode)
-	log.PanicIf(err)
+	if err != nil {
+		return nil, err
+	}
 
 	err = binary.Read(db.inodeReader, binary.LittleEndian, &raw.RecLen)
-	log.PanicIf(err)
+	if err != nil {
+		return nil, err
+	}
+
+	// A record is at least the fixed fields, is four-byte aligned, and never
+	// crosses a block boundary.
+
+	recordOffset := db.dataRead % db.blockSize
+
+	if raw.RecLen < 8 || raw.RecLen%4 != 0 {
+		return nil, newErrCorrupt("directory entry", 0, "record-length not valid: (%d)", raw.RecLen)
+	} else if recordOffset+uint64(raw.RecLen) > db.blockSize {
+		return nil, newErrCorrupt("directory entry", 0, "record of length (%d) at offset (%d) crosses a block boundary", raw.RecLen, db.dataRead)
+	}
 
 	// Read the remaining data, which is variable-length.
 
@@ -58,7 +87,9 @@ func (db *DirectoryBrowser) Next() (de *DirectoryEntry, err error) {
 
 	for offset < needBytes {
 		n, err := db.inodeReader.Read(record[offset:])
-		log.PanicIf(err)
+		if err != nil {
+			return nil, err
+		}
 
 		offset += n
 	}
@@ -66,19 +97,27 @@ func (db *DirectoryBrowser) Next() (de *DirectoryEntry, err error) {
 	b := bytes.NewBuffer(record)
 
 	err = binary.Read(b, binary.LittleEndian, &raw.NameLen)
-	log.PanicIf(err)
+	if err != nil {
+		return nil, err
+	}
 
 	err = binary.Read(b, binary.LittleEndian, &raw.FileType)
-	log.PanicIf(err)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(raw.NameLen)+8 > int(raw.RecLen) {
+		return nil, newErrCorrupt("directory entry", 0, "name-length (%d) does not fit in record-length (%d)", raw.NameLen, raw.RecLen)
+	}
 
-	raw.Name = record[2 : 2+raw.NameLen]
+	raw.Name = record[2 : 2+int(raw.NameLen)]
 
 	// Done. Wrap up.
 
 	db.dataRead += uint64(raw.RecLen)
 
 	if db.dataRead > db.dataSize {
-		log.Panicf("inode size is not aligned to the directory-entry record size (we ran over): (%d) > (%d)", db.dataRead, db.dataSize)
+		return nil, newErrCorrupt("directory entry", 0, "inode size is not aligned to the directory-entry record size (we ran over): (%d) > (%d)", db.dataRead, db.dataSize)
 	}
 
 	de = &DirectoryEntry{
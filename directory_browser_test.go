@@ -1,6 +1,7 @@
 package ext4
 
 import (
+	"errors"
 	"io"
 	"reflect"
 	"sort"
@@ -15,7 +16,8 @@ func TestDirectoryBrowser_Next(t *testing.T) {
 
 	defer f.Close()
 
-	db := NewDirectoryBrowser(f, inode)
+	db, err := NewDirectoryBrowser(inode)
+	log.PanicIf(err)
 
 	entryDescriptions := make([]string, 0)
 
@@ -43,3 +45,15 @@ func TestDirectoryBrowser_Next(t *testing.T) {
 		t.Fatalf("Root directory entries are not correct.")
 	}
 }
+
+func TestNewDirectoryBrowser_NotDirectory(t *testing.T) {
+	f, inode, err := GetTestInode(TestFileInodeNumber)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	_, err = NewDirectoryBrowser(inode)
+	if errors.Is(err, ErrNotDirectory) == false {
+		t.Fatalf("Expected ErrNotDirectory: %v", err)
+	}
+}
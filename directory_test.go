@@ -0,0 +1,44 @@
+package ext4
+
+import (
+	"errors"
+	"testing"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestParseDirectoryBlock(t *testing.T) {
+	data := make([]byte, 64)
+
+	binary.LittleEndian.PutUint32(data[0:], 12)
+	binary.LittleEndian.PutUint16(data[4:], 16)
+	data[6] = 5
+	data[7] = FileTypeRegular
+	copy(data[8:], "a.txt")
+
+	// An unused entry takes up the rest.
+	binary.LittleEndian.PutUint16(data[16+4:], 48)
+
+	entries, err := ParseDirectoryBlock(data)
+	log.PanicIf(err)
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected two entries: %v", entries)
+	} else if entries[0].String() != "DirectoryEntry<NAME=[a.txt] INODE=(12) TYPE=[regular]-(1)>" {
+		t.Fatalf("First entry not correct: %s", entries[0])
+	} else if entries[1].Data().Inode != 0 || entries[1].Data().RecLen != 48 {
+		t.Fatalf("Unused entry not correct: %s", entries[1])
+	}
+
+	// A record that runs past the block.
+	binary.LittleEndian.PutUint16(data[16+4:], 52)
+
+	_, err = ParseDirectoryBlock(data)
+
+	var errCorrupt *ErrCorrupt
+	if errors.As(err, &errCorrupt) == false {
+		t.Fatalf("Expected corrupt error: %v", err)
+	}
+}
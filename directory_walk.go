@@ -3,8 +3,6 @@ package ext4
 import (
 	"io"
 	"path"
-
-	"github.com/dsoprea/go-logging"
 )
 
 type directoryWalkQueueItem struct {
@@ -15,25 +13,24 @@ type directoryWalkQueueItem struct {
 
 // DirectoryWalk provides full directory-structure recursion.
 type DirectoryWalk struct {
-	rs                   io.ReadSeeker
 	blockGroupDescriptor *BlockGroupDescriptor
 	inodeQueue           []directoryWalkQueueItem
-}
 
-func NewDirectoryWalk(rs io.ReadSeeker, bgd *BlockGroupDescriptor, rootInodeNumber int) (dw *DirectoryWalk, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
+	// visited has every directory inode that we've queued. A directory that
+	// shows up twice means that the tree has a loop in it.
+	visited map[int]struct{}
+}
 
+func NewDirectoryWalk(bgd *BlockGroupDescriptor, rootInodeNumber int) (dw *DirectoryWalk, err error) {
 	dw = &DirectoryWalk{
-		rs:                   rs,
 		blockGroupDescriptor: bgd,
+		visited:              make(map[int]struct{}),
 	}
 
 	inode, db, err := dw.openInode(rootInodeNumber)
-	log.PanicIf(err)
+	if err != nil {
+		return nil, err
+	}
 
 	dwqi := directoryWalkQueueItem{
 		inode:            inode,
@@ -41,21 +38,21 @@ func NewDirectoryWalk(rs io.ReadSeeker, bgd *BlockGroupDescriptor, rootInodeNumb
 	}
 
 	dw.inodeQueue = []directoryWalkQueueItem{dwqi}
+	dw.visited[rootInodeNumber] = struct{}{}
 
 	return dw, nil
 }
 
 func (dw *DirectoryWalk) openInode(inodeNumber int) (inode *Inode, db *DirectoryBrowser, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
-
-	inode, err = NewInodeWithReadSeeker(dw.blockGroupDescriptor, dw.rs, inodeNumber)
-	log.PanicIf(err)
+	inode, err = NewInodeWithBlockGroupDescriptor(dw.blockGroupDescriptor, inodeNumber)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	db = NewDirectoryBrowser(dw.rs, inode)
+	db, err = NewDirectoryBrowser(inode)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	return inode, db, nil
 }
@@ -64,12 +61,6 @@ func (dw *DirectoryWalk) openInode(inodeNumber int) (inode *Inode, db *Directory
 // entry at a time. We guarantee that all adjacent entries will be processed
 // adjacently. This will not return the "." and ".." entries.
 func (dw *DirectoryWalk) Next() (fullPath string, de *DirectoryEntry, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
-
 	for {
 		if len(dw.inodeQueue) == 0 {
 			return "", nil, io.EOF
@@ -86,7 +77,7 @@ func (dw *DirectoryWalk) Next() (fullPath string, de *DirectoryEntry, err error)
 			dw.inodeQueue = dw.inodeQueue[1:]
 			continue
 		} else if err != nil {
-			log.Panic(err)
+			return "", nil, err
 		}
 
 		// There was at least one more entry.
@@ -111,8 +102,18 @@ func (dw *DirectoryWalk) Next() (fullPath string, de *DirectoryEntry, err error)
 		// TODO(dustin): We get the impression that the "lost+found" inode isn't necessarily always in inode (11), so we only do a string match. Use `(superblock).SLpfIno` instead.
 		// TODO(dustin): "lost+found" produces some empty entries for our tiny, mostly untouched, mostly vanilla test image, which doesn't make sense to us. Just skipping for now. Revisit.
 		if de.IsDirectory() && filename != "lost+found" {
-			childInode, childDb, err := dw.openInode(int(de.data.Inode))
-			log.PanicIf(err)
+			childInodeNumber := int(de.data.Inode)
+
+			if _, found := dw.visited[childInodeNumber]; found == true {
+				return "", nil, newErrCorrupt("directory entry", 0, "directory [%s] refers back to inode (%d), which was already visited", fullFilepath, childInodeNumber)
+			}
+
+			dw.visited[childInodeNumber] = struct{}{}
+
+			childInode, childDb, err := dw.openInode(childInodeNumber)
+			if err != nil {
+				return "", nil, err
+			}
 
 			newDwqi := directoryWalkQueueItem{
 				fullDirectoryPath: fullFilepath,
@@ -24,7 +24,7 @@ func TestDirectoryWalk_Next32(t *testing.T) {
 
 	bgd := inode.BlockGroupDescriptor()
 
-	dw, err := NewDirectoryWalk(f, bgd, inodeNumber)
+	dw, err := NewDirectoryWalk(bgd, inodeNumber)
 	log.PanicIf(err)
 
 	allEntries := make([]string, 0)
@@ -77,19 +77,16 @@ func ExampleDirectoryWalk_Next() {
 
 	defer f.Close()
 
-	_, err = f.Seek(Superblock0Offset, io.SeekStart)
+	sb, err := NewSuperblockWithReaderAt(f)
 	log.PanicIf(err)
 
-	sb, err := NewSuperblockWithReader(f)
-	log.PanicIf(err)
-
-	bgdl, err := NewBlockGroupDescriptorListWithReadSeeker(f, sb)
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
 	log.PanicIf(err)
 
 	bgd, err := bgdl.GetWithAbsoluteInode(inodeNumber)
 	log.PanicIf(err)
 
-	dw, err := NewDirectoryWalk(f, bgd, inodeNumber)
+	dw, err := NewDirectoryWalk(bgd, inodeNumber)
 	log.PanicIf(err)
 
 	allEntries := make([]string, 0)
@@ -144,7 +141,7 @@ func TestDirectoryWalk_Next64(t *testing.T) {
 
 	bgd := inode.BlockGroupDescriptor()
 
-	dw, err := NewDirectoryWalk(f, bgd, inodeNumber)
+	dw, err := NewDirectoryWalk(bgd, inodeNumber)
 	log.PanicIf(err)
 
 	allEntries := make([]string, 0)
@@ -0,0 +1,52 @@
+package ext4
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned when a requested inode, block-group, directory
+	// entry, etc.. doesn't exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrNotDirectory is returned when a directory operation is attempted on
+	// something that isn't one.
+	ErrNotDirectory = errors.New("not a directory")
+)
+
+// ErrUnsupportedFeature is returned when the image uses something that we
+// can't (yet) read. The image isn't necessarily broken.
+type ErrUnsupportedFeature struct {
+	Feature string
+}
+
+func (e *ErrUnsupportedFeature) Error() string {
+	return fmt.Sprintf("unsupported feature: %s", e.Feature)
+}
+
+// ErrCorrupt is returned when an on-disk structure fails validation. `Block`
+// is the absolute block that the structure was read from, or zero if that's
+// not known or not meaningful.
+type ErrCorrupt struct {
+	Structure string
+	Block     uint64
+	Reason    string
+}
+
+func (e *ErrCorrupt) Error() string {
+	if e.Block == 0 {
+		return fmt.Sprintf("corrupt %s: %s", e.Structure, e.Reason)
+	}
+
+	return fmt.Sprintf("corrupt %s (block %d): %s", e.Structure, e.Block, e.Reason)
+}
+
+// newErrCorrupt builds an `ErrCorrupt` with a formatted reason.
+func newErrCorrupt(structure string, block uint64, format string, args ...interface{}) error {
+	return &ErrCorrupt{
+		Structure: structure,
+		Block:     block,
+		Reason:    fmt.Sprintf(format, args...),
+	}
+}
@@ -5,16 +5,24 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"sort"
 
 	"encoding/binary"
-
-	"github.com/dsoprea/go-logging"
 )
 
 const (
 	ExtentMagic            = uint16(0xf30A)
 	ExtentHeaderSize       = 12
 	ExtentIndexAndLeafSize = 12
+
+	// ExtentMaxDepth is the deepest that an extent-tree can be
+	// (EXT4_MAX_EXTENT_DEPTH).
+	ExtentMaxDepth = 5
+
+	// ExtentInitMaxLength is the longest that an initialized extent can be.
+	// Larger lengths mark unwritten (preallocated) extents, whose real length is
+	// the difference.
+	ExtentInitMaxLength = 32768
 )
 
 type ExtentHeaderNode struct {
@@ -55,6 +63,22 @@ func (eln *ExtentLeafNode) StartPhysicalBlock() uint64 {
 	return (uint64(eln.EeStartPhysicalBlockHi) << 32) | uint64(eln.EeStartPhysicalBlockLo)
 }
 
+// IsUnwritten indicates that the blocks are allocated but have never been
+// written, and so must read as zeros.
+func (eln *ExtentLeafNode) IsUnwritten() bool {
+	return eln.EeLogicalBlockCount > ExtentInitMaxLength
+}
+
+// Length returns the number of blocks covered, regardless of whether the
+// extent is unwritten.
+func (eln *ExtentLeafNode) Length() uint64 {
+	if eln.IsUnwritten() == true {
+		return uint64(eln.EeLogicalBlockCount - ExtentInitMaxLength)
+	}
+
+	return uint64(eln.EeLogicalBlockCount)
+}
+
 func (eln *ExtentLeafNode) String() string {
 	return fmt.Sprintf("ExtentLeafNode<FIRST-LBLOCK=(%d) LBLOCK-COUNT=(%d) START-PBLOCK=(%d)>", eln.EeFirstLogicalBlock, eln.EeLogicalBlockCount, eln.StartPhysicalBlock())
 }
@@ -67,28 +91,35 @@ type ExtentTail struct {
 	EbChecksum uint32
 }
 
+// ExtentNavigator resolves file offsets to physical blocks. It holds no
+// position of its own, so it can be shared between goroutines.
 type ExtentNavigator struct {
-	rs    io.ReadSeeker
 	inode *Inode
 }
 
-func NewExtentNavigatorWithReadSeeker(rs io.ReadSeeker, inode *Inode) *ExtentNavigator {
+func NewExtentNavigatorWithInode(inode *Inode) *ExtentNavigator {
 	return &ExtentNavigator{
-		rs:    rs,
 		inode: inode,
 	}
 }
 
+// NewExtentNavigatorWithReadSeeker returns an `ExtentNavigator` for the given
+// inode.
+//
+// Deprecated: Use `NewExtentNavigatorWithInode`. `rs` isn't used; the data is
+// read through the inode's superblock.
+func NewExtentNavigatorWithReadSeeker(rs io.ReadSeeker, inode *Inode) *ExtentNavigator {
+	return NewExtentNavigatorWithInode(inode)
+}
+
 // Read returns the inode data from the given offset to the end of the logical
-// block that it's found in.
+// block that it's found in. Holes and unwritten extents read as zeros.
 //
 // "logical", meaning that (0) refers to the first block of this inode's data.
 func (en *ExtentNavigator) Read(offset uint64) (data []byte, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
+	if offset >= en.inode.Size() {
+		return nil, io.EOF
+	}
 
 	sb := en.inode.BlockGroupDescriptor().Superblock()
 
@@ -96,141 +127,327 @@ func (en *ExtentNavigator) Read(offset uint64) (data []byte, err error) {
 	lBlockNumber := offset / blockSize
 	pBlockOffset := offset % blockSize
 
-	inodeIblock := en.inode.Data().IBlock[:]
-	pBlockNumber, err := en.parseHeader(inodeIblock, lBlockNumber, false)
-	log.PanicIf(err)
+	// If the inode's data stops mid-block, take just that amount.
+	dataLength := uint64(math.Min(float64(en.inode.Size()-offset), float64(blockSize-pBlockOffset)))
+
+	pBlockNumber, mapped, err := en.MapLogicalBlock(lBlockNumber)
+	if err != nil {
+		return nil, err
+	} else if mapped == false {
+		return make([]byte, dataLength), nil
+	}
 
 	// We'll return whichever data we got between the offset and the end of
 	// that immediate physical block.
 	rawPBlockData, err := sb.ReadPhysicalBlock(pBlockNumber, blockSize)
-	log.PanicIf(err)
-
-	// If the inode's data stops mid-block, take just that amount.
-	dataLength := uint64(math.Min(float64(en.inode.Size()-offset), float64(blockSize-pBlockOffset)))
+	if err != nil {
+		return nil, err
+	}
 
 	return rawPBlockData[pBlockOffset : pBlockOffset+dataLength], nil
 }
 
-// parseHeader parses the extent header and then recursively processes the
-// array of index-nodes or array of leaf-nodes following it.
-//
-// `hasTailChecksum` will be true for any of the arrays of extent structs that
-// we read after the first. Those are located in the inode's IBlock data,
-// which is already covered by the inode checksum.
-func (en *ExtentNavigator) parseHeader(extentHeaderData []byte, lBlock uint64, hasTailChecksum bool) (dataPBlock uint64, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
+// MapLogicalBlock returns the physical block that holds the given logical
+// block. `mapped` is false if there's nothing there to read (a hole or an
+// unwritten extent).
+func (en *ExtentNavigator) MapLogicalBlock(lBlock uint64) (pBlock uint64, mapped bool, err error) {
+	inodeIblock := en.inode.Data().IBlock[:]
 
-	b := bytes.NewBuffer(extentHeaderData)
+	return en.parseHeader(inodeIblock, 0, lBlock, -1, nil)
+}
 
-	// TODO(dustin): Pass this in as another argument and only parse if we receive a nil. Except for the first one, we'll otherwise double-parse every header struct.
-	eh := new(ExtentHeaderNode)
+// ExtentNode is one node of an extent-tree: the header and then either index
+// entries or (at depth zero) leaf entries.
+type ExtentNode struct {
+	Header  ExtentHeaderNode
+	Indexes []ExtentIndexNode
+	Leaves  []ExtentLeafNode
+}
+
+// ParseExtentNode parses an extent-tree node from the given data, which is
+// either an inode's `IBlock` or a whole extent block.
+func ParseExtentNode(data []byte) (node *ExtentNode, err error) {
+	return parseExtentNode(data, 0, -1)
+}
+
+// parseExtentNode parses and validates a node. `pBlock` is the block that the
+// data came from and is zero for the inode's IBlock. Only the others have a
+// tail checksum (the inode's data is already covered by the inode checksum).
+// `expectedDepth` is the depth that the parent said we'd be at (or -1 at the
+// top).
+func parseExtentNode(data []byte, pBlock uint64, expectedDepth int) (node *ExtentNode, err error) {
+	b := bytes.NewBuffer(data)
+
+	node = new(ExtentNode)
+	eh := &node.Header
 
 	err = binary.Read(b, binary.LittleEndian, eh)
-	log.PanicIf(err)
+	if err != nil {
+		return nil, err
+	}
 
 	if eh.EhMagic != ExtentMagic {
-		log.Panicf("extent-header magic-bytes not correct: (%04x)", eh.EhMagic)
+		return nil, newErrCorrupt("extent header", pBlock, "magic-bytes not correct: (%04x)", eh.EhMagic)
+	} else if eh.EhDepth > ExtentMaxDepth {
+		return nil, newErrCorrupt("extent header", pBlock, "depth too large: (%d)", eh.EhDepth)
+	} else if expectedDepth >= 0 && int(eh.EhDepth) != expectedDepth {
+		return nil, newErrCorrupt("extent header", pBlock, "depth (%d) not what the parent expected (%d)", eh.EhDepth, expectedDepth)
+	} else if eh.EhEntryCount > eh.EhMax {
+		return nil, newErrCorrupt("extent header", pBlock, "entry-count (%d) exceeds maximum (%d)", eh.EhEntryCount, eh.EhMax)
 	}
 
-	if eh.EhDepth == 0 {
-		// Our nodes are leaf nodes.
-
-		leafNodes := make([]ExtentLeafNode, eh.EhEntryCount)
+	capacity := (len(data) - ExtentHeaderSize) / ExtentIndexAndLeafSize
+	if int(eh.EhMax) > capacity {
+		return nil, newErrCorrupt("extent header", pBlock, "maximum entries (%d) exceeds what fits (%d)", eh.EhMax, capacity)
+	}
 
-		err = binary.Read(b, binary.LittleEndian, &leafNodes)
-		log.PanicIf(err)
+	if pBlock != 0 {
+		// The tail comes right after the full capacity of entries.
 
-		if hasTailChecksum == true {
+		tailOffset := ExtentHeaderSize + int(eh.EhMax)*ExtentIndexAndLeafSize
+		if tailOffset+Ext4ExtentChecksumTailSize <= len(data) {
 			et := new(ExtentTail)
 
-			err := binary.Read(b, binary.LittleEndian, et)
-			log.PanicIf(err)
+			err := binary.Read(bytes.NewBuffer(data[tailOffset:]), binary.LittleEndian, et)
+			if err != nil {
+				return nil, err
+			}
 
 			// TODO(dustin): Finish implementing checksums.
-			et = et
+			_ = et
+		}
+	}
+
+	if eh.EhDepth == 0 {
+		node.Leaves = make([]ExtentLeafNode, eh.EhEntryCount)
+
+		err = binary.Read(b, binary.LittleEndian, &node.Leaves)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		node.Indexes = make([]ExtentIndexNode, eh.EhEntryCount)
+
+		err = binary.Read(b, binary.LittleEndian, &node.Indexes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+// readChild reads the node that the given index entry points to. `ancestors`
+// is only kept to describe a loop when it happens; since depth always has to
+// decrease by exactly one, a tree that loops back on itself can never get past
+// the depth check.
+func (en *ExtentNavigator) readChild(hit *ExtentIndexNode, pBlock uint64, depth int, ancestors []uint64) (childPBlock uint64, child *ExtentNode, err error) {
+	sb := en.inode.BlockGroupDescriptor().Superblock()
+
+	childPBlock = hit.LeafPhysicalBlock()
+
+	if childPBlock >= sb.BlockCount() {
+		return 0, nil, newErrCorrupt("extent index", pBlock, "child block (%d) of %s is beyond the block-count (%d)", childPBlock, en.inode, sb.BlockCount())
+	}
+
+	for _, ancestor := range ancestors {
+		if ancestor == childPBlock {
+			return 0, nil, newErrCorrupt("extent index", pBlock, "extent-tree of %s loops back to block (%d)", en.inode, childPBlock)
 		}
+	}
+
+	childExtentData, err := sb.ReadPhysicalBlock(childPBlock, uint64(sb.BlockSize()))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	child, err = parseExtentNode(childExtentData, childPBlock, depth-1)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return childPBlock, child, nil
+}
+
+// parseHeader finds the given logical block in the given node, recursing
+// through index nodes down to the leaf nodes.
+func (en *ExtentNavigator) parseHeader(extentHeaderData []byte, pBlock uint64, lBlock uint64, expectedDepth int, ancestors []uint64) (dataPBlock uint64, mapped bool, err error) {
+	node, err := parseExtentNode(extentHeaderData, pBlock, expectedDepth)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return en.findInNode(node, pBlock, lBlock, ancestors)
+}
+
+// findInNode does the work of `parseHeader` for an already-parsed node.
+func (en *ExtentNavigator) findInNode(node *ExtentNode, pBlock uint64, lBlock uint64, ancestors []uint64) (dataPBlock uint64, mapped bool, err error) {
+	sb := en.inode.BlockGroupDescriptor().Superblock()
+
+	if node.Header.EhDepth == 0 {
+		// Our nodes are leaf nodes.
 
 		// Forward through the leaf-nodes on this level until we find one that
 		// extends beyond the logical-block we wanted.
 
 		var hit *ExtentLeafNode
-		for i, eln := range leafNodes {
-			if uint64(eln.EeFirstLogicalBlock+uint32(eln.EeLogicalBlockCount)) > lBlock {
-				hit = &leafNodes[i]
+		for i, eln := range node.Leaves {
+			if uint64(eln.EeFirstLogicalBlock)+eln.Length() > lBlock {
+				hit = &node.Leaves[i]
 				break
 			}
 		}
 
+		if hit == nil || uint64(hit.EeFirstLogicalBlock) > lBlock {
+			// A hole.
+			return 0, false, nil
+		} else if hit.IsUnwritten() == true {
+			return 0, false, nil
+		}
+
 		blockExtOffset := lBlock - uint64(hit.EeFirstLogicalBlock)
-		pBlock := hit.StartPhysicalBlock() + blockExtOffset
+		dataPBlock := hit.StartPhysicalBlock() + blockExtOffset
 
-		return pBlock, nil
-	} else {
-		// Our nodes are interior/index nodes.
+		if dataPBlock >= sb.BlockCount() {
+			return 0, false, newErrCorrupt("extent leaf", pBlock, "physical block (%d) for logical block (%d) of %s is beyond the block-count (%d)", dataPBlock, lBlock, en.inode, sb.BlockCount())
+		}
 
-		indexNodes := make([]ExtentIndexNode, eh.EhEntryCount)
+		return dataPBlock, true, nil
+	}
 
-		err = binary.Read(b, binary.LittleEndian, &indexNodes)
-		log.PanicIf(err)
+	// Our nodes are interior/index nodes.
 
-		if hasTailChecksum == true {
-			et := new(ExtentTail)
+	var hit *ExtentIndexNode
+	for i, ein := range node.Indexes {
+		if uint64(ein.EiLogicalBlock) <= lBlock {
+			hit = &node.Indexes[i]
+		} else {
+			break
+		}
+	}
 
-			err := binary.Read(b, binary.LittleEndian, et)
-			log.PanicIf(err)
+	if hit == nil {
+		// We're before the first index, so we're in a hole.
+		return 0, false, nil
+	}
 
-			// TODO(dustin): Finish implementing checksums.
-			et = et
-		}
+	ancestors = append(ancestors, pBlock)
 
-		var hit *ExtentIndexNode
-		for i, ein := range indexNodes {
-			if uint64(ein.EiLogicalBlock) <= lBlock {
-				hit = &indexNodes[i]
-			} else {
-				break
-			}
+	childPBlock, child, err := en.readChild(hit, pBlock, int(node.Header.EhDepth), ancestors)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return en.findInNode(child, childPBlock, lBlock, ancestors)
+}
+
+// Extents returns every leaf extent of the inode, in order, along with the
+// blocks that hold the rest of the tree (the index and leaf blocks below the
+// inode itself).
+func (en *ExtentNavigator) Extents() (leaves []ExtentLeafNode, treeBlocks []uint64, err error) {
+	node, err := ParseExtentNode(en.inode.Data().IBlock[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	leaves = make([]ExtentLeafNode, 0)
+	treeBlocks = make([]uint64, 0)
+
+	visited := make(map[uint64]struct{})
+
+	err = en.walk(node, 0, nil, visited, func(pBlock uint64, node *ExtentNode) {
+		if pBlock != 0 {
+			treeBlocks = append(treeBlocks, pBlock)
 		}
 
-		if hit == nil {
-			log.Panicf("None of the index nodes at the current level of the "+
-				"extent-tree for inode had a logical-block less "+
-				"than what was requested (%d): %s", lBlock, en.inode.String())
+		leaves = append(leaves, node.Leaves...)
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return leaves, treeBlocks, nil
+}
+
+// AllocatedClusters returns the number of clusters that the inode has
+// allocated: every cluster that any of its data or extent-tree blocks are in.
+// Without bigalloc, clusters are blocks. With bigalloc, a cluster belongs to
+// the inode as a whole even if only some of its blocks are mapped, so this is
+// the space that the inode actually takes up.
+func (en *ExtentNavigator) AllocatedClusters() (clusters uint64, err error) {
+	leaves, treeBlocks, err := en.Extents()
+	if err != nil {
+		return 0, err
+	}
+
+	sb := en.inode.bgd.sb
+
+	// The runs of clusters, which can overlap where extents share a cluster.
+	runs := make([][2]uint64, 0, len(leaves)+len(treeBlocks))
+
+	for _, leaf := range leaves {
+		if leaf.Length() == 0 {
+			continue
 		}
 
-		pBlock := hit.LeafPhysicalBlock()
+		start := leaf.StartPhysicalBlock()
+		runs = append(runs, [2]uint64{sb.BlockCluster(start), sb.BlockCluster(start + leaf.Length() - 1)})
+	}
 
-		// TODO(dustin): Refactor this to prevent reparsing the data in the next recursion when we're already parsing it here.
+	for _, block := range treeBlocks {
+		runs = append(runs, [2]uint64{sb.BlockCluster(block), sb.BlockCluster(block)})
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i][0] < runs[j][0]
+	})
 
-		// Do a preliminary read of the header to establish how much data we
-		// really need.
+	// Count each cluster once.
+	next := uint64(0)
+	for i, run := range runs {
+		if i > 0 && run[0] < next {
+			if run[1] < next {
+				continue
+			}
 
-		sb := en.inode.BlockGroupDescriptor().Superblock()
+			run[0] = next
+		}
 
-		data, err := sb.ReadPhysicalBlock(pBlock, uint64(ExtentHeaderSize))
-		log.PanicIf(err)
+		clusters += run[1] - run[0] + 1
+		next = run[1] + 1
+	}
 
-		nonleafHeaderBuffer := bytes.NewBuffer(data)
+	return clusters, nil
+}
 
-		nextEh := new(ExtentHeaderNode)
+// walk calls `cb` with every node of the tree, depth-first. No block can be
+// in the tree twice, which also keeps a corrupt tree from making us read the
+// same blocks over and over.
+func (en *ExtentNavigator) walk(node *ExtentNode, pBlock uint64, ancestors []uint64, visited map[uint64]struct{}, cb func(pBlock uint64, node *ExtentNode)) (err error) {
+	cb(pBlock, node)
 
-		err = binary.Read(nonleafHeaderBuffer, binary.LittleEndian, nextEh)
-		log.PanicIf(err)
+	ancestors = append(ancestors, pBlock)
 
-		// Now, read the full data for our child extents.
+	for i := range node.Indexes {
+		childPBlock := node.Indexes[i].LeafPhysicalBlock()
 
-		childExtentsLength := ExtentHeaderSize + ExtentIndexAndLeafSize*nextEh.EhEntryCount + Ext4ExtentChecksumTailSize
+		if _, found := visited[childPBlock]; found == true {
+			return newErrCorrupt("extent index", pBlock, "extent-tree of %s refers to block (%d) more than once", en.inode, childPBlock)
+		}
 
-		childExtentData, err := sb.ReadPhysicalBlock(pBlock, uint64(childExtentsLength))
-		log.PanicIf(err)
+		visited[childPBlock] = struct{}{}
 
-		dataPBlock, err = en.parseHeader(childExtentData, lBlock, true)
-		log.PanicIf(err)
+		childPBlock, child, err := en.readChild(&node.Indexes[i], pBlock, int(node.Header.EhDepth), ancestors)
+		if err != nil {
+			return err
+		}
 
-		return dataPBlock, nil
+		err = en.walk(child, childPBlock, ancestors, visited, cb)
+		if err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
@@ -3,6 +3,8 @@ package ext4
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"path"
 	"strings"
 	"testing"
 
@@ -17,7 +19,7 @@ func TestExtentNavigator_Read(t *testing.T) {
 
 	defer f.Close()
 
-	en := NewExtentNavigatorWithReadSeeker(f, inode)
+	en := NewExtentNavigatorWithInode(inode)
 
 	inodeSize := inode.Size()
 	actualBytes := make([]byte, inodeSize)
@@ -38,13 +40,72 @@ func TestExtentNavigator_Read(t *testing.T) {
 	}
 }
 
+func TestExtentNavigator_Read_Eof(t *testing.T) {
+	f, inode, err := GetTestInode(TestFileInodeNumber)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	en := NewExtentNavigatorWithInode(inode)
+
+	_, err = en.Read(inode.Size())
+	if err != io.EOF {
+		t.Fatalf("Expected EOF: %v", err)
+	}
+}
+
+func TestExtentNavigator_MapLogicalBlock_Hole(t *testing.T) {
+	f, inode, err := GetTestInode(TestFileInodeNumber)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	en := NewExtentNavigatorWithInode(inode)
+
+	_, mapped, err := en.MapLogicalBlock(0)
+	log.PanicIf(err)
+
+	if mapped != true {
+		t.Fatalf("Expected first block to be mapped.")
+	}
+
+	blockSize := uint64(inode.BlockGroupDescriptor().Superblock().BlockSize())
+
+	_, mapped, err = en.MapLogicalBlock(inode.Size()/blockSize + 100)
+	log.PanicIf(err)
+
+	if mapped != false {
+		t.Fatalf("Expected block past the end to be unmapped.")
+	}
+}
+
+func TestExtentLeafNode_Length(t *testing.T) {
+	eln := ExtentLeafNode{
+		EeLogicalBlockCount: 10,
+	}
+
+	if eln.IsUnwritten() != false {
+		t.Fatalf("Expected written extent.")
+	} else if eln.Length() != 10 {
+		t.Fatalf("Length not correct: (%d)", eln.Length())
+	}
+
+	eln.EeLogicalBlockCount = ExtentInitMaxLength + 10
+
+	if eln.IsUnwritten() != true {
+		t.Fatalf("Expected unwritten extent.")
+	} else if eln.Length() != 10 {
+		t.Fatalf("Length not correct for unwritten extent: (%d)", eln.Length())
+	}
+}
+
 func ExampleExtentNavigator_Read() {
 	f, inode, err := GetTestInode(TestFileInodeNumber)
 	log.PanicIf(err)
 
 	defer f.Close()
 
-	en := NewExtentNavigatorWithReadSeeker(f, inode)
+	en := NewExtentNavigatorWithInode(inode)
 
 	inodeSize := inode.Size()
 	actualBytes := make([]byte, inodeSize)
@@ -70,3 +131,65 @@ func ExampleExtentNavigator_Read() {
 	//
 	// This eBook is for the use of anyo
 }
+
+func TestExtentNavigator_Extents(t *testing.T) {
+	f, inode, err := GetTestInode(TestFileInodeNumber)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	en := NewExtentNavigatorWithInode(inode)
+
+	leaves, treeBlocks, err := en.Extents()
+	log.PanicIf(err)
+
+	if len(treeBlocks) != 1 || treeBlocks[0] != 20 {
+		t.Fatalf("Tree blocks not correct: %v", treeBlocks)
+	}
+
+	// The extents cover the whole file, in order.
+
+	next := uint64(0)
+	for _, leaf := range leaves {
+		if uint64(leaf.EeFirstLogicalBlock) != next {
+			t.Fatalf("Extent not where expected: %s", leaf.String())
+		}
+
+		next += leaf.Length()
+	}
+
+	if next != (inode.Size()+1023)/1024 {
+		t.Fatalf("Extents do not cover the file: (%d)", next)
+	}
+}
+
+func TestExtentNavigator_AllocatedClusters(t *testing.T) {
+	// Without bigalloc, it's every data and tree block.
+
+	f, inode, err := GetTestInode(TestFileInodeNumber)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	clusters, err := NewExtentNavigatorWithInode(inode).AllocatedClusters()
+	log.PanicIf(err)
+
+	if clusters != uint64(inode.Data().IBlocksLo)/2 {
+		t.Fatalf("Allocated blocks not correct: (%d)", clusters)
+	}
+
+	// With bigalloc, the (49) blocks of the file take up (13) clusters of
+	// four.
+
+	g, inode, err := GetInode(path.Join(assetsPath, "bigalloc.ext4"), TestFileInodeNumber)
+	log.PanicIf(err)
+
+	defer g.Close()
+
+	clusters, err = NewExtentNavigatorWithInode(inode).AllocatedClusters()
+	log.PanicIf(err)
+
+	if clusters != 13 || clusters*4*2 != uint64(inode.Data().IBlocksLo) {
+		t.Fatalf("Allocated clusters not correct: (%d)", clusters)
+	}
+}
@@ -0,0 +1,216 @@
+package ext4
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"testing"
+
+	"io/ioutil"
+)
+
+const (
+	// fuzzMaxEntries and fuzzMaxBytes keep a mutated image that describes a
+	// huge (or endless) tree or file from stalling the fuzzer.
+	fuzzMaxEntries = 1000
+	fuzzMaxBytes   = 64 * 1024
+)
+
+func getFuzzImage(tb testing.TB) []byte {
+	filepath := path.Join(assetsPath, "tiny.ext4")
+
+	raw, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return raw
+}
+
+// spliceFuzzImage returns a copy of the image with `data` written over it at
+// `offset`.
+func spliceFuzzImage(image []byte, offset int64, data []byte) *bytes.Reader {
+	mutated := make([]byte, len(image))
+	copy(mutated, image)
+
+	if offset < int64(len(mutated)) {
+		copy(mutated[offset:], data)
+	}
+
+	return bytes.NewReader(mutated)
+}
+
+// exerciseFuzzImage runs everything that we have over the image. Errors are
+// expected; panics and hangs aren't.
+func exerciseFuzzImage(ra io.ReaderAt) {
+	sb, err := NewSuperblockWithReaderAt(ra)
+	if err != nil {
+		return
+	}
+
+	sb.CheckSupport()
+	sb.SetSupportPolicy(SupportPolicyBestEffort)
+
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
+	if err != nil {
+		return
+	}
+
+	for _, inodeNumber := range []int{InodeRootDirectory, TestFileInodeNumber} {
+		bgd, err := bgdl.GetWithAbsoluteInode(inodeNumber)
+		if err != nil {
+			continue
+		}
+
+		inode, err := NewInodeWithBlockGroupDescriptor(bgd, inodeNumber)
+		if err != nil {
+			continue
+		}
+
+		exerciseFuzzInode(inode)
+	}
+
+	bgd, err := bgdl.GetWithAbsoluteInode(InodeRootDirectory)
+	if err != nil {
+		return
+	}
+
+	dw, err := NewDirectoryWalk(bgd, InodeRootDirectory)
+	if err != nil {
+		return
+	}
+
+	for i := 0; i < fuzzMaxEntries; i++ {
+		_, de, err := dw.Next()
+		if err != nil {
+			return
+		}
+
+		_ = de.String()
+	}
+}
+
+func exerciseFuzzInode(inode *Inode) {
+	en := NewExtentNavigatorWithInode(inode)
+	ir := NewInodeReader(en)
+
+	io.Copy(ioutil.Discard, io.LimitReader(ir, fuzzMaxBytes))
+
+	if inode.IsDirectory() == false {
+		return
+	}
+
+	db, err := NewDirectoryBrowser(inode)
+	if err != nil {
+		return
+	}
+
+	for i := 0; i < fuzzMaxEntries; i++ {
+		_, err := db.Next()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// getFuzzInodeOffset returns the absolute offset of the given inode in the
+// test image.
+func getFuzzInodeOffset(tb testing.TB, inodeNumber int) (inode *Inode, offset int64) {
+	f, inode, err := GetTestInode(inodeNumber)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	defer f.Close()
+
+	sb := inode.BlockGroupDescriptor().Superblock()
+
+	index := uint64(sb.BlockGroupInodeNumberWithAbsoluteInodeNumber(inodeNumber))
+	offset = int64(inode.BlockGroupDescriptor().InodeTableBlock()*uint64(sb.BlockSize()) + index*uint64(sb.InodeSize()))
+
+	return inode, offset
+}
+
+func FuzzNewSuperblockWithReaderAt(f *testing.F) {
+	image := getFuzzImage(f)
+
+	f.Add(image[Superblock0Offset : Superblock0Offset+SuperblockSize])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		exerciseFuzzImage(spliceFuzzImage(image, Superblock0Offset, data))
+	})
+}
+
+func FuzzNewBlockGroupDescriptorListWithSuperblock(f *testing.F) {
+	image := getFuzzImage(f)
+
+	sb, err := NewSuperblockWithReaderAt(bytes.NewReader(image))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	blockSize := int64(sb.BlockSize())
+	offset := (int64(sb.Data().SFirstDataBlock) + 1) * blockSize
+
+	f.Add(image[offset : offset+blockSize])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		exerciseFuzzImage(spliceFuzzImage(image, offset, data))
+	})
+}
+
+func FuzzNewInodeWithBlockGroupDescriptor(f *testing.F) {
+	image := getFuzzImage(f)
+
+	inode, offset := getFuzzInodeOffset(f, InodeRootDirectory)
+	inodeSize := int64(inode.BlockGroupDescriptor().Superblock().InodeSize())
+
+	f.Add(image[offset : offset+inodeSize])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		exerciseFuzzImage(spliceFuzzImage(image, offset, data))
+	})
+}
+
+func FuzzExtentNavigator_Read(f *testing.F) {
+	image := getFuzzImage(f)
+
+	inode, offset := getFuzzInodeOffset(f, TestFileInodeNumber)
+
+	// IBlock, which holds the root of the extent-tree, is at 0x28 in the inode.
+	iblockOffset := offset + 0x28
+
+	f.Add(inode.Data().IBlock[:])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > len(inode.Data().IBlock) {
+			data = data[:len(inode.Data().IBlock)]
+		}
+
+		exerciseFuzzImage(spliceFuzzImage(image, iblockOffset, data))
+	})
+}
+
+func FuzzDirectoryBrowser_Next(f *testing.F) {
+	image := getFuzzImage(f)
+
+	inode, _ := getFuzzInodeOffset(f, InodeRootDirectory)
+
+	en := NewExtentNavigatorWithInode(inode)
+
+	pBlock, mapped, err := en.MapLogicalBlock(0)
+	if err != nil {
+		f.Fatal(err)
+	} else if mapped == false {
+		f.Fatalf("first block of root directory not mapped")
+	}
+
+	blockSize := int64(inode.BlockGroupDescriptor().Superblock().BlockSize())
+	offset := int64(pBlock) * blockSize
+
+	f.Add(image[offset : offset+blockSize])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		exerciseFuzzImage(spliceFuzzImage(image, offset, data))
+	})
+}
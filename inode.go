@@ -1,14 +1,13 @@
 package ext4
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"sort"
 	"time"
 
 	"encoding/binary"
-
-	"github.com/dsoprea/go-logging"
 )
 
 // Reserved inodes.
@@ -63,6 +62,18 @@ const (
 	InodeFlagProjinherit     = 0x20000000
 )
 
+// File-type bits of `IMode`.
+const (
+	InodeModeTypeMask        = uint16(0xF000)
+	InodeModeFifo            = uint16(0x1000)
+	InodeModeCharacterDevice = uint16(0x2000)
+	InodeModeDirectory       = uint16(0x4000)
+	InodeModeBlockDevice     = uint16(0x6000)
+	InodeModeRegular         = uint16(0x8000)
+	InodeModeSymbolicLink    = uint16(0xA000)
+	InodeModeSocket          = uint16(0xC000)
+)
+
 var (
 	InodeFlagLookup = map[string]int{
 		"Secrm":           InodeFlagSecrm,
@@ -170,51 +181,70 @@ type InodeData struct {
 }
 
 type Inode struct {
-	data *InodeData
-	bgd  *BlockGroupDescriptor
+	data   *InodeData
+	bgd    *BlockGroupDescriptor
+	number int
 }
 
 func (inode *Inode) String() string {
-	return fmt.Sprintf("Inode<>")
+	return fmt.Sprintf("Inode<NUMBER=(%d)>", inode.number)
+}
+
+// Number returns the absolute inode number.
+func (inode *Inode) Number() int {
+	return inode.number
 }
 
 func (inode *Inode) BlockGroupDescriptor() (bgd *BlockGroupDescriptor) {
 	return inode.bgd
 }
 
-func NewInodeWithReadSeeker(bgd *BlockGroupDescriptor, rs io.ReadSeeker, absoluteInodeNumber int) (inode *Inode, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
-
-	// TODO(dustin): !! We might want to find a way to verify this against the bitmap if we can pre-store it in the BGD.
-	// func (bgd *BlockGroupDescriptor) InodeBitmapBlock() uint64 {
-
+// readInode loads the given inode from the inode-table of the given
+// block-group, whatever it uses.
+func readInode(bgd *BlockGroupDescriptor, absoluteInodeNumber int) (inode *Inode, err error) {
 	sb := bgd.Superblock()
 
-	absoluteInodeTableBlock := bgd.InodeTableBlock()
-	offset := uint64(sb.BlockSize()) * absoluteInodeTableBlock
+	if absoluteInodeNumber < 1 || uint64(absoluteInodeNumber) > uint64(sb.Data().SInodesCount) {
+		return nil, fmt.Errorf("inode (%d): %w", absoluteInodeNumber, ErrNotFound)
+	}
+
+	blockSize := uint64(sb.BlockSize())
 
 	// bgRelativeInode is the number of the inode within the inode-table for
 	// this particular block-group. The math only makes sense if we take
 	// (inode - 1) since there is no "inode 0".
 	bgRelativeInode := (uint64(absoluteInodeNumber) - 1) % uint64(sb.Data().SInodesPerGroup)
 
-	offset += bgRelativeInode * uint64(sb.Data().SInodeSize)
+	tableOffset := bgRelativeInode * uint64(sb.InodeSize())
 
-	_, err = rs.Seek(int64(offset), io.SeekStart)
-	log.PanicIf(err)
+	// Inodes never straddle blocks, so we can go through the (cached) block
+	// reads.
+	absoluteInodeBlock := bgd.InodeTableBlock() + tableOffset/blockSize
+	blockOffset := tableOffset % blockSize
 
-	id := new(InodeData)
+	if absoluteInodeBlock >= sb.BlockCount() {
+		return nil, newErrCorrupt("block-group descriptor", 0, "inode-table block (%d) for inode (%d) is beyond the block-count (%d)", absoluteInodeBlock, absoluteInodeNumber, sb.BlockCount())
+	}
 
-	err = binary.Read(rs, binary.LittleEndian, id)
-	log.PanicIf(err)
+	blockData, err := sb.ReadPhysicalBlock(absoluteInodeBlock, blockSize)
+	if err != nil {
+		return nil, err
+	}
 
-	inode = &Inode{
-		data: id,
-		bgd:  bgd,
+	inodeSize := uint64(sb.InodeSize())
+
+	return NewInodeWithBytes(bgd, absoluteInodeNumber, blockData[blockOffset:blockOffset+inodeSize])
+}
+
+// NewInodeWithBlockGroupDescriptor loads the given inode from the inode-table of
+// the given block-group. The read is positional, through the superblock.
+func NewInodeWithBlockGroupDescriptor(bgd *BlockGroupDescriptor, absoluteInodeNumber int) (inode *Inode, err error) {
+	// This doesn't check the bitmap; an unallocated inode is loaded just the
+	// same. Use `IsAllocated` for that.
+
+	inode, err = readInode(bgd, absoluteInodeNumber)
+	if err != nil {
+		return nil, err
 	}
 
 	// Assert our present operating assumptions in order to stabilize
@@ -222,9 +252,48 @@ func NewInodeWithReadSeeker(bgd *BlockGroupDescriptor, rs io.ReadSeeker, absolut
 
 	if inode.Flag(InodeFlagIndex) == true {
 		// TODO(dustin): Might be present in large directories. We might need to implement both mechanisms (this and "linear directories").
-		log.Panicf("hash-tree directories not currently supported")
+		return nil, &ErrUnsupportedFeature{Feature: "hash-tree directories"}
+	} else if inode.Flag(InodeFlagInlineData) == true {
+		return nil, &ErrUnsupportedFeature{Feature: "inline_data"}
+	} else if inode.Flag(InodeFlagCompr) == true {
+		return nil, &ErrUnsupportedFeature{Feature: "compression"}
 	} else if inode.Flag(InodeFlagExtents) == false {
-		log.Panicf("only inodes having extent trees are supported")
+		return nil, &ErrUnsupportedFeature{Feature: "block-mapped (non-extent) inodes"}
+	}
+
+	return inode, nil
+}
+
+// NewInodeWithReadSeeker loads the given inode from the inode-table of the
+// given block-group.
+//
+// Deprecated: Use `NewInodeWithBlockGroupDescriptor`. `rs` isn't used; the
+// inode is read through the superblock that `bgd` came from.
+func NewInodeWithReadSeeker(bgd *BlockGroupDescriptor, rs io.ReadSeeker, absoluteInodeNumber int) (inode *Inode, err error) {
+	return NewInodeWithBlockGroupDescriptor(bgd, absoluteInodeNumber)
+}
+
+// NewInodeWithBytes parses the given inode from its raw bytes (e.g. a copy of
+// its inode-table block from somewhere else, like the journal). Unlike
+// `NewInodeWithBlockGroupDescriptor`, this doesn't check whether the inode
+// uses anything that we can't read.
+func NewInodeWithBytes(bgd *BlockGroupDescriptor, absoluteInodeNumber int, raw []byte) (inode *Inode, err error) {
+	id := new(InodeData)
+
+	// The on-disk inode may be smaller than our struct (e.g. 128-byte inodes),
+	// in which case the trailing fields are left zeroed.
+	padded := make([]byte, binary.Size(id))
+	copy(padded, raw)
+
+	err = binary.Read(bytes.NewBuffer(padded), binary.LittleEndian, id)
+	if err != nil {
+		return nil, err
+	}
+
+	inode = &Inode{
+		data:   id,
+		bgd:    bgd,
+		number: absoluteInodeNumber,
 	}
 
 	return inode, nil
@@ -258,18 +327,56 @@ func (inode *Inode) Size() uint64 {
 	return (uint64(inode.data.ISizeHigh) << 32) | uint64(inode.data.ISizeLo)
 }
 
+// IsInUse indicates whether the inode's own fields say that it's in use: it
+// has a mode and links and hasn't been deleted. The inode bitmap is what
+// actually decides, but this is all there is to go on for a copy of an inode
+// from somewhere else (like the journal).
+func (inode *Inode) IsInUse() bool {
+	return inode.data.IMode != 0 && inode.data.ILinksCount != 0 && inode.data.IDtime == 0
+}
+
+// IsAllocated indicates whether the inode is allocated according to the inode
+// bitmap of its block-group. Inodes in a group that was never initialized, or
+// past the group's in-use part of the inode-table, aren't allocated whatever
+// the bitmap says.
+func (inode *Inode) IsAllocated() (allocated bool, err error) {
+	index := uint64(inode.number-1) % uint64(inode.bgd.sb.Data().SInodesPerGroup)
+
+	if index >= inode.bgd.usedInodeLimit() {
+		return false, nil
+	}
+
+	bm, err := inode.bgd.inodeBitmap()
+	if err != nil {
+		return false, err
+	}
+
+	return bm.IsSet(index), nil
+}
+
 func (inode *Inode) Flag(flag int) bool {
 	return (inode.data.IFlags & uint32(flag)) > 0
 }
 
-func (inode *Inode) Dump() {
-	defer func() {
-		if state := recover(); state != nil {
-			err := log.Wrap(state.(error))
-			log.Panic(err)
-		}
-	}()
+// FileType returns the file-type bits of the mode (one of the `InodeMode*`
+// type constants).
+func (inode *Inode) FileType() uint16 {
+	return inode.data.IMode & InodeModeTypeMask
+}
 
+func (inode *Inode) IsDirectory() bool {
+	return inode.FileType() == InodeModeDirectory
+}
+
+func (inode *Inode) IsRegular() bool {
+	return inode.FileType() == InodeModeRegular
+}
+
+func (inode *Inode) IsSymbolicLink() bool {
+	return inode.FileType() == InodeModeSymbolicLink
+}
+
+func (inode *Inode) Dump() {
 	fmt.Printf("IAtime: [%s]\n", inode.AccessTime())
 	fmt.Printf("ICtime: [%s]\n", inode.InodeChangeTime())
 	fmt.Printf("IMtime: [%s]\n", inode.ModificationTime())
@@ -281,13 +388,6 @@ func (inode *Inode) Dump() {
 }
 
 func (inode *Inode) DumpFlags(includeFalses bool) {
-	defer func() {
-		if state := recover(); state != nil {
-			err := log.Wrap(state.(error))
-			log.Panic(err)
-		}
-	}()
-
 	fmt.Printf("\n")
 	fmt.Printf("Flags:\n")
 	fmt.Printf("\n")
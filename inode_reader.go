@@ -1,10 +1,9 @@
 package ext4
 
 import (
+	"fmt"
 	"io"
 	"math"
-
-	"github.com/dsoprea/go-logging"
 )
 
 // InodeReader fulfills the `io.Reader` interface to read arbitrary amounts of
@@ -29,19 +28,15 @@ func (ir *InodeReader) Offset() uint64 {
 }
 
 func (ir *InodeReader) fill() (err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
-
 	if len(ir.currentBlock) == 0 {
 		if ir.bytesRead >= ir.bytesTotal {
 			return io.EOF
 		}
 
 		data, err := ir.en.Read(ir.bytesRead)
-		log.PanicIf(err)
+		if err != nil {
+			return err
+		}
 
 		ir.currentBlock = data
 		ir.bytesRead += uint64(len(data))
@@ -53,17 +48,9 @@ func (ir *InodeReader) fill() (err error) {
 // Read fills the given slice with data and returns an `io.EOF` error with (0)
 // bytes when done. (`n`) may be less then `len(p)`.
 func (ir *InodeReader) Read(p []byte) (n int, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
-
 	err = ir.fill()
-	if err == io.EOF {
-		return 0, io.EOF
-	} else if err != nil {
-		log.PanicIf(err)
+	if err != nil {
+		return 0, err
 	}
 
 	// Determine how much of the buffer we can fill.
@@ -77,17 +64,9 @@ func (ir *InodeReader) Read(p []byte) (n int, err error) {
 
 // Skip simulates a read but just discards the data.
 func (ir *InodeReader) Skip(n uint64) (skipped uint64, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
-
 	err = ir.fill()
-	if err == io.EOF {
-		return 0, io.EOF
-	} else if err != nil {
-		log.Panic(err)
+	if err != nil {
+		return 0, err
 	}
 
 	currentBytesReadCount := uint64(math.Min(float64(len(ir.currentBlock)), float64(n)))
@@ -95,3 +74,22 @@ func (ir *InodeReader) Skip(n uint64) (skipped uint64, err error) {
 
 	return currentBytesReadCount, nil
 }
+
+// ReadAt fulfills the `io.ReaderAt` interface. It neither uses nor affects the
+// position that `Read` and `Skip` work from, and can be called concurrently.
+func (ir *InodeReader) ReadAt(p []byte, offset int64) (n int, err error) {
+	if offset < 0 {
+		return 0, fmt.Errorf("offset not valid: (%d)", offset)
+	}
+
+	for n < len(p) {
+		data, err := ir.en.Read(uint64(offset) + uint64(n))
+		if err != nil {
+			return n, err
+		}
+
+		n += copy(p[n:], data)
+	}
+
+	return n, nil
+}
@@ -2,6 +2,7 @@ package ext4
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"testing"
 
@@ -16,7 +17,7 @@ func TestInodeReader_Read(t *testing.T) {
 
 	defer f.Close()
 
-	en := NewExtentNavigatorWithReadSeeker(f, inode)
+	en := NewExtentNavigatorWithInode(inode)
 
 	var r io.Reader
 	r = NewInodeReader(en)
@@ -32,13 +33,54 @@ func TestInodeReader_Read(t *testing.T) {
 	}
 }
 
+func TestInodeReader_ReadAt(t *testing.T) {
+	f, inode, err := GetTestInode(TestFileInodeNumber)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	en := NewExtentNavigatorWithInode(inode)
+
+	var ra io.ReaderAt
+	ra = NewInodeReader(en)
+
+	expectedBytes, err := ioutil.ReadFile("assets/thejungle.txt")
+	log.PanicIf(err)
+
+	// Straddle a block boundary.
+
+	buffer := make([]byte, 3000)
+
+	n, err := ra.ReadAt(buffer, 1000)
+	log.PanicIf(err)
+
+	if n != len(buffer) {
+		t.Fatalf("Read count not correct: (%d)", n)
+	} else if bytes.Equal(buffer, expectedBytes[1000:4000]) == false {
+		t.Fatalf("Bytes not read correctly.")
+	}
+
+	// Run off the end.
+
+	offset := int64(len(expectedBytes) - 10)
+
+	n, err = ra.ReadAt(buffer, offset)
+	if err != io.EOF {
+		t.Fatalf("Expected EOF: %v", err)
+	} else if n != 10 {
+		t.Fatalf("Short read count not correct: (%d)", n)
+	} else if bytes.Equal(buffer[:n], expectedBytes[offset:]) == false {
+		t.Fatalf("Short read bytes not correct.")
+	}
+}
+
 func TestInodeReader_Skip(t *testing.T) {
 	f, inode, err := GetTestInode(TestFileInodeNumber)
 	log.PanicIf(err)
 
 	defer f.Close()
 
-	en := NewExtentNavigatorWithReadSeeker(f, inode)
+	en := NewExtentNavigatorWithInode(inode)
 
 	ir := NewInodeReader(en)
 
@@ -60,3 +102,42 @@ func TestInodeReader_Skip(t *testing.T) {
 		t.Fatalf("Bytes not read correctly.")
 	}
 }
+
+func TestInodeReader_Read_Concurrent(t *testing.T) {
+	f, inode, err := GetTestInode(TestFileInodeNumber)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	expectedBytes, err := ioutil.ReadFile("assets/thejungle.txt")
+	log.PanicIf(err)
+
+	// Every reader shares the same inode (and so the same superblock and
+	// file). With positional I/O, none of them should see the others' reads.
+
+	readerCount := 8
+	errs := make(chan error, readerCount)
+
+	for i := 0; i < readerCount; i++ {
+		go func() {
+			en := NewExtentNavigatorWithInode(inode)
+			ir := NewInodeReader(en)
+
+			actualBytes, err := ioutil.ReadAll(ir)
+			if err != nil {
+				errs <- err
+			} else if bytes.Compare(actualBytes, expectedBytes) != 0 {
+				errs <- fmt.Errorf("bytes not read correctly")
+			} else {
+				errs <- nil
+			}
+		}()
+	}
+
+	for i := 0; i < readerCount; i++ {
+		err := <-errs
+		if err != nil {
+			t.Fatalf("Concurrent read failed: %s", err)
+		}
+	}
+}
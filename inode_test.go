@@ -2,7 +2,6 @@ package ext4
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"path"
 	"testing"
@@ -10,7 +9,7 @@ import (
 	"github.com/dsoprea/go-logging"
 )
 
-func TestNewInodeWithReadSeeker_RootInode(t *testing.T) {
+func TestNewInodeWithBlockGroupDescriptor_RootInode(t *testing.T) {
 	filepath := path.Join(assetsPath, "tiny.ext4")
 
 	f, err := os.Open(filepath)
@@ -18,13 +17,10 @@ func TestNewInodeWithReadSeeker_RootInode(t *testing.T) {
 
 	defer f.Close()
 
-	_, err = f.Seek(Superblock0Offset, io.SeekStart)
+	sb, err := NewSuperblockWithReaderAt(f)
 	log.PanicIf(err)
 
-	sb, err := NewSuperblockWithReader(f)
-	log.PanicIf(err)
-
-	bgdl, err := NewBlockGroupDescriptorListWithReadSeeker(f, sb)
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
 	log.PanicIf(err)
 
 	inodeNumber := 2
@@ -33,7 +29,7 @@ func TestNewInodeWithReadSeeker_RootInode(t *testing.T) {
 	bgd, err := bgdl.GetWithAbsoluteInode(inodeNumber)
 	log.PanicIf(err)
 
-	inode, err := NewInodeWithReadSeeker(bgd, f, inodeNumber)
+	inode, err := NewInodeWithBlockGroupDescriptor(bgd, inodeNumber)
 	log.PanicIf(err)
 
 	actualTimestamp := inode.InodeChangeTime().UTC().String()
@@ -42,7 +38,7 @@ func TestNewInodeWithReadSeeker_RootInode(t *testing.T) {
 	}
 }
 
-func ExampleNewInodeWithReadSeeker_RootDirectoryInode() {
+func ExampleNewInodeWithBlockGroupDescriptor_rootDirectoryInode() {
 	filepath := path.Join(assetsPath, "tiny.ext4")
 
 	f, err := os.Open(filepath)
@@ -50,13 +46,10 @@ func ExampleNewInodeWithReadSeeker_RootDirectoryInode() {
 
 	defer f.Close()
 
-	_, err = f.Seek(Superblock0Offset, io.SeekStart)
-	log.PanicIf(err)
-
-	sb, err := NewSuperblockWithReader(f)
+	sb, err := NewSuperblockWithReaderAt(f)
 	log.PanicIf(err)
 
-	bgdl, err := NewBlockGroupDescriptorListWithReadSeeker(f, sb)
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
 	log.PanicIf(err)
 
 	inodeNumber := InodeRootDirectory
@@ -64,7 +57,7 @@ func ExampleNewInodeWithReadSeeker_RootDirectoryInode() {
 	bgd, err := bgdl.GetWithAbsoluteInode(inodeNumber)
 	log.PanicIf(err)
 
-	inode, err := NewInodeWithReadSeeker(bgd, f, inodeNumber)
+	inode, err := NewInodeWithBlockGroupDescriptor(bgd, inodeNumber)
 	log.PanicIf(err)
 
 	fmt.Println(inode.InodeChangeTime().UTC())
@@ -74,7 +67,7 @@ func ExampleNewInodeWithReadSeeker_RootDirectoryInode() {
 	// 2018-09-08 06:08:45 +0000 UTC
 }
 
-func TestNewInodeWithReadSeeker_FileInode(t *testing.T) {
+func TestNewInodeWithBlockGroupDescriptor_FileInode(t *testing.T) {
 	filepath := path.Join(assetsPath, "tiny.ext4")
 
 	f, err := os.Open(filepath)
@@ -82,13 +75,10 @@ func TestNewInodeWithReadSeeker_FileInode(t *testing.T) {
 
 	defer f.Close()
 
-	_, err = f.Seek(Superblock0Offset, io.SeekStart)
+	sb, err := NewSuperblockWithReaderAt(f)
 	log.PanicIf(err)
 
-	sb, err := NewSuperblockWithReader(f)
-	log.PanicIf(err)
-
-	bgdl, err := NewBlockGroupDescriptorListWithReadSeeker(f, sb)
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
 	log.PanicIf(err)
 
 	inodeNumber := 12
@@ -96,7 +86,7 @@ func TestNewInodeWithReadSeeker_FileInode(t *testing.T) {
 	bgd, err := bgdl.GetWithAbsoluteInode(inodeNumber)
 	log.PanicIf(err)
 
-	inode, err := NewInodeWithReadSeeker(bgd, f, inodeNumber)
+	inode, err := NewInodeWithBlockGroupDescriptor(bgd, inodeNumber)
 	log.PanicIf(err)
 
 	actualTimestamp := inode.InodeChangeTime().UTC().String()
@@ -105,7 +95,7 @@ func TestNewInodeWithReadSeeker_FileInode(t *testing.T) {
 	}
 }
 
-func ExampleNewInodeWithReadSeeker_FileInode() {
+func ExampleNewInodeWithBlockGroupDescriptor_fileInode() {
 	filepath := path.Join(assetsPath, "tiny.ext4")
 
 	f, err := os.Open(filepath)
@@ -113,13 +103,10 @@ func ExampleNewInodeWithReadSeeker_FileInode() {
 
 	defer f.Close()
 
-	_, err = f.Seek(Superblock0Offset, io.SeekStart)
-	log.PanicIf(err)
-
-	sb, err := NewSuperblockWithReader(f)
+	sb, err := NewSuperblockWithReaderAt(f)
 	log.PanicIf(err)
 
-	bgdl, err := NewBlockGroupDescriptorListWithReadSeeker(f, sb)
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
 	log.PanicIf(err)
 
 	inodeNumber := 12
@@ -127,7 +114,7 @@ func ExampleNewInodeWithReadSeeker_FileInode() {
 	bgd, err := bgdl.GetWithAbsoluteInode(inodeNumber)
 	log.PanicIf(err)
 
-	inode, err := NewInodeWithReadSeeker(bgd, f, inodeNumber)
+	inode, err := NewInodeWithBlockGroupDescriptor(bgd, inodeNumber)
 	log.PanicIf(err)
 
 	fmt.Println(inode.InodeChangeTime().UTC())
@@ -136,3 +123,57 @@ func ExampleNewInodeWithReadSeeker_FileInode() {
 	//
 	// 2018-09-08 06:08:45 +0000 UTC
 }
+
+func TestInode_IsAllocated(t *testing.T) {
+	f, _, bgdl := getTestBitmapsFilesystem()
+	defer f.Close()
+
+	expected := map[int]bool{
+		2:  true,
+		12: true,
+		13: true,
+		14: false,
+		32: false,
+
+		// In groups that were never initialized.
+		33:  false,
+		128: false,
+	}
+
+	for inodeNumber, allocated := range expected {
+		bgd, err := bgdl.GetWithAbsoluteInode(inodeNumber)
+		log.PanicIf(err)
+
+		inode, err := readInode(bgd, inodeNumber)
+		log.PanicIf(err)
+
+		isAllocated, err := inode.IsAllocated()
+		log.PanicIf(err)
+
+		if isAllocated != allocated {
+			t.Fatalf("Inode (%d) allocation not correct: [%v]", inodeNumber, isAllocated)
+		}
+	}
+}
+
+func TestInode_IsAllocated_ItableUnused(t *testing.T) {
+	f, _, bgdl := getTestBitmapsFilesystem()
+	defer f.Close()
+
+	bgd, err := bgdl.Get(0)
+	log.PanicIf(err)
+
+	inode, err := readInode(bgd, 12)
+	log.PanicIf(err)
+
+	// Only the first (11) inodes of the group can be in use now, whatever the
+	// bitmap says.
+	bgd.Data().BgItableUnusedLo = 21
+
+	isAllocated, err := inode.IsAllocated()
+	log.PanicIf(err)
+
+	if isAllocated != false {
+		t.Fatalf("Inode past the in-use part of the table should not be allocated.")
+	}
+}
@@ -0,0 +1,83 @@
+package ext4
+
+import (
+	"io"
+)
+
+// InodeWalk steps through every allocated inode of the filesystem, group by
+// group, according to the inode bitmaps. This finds every file without going
+// through the directories, so it also turns up inodes that no directory refers
+// to (orphans).
+//
+// Inodes are returned whatever they use (e.g. inline data or block maps), so
+// the caller has to check before trying to read them.
+type InodeWalk struct {
+	bgdl *BlockGroupDescriptorList
+
+	group  int
+	index  uint64
+	limit  uint64
+	bitmap *Bitmap
+}
+
+// NewInodeWalk returns an `InodeWalk` over the groups of `bgdl`.
+func NewInodeWalk(bgdl *BlockGroupDescriptorList) *InodeWalk {
+	return &InodeWalk{
+		bgdl:  bgdl,
+		group: -1,
+	}
+}
+
+// nextGroup moves to the next group that can have any inodes in use. It
+// returns false when there are no more groups.
+func (iw *InodeWalk) nextGroup() (found bool, err error) {
+	for {
+		iw.group++
+
+		bgd, err := iw.bgdl.Get(iw.group)
+		if err != nil {
+			return false, nil
+		}
+
+		iw.index = 0
+		iw.limit = bgd.usedInodeLimit()
+
+		if iw.limit == 0 {
+			continue
+		}
+
+		iw.bitmap, err = bgd.inodeBitmap()
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+}
+
+// Next returns the next allocated inode, or `io.EOF` when there are no more.
+func (iw *InodeWalk) Next() (inode *Inode, err error) {
+	inodesPerGroup := int(iw.bgdl.sb.Data().SInodesPerGroup)
+
+	for {
+		if iw.group < 0 || iw.index >= iw.limit {
+			found, err := iw.nextGroup()
+			if err != nil {
+				return nil, err
+			} else if found == false {
+				return nil, io.EOF
+			}
+		}
+
+		index := iw.index
+		iw.index++
+
+		if iw.bitmap.IsSet(index) == false {
+			continue
+		}
+
+		bgd, _ := iw.bgdl.Get(iw.group)
+
+		return readInode(bgd, iw.group*inodesPerGroup+int(index)+1)
+	}
+}
@@ -0,0 +1,131 @@
+package ext4
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestInodeWalk_Next(t *testing.T) {
+	f, _, bgdl := getTestBitmapsFilesystem()
+	defer f.Close()
+
+	iw := NewInodeWalk(bgdl)
+
+	numbers := make([]int, 0)
+
+	for {
+		inode, err := iw.Next()
+		if err == io.EOF {
+			break
+		}
+
+		log.PanicIf(err)
+
+		numbers = append(numbers, inode.Number())
+	}
+
+	expected := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13}
+	if reflect.DeepEqual(numbers, expected) == false {
+		t.Fatalf("Inodes not correct: %v", numbers)
+	}
+
+	// It stays finished.
+	_, err := iw.Next()
+	if err != io.EOF {
+		t.Fatalf("Expected EOF: %v", err)
+	}
+}
+
+func TestInodeWalk_Next_Orphans(t *testing.T) {
+	f, sb, bgdl := getTestBitmapsFilesystem()
+	defer f.Close()
+
+	// Everything that a directory refers to.
+
+	bgd, err := bgdl.GetWithAbsoluteInode(TestDirectoryInodeNumber)
+	log.PanicIf(err)
+
+	dw, err := NewDirectoryWalk(bgd, TestDirectoryInodeNumber)
+	log.PanicIf(err)
+
+	linked := map[int]bool{
+		TestDirectoryInodeNumber: true,
+	}
+
+	for {
+		_, de, err := dw.Next()
+		if err == io.EOF {
+			break
+		}
+
+		log.PanicIf(err)
+
+		linked[int(de.Data().Inode)] = true
+	}
+
+	// Every regular file that's allocated but not linked.
+
+	iw := NewInodeWalk(bgdl)
+
+	orphans := make([]int, 0)
+
+	for {
+		inode, err := iw.Next()
+		if err == io.EOF {
+			break
+		}
+
+		log.PanicIf(err)
+
+		if inode.Number() < int(sb.Data().SFirstIno) || inode.IsRegular() == false {
+			continue
+		}
+
+		if linked[inode.Number()] == false {
+			orphans = append(orphans, inode.Number())
+		}
+	}
+
+	if reflect.DeepEqual(orphans, []int{13}) == false {
+		t.Fatalf("Orphans not correct: %v", orphans)
+	}
+}
+
+func ExampleInodeWalk_Next() {
+	filepath := path.Join(assetsPath, "tiny.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
+	log.PanicIf(err)
+
+	iw := NewInodeWalk(bgdl)
+
+	for {
+		inode, err := iw.Next()
+		if err == io.EOF {
+			break
+		}
+
+		log.PanicIf(err)
+
+		if inode.IsRegular() == true && inode.Number() >= int(sb.Data().SFirstIno) {
+			fmt.Printf("%s SIZE=(%d)\n", inode, inode.Size())
+		}
+	}
+
+	// Output:
+	// Inode<NUMBER=(12)> SIZE=(849597)
+}
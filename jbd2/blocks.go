@@ -30,35 +30,67 @@ const (
 	JbtfLastTag               = uint16(0x8) // This is the last tag in this descriptor block.
 )
 
-// JournalBlockTag32NoCsumV3 (journal_block_tag_s struct) is a subelement of the
-// descriptor block.
-type JournalBlockTag32NoCsumV3 struct {
-	// 0x0
+// JournalBlockTag (journal_block_tag_s or journal_block_tag3_s struct) is a
+// subelement of the descriptor block. Which of the fields are actually on disk,
+// and how wide they are, depends on the journal's features (see
+// `JournalSuperblock.TagSize`).
+type JournalBlockTag struct {
 	TBlocknr uint32 // Lower 32-bits of the location of where the corresponding data block should end up on disk.
 
-	// 0x4
-	TChecksum uint16 // Checksum of the journal UUID, the sequence number, and the data block. Note that only the lower 16 bits are stored.
+	// Only present if the super block indicates support for 64-bit block numbers.
+	TBlocknrHigh uint32 // Upper 32-bits of the location of where the corresponding data block should end up on disk.
 
-	// 0x6
-	TFlags uint16 // Flags that go with the descriptor. See the table jbd2_tag_flags for more info.
+	// Checksum of the journal UUID, the sequence number, and the data block. Only the lower 16 bits are stored unless the journal is csum v3.
+	TChecksum uint32
 
-	// Only present if the super block indicates support for 64-bit block numbers.
-	// TBlocknrHigh uint32 //  Upper 32-bits of the location of where the corresponding data block should end up on disk.
+	TFlags uint16 // Flags that go with the descriptor. See the table jbd2_tag_flags for more info.
 
-	// 0x8 or 0xC
-	// This field appears to be open coded. It always comes at the end of the tag, after t_flags or t_blocknr_high. This field is not present if the “same UUID” flag is set.
+	// This field is not present if the "same UUID" flag is set.
 	Uuid [16]byte //  A UUID to go with this tag. This field appears to be copied from the j_uuid field in struct journal_s, but only tune2fs touches that field.
 }
 
-func (jbtnc3 *JournalBlockTag32NoCsumV3) String() string {
-	return fmt.Sprintf("JournalBlockTag32<TBLOCKNR=(%d) TCHECKSUM=(%d) TFLAGS=(%d) UUID=[%032x]>", jbtnc3.TBlocknr, jbtnc3.TChecksum, jbtnc3.TFlags, jbtnc3.Uuid)
+// Blocknr returns the filesystem block that the data belongs at.
+func (jbt *JournalBlockTag) Blocknr() uint64 {
+	return (uint64(jbt.TBlocknrHigh) << 32) | uint64(jbt.TBlocknr)
+}
+
+func (jbt *JournalBlockTag) String() string {
+	return fmt.Sprintf("JournalBlockTag<TBLOCKNR=(%d) TCHECKSUM=(%d) TFLAGS=(%d) UUID=[%032x]>", jbt.Blocknr(), jbt.TChecksum, jbt.TFlags, jbt.Uuid)
+}
+
+// JournalDataBlock is one of the blocks that follow a descriptor block, paired
+// with the tag that describes it.
+type JournalDataBlock struct {
+	Tag JournalBlockTag
+
+	// Data is the block as it's supposed to be written to the filesystem. If
+	// it was escaped in the journal, the magic-bytes have been put back.
+	Data []byte
+
+	// JournalBlock is where the data was found in the journal.
+	JournalBlock uint32
+
+	// ChecksumValid indicates whether the block as stored in the journal
+	// matched the checksum in its tag. Always true if the journal doesn't have
+	// metadata checksums.
+	ChecksumValid bool
+}
+
+// Blocknr returns the filesystem block that the data belongs at.
+func (jdb *JournalDataBlock) Blocknr() uint64 {
+	return jdb.Tag.Blocknr()
+}
+
+func (jdb *JournalDataBlock) String() string {
+	return fmt.Sprintf("JournalDataBlock<BLOCKNR=(%d) LENGTH=(%d) CHECKSUM-VALID=[%v]>", jdb.Blocknr(), len(jdb.Data), jdb.ChecksumValid)
 }
 
 // JournalDescriptorBlock is a top-level journal block-type that describes
-// where the data is supposed to go on disk.
+// where the data is supposed to go on disk. There's one data block for every
+// tag, in the same order.
 type JournalDescriptorBlock struct {
-	Tags            []JournalBlockTag32NoCsumV3
-	transactionData []byte
+	Tags       []JournalBlockTag
+	DataBlocks []JournalDataBlock
 
 	JournalCommonBlockType
 }
@@ -67,20 +99,26 @@ func (*JournalDescriptorBlock) Type() uint32 {
 	return BtDescriptor
 }
 
-func (jdb *JournalDescriptorBlock) SetTransactionData(transactionData []byte) {
-	jdb.transactionData = transactionData
+// DataLength returns the total size of the data blocks.
+func (jdb *JournalDescriptorBlock) DataLength() int {
+	length := 0
+	for _, dataBlock := range jdb.DataBlocks {
+		length += len(dataBlock.Data)
+	}
+
+	return length
 }
 
 func (jdb *JournalDescriptorBlock) String() string {
-	return fmt.Sprintf("DescriptorBlock<TAGS=(%d) DATA-LENGTH=(%d)>", len(jdb.Tags), len(jdb.transactionData))
+	return fmt.Sprintf("DescriptorBlock<TAGS=(%d) DATA-LENGTH=(%d)>", len(jdb.Tags), jdb.DataLength())
 }
 
 func (jdb *JournalDescriptorBlock) Dump() {
 	fmt.Printf("%s\n", jdb)
 	fmt.Printf("\n")
 
-	for i, jbtnc3 := range jdb.Tags {
-		fmt.Printf("  TAG(%d): %s\n", i, jbtnc3.String())
+	for i, jbt := range jdb.Tags {
+		fmt.Printf("  TAG(%d): %s\n", i, jbt.String())
 	}
 
 	fmt.Printf("\n")
@@ -130,23 +168,30 @@ func (jcb *JournalCommitBlock) String() string {
 	return fmt.Sprintf("CommitBlock<HChksumType=(%d) HChksumSize=(%d) CommitTime=[%s]>", jcb.data.HChksumType, jcb.data.HChksumSize, jcb.CommitTime().UTC())
 }
 
-// JournalRevokeBlock32Data (jbd2_journal_revoke_header_s struct) is top-level
-// journal block-type.
-type JournalRevokeBlock32Data struct {
+const (
+	// JournalRevokeHeaderSize is the size of the header at the top of a revoke
+	// block (the common header plus `RCount`).
+	JournalRevokeHeaderSize = JournalHeaderSize + 4
+)
+
+// JournalRevokeBlockData (jbd2_journal_revoke_header_s struct) is top-level
+// journal block-type. The records are four bytes wide, or eight if the
+// journal has the 64-bit feature.
+type JournalRevokeBlockData struct {
 	// 0xC
-	RCount uint32 // Number of bytes used in this block.
+	RCount uint32 // Number of bytes used in this block, including the header.
 
 	// 0x10
-	Blocks []uint32 // Blocks to revoke.
+	Blocks []uint64 // Blocks to revoke.
 }
 
 type JournalRevokeBlock struct {
-	data *JournalRevokeBlock32Data
+	data *JournalRevokeBlockData
 
 	JournalCommonBlockType
 }
 
-func (jrb *JournalRevokeBlock) Data() *JournalRevokeBlock32Data {
+func (jrb *JournalRevokeBlock) Data() *JournalRevokeBlockData {
 	return jrb.data
 }
 
@@ -0,0 +1,217 @@
+package jbd2
+
+import (
+	"bytes"
+	"hash"
+
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+const (
+	// JournalBlockTailSize is the size of the checksum (jbd2_journal_block_tail)
+	// at the end of descriptor and revoke blocks when metadata checksums are
+	// enabled.
+	JournalBlockTailSize = 4
+
+	// commitBlockChecksumOffset is where the first checksum (`HChksum`) is in
+	// a commit block.
+	commitBlockChecksumOffset = 0x10
+
+	// crc32BePolynomial is the CRC-32 polynomial, for the kernel's crc32_be().
+	crc32BePolynomial = uint32(0x04c11db7)
+)
+
+var (
+	castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+	crc32BeTable    = makeCrc32BeTable()
+)
+
+// crc32c continues a checksum the way that the kernel's crc32c() does, which
+// (unlike `crc32.Update`) doesn't invert the value on the way in or out.
+func crc32c(crc uint32, data []byte) uint32 {
+	return ^crc32.Update(^crc, castagnoliTable, data)
+}
+
+// makeCrc32BeTable returns the table for the most-significant-bit-first CRC-32
+// that `crc32Be` calculates. hash/crc32 only does the reflected one.
+func makeCrc32BeTable() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+
+		for j := 0; j < 8; j++ {
+			if (crc & 0x80000000) > 0 {
+				crc = (crc << 1) ^ crc32BePolynomial
+			} else {
+				crc <<= 1
+			}
+		}
+
+		table[i] = crc
+	}
+
+	return table
+}
+
+// crc32Be continues a checksum the way that the kernel's crc32_be() does,
+// which is what `JsbFeatureCompatChecksum` uses. Like `crc32c`, the value
+// isn't inverted on the way in or out.
+func crc32Be(crc uint32, data []byte) uint32 {
+	for _, b := range data {
+		crc = (crc << 8) ^ crc32BeTable[byte(crc>>24)^b]
+	}
+
+	return crc
+}
+
+// HasMetadataChecksums indicates whether the superblock, descriptor blocks,
+// revoke blocks, and block tags carry checksums (csum v2 or v3).
+func (jsb *JournalSuperblock) HasMetadataChecksums() bool {
+	return jsb.HasIncompatibleFeature(JsbFeatureIncompatCsumV2) == true || jsb.HasIncompatibleFeature(JsbFeatureIncompatCsumV3) == true
+}
+
+// superblockChecksum calculates the checksum of the superblock, which is
+// taken with the checksum field zeroed.
+func (jsb *JournalSuperblock) superblockChecksum() (checksum uint32, err error) {
+	jsbd := *jsb.data
+	jsbd.SChecksum = 0
+
+	b := new(bytes.Buffer)
+
+	err = binary.Write(b, binary.BigEndian, jsbd)
+	if err != nil {
+		return 0, err
+	}
+
+	return crc32c(0xffffffff, b.Bytes()), nil
+}
+
+// blockTailChecksum calculates the tail checksum of a whole descriptor or
+// revoke block, which is taken with the tail zeroed.
+func (jsb *JournalSuperblock) blockTailChecksum(block []byte) uint32 {
+	tailOffset := len(block) - JournalBlockTailSize
+
+	checksum := crc32c(jsb.checksumSeed, block[:tailOffset])
+	return crc32c(checksum, []byte{0, 0, 0, 0})
+}
+
+// VerifyBlockTailChecksum checks the tail checksum of a whole descriptor or
+// revoke block. Always true if metadata checksums aren't enabled.
+func (jsb *JournalSuperblock) VerifyBlockTailChecksum(block []byte) bool {
+	if jsb.HasMetadataChecksums() == false {
+		return true
+	} else if len(block) < JournalBlockTailSize {
+		return false
+	}
+
+	provided := binary.BigEndian.Uint32(block[len(block)-JournalBlockTailSize:])
+	return jsb.blockTailChecksum(block) == provided
+}
+
+// dataBlockChecksum calculates the checksum of a data block, as it's stored in
+// the journal, for its tag. It covers the sequence of the transaction that it
+// belongs to. v2 only stores the lower sixteen bits.
+func (jsb *JournalSuperblock) dataBlockChecksum(sequence uint32, data []byte) uint32 {
+	sequenceBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(sequenceBytes, sequence)
+
+	checksum := crc32c(jsb.checksumSeed, sequenceBytes)
+	checksum = crc32c(checksum, data)
+
+	if jsb.HasIncompatibleFeature(JsbFeatureIncompatCsumV3) == true {
+		return checksum
+	}
+
+	return checksum & 0xffff
+}
+
+// VerifyDataBlockChecksum checks a data block, exactly as it was stored in the
+// journal (before unescaping), against the checksum in its tag. Always true if
+// metadata checksums aren't enabled.
+func (jsb *JournalSuperblock) VerifyDataBlockChecksum(tag *JournalBlockTag, sequence uint32, data []byte) bool {
+	if jsb.HasMetadataChecksums() == false {
+		return true
+	}
+
+	return tag.TChecksum == jsb.dataBlockChecksum(sequence, data)
+}
+
+// commitBlockChecksum calculates the checksum of a whole commit block, which
+// is taken with the checksum zeroed.
+func (jsb *JournalSuperblock) commitBlockChecksum(block []byte) uint32 {
+	checksum := crc32c(jsb.checksumSeed, block[:commitBlockChecksumOffset])
+	checksum = crc32c(checksum, []byte{0, 0, 0, 0})
+
+	return crc32c(checksum, block[commitBlockChecksumOffset+4:])
+}
+
+// VerifyCommitBlockChecksum checks the checksum of a whole commit block.
+// Always true if metadata checksums aren't enabled.
+func (jsb *JournalSuperblock) VerifyCommitBlockChecksum(block []byte) bool {
+	if jsb.HasMetadataChecksums() == false {
+		return true
+	} else if len(block) < commitBlockChecksumOffset+4 {
+		return false
+	}
+
+	provided := binary.BigEndian.Uint32(block[commitBlockChecksumOffset:])
+	return jsb.commitBlockChecksum(block) == provided
+}
+
+// transactionChecksums accumulates the checksums that a commit block can have
+// of its transaction with `JsbFeatureCompatChecksum`. They cover the
+// descriptor blocks and the data blocks, as they were logged, but not the
+// revoke blocks. The kernel only writes crc32, but md5 and sha1 are also
+// defined.
+type transactionChecksums struct {
+	crc32 uint32
+	md5   hash.Hash
+	sha1  hash.Hash
+}
+
+func newTransactionChecksums() *transactionChecksums {
+	return &transactionChecksums{
+		crc32: 0xffffffff,
+		md5:   md5.New(),
+		sha1:  sha1.New(),
+	}
+}
+
+// update adds the next descriptor or data block.
+func (tc *transactionChecksums) update(block []byte) {
+	tc.crc32 = crc32Be(tc.crc32, block)
+	tc.md5.Write(block)
+	tc.sha1.Write(block)
+}
+
+// verify checks the checksum in the given commit block. Like in the kernel, a
+// commit block without one (with a zero type, size, and checksum) passes.
+func (tc *transactionChecksums) verify(jcbd *JournalCommitBlockData) bool {
+	var expected []byte
+
+	switch jcbd.HChksumType {
+	case 0:
+		return jcbd.HChksumSize == 0 && jcbd.HChksum[0] == 0
+	case JccCrc32:
+		return jcbd.HChksumSize == 4 && jcbd.HChksum[0] == tc.crc32
+	case JccMd5:
+		expected = tc.md5.Sum(nil)
+	case JccSha1:
+		expected = tc.sha1.Sum(nil)
+	default:
+		return false
+	}
+
+	if int(jcbd.HChksumSize) != len(expected) {
+		return false
+	}
+
+	stored := make([]byte, Jbd2ChecksumBytes*4)
+	for i, word := range jcbd.HChksum {
+		binary.BigEndian.PutUint32(stored[i*4:], word)
+	}
+
+	return bytes.Equal(stored[:len(expected)], expected)
+}
@@ -0,0 +1,221 @@
+package jbd2
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"testing"
+
+	"io/ioutil"
+
+	"github.com/dsoprea/go-ext4"
+	"github.com/dsoprea/go-logging"
+)
+
+// getTestJournalBytes returns the whole journal from the given test image.
+func getTestJournalBytes(filename string) []byte {
+	filepath := path.Join(assetsPath, filename)
+
+	f, inode, err := GetJournalInode(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	en := ext4.NewExtentNavigatorWithInode(inode)
+	ir := ext4.NewInodeReader(en)
+
+	data, err := ioutil.ReadAll(ir)
+	log.PanicIf(err)
+
+	return data
+}
+
+func TestNewJournalSuperblock_Checksummed(t *testing.T) {
+	journal := getTestJournalBytes("journal_csum.ext4")
+
+	jsb, err := NewJournalSuperblock(bytes.NewReader(journal))
+	log.PanicIf(err)
+
+	if jsb.HasMetadataChecksums() != true {
+		t.Fatalf("Expected metadata checksums.")
+	} else if jsb.HasIncompatibleFeature(JsbFeatureIncompat64bit) != true {
+		t.Fatalf("Expected 64-bit journal.")
+	} else if jsb.TagSize() != 16 {
+		t.Fatalf("Tag size not correct: (%d)", jsb.TagSize())
+	}
+}
+
+func TestNewJournalSuperblock_BadChecksum(t *testing.T) {
+	journal := getTestJournalBytes("journal_csum.ext4")
+
+	// Somewhere in the padding.
+	journal[0x80] ^= 0xff
+
+	_, err := NewJournalSuperblock(bytes.NewReader(journal))
+
+	var errCorrupt *ext4.ErrCorrupt
+	if errors.As(err, &errCorrupt) == false {
+		t.Fatalf("Expected corrupt error: %v", err)
+	}
+}
+
+func TestJournalSuperblock_NextBlock_Checksummed(t *testing.T) {
+	journal := getTestJournalBytes("journal_csum.ext4")
+
+	r := bytes.NewReader(journal)
+
+	jsb, err := NewJournalSuperblock(r)
+	log.PanicIf(err)
+
+	jb, err := jsb.NextBlock(r)
+	log.PanicIf(err)
+
+	jdb := jb.(*JournalDescriptorBlock)
+
+	if len(jdb.Tags) != 3 {
+		t.Fatalf("Tag count not correct: (%d)", len(jdb.Tags))
+	}
+
+	expectedFlags := []uint16{JbtfDataMatchesMagicBytes, JbtfSameUuidAsPrevious, JbtfSameUuidAsPrevious | JbtfLastTag}
+
+	// The data blocks directly follow the descriptor (journal block 1).
+
+	blockSize := int(jsb.Data().SBlocksize)
+
+	for i, jbt := range jdb.Tags {
+		if jbt.Blocknr() != uint64(3000+i) {
+			t.Fatalf("Tag (%d) block not correct: (%d)", i, jbt.Blocknr())
+		} else if jbt.TFlags != expectedFlags[i] {
+			t.Fatalf("Tag (%d) flags not correct: (%d)", i, jbt.TFlags)
+		}
+
+		offset := (2 + i) * blockSize
+		data := journal[offset : offset+blockSize]
+
+		if jsb.VerifyDataBlockChecksum(&jbt, 1, data) != true {
+			t.Fatalf("Tag (%d) data checksum not correct.", i)
+		} else if jsb.VerifyDataBlockChecksum(&jbt, 2, data) != false {
+			t.Fatalf("Tag (%d) data checksum should cover the sequence.", i)
+		}
+
+		corrupted := make([]byte, len(data))
+		copy(corrupted, data)
+		corrupted[100] ^= 0xff
+
+		if jsb.VerifyDataBlockChecksum(&jbt, 1, corrupted) != false {
+			t.Fatalf("Tag (%d) data checksum should have failed.", i)
+		}
+	}
+}
+
+func TestJournalSuperblock_NextBlock_BadTailChecksum(t *testing.T) {
+	journal := getTestJournalBytes("journal_csum.ext4")
+
+	blockSize := 1024
+
+	// Change the unused space after the tags in the descriptor (journal block
+	// 1), which only the checksum would notice.
+	journal[blockSize+500] ^= 0xff
+
+	r := bytes.NewReader(journal)
+
+	jsb, err := NewJournalSuperblock(r)
+	log.PanicIf(err)
+
+	_, err = jsb.NextBlock(r)
+
+	var errCorrupt *ext4.ErrCorrupt
+	if errors.As(err, &errCorrupt) == false {
+		t.Fatalf("Expected corrupt error: %v", err)
+	}
+}
+
+func TestJournalSuperblock_TagSize(t *testing.T) {
+	cases := []struct {
+		incompat uint32
+		size     int
+	}{
+		{0, 8},
+		{JsbFeatureIncompat64bit, 12},
+		{JsbFeatureIncompatCsumV2, 10},
+		{JsbFeatureIncompatCsumV2 | JsbFeatureIncompat64bit, 14},
+		{JsbFeatureIncompatCsumV3, 16},
+		{JsbFeatureIncompatCsumV3 | JsbFeatureIncompat64bit, 16},
+	}
+
+	for _, c := range cases {
+		jsb := &JournalSuperblock{
+			data: &JournalSuperblockData{
+				SFeatureIncompat: c.incompat,
+			},
+		}
+
+		if jsb.TagSize() != c.size {
+			t.Fatalf("Tag size for (0x%02x) not correct: (%d) != (%d)", c.incompat, jsb.TagSize(), c.size)
+		}
+	}
+}
+
+func TestJournalSuperblock_parseBlockTag_64bit(t *testing.T) {
+	jsb := &JournalSuperblock{
+		data: &JournalSuperblockData{
+			SFeatureIncompat: JsbFeatureIncompat64bit,
+		},
+	}
+
+	data := []byte{
+		0x00, 0x00, 0x00, 0x0a, // blocknr
+		0x12, 0x34, // checksum
+		0x00, 0x0a, // flags (same UUID, last)
+		0x00, 0x00, 0x00, 0x01, // blocknr high
+	}
+
+	jbt, n, err := jsb.parseBlockTag(data)
+	log.PanicIf(err)
+
+	if n != 12 {
+		t.Fatalf("Size not correct: (%d)", n)
+	} else if jbt.Blocknr() != 0x10000000a {
+		t.Fatalf("Block not correct: (%d)", jbt.Blocknr())
+	} else if jbt.TChecksum != 0x1234 {
+		t.Fatalf("Checksum not correct: (%04x)", jbt.TChecksum)
+	} else if jbt.TFlags != JbtfSameUuidAsPrevious|JbtfLastTag {
+		t.Fatalf("Flags not correct: (%d)", jbt.TFlags)
+	}
+}
+
+func TestCrc32Be(t *testing.T) {
+	// The standard check value of CRC-32/BZIP2, which inverts on the way in
+	// and out.
+	checksum := ^crc32Be(0xffffffff, []byte("123456789"))
+	if checksum != 0xfc891918 {
+		t.Fatalf("Checksum not correct: (%08x)", checksum)
+	}
+}
+
+func TestJournalSuperblock_VerifyCommitBlockChecksum(t *testing.T) {
+	journal := getTestJournalBytes("journal_csum.ext4")
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(journal))
+	log.PanicIf(err)
+
+	transactions, err := getTestTransactions(j.Transactions())
+	log.PanicIf(err)
+
+	for _, transaction := range transactions {
+		n := transaction.StartBlock + uint32(transaction.BlockCount) - 1
+
+		block, err := j.ReadBlock(n)
+		log.PanicIf(err)
+
+		if j.Superblock().VerifyCommitBlockChecksum(block) != true {
+			t.Fatalf("Commit block (%d) checksum not valid.", n)
+		}
+
+		block[0x30] ^= 0xff
+
+		if j.Superblock().VerifyCommitBlockChecksum(block) != false {
+			t.Fatalf("Corrupted commit block (%d) checksum should not be valid.", n)
+		}
+	}
+}
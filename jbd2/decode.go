@@ -0,0 +1,544 @@
+package jbd2
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/dsoprea/go-ext4"
+)
+
+// DecodedBlock is a copy of a filesystem block, parsed according to what the
+// block is used for. Besides `Info` and `Data`, only the field that goes with
+// the block's class is set; data, journal, and unknown blocks are just bytes.
+type DecodedBlock struct {
+	Info ext4.BlockInfo
+	Data []byte
+
+	Superblock       *ext4.Superblock
+	GroupDescriptors []*ext4.BlockGroupDescriptor
+	Inodes           []*ext4.Inode
+	DirectoryEntries []*ext4.DirectoryEntry
+	ExtentNode       *ext4.ExtentNode
+
+	// sb is the filesystem's superblock, not a decoded copy.
+	sb *ext4.Superblock
+}
+
+// DecodeBlock parses a copy of the given filesystem block (e.g. one from the
+// journal). `bm` says what the block is, according to the filesystem as it is
+// now.
+func DecodeBlock(bm *ext4.BlockMap, block uint64, data []byte) (db *DecodedBlock, err error) {
+	bi, err := bm.Classify(block)
+	if err != nil {
+		return nil, err
+	}
+
+	sb := bm.Superblock()
+
+	if len(data) != int(sb.BlockSize()) {
+		return nil, fmt.Errorf("block (%d) has (%d) bytes rather than (%d)", block, len(data), sb.BlockSize())
+	}
+
+	db = &DecodedBlock{
+		Info: bi,
+		Data: data,
+		sb:   sb,
+	}
+
+	switch bi.Class {
+	case ext4.BlockClassSuperblock:
+		db.Superblock, err = decodeSuperblock(block, data)
+	case ext4.BlockClassGroupDescriptors:
+		db.GroupDescriptors, err = decodeGroupDescriptors(sb, bi, data)
+	case ext4.BlockClassInodeTable:
+		db.Inodes, err = decodeInodes(bm, bi, data)
+	case ext4.BlockClassDirectory:
+		db.DirectoryEntries, err = ext4.ParseDirectoryBlock(data)
+	case ext4.BlockClassExtentTree:
+		db.ExtentNode, err = ext4.ParseExtentNode(data)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("block (%d) could not be decoded as %s: %w", block, bi.Class, err)
+	}
+
+	return db, nil
+}
+
+// decodeSuperblock parses a copy of the superblock. The primary is 1024 bytes
+// into the filesystem, which is in block zero unless blocks are 1K; backups
+// are at the start of their block.
+func decodeSuperblock(block uint64, data []byte) (sb *ext4.Superblock, err error) {
+	offset := 0
+	if block == 0 {
+		offset = int(ext4.Superblock0Offset)
+	}
+
+	if offset+ext4.SuperblockSize > len(data) {
+		return nil, fmt.Errorf("superblock does not fit in the block")
+	}
+
+	raw := make([]byte, ext4.Superblock0Offset+ext4.SuperblockSize)
+	copy(raw[ext4.Superblock0Offset:], data[offset:offset+ext4.SuperblockSize])
+
+	return ext4.NewSuperblockWithReaderAt(bytes.NewReader(raw))
+}
+
+func decodeGroupDescriptors(sb *ext4.Superblock, bi ext4.BlockInfo, data []byte) (bgds []*ext4.BlockGroupDescriptor, err error) {
+	descriptorSize := sb.DescriptorSize()
+
+	bgds = make([]*ext4.BlockGroupDescriptor, 0)
+
+	for i := 0; (i+1)*descriptorSize <= len(data) && bi.Group+uint64(i) < sb.BlockGroupCount(); i++ {
+		bgd, err := ext4.NewBlockGroupDescriptorWithBytes(data[i*descriptorSize:], sb)
+		if err != nil {
+			return nil, err
+		}
+
+		bgds = append(bgds, bgd)
+	}
+
+	return bgds, nil
+}
+
+func decodeInodes(bm *ext4.BlockMap, bi ext4.BlockInfo, data []byte) (inodes []*ext4.Inode, err error) {
+	bgd, err := bm.BlockGroupDescriptors().Get(int(bi.Group))
+	if err != nil {
+		return nil, err
+	}
+
+	inodeSize := int(bm.Superblock().InodeSize())
+	inodesPerGroup := int(bm.Superblock().Data().SInodesPerGroup)
+
+	inodes = make([]*ext4.Inode, 0, bi.InodeCount)
+
+	for i := 0; i < bi.InodeCount; i++ {
+		inodeNumber := bi.FirstInode + i
+
+		// The last block of the table might not be full.
+		if inodeNumber > (int(bi.Group)+1)*inodesPerGroup {
+			break
+		}
+
+		inode, err := ext4.NewInodeWithBytes(bgd, inodeNumber, data[i*inodeSize:(i+1)*inodeSize])
+		if err != nil {
+			return nil, err
+		}
+
+		inodes = append(inodes, inode)
+	}
+
+	return inodes, nil
+}
+
+func (db *DecodedBlock) String() string {
+	return db.Info.String()
+}
+
+// Changes describes how the block differs from an earlier copy of it, one
+// change per line (e.g. "inode (12) mtime changed"). There's nothing to
+// compare with if `previous` is nil or was used for something else.
+func (db *DecodedBlock) Changes(previous *DecodedBlock) (changes []string) {
+	if previous == nil || previous.Info.Class != db.Info.Class || bytes.Equal(previous.Data, db.Data) == true {
+		return nil
+	}
+
+	switch db.Info.Class {
+	case ext4.BlockClassSuperblock:
+		return superblockChanges(previous.Superblock, db.Superblock)
+	case ext4.BlockClassGroupDescriptors:
+		return groupDescriptorChanges(db.Info.Group, previous.GroupDescriptors, db.GroupDescriptors)
+	case ext4.BlockClassBlockBitmap, ext4.BlockClassInodeBitmap:
+		return db.bitmapChanges(previous.Data)
+	case ext4.BlockClassInodeTable:
+		return inodeChanges(previous.Inodes, db.Inodes)
+	case ext4.BlockClassDirectory:
+		return directoryChanges(db.Info.Inode, previous.DirectoryEntries, db.DirectoryEntries)
+	case ext4.BlockClassExtentTree:
+		return []string{
+			fmt.Sprintf("extent node of inode (%d) changed: (%d) -> (%d) entries", db.Info.Inode, previous.ExtentNode.Header.EhEntryCount, db.ExtentNode.Header.EhEntryCount),
+		}
+	case ext4.BlockClassData, ext4.BlockClassJournal:
+		return []string{
+			fmt.Sprintf("logical block (%d) of inode (%d) changed", db.Info.LogicalBlock, db.Info.Inode),
+		}
+	}
+
+	return []string{
+		fmt.Sprintf("block (%d) changed", db.Info.Block),
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func superblockChanges(previous, current *ext4.Superblock) (changes []string) {
+	pd := previous.Data()
+	cd := current.Data()
+
+	changes = make([]string, 0)
+
+	previousFree := uint64(pd.SFreeBlocksCountHi)<<32 | uint64(pd.SFreeBlocksCountLo)
+	currentFree := uint64(cd.SFreeBlocksCountHi)<<32 | uint64(cd.SFreeBlocksCountLo)
+
+	if previousFree != currentFree {
+		changes = append(changes, fmt.Sprintf("superblock free-blocks changed (%d) -> (%d)", previousFree, currentFree))
+	}
+
+	if pd.SFreeInodesCount != cd.SFreeInodesCount {
+		changes = append(changes, fmt.Sprintf("superblock free-inodes changed (%d) -> (%d)", pd.SFreeInodesCount, cd.SFreeInodesCount))
+	}
+
+	if pd.SMntCount != cd.SMntCount {
+		changes = append(changes, fmt.Sprintf("superblock mount-count changed (%d) -> (%d)", pd.SMntCount, cd.SMntCount))
+	}
+
+	if pd.SWtime != cd.SWtime {
+		changes = append(changes, fmt.Sprintf("superblock write-time changed [%s] -> [%s]", formatTime(previous.WriteTime()), formatTime(current.WriteTime())))
+	}
+
+	if pd.SLastOrphan != cd.SLastOrphan {
+		changes = append(changes, fmt.Sprintf("superblock orphan-list head changed (%d) -> (%d)", pd.SLastOrphan, cd.SLastOrphan))
+	}
+
+	return changes
+}
+
+func groupDescriptorChanges(firstGroup uint64, previous, current []*ext4.BlockGroupDescriptor) (changes []string) {
+	changes = make([]string, 0)
+
+	for i := 0; i < len(previous) && i < len(current); i++ {
+		pd := previous[i].Data()
+		cd := current[i].Data()
+
+		group := firstGroup + uint64(i)
+
+		fields := []struct {
+			name              string
+			previous, current uint32
+		}{
+			{"free-blocks", uint32(pd.BgFreeBlocksCountHi)<<16 | uint32(pd.BgFreeBlocksCountLo), uint32(cd.BgFreeBlocksCountHi)<<16 | uint32(cd.BgFreeBlocksCountLo)},
+			{"free-inodes", uint32(pd.BgFreeInodesCountHi)<<16 | uint32(pd.BgFreeInodesCountLo), uint32(cd.BgFreeInodesCountHi)<<16 | uint32(cd.BgFreeInodesCountLo)},
+			{"directories", uint32(pd.BgUsedDirsCountHi)<<16 | uint32(pd.BgUsedDirsCountLo), uint32(cd.BgUsedDirsCountHi)<<16 | uint32(cd.BgUsedDirsCountLo)},
+			{"unused-inodes", uint32(pd.BgItableUnusedHi)<<16 | uint32(pd.BgItableUnusedLo), uint32(cd.BgItableUnusedHi)<<16 | uint32(cd.BgItableUnusedLo)},
+			{"flags", uint32(pd.BgFlags), uint32(cd.BgFlags)},
+		}
+
+		for _, field := range fields {
+			if field.previous != field.current {
+				changes = append(changes, fmt.Sprintf("group (%d) %s changed (%d) -> (%d)", group, field.name, field.previous, field.current))
+			}
+		}
+	}
+
+	return changes
+}
+
+// bitmapChanges describes the bits that were set or cleared, by the blocks or
+// inodes that they stand for. Consecutive bits that changed the same way are
+// described together. With bigalloc, each bit of a block-bitmap is a cluster,
+// so it stands for `ClusterRatio` blocks. Only the bits that the group uses are
+// looked at; the rest of the block is padding.
+func (db *DecodedBlock) bitmapChanges(previous []byte) (changes []string) {
+	var what string
+	var first, ratio, count uint64
+
+	if db.Info.Class == ext4.BlockClassBlockBitmap {
+		what = "block"
+		first = db.sb.GroupFirstBlock(db.Info.Group)
+		ratio = db.sb.ClusterRatio()
+		count = db.sb.GroupClusterCount(db.Info.Group)
+	} else {
+		what = "inode"
+		first = db.Info.Group*uint64(db.sb.Data().SInodesPerGroup) + 1
+		ratio = 1
+		count = uint64(db.sb.Data().SInodesPerGroup)
+	}
+
+	if bits := uint64(len(db.Data)) * 8; bits < count {
+		count = bits
+	}
+
+	changes = make([]string, 0)
+
+	describe := func(start, end uint64, set bool) {
+		action := "cleared"
+		if set == true {
+			action = "set"
+		}
+
+		low := first + start*ratio
+		high := first + end*ratio - 1
+
+		if low == high {
+			changes = append(changes, fmt.Sprintf("%s-bitmap bit for %s (%d) %s", what, what, low, action))
+		} else if start == end-1 {
+			changes = append(changes, fmt.Sprintf("%s-bitmap bit for %ss (%d)-(%d) %s", what, what, low, high, action))
+		} else {
+			changes = append(changes, fmt.Sprintf("%s-bitmap bits for %ss (%d)-(%d) %s", what, what, low, high, action))
+		}
+	}
+
+	start := uint64(0)
+	started := false
+	var startSet bool
+
+	for i := uint64(0); i <= count; i++ {
+		changed := false
+		set := false
+
+		if i < count {
+			before := previous[i/8]&(1<<uint(i%8)) != 0
+			set = db.Data[i/8]&(1<<uint(i%8)) != 0
+			changed = before != set
+		}
+
+		if started == true && (changed == false || set != startSet) {
+			describe(start, i, startSet)
+			started = false
+		}
+
+		if changed == true && started == false {
+			start = i
+			started = true
+			startSet = set
+		}
+	}
+
+	return changes
+}
+
+func inodeChanges(previous, current []*ext4.Inode) (changes []string) {
+	changes = make([]string, 0)
+
+	for i := 0; i < len(previous) && i < len(current); i++ {
+		changes = append(changes, compareInodes(previous[i], current[i])...)
+	}
+
+	return changes
+}
+
+// compareInodes describes how an inode changed. An inode that started or
+// stopped being used is described just by that.
+func compareInodes(previous, current *ext4.Inode) (changes []string) {
+	number := current.Number()
+
+	if previous.IsInUse() == false && current.IsInUse() == true {
+		return []string{fmt.Sprintf("inode (%d) allocated", number)}
+	} else if previous.IsInUse() == true && current.IsInUse() == false {
+		return []string{fmt.Sprintf("inode (%d) freed", number)}
+	} else if current.IsInUse() == false {
+		return nil
+	}
+
+	pd := previous.Data()
+	cd := current.Data()
+
+	changes = make([]string, 0)
+
+	if pd.IMode != cd.IMode {
+		changes = append(changes, fmt.Sprintf("inode (%d) mode changed (%o) -> (%o)", number, pd.IMode, cd.IMode))
+	}
+
+	if pd.ILinksCount != cd.ILinksCount {
+		changes = append(changes, fmt.Sprintf("inode (%d) links changed (%d) -> (%d)", number, pd.ILinksCount, cd.ILinksCount))
+	}
+
+	if previous.Size() != current.Size() {
+		changes = append(changes, fmt.Sprintf("inode (%d) size changed (%d) -> (%d)", number, previous.Size(), current.Size()))
+	}
+
+	times := []struct {
+		name              string
+		previous, current time.Time
+	}{
+		{"atime", previous.AccessTime(), current.AccessTime()},
+		{"mtime", previous.ModificationTime(), current.ModificationTime()},
+		{"ctime", previous.InodeChangeTime(), current.InodeChangeTime()},
+	}
+
+	for _, t := range times {
+		if t.previous.Equal(t.current) == false {
+			changes = append(changes, fmt.Sprintf("inode (%d) %s changed [%s] -> [%s]", number, t.name, formatTime(t.previous), formatTime(t.current)))
+		}
+	}
+
+	if pd.IFlags != cd.IFlags {
+		changes = append(changes, fmt.Sprintf("inode (%d) flags changed (%08x) -> (%08x)", number, pd.IFlags, cd.IFlags))
+	}
+
+	if pd.IBlock != cd.IBlock {
+		changes = append(changes, fmt.Sprintf("inode (%d) block map changed", number))
+	}
+
+	return changes
+}
+
+// directoryEntryInodes returns the inode of each name that's in use.
+func directoryEntryInodes(entries []*ext4.DirectoryEntry) (inodes map[string]uint32, names []string) {
+	inodes = make(map[string]uint32)
+	names = make([]string, 0, len(entries))
+
+	for _, de := range entries {
+		if de.Data().Inode == 0 {
+			continue
+		}
+
+		inodes[de.Name()] = de.Data().Inode
+		names = append(names, de.Name())
+	}
+
+	return inodes, names
+}
+
+func directoryChanges(directoryInode int, previous, current []*ext4.DirectoryEntry) (changes []string) {
+	previousInodes, previousNames := directoryEntryInodes(previous)
+	currentInodes, currentNames := directoryEntryInodes(current)
+
+	changes = make([]string, 0)
+
+	for _, name := range previousNames {
+		before := previousInodes[name]
+
+		if after, found := currentInodes[name]; found == false {
+			changes = append(changes, fmt.Sprintf("directory (%d) entry [%s] -> (%d) removed", directoryInode, name, before))
+		} else if after != before {
+			changes = append(changes, fmt.Sprintf("directory (%d) entry [%s] changed (%d) -> (%d)", directoryInode, name, before, after))
+		}
+	}
+
+	for _, name := range currentNames {
+		if _, found := previousInodes[name]; found == false {
+			changes = append(changes, fmt.Sprintf("directory (%d) entry [%s] -> (%d) added", directoryInode, name, currentInodes[name]))
+		}
+	}
+
+	return changes
+}
+
+// BlockUpdate is a copy of a filesystem block from a transaction, along with
+// the copy before it, if there was one.
+type BlockUpdate struct {
+	Sequence uint32
+	Current  *DecodedBlock
+	Previous *DecodedBlock
+
+	// Err is why the copy couldn't be decoded (e.g. the block has since been
+	// freed and reused for something else). `Current` then only has `Info`
+	// and `Data`.
+	Err error
+}
+
+// Changes describes how the block changed since the previous copy, or why it
+// couldn't be decoded.
+func (bu BlockUpdate) Changes() []string {
+	if bu.Err != nil {
+		return []string{bu.Err.Error()}
+	}
+
+	return bu.Current.Changes(bu.Previous)
+}
+
+func (bu BlockUpdate) String() string {
+	if bu.Err != nil {
+		return fmt.Sprintf("BlockUpdate<SEQ=(%d) %s ERROR=[%s]>", bu.Sequence, bu.Current, bu.Err)
+	}
+
+	return fmt.Sprintf("BlockUpdate<SEQ=(%d) %s>", bu.Sequence, bu.Current)
+}
+
+// BlockDecoder decodes the blocks of successive transactions and keeps the
+// latest copy of each block, so that each new copy can be compared with the
+// one before it.
+type BlockDecoder struct {
+	bm     *ext4.BlockMap
+	latest map[uint64]*DecodedBlock
+
+	// revokes has the revokes of the transactions decoded so far and any
+	// given to `AddRevokes`.
+	revokes *RevokeSet
+}
+
+// NewBlockDecoder returns a `BlockDecoder` for the filesystem described by
+// `bm`.
+func NewBlockDecoder(bm *ext4.BlockMap) *BlockDecoder {
+	return &BlockDecoder{
+		bm:      bm,
+		latest:  make(map[uint64]*DecodedBlock),
+		revokes: NewRevokeSet(),
+	}
+}
+
+// AddRevokes adds revokes from transactions that haven't been decoded yet
+// (e.g. from `HistoryRevokes`), so that the copies that they revoke are
+// skipped too. Otherwise, only the revokes of the transaction being decoded
+// and the ones before it are known.
+func (bd *BlockDecoder) AddRevokes(rs *RevokeSet) {
+	bd.revokes.Merge(rs)
+}
+
+// Decode decodes the blocks that the given transaction logged, in the order
+// that they were logged. The transactions have to be given in order (e.g.
+// from `History`). Copies that fail their checksum or were revoked are
+// skipped, since recovery would never write them. A copy that can't be decoded
+// is returned with its error, and the copy after it is compared with nothing.
+func (bd *BlockDecoder) Decode(t *Transaction) (updates []BlockUpdate, err error) {
+	bd.revokes.Merge(t.Revokes)
+
+	updates = make([]BlockUpdate, 0, len(t.DataBlocks))
+
+	for _, dataBlock := range t.DataBlocks {
+		if dataBlock.ChecksumValid == false {
+			continue
+		}
+
+		block := dataBlock.Blocknr()
+
+		// The block was freed after this copy, so whatever comes next is
+		// unrelated to what came before.
+		if bd.revokes.IsRevoked(block, t.Sequence) == true {
+			delete(bd.latest, block)
+			continue
+		}
+
+		db, err := DecodeBlock(bd.bm, block, dataBlock.Data)
+		if err != nil {
+			updates = append(updates, BlockUpdate{
+				Sequence: t.Sequence,
+				Current:  bd.undecodedBlock(block, dataBlock.Data),
+				Previous: bd.latest[block],
+				Err:      err,
+			})
+
+			delete(bd.latest, block)
+			continue
+		}
+
+		updates = append(updates, BlockUpdate{
+			Sequence: t.Sequence,
+			Current:  db,
+			Previous: bd.latest[block],
+		})
+
+		bd.latest[block] = db
+	}
+
+	return updates, nil
+}
+
+// undecodedBlock returns a `DecodedBlock` with just what's known about a copy
+// that couldn't be decoded.
+func (bd *BlockDecoder) undecodedBlock(block uint64, data []byte) *DecodedBlock {
+	bi, err := bd.bm.Classify(block)
+	if err != nil {
+		bi = ext4.BlockInfo{Block: block, Class: ext4.BlockClassUnknown}
+	}
+
+	return &DecodedBlock{
+		Info: bi,
+		Data: data,
+		sb:   bd.bm.Superblock(),
+	}
+}
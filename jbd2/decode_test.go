@@ -0,0 +1,443 @@
+package jbd2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-ext4"
+	"github.com/dsoprea/go-logging"
+)
+
+// getTestDirectoryBlock returns a directory block with the given entries. The
+// last one takes up the rest of the block.
+func getTestDirectoryBlock(blockSize int, names []string, inodes []uint32) []byte {
+	data := make([]byte, blockSize)
+
+	offset := 0
+	for i, name := range names {
+		recordLength := (8 + len(name) + 3) / 4 * 4
+		if i == len(names)-1 {
+			recordLength = blockSize - offset
+		}
+
+		binary.LittleEndian.PutUint32(data[offset:], inodes[i])
+		binary.LittleEndian.PutUint16(data[offset+4:], uint16(recordLength))
+		data[offset+6] = uint8(len(name))
+		data[offset+7] = ext4.FileTypeRegular
+		copy(data[offset+8:], name)
+
+		offset += recordLength
+	}
+
+	return data
+}
+
+// getTestTransaction returns a committed transaction that logs the given
+// filesystem blocks.
+func getTestTransaction(sequence uint32, blocks map[uint64][]byte) *Transaction {
+	t := &Transaction{
+		Sequence: sequence,
+		Revokes:  NewRevokeSet(),
+		Complete: true,
+	}
+
+	for _, block := range []uint64{69, 1330} {
+		data, found := blocks[block]
+		if found == false {
+			continue
+		}
+
+		t.DataBlocks = append(t.DataBlocks, JournalDataBlock{
+			Tag:           JournalBlockTag{TBlocknr: uint32(block)},
+			Data:          data,
+			ChecksumValid: true,
+		})
+	}
+
+	return t
+}
+
+// getTestBlockUpdates decodes every transaction in the history of the given
+// filesystem and returns the changes by sequence.
+func getTestBlockUpdates(filename string) map[uint32][]string {
+	f, err := os.Open(path.Join(assetsPath, filename))
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	j, err := NewJournalWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bm, err := ext4.NewBlockMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	rs, err := j.HistoryRevokes()
+	log.PanicIf(err)
+
+	bd := NewBlockDecoder(bm)
+	bd.AddRevokes(rs)
+
+	changes := make(map[uint32][]string)
+
+	ti := j.History()
+	for {
+		t, err := ti.Next()
+		if err == io.EOF {
+			break
+		}
+
+		log.PanicIf(err)
+
+		updates, err := bd.Decode(t)
+		log.PanicIf(err)
+
+		changes[t.Sequence] = make([]string, 0)
+		for _, bu := range updates {
+			changes[t.Sequence] = append(changes[t.Sequence], bu.Changes()...)
+		}
+	}
+
+	return changes
+}
+
+func TestBlockDecoder_Decode(t *testing.T) {
+	changes := getTestBlockUpdates("history.ext4")
+
+	// Everything in the first transaction is the first copy that we've seen.
+	if len(changes[1]) != 0 {
+		t.Fatalf("Expected no changes for the first transaction: %v", changes[1])
+	}
+
+	expected := []string{
+		"inode (13) size changed (500) -> (1000)",
+		"inode (13) mtime changed [2026-10-18T20:30:23Z] -> [2024-01-02T03:04:05Z]",
+		"directory (12) entry [b.txt] -> (14) removed",
+		"directory (12) entry [c.txt] -> (14) added",
+	}
+
+	if reflect.DeepEqual(changes[2], expected) == false {
+		t.Fatalf("Changes of the second transaction not correct: %v", changes[2])
+	}
+
+	expected = []string{
+		"superblock free-blocks changed (2762) -> (2763)",
+		"superblock free-inodes changed (1009) -> (1010)",
+		"group (0) free-blocks changed (2762) -> (2763)",
+		"group (0) free-inodes changed (1009) -> (1010)",
+		"block-bitmap bit for block (1333) cleared",
+		"inode-bitmap bit for inode (15) cleared",
+		"inode (15) freed",
+		"directory (12) entry [deleted.txt] -> (15) removed",
+	}
+
+	if reflect.DeepEqual(changes[3], expected) == false {
+		t.Fatalf("Changes of the third transaction not correct: %v", changes[3])
+	}
+}
+
+func TestBlockDecoder_Decode_Undecodable(t *testing.T) {
+	f, err := os.Open(path.Join(assetsPath, "history.ext4"))
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	bm, err := ext4.NewBlockMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bd := NewBlockDecoder(bm)
+
+	// Block (1330) is a directory. A block of zeroes has a record-length of
+	// zero, so it doesn't parse.
+
+	directory := getTestDirectoryBlock(1024, []string{".", "..", "a.txt"}, []uint32{12, 2, 13})
+
+	_, err = bd.Decode(getTestTransaction(1, map[uint64][]byte{1330: directory}))
+	log.PanicIf(err)
+
+	updates, err := bd.Decode(getTestTransaction(2, map[uint64][]byte{1330: make([]byte, 1024)}))
+	log.PanicIf(err)
+
+	if len(updates) != 1 {
+		t.Fatalf("Expected one update: %v", updates)
+	}
+
+	bu := updates[0]
+
+	var ec *ext4.ErrCorrupt
+	if errors.As(bu.Err, &ec) == false {
+		t.Fatalf("Expected a corruption error: [%v]", bu.Err)
+	} else if bu.Current.Info.Class != ext4.BlockClassDirectory || bu.Current.DirectoryEntries != nil {
+		t.Fatalf("Current copy not correct: %s", bu.Current)
+	} else if bu.Previous == nil {
+		t.Fatalf("Expected the previous copy.")
+	}
+
+	changes := bu.Changes()
+	if len(changes) != 1 || changes[0] != bu.Err.Error() {
+		t.Fatalf("Changes not correct: %v", changes)
+	}
+
+	// The next copy is only a baseline.
+
+	updates, err = bd.Decode(getTestTransaction(3, map[uint64][]byte{1330: directory}))
+	log.PanicIf(err)
+
+	if len(updates) != 1 || updates[0].Err != nil || updates[0].Previous != nil {
+		t.Fatalf("Copy after the undecodable one not correct: %v", updates)
+	}
+}
+
+func TestBlockDecoder_Decode_Revoked(t *testing.T) {
+	f, err := os.Open(path.Join(assetsPath, "history.ext4"))
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	bm, err := ext4.NewBlockMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bd := NewBlockDecoder(bm)
+
+	directory := getTestDirectoryBlock(1024, []string{".", "..", "a.txt"}, []uint32{12, 2, 13})
+
+	// Transaction (3), which hasn't been decoded yet, revokes the copies in
+	// (2) and before.
+
+	rs := NewRevokeSet()
+	rs.Add(1330, 3)
+
+	bd.AddRevokes(rs)
+
+	for sequence := uint32(1); sequence <= 2; sequence++ {
+		updates, err := bd.Decode(getTestTransaction(sequence, map[uint64][]byte{1330: directory}))
+		log.PanicIf(err)
+
+		if len(updates) != 0 {
+			t.Fatalf("Revoked copy in (%d) should have been skipped: %v", sequence, updates)
+		}
+	}
+
+	// A transaction's own revokes count, too.
+
+	tx := getTestTransaction(3, map[uint64][]byte{69: make([]byte, 1024)})
+	tx.Revokes.Add(69, 3)
+
+	updates, err := bd.Decode(tx)
+	log.PanicIf(err)
+
+	if len(updates) != 0 {
+		t.Fatalf("Copy revoked by its own transaction should have been skipped: %v", updates)
+	}
+
+	updates, err = bd.Decode(getTestTransaction(4, map[uint64][]byte{1330: directory}))
+	log.PanicIf(err)
+
+	if len(updates) != 1 || updates[0].Previous != nil {
+		t.Fatalf("Copy after the revoke not correct: %v", updates)
+	}
+}
+
+func TestDecodeBlock(t *testing.T) {
+	f, err := os.Open(path.Join(assetsPath, "history.ext4"))
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	bm, err := ext4.NewBlockMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	data, err := sb.ReadPhysicalBlock(69, 1024)
+	log.PanicIf(err)
+
+	db, err := DecodeBlock(bm, 69, data)
+	log.PanicIf(err)
+
+	if db.String() != "BlockInfo<BLOCK=(69) CLASS=[inode table] GROUP=(0) INODES=(13)-(16)>" {
+		t.Fatalf("Block not classified correctly: %s", db)
+	} else if len(db.Inodes) != 4 || db.Inodes[0].Size() != 1000 {
+		t.Fatalf("Inodes not decoded correctly.")
+	}
+
+	data, err = sb.ReadPhysicalBlock(1330, 1024)
+	log.PanicIf(err)
+
+	db, err = DecodeBlock(bm, 1330, data)
+	log.PanicIf(err)
+
+	if db.Info.Inode != 12 || len(db.DirectoryEntries) == 0 || db.DirectoryEntries[0].Name() != "." {
+		t.Fatalf("Directory not decoded correctly: %s", db)
+	}
+
+	// The data has to be a whole block.
+	_, err = DecodeBlock(bm, 1, data[:100])
+	if err == nil {
+		t.Fatalf("Expected error for short data.")
+	}
+}
+
+func TestDecodedBlock_Changes_Bitmap(t *testing.T) {
+	f, err := os.Open(path.Join(assetsPath, "history.ext4"))
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	bm, err := ext4.NewBlockMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	before := make([]byte, 1024)
+	before[1] = 0x0f
+
+	after := make([]byte, 1024)
+	after[1] = 0xf0
+
+	previous, err := DecodeBlock(bm, 34, before)
+	log.PanicIf(err)
+
+	current, err := DecodeBlock(bm, 34, after)
+	log.PanicIf(err)
+
+	// Blocks are 1K, so the first bit is block (1).
+	expected := []string{
+		"block-bitmap bits for blocks (9)-(12) cleared",
+		"block-bitmap bits for blocks (13)-(16) set",
+	}
+
+	changes := current.Changes(previous)
+	if reflect.DeepEqual(changes, expected) == false {
+		t.Fatalf("Changes not correct: %v", changes)
+	}
+}
+
+func TestDecodedBlock_Changes_Bitmap_Bigalloc(t *testing.T) {
+	f, err := os.Open(path.Join(assetsPath, "..", "..", "assets", "bigalloc.ext4"))
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	bm, err := ext4.NewBlockMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	// Group (2) starts at block (2048) and, being the last, only has (237)
+	// clusters of four blocks. Bits past those are padding and aren't reported.
+
+	before := make([]byte, 1024)
+
+	after := make([]byte, 1024)
+	after[0] = 0x01
+	after[29] = 0xf0
+	after[100] = 0xff
+
+	previous, err := DecodeBlock(bm, 5, before)
+	log.PanicIf(err)
+
+	current, err := DecodeBlock(bm, 5, after)
+	log.PanicIf(err)
+
+	expected := []string{
+		"block-bitmap bit for blocks (2048)-(2051) set",
+		"block-bitmap bit for blocks (2992)-(2995) set",
+	}
+
+	changes := current.Changes(previous)
+	if reflect.DeepEqual(changes, expected) == false {
+		t.Fatalf("Block-bitmap changes not correct: %v", changes)
+	}
+
+	// There are (32) inodes per group, so only the first four bytes of the
+	// inode-bitmap count.
+
+	after = make([]byte, 1024)
+	after[3] = 0xc0
+	after[4] = 0xff
+
+	previous, err = DecodeBlock(bm, 6, before)
+	log.PanicIf(err)
+
+	current, err = DecodeBlock(bm, 6, after)
+	log.PanicIf(err)
+
+	expected = []string{
+		"inode-bitmap bits for inodes (31)-(32) set",
+	}
+
+	changes = current.Changes(previous)
+	if reflect.DeepEqual(changes, expected) == false {
+		t.Fatalf("Inode-bitmap changes not correct: %v", changes)
+	}
+}
+
+func ExampleBlockDecoder_Decode() {
+	f, err := os.Open(path.Join(assetsPath, "history.ext4"))
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	j, err := NewJournalWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bm, err := ext4.NewBlockMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bd := NewBlockDecoder(bm)
+
+	ti := j.History()
+	for {
+		t, err := ti.Next()
+		if err == io.EOF {
+			break
+		}
+
+		log.PanicIf(err)
+
+		updates, err := bd.Decode(t)
+		log.PanicIf(err)
+
+		if t.Sequence != 2 {
+			continue
+		}
+
+		for _, bu := range updates {
+			fmt.Printf("%s\n", bu.Current)
+
+			for _, change := range bu.Changes() {
+				fmt.Printf("  %s\n", change)
+			}
+		}
+	}
+
+	// Output:
+	// BlockInfo<BLOCK=(69) CLASS=[inode table] GROUP=(0) INODES=(13)-(16)>
+	//   inode (13) size changed (500) -> (1000)
+	//   inode (13) mtime changed [2026-10-18T20:30:23Z] -> [2024-01-02T03:04:05Z]
+	// BlockInfo<BLOCK=(1330) CLASS=[directory] INODE=(12) LOGICAL-BLOCK=(0)>
+	//   directory (12) entry [b.txt] -> (14) removed
+	//   directory (12) entry [c.txt] -> (14) added
+}
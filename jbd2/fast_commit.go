@@ -0,0 +1,345 @@
+package jbd2
+
+import (
+	"bytes"
+	"fmt"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-ext4"
+)
+
+// Fast-commit tags (EXT4_FC_TAG_*).
+const (
+	FcTagAddRange = uint16(0x1) // Blocks were mapped into an inode.
+	FcTagDelRange = uint16(0x2) // Blocks were unmapped from an inode.
+	FcTagCreate   = uint16(0x3) // A file was created (an inode and its first name).
+	FcTagLink     = uint16(0x4) // A name was added for an existing inode.
+	FcTagUnlink   = uint16(0x5) // A name was removed.
+	FcTagInode    = uint16(0x6) // The latest copy of an inode.
+	FcTagPad      = uint16(0x7) // Filler up to the end of the block.
+	FcTagTail     = uint16(0x8) // The end of a fast-commit, with its checksum.
+	FcTagHead     = uint16(0x9) // The start of the fast-commit area.
+)
+
+var (
+	FcTagLookup = map[uint16]string{
+		FcTagAddRange: "add range",
+		FcTagDelRange: "del range",
+		FcTagCreate:   "create",
+		FcTagLink:     "link",
+		FcTagUnlink:   "unlink",
+		FcTagInode:    "inode",
+		FcTagPad:      "pad",
+		FcTagTail:     "tail",
+		FcTagHead:     "head",
+	}
+)
+
+const (
+	// FcTagHeaderSize is the size of the tag and length in front of every
+	// value (struct ext4_fc_tl).
+	FcTagHeaderSize = 4
+
+	// FcSupportedFeatures is the mask of fast-commit features that we
+	// understand (EXT4_FC_SUPPORTED_FEATURES). There aren't any yet.
+	FcSupportedFeatures = uint32(0)
+)
+
+// FastCommitRecord is one of the typed records in a fast-commit.
+type FastCommitRecord interface {
+	// Tag is the `FcTag*` value of the record.
+	Tag() uint16
+
+	String() string
+}
+
+// FastCommitRange is an add-range (ext4_fc_add_range) or del-range
+// (ext4_fc_del_range) record. A del-range only has the logical blocks.
+type FastCommitRange struct {
+	tag uint16
+
+	Inode         uint32
+	LogicalBlock  uint32
+	Length        uint32
+	PhysicalBlock uint64
+	Unwritten     bool
+}
+
+func (fcr *FastCommitRange) Tag() uint16 {
+	return fcr.tag
+}
+
+func (fcr *FastCommitRange) String() string {
+	if fcr.tag == FcTagAddRange {
+		return fmt.Sprintf("FastCommitRange<TAG=[%s] INODE=(%d) LBLOCK=(%d) LENGTH=(%d) PBLOCK=(%d) UNWRITTEN=[%v]>", FcTagLookup[fcr.tag], fcr.Inode, fcr.LogicalBlock, fcr.Length, fcr.PhysicalBlock, fcr.Unwritten)
+	}
+
+	return fmt.Sprintf("FastCommitRange<TAG=[%s] INODE=(%d) LBLOCK=(%d) LENGTH=(%d)>", FcTagLookup[fcr.tag], fcr.Inode, fcr.LogicalBlock, fcr.Length)
+}
+
+// FastCommitDentry is a create, link, or unlink record
+// (ext4_fc_dentry_info).
+type FastCommitDentry struct {
+	tag uint16
+
+	ParentInode uint32
+	Inode       uint32
+	Name        string
+}
+
+func (fcd *FastCommitDentry) Tag() uint16 {
+	return fcd.tag
+}
+
+func (fcd *FastCommitDentry) String() string {
+	return fmt.Sprintf("FastCommitDentry<TAG=[%s] PARENT=(%d) INODE=(%d) NAME=[%s]>", FcTagLookup[fcd.tag], fcd.ParentInode, fcd.Inode, fcd.Name)
+}
+
+// FastCommitInode is an inode record (ext4_fc_inode). `Raw` is the on-disk
+// inode, as long as the filesystem's inode-size.
+type FastCommitInode struct {
+	Inode uint32
+	Raw   []byte
+}
+
+func (fci *FastCommitInode) Tag() uint16 {
+	return FcTagInode
+}
+
+// Decode parses the inode. `bgd` is the descriptor of its block-group.
+func (fci *FastCommitInode) Decode(bgd *ext4.BlockGroupDescriptor) (inode *ext4.Inode, err error) {
+	return ext4.NewInodeWithBytes(bgd, int(fci.Inode), fci.Raw)
+}
+
+func (fci *FastCommitInode) String() string {
+	return fmt.Sprintf("FastCommitInode<INODE=(%d) SIZE=(%d)>", fci.Inode, len(fci.Raw))
+}
+
+// FastCommit is everything between the start of the fast-commit area or the
+// end of the previous fast-commit and the next tail. All of the fast-commits
+// after a full commit have the sequence of the transaction that's running
+// (which hasn't been committed).
+type FastCommit struct {
+	Sequence uint32
+
+	// StartBlock is the journal block that the fast-commit starts in.
+	StartBlock uint32
+
+	// Records are the records in the order that they were written, not
+	// including the head, the tail, or padding.
+	Records []FastCommitRecord
+
+	// Complete indicates that the fast-commit has a tail. ChecksumValid
+	// indicates whether the tail's checksum (and sequence) matched.
+	Complete      bool
+	ChecksumValid bool
+
+	// Current indicates that the fast-commit is for the transaction after the
+	// last full commit in the log, so recovery would replay it (if it's
+	// complete and valid). Otherwise it was left over from before that commit,
+	// which already covers it.
+	Current bool
+}
+
+func (fc *FastCommit) String() string {
+	return fmt.Sprintf("FastCommit<SEQ=(%d) START=(%d) RECORDS=(%d) COMPLETE=[%v] CHECKSUM-VALID=[%v] CURRENT=[%v]>", fc.Sequence, fc.StartBlock, len(fc.Records), fc.Complete, fc.ChecksumValid, fc.Current)
+}
+
+// parseFastCommitRecord parses the value of one of the record tags.
+func parseFastCommitRecord(tag uint16, value []byte) (fcr FastCommitRecord, err error) {
+	switch tag {
+	case FcTagAddRange:
+		if len(value) != 16 {
+			break
+		}
+
+		// The value is the inode and then an extent (ext4_extent).
+		var eln ext4.ExtentLeafNode
+
+		err := binary.Read(bytes.NewBuffer(value[4:]), binary.LittleEndian, &eln)
+		if err != nil {
+			return nil, err
+		}
+
+		fcr := &FastCommitRange{
+			tag:           tag,
+			Inode:         binary.LittleEndian.Uint32(value),
+			LogicalBlock:  eln.EeFirstLogicalBlock,
+			Length:        uint32(eln.Length()),
+			PhysicalBlock: eln.StartPhysicalBlock(),
+			Unwritten:     eln.IsUnwritten(),
+		}
+
+		return fcr, nil
+	case FcTagDelRange:
+		if len(value) != 12 {
+			break
+		}
+
+		fcr := &FastCommitRange{
+			tag:          tag,
+			Inode:        binary.LittleEndian.Uint32(value),
+			LogicalBlock: binary.LittleEndian.Uint32(value[4:]),
+			Length:       binary.LittleEndian.Uint32(value[8:]),
+		}
+
+		return fcr, nil
+	case FcTagCreate, FcTagLink, FcTagUnlink:
+		if len(value) <= 8 || len(value) > 8+ext4.Ext4FilenameMaxLen {
+			break
+		}
+
+		fcd := &FastCommitDentry{
+			tag:         tag,
+			ParentInode: binary.LittleEndian.Uint32(value),
+			Inode:       binary.LittleEndian.Uint32(value[4:]),
+			Name:        string(value[8:]),
+		}
+
+		return fcd, nil
+	case FcTagInode:
+		if len(value) <= 4 {
+			break
+		}
+
+		fci := &FastCommitInode{
+			Inode: binary.LittleEndian.Uint32(value),
+			Raw:   value[4:],
+		}
+
+		return fci, nil
+	}
+
+	return nil, fmt.Errorf("length (%d) not valid for [%s] tag", len(value), FcTagLookup[tag])
+}
+
+// FastCommits parses the fast-commit area at the end of the journal, the way
+// that recovery scans it: the area has to start with a head, and each
+// fast-commit ends with a tail whose checksum covers everything since the
+// previous one. The first thing that isn't valid ends the area. A fast-commit
+// that was cut off that way, or whose tail doesn't match, is still returned,
+// but recovery would stop before it. Nothing is returned if the journal
+// doesn't have fast-commits or if the area doesn't start with a head.
+//
+// As in the kernel, the area starts one block after the end of the log.
+func (j *Journal) FastCommits() (fcs []*FastCommit, err error) {
+	fcs = make([]*FastCommit, 0)
+
+	if j.jsb.FastCommitBlockCount() == 0 {
+		return fcs, nil
+	}
+
+	// Only fast-commits for the transaction after the last full commit would
+	// be replayed.
+	_, current, err := scanLog(j.Transactions(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize := j.BlockSize()
+	firstBlock := j.jsb.LastLogBlock() + 1
+
+	var fc *FastCommit
+	sequence := uint32(0)
+	crc := uint32(0)
+
+	finish := func() []*FastCommit {
+		if fc != nil && len(fc.Records) > 0 {
+			fcs = append(fcs, fc)
+		}
+
+		return fcs
+	}
+
+	for n := firstBlock; n < j.jsb.data.SMaxlen; n++ {
+		block, err := j.ReadBlock(n)
+		if err != nil {
+			return nil, err
+		}
+
+		for offset := 0; offset+FcTagHeaderSize < blockSize; {
+			tag := binary.LittleEndian.Uint16(block[offset:])
+			length := int(binary.LittleEndian.Uint16(block[offset+2:]))
+
+			end := offset + FcTagHeaderSize + length
+			if end > blockSize {
+				return finish(), nil
+			}
+
+			value := block[offset+FcTagHeaderSize : end]
+
+			if n == firstBlock && offset == 0 && tag != FcTagHead {
+				return fcs, nil
+			}
+
+			if fc == nil {
+				fc = &FastCommit{
+					Sequence:   sequence,
+					StartBlock: n,
+					Records:    make([]FastCommitRecord, 0),
+					Current:    sequence == current,
+				}
+			}
+
+			switch tag {
+			case FcTagHead:
+				if length != 8 || binary.LittleEndian.Uint32(value)&^FcSupportedFeatures != 0 {
+					return finish(), nil
+				}
+
+				tid := binary.LittleEndian.Uint32(value[4:])
+				if n == firstBlock && offset == 0 {
+					sequence = tid
+					fc.Sequence = tid
+					fc.Current = tid == current
+				} else if tid != sequence {
+					return finish(), nil
+				}
+
+				crc = crc32c(crc, block[offset:end])
+			case FcTagPad:
+				crc = crc32c(crc, block[offset:end])
+			case FcTagTail:
+				if length < 8 {
+					return finish(), nil
+				}
+
+				// The checksum covers the tail up to (not including) itself.
+				crc = crc32c(crc, block[offset:offset+FcTagHeaderSize+4])
+
+				tid := binary.LittleEndian.Uint32(value)
+				fc.Complete = true
+				fc.ChecksumValid = tid == sequence && binary.LittleEndian.Uint32(value[4:]) == crc
+
+				fcs = append(fcs, fc)
+
+				if fc.ChecksumValid == false {
+					return fcs, nil
+				}
+
+				fc = nil
+				crc = 0
+
+				// The next fast-commit starts in the next block.
+				end = blockSize
+			default:
+				if _, found := FcTagLookup[tag]; found == false {
+					return finish(), nil
+				}
+
+				record, err := parseFastCommitRecord(tag, value)
+				if err != nil {
+					return finish(), nil
+				}
+
+				crc = crc32c(crc, block[offset:end])
+				fc.Records = append(fc.Records, record)
+			}
+
+			offset = end
+		}
+	}
+
+	return finish(), nil
+}
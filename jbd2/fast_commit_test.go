@@ -0,0 +1,261 @@
+package jbd2
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-logging"
+)
+
+// getTestFastCommitTag returns a tag, its length, and its value.
+func getTestFastCommitTag(tag uint16, value []byte) []byte {
+	data := make([]byte, FcTagHeaderSize+len(value))
+
+	binary.LittleEndian.PutUint16(data[0:], tag)
+	binary.LittleEndian.PutUint16(data[2:], uint16(len(value)))
+	copy(data[FcTagHeaderSize:], value)
+
+	return data
+}
+
+// getTestFastCommitBlock returns a block with a fast-commit: a head (if
+// `head` is true), the given tags, and a tail with a correct checksum.
+func getTestFastCommitBlock(blockSize int, sequence uint32, head bool, tags [][]byte) []byte {
+	data := make([]byte, 0, blockSize)
+
+	if head == true {
+		value := make([]byte, 8)
+		binary.LittleEndian.PutUint32(value[4:], sequence)
+
+		data = append(data, getTestFastCommitTag(FcTagHead, value)...)
+	}
+
+	for _, tag := range tags {
+		data = append(data, tag...)
+	}
+
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint32(value, sequence)
+
+	data = append(data, getTestFastCommitTag(FcTagTail, value)...)
+
+	crc := crc32c(0, data[:len(data)-4])
+	binary.LittleEndian.PutUint32(data[len(data)-4:], crc)
+
+	block := make([]byte, blockSize)
+	copy(block, data)
+
+	return block
+}
+
+// getTestFastCommitRecords returns an add-range, a create, and an inode
+// record.
+func getTestFastCommitRecords() [][]byte {
+	addRange := make([]byte, 16)
+	binary.LittleEndian.PutUint32(addRange[0:], 12)
+	binary.LittleEndian.PutUint32(addRange[4:], 0)
+	binary.LittleEndian.PutUint16(addRange[8:], 2)
+	binary.LittleEndian.PutUint32(addRange[12:], 100)
+
+	create := make([]byte, 8)
+	binary.LittleEndian.PutUint32(create[0:], 2)
+	binary.LittleEndian.PutUint32(create[4:], 12)
+	create = append(create, []byte("new.txt")...)
+
+	inode := make([]byte, 4+128)
+	binary.LittleEndian.PutUint32(inode[0:], 12)
+
+	return [][]byte{
+		getTestFastCommitTag(FcTagAddRange, addRange),
+		getTestFastCommitTag(FcTagCreate, create),
+		getTestFastCommitTag(FcTagInode, inode),
+	}
+}
+
+// getTestFastCommitJournal returns a 32-block journal with a four-block
+// fast-commit area (blocks (29) through (31)) and one full commit (1), so
+// fast-commits for (2) are current.
+func getTestFastCommitJournal(fcBlocks map[uint32][]byte) *Journal {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SMaxlen = 32
+	jsbd.SFeatureIncompat = JsbFeatureIncompatFastCommit
+	jsbd.SNumFcBlks = 4
+
+	blockSize := int(jsbd.SBlocksize)
+
+	blocks := map[uint32][]byte{
+		1: getTestDescriptorBlock(blockSize, 1, []uint32{10}),
+		2: getTestDataBlock(blockSize, 0x11),
+		3: getTestCommitBlock(blockSize, 1, 1000),
+	}
+
+	for n, block := range fcBlocks {
+		blocks[n] = block
+	}
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(getTestJournal(jsbd, blocks)))
+	log.PanicIf(err)
+
+	return j
+}
+
+func TestJournal_FastCommits(t *testing.T) {
+	delRange := make([]byte, 12)
+	binary.LittleEndian.PutUint32(delRange[0:], 13)
+	binary.LittleEndian.PutUint32(delRange[4:], 5)
+	binary.LittleEndian.PutUint32(delRange[8:], 100000)
+
+	j := getTestFastCommitJournal(map[uint32][]byte{
+		29: getTestFastCommitBlock(1024, 2, true, getTestFastCommitRecords()),
+		30: getTestFastCommitBlock(1024, 2, false, [][]byte{getTestFastCommitTag(FcTagDelRange, delRange)}),
+	})
+
+	fcs, err := j.FastCommits()
+	log.PanicIf(err)
+
+	if len(fcs) != 2 {
+		t.Fatalf("Expected two fast-commits: (%d)", len(fcs))
+	} else if fcs[0].String() != "FastCommit<SEQ=(2) START=(29) RECORDS=(3) COMPLETE=[true] CHECKSUM-VALID=[true] CURRENT=[true]>" {
+		t.Fatalf("First fast-commit not correct: %s", fcs[0])
+	} else if fcs[1].String() != "FastCommit<SEQ=(2) START=(30) RECORDS=(1) COMPLETE=[true] CHECKSUM-VALID=[true] CURRENT=[true]>" {
+		t.Fatalf("Second fast-commit not correct: %s", fcs[1])
+	}
+
+	expected := []string{
+		"FastCommitRange<TAG=[add range] INODE=(12) LBLOCK=(0) LENGTH=(2) PBLOCK=(100) UNWRITTEN=[false]>",
+		"FastCommitDentry<TAG=[create] PARENT=(2) INODE=(12) NAME=[new.txt]>",
+		"FastCommitInode<INODE=(12) SIZE=(128)>",
+		"FastCommitRange<TAG=[del range] INODE=(13) LBLOCK=(5) LENGTH=(100000)>",
+	}
+
+	records := append(fcs[0].Records, fcs[1].Records...)
+	for i, record := range records {
+		if record.String() != expected[i] {
+			t.Fatalf("Record (%d) not correct: %s", i, record)
+		}
+	}
+
+	if records[1].Tag() != FcTagCreate || records[2].Tag() != FcTagInode {
+		t.Fatalf("Tags not correct.")
+	}
+}
+
+func TestJournal_FastCommits_BadChecksum(t *testing.T) {
+	second := getTestFastCommitBlock(1024, 2, false, getTestFastCommitRecords()[:1])
+	second[FcTagHeaderSize+4] ^= 0xff
+
+	third := getTestFastCommitBlock(1024, 2, false, getTestFastCommitRecords()[:1])
+
+	j := getTestFastCommitJournal(map[uint32][]byte{
+		29: getTestFastCommitBlock(1024, 2, true, getTestFastCommitRecords()),
+		30: second,
+		31: third,
+	})
+
+	fcs, err := j.FastCommits()
+	log.PanicIf(err)
+
+	// Nothing after the one that doesn't match is used.
+	if len(fcs) != 2 {
+		t.Fatalf("Expected two fast-commits: (%d)", len(fcs))
+	} else if fcs[0].ChecksumValid != true {
+		t.Fatalf("First fast-commit should be valid.")
+	} else if fcs[1].Complete != true || fcs[1].ChecksumValid != false {
+		t.Fatalf("Second fast-commit should not be valid: %s", fcs[1])
+	}
+}
+
+func TestJournal_FastCommits_Incomplete(t *testing.T) {
+	// Everything but the tail.
+	block := getTestFastCommitBlock(1024, 2, true, getTestFastCommitRecords())
+	length := 12 + 20 + 19 + 136
+
+	block = append(block[:length], make([]byte, 1024-length)...)
+
+	j := getTestFastCommitJournal(map[uint32][]byte{
+		29: block,
+	})
+
+	fcs, err := j.FastCommits()
+	log.PanicIf(err)
+
+	if len(fcs) != 1 {
+		t.Fatalf("Expected one fast-commit: (%d)", len(fcs))
+	} else if fcs[0].String() != "FastCommit<SEQ=(2) START=(29) RECORDS=(3) COMPLETE=[false] CHECKSUM-VALID=[false] CURRENT=[true]>" {
+		t.Fatalf("Fast-commit not correct: %s", fcs[0])
+	}
+}
+
+func TestJournal_FastCommits_Stale(t *testing.T) {
+	// Left over from before the full commit of (1).
+	j := getTestFastCommitJournal(map[uint32][]byte{
+		29: getTestFastCommitBlock(1024, 1, true, getTestFastCommitRecords()),
+	})
+
+	fcs, err := j.FastCommits()
+	log.PanicIf(err)
+
+	if len(fcs) != 1 {
+		t.Fatalf("Expected one fast-commit: (%d)", len(fcs))
+	} else if fcs[0].ChecksumValid != true || fcs[0].Current != false {
+		t.Fatalf("Fast-commit not correct: %s", fcs[0])
+	}
+}
+
+func TestJournal_FastCommits_NoHead(t *testing.T) {
+	j := getTestFastCommitJournal(map[uint32][]byte{
+		29: getTestFastCommitBlock(1024, 2, false, getTestFastCommitRecords()),
+	})
+
+	fcs, err := j.FastCommits()
+	log.PanicIf(err)
+
+	if len(fcs) != 0 {
+		t.Fatalf("Expected no fast-commits: (%d)", len(fcs))
+	}
+}
+
+func TestJournal_FastCommits_NotEnabled(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SMaxlen = 32
+
+	blocks := map[uint32][]byte{
+		31: getTestFastCommitBlock(1024, 1, true, getTestFastCommitRecords()),
+	}
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(getTestJournal(jsbd, blocks)))
+	log.PanicIf(err)
+
+	fcs, err := j.FastCommits()
+	log.PanicIf(err)
+
+	if len(fcs) != 0 {
+		t.Fatalf("Expected no fast-commits: (%d)", len(fcs))
+	}
+}
+
+func ExampleJournal_FastCommits() {
+	j := getTestFastCommitJournal(map[uint32][]byte{
+		29: getTestFastCommitBlock(1024, 2, true, getTestFastCommitRecords()),
+	})
+
+	fcs, err := j.FastCommits()
+	log.PanicIf(err)
+
+	for _, fc := range fcs {
+		fmt.Println(fc)
+
+		for _, record := range fc.Records {
+			fmt.Println(record)
+		}
+	}
+
+	// Output:
+	// FastCommit<SEQ=(2) START=(29) RECORDS=(3) COMPLETE=[true] CHECKSUM-VALID=[true] CURRENT=[true]>
+	// FastCommitRange<TAG=[add range] INODE=(12) LBLOCK=(0) LENGTH=(2) PBLOCK=(100) UNWRITTEN=[false]>
+	// FastCommitDentry<TAG=[create] PARENT=(2) INODE=(12) NAME=[new.txt]>
+	// FastCommitInode<INODE=(12) SIZE=(128)>
+}
@@ -0,0 +1,114 @@
+package jbd2
+
+import (
+	"bytes"
+	"testing"
+
+	"encoding/binary"
+	"io/ioutil"
+)
+
+const (
+	// fuzzMaxBlocks keeps a mutated journal that never terminates from
+	// stalling the fuzzer.
+	fuzzMaxBlocks = 100
+)
+
+// getFuzzJournal returns a journal superblock followed by the first few
+// blocks of the raw journal that we have on hand.
+func getFuzzJournal(tb testing.TB) []byte {
+	raw, err := ioutil.ReadFile("journal.hex")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	// The first transaction in the raw journal is (2).
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SSequence = 2
+
+	b := bytes.NewBuffer(encodeTestJournalSuperblock(jsbd))
+	b.Write(raw[:12*1024])
+
+	return b.Bytes()
+}
+
+func FuzzNewJournalSuperblock(f *testing.F) {
+	journal := getFuzzJournal(f)
+
+	f.Add(journal[:binary.Size(JournalSuperblockData{})])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		jsb, err := NewJournalSuperblock(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		_ = jsb.Data()
+	})
+}
+
+func FuzzJournalSuperblock_NextBlock(f *testing.F) {
+	f.Add(getFuzzJournal(f))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+
+		jsb, err := NewJournalSuperblock(r)
+		if err != nil {
+			return
+		}
+
+		for i := 0; i < fuzzMaxBlocks; i++ {
+			jb, err := jsb.NextBlock(r)
+			if err != nil {
+				return
+			}
+
+			_ = jb.String()
+		}
+	})
+}
+
+func FuzzJournal_Transactions(f *testing.F) {
+	f.Add(getFuzzJournal(f))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		j, err := NewJournalWithReaderAt(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		ti := j.Transactions()
+
+		for i := 0; i < fuzzMaxBlocks; i++ {
+			transaction, err := ti.Next()
+			if err != nil {
+				return
+			}
+
+			_ = transaction.String()
+		}
+	})
+}
+
+func FuzzJournal_History(f *testing.F) {
+	f.Add(getFuzzJournal(f))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		j, err := NewJournalWithReaderAt(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		ti := j.History()
+
+		for i := 0; i < fuzzMaxBlocks; i++ {
+			transaction, err := ti.Next()
+			if err != nil {
+				return
+			}
+
+			_ = transaction.String()
+		}
+	})
+}
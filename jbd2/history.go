@@ -0,0 +1,196 @@
+package jbd2
+
+import (
+	"errors"
+	"io"
+
+	"github.com/dsoprea/go-ext4"
+)
+
+// scanHeaders reads every block in the log and returns the descriptor,
+// revoke, and commit blocks that it finds, by sequence. The blocks for each
+// sequence are in ascending order. Data blocks that happen to look like
+// headers are escaped when they're logged, so anything with the magic-bytes
+// was written as a header at some point.
+func (j *Journal) scanHeaders() (headers map[uint32][]uint32, err error) {
+	headers = make(map[uint32][]uint32)
+
+	for n := j.jsb.data.SFirst; n < j.jsb.LastLogBlock(); n++ {
+		block, err := j.ReadBlock(n)
+		if err != nil {
+			return nil, err
+		}
+
+		jh, err := parseJournalHeader(block)
+		if err != nil {
+			return nil, err
+		}
+
+		if jh.HMagic != JournalBlockHeaderMagicBytes {
+			continue
+		}
+
+		switch jh.HBlocktype {
+		case BtDescriptor, BtBlockCommitRecord, BtBlockRevocationRecord:
+			headers[jh.HSequence] = append(headers[jh.HSequence], n)
+		}
+	}
+
+	return headers, nil
+}
+
+// transactionStart returns the first block of the transaction with the given
+// header blocks. A transaction might wrap from the end of the log to the top,
+// so it starts after the largest gap between its blocks (going around).
+func (j *Journal) transactionStart(blocks []uint32) uint32 {
+	start := blocks[0]
+	largest := (j.jsb.LastLogBlock() - blocks[len(blocks)-1]) + (blocks[0] - j.jsb.data.SFirst)
+
+	for i := 1; i < len(blocks); i++ {
+		gap := blocks[i] - blocks[i-1]
+		if gap > largest {
+			largest = gap
+			start = blocks[i]
+		}
+	}
+
+	return start
+}
+
+// followsTo indicates how far the log can be followed from the given
+// transaction without running into something incomplete or overwritten. It
+// returns the sequence after the last complete transaction, stopping at
+// `until`.
+func (j *Journal) followsTo(startBlock uint32, sequence uint32, until uint32) (next uint32, err error) {
+	ti := j.TransactionsFrom(startBlock, sequence)
+	next = sequence
+
+	for next != until {
+		t, err := ti.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			var errCorrupt *ext4.ErrCorrupt
+			if errors.As(err, &errCorrupt) == true {
+				break
+			}
+
+			return 0, err
+		}
+
+		if t.Complete == false {
+			break
+		}
+
+		next = t.Sequence + 1
+	}
+
+	return next, nil
+}
+
+// findOldestTransaction finds the oldest transaction that's still intact and
+// that the log can be followed from, all the way to the first transaction in
+// the log proper (or the next one to be written, if the journal is clean).
+// Transactions are only overwritten from the oldest forward, so everything
+// from there on is still there.
+func (j *Journal) findOldestTransaction() (startBlock uint32, sequence uint32, found bool, err error) {
+	headers, err := j.scanHeaders()
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	newest := j.jsb.data.SSequence
+
+	oldest := newest
+	for {
+		if _, found := headers[oldest-1]; found == false {
+			break
+		}
+
+		oldest--
+	}
+
+	for sequence = oldest; sequence != newest; {
+		startBlock = j.transactionStart(headers[sequence])
+
+		next, err := j.followsTo(startBlock, sequence, newest)
+		if err != nil {
+			return 0, 0, false, err
+		}
+
+		if next == newest {
+			return startBlock, sequence, true, nil
+		}
+
+		// Transaction `next` was partially overwritten, so try again with the
+		// one after it.
+		sequence = next + 1
+	}
+
+	return 0, 0, false, nil
+}
+
+// historyIterators returns a function that creates iterators over the
+// transactions from `History`.
+func (j *Journal) historyIterators() (newIterator func() *TransactionIterator, err error) {
+	startBlock, sequence, found, err := j.findOldestTransaction()
+	if err != nil {
+		return nil, err
+	}
+
+	if found == false {
+		return j.Transactions, nil
+	}
+
+	newIterator = func() *TransactionIterator {
+		return j.TransactionsFrom(startBlock, sequence)
+	}
+
+	return newIterator, nil
+}
+
+// History returns an iterator over every transaction that's still in the
+// journal, starting with the oldest one that hasn't been overwritten. Unlike
+// `Transactions`, this includes the transactions that were already
+// checkpointed (everything before `SStart`, or everything if the journal is
+// clean), which still hold older copies of the blocks that they logged.
+//
+// Finding the oldest transaction requires reading the whole log.
+func (j *Journal) History() *TransactionIterator {
+	newIterator, err := j.historyIterators()
+	if err != nil {
+		return &TransactionIterator{
+			journal:      j,
+			nextSequence: j.jsb.data.SSequence,
+			done:         true,
+			err:          err,
+		}
+	}
+
+	return newIterator()
+}
+
+// HistoryRevokes returns the revokes of every committed transaction in
+// `History`. A copy of a block in a transaction up to the one that revoked it
+// is stale, since the block was freed after it was written.
+func (j *Journal) HistoryRevokes() (rs *RevokeSet, err error) {
+	rs = NewRevokeSet()
+
+	ti := j.History()
+	for {
+		t, err := ti.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if t.Complete == false {
+			break
+		}
+
+		rs.Merge(t.Revokes)
+	}
+
+	return rs, nil
+}
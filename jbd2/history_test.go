@@ -0,0 +1,130 @@
+package jbd2
+
+import (
+	"bytes"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestJournal_History(t *testing.T) {
+	filepath := path.Join(assetsPath, "history.ext4")
+
+	f, inode, err := GetJournalInode(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	j, err := NewJournalWithInode(inode)
+	log.PanicIf(err)
+
+	// The journal is clean, but all three transactions are still in it.
+
+	transactions, err := getTestTransactions(j.History())
+	log.PanicIf(err)
+
+	descriptions := make([]string, len(transactions))
+	for i, t := range transactions {
+		descriptions[i] = t.String()
+	}
+
+	expected := []string{
+		"Transaction<SEQ=(1) START=(1) BLOCKS=(11) DATA-BLOCKS=(9) REVOKES=(0) COMPLETE=[true]>",
+		"Transaction<SEQ=(2) START=(12) BLOCKS=(4) DATA-BLOCKS=(2) REVOKES=(0) COMPLETE=[true]>",
+		"Transaction<SEQ=(3) START=(16) BLOCKS=(8) DATA-BLOCKS=(6) REVOKES=(0) COMPLETE=[true]>",
+	}
+
+	if reflect.DeepEqual(descriptions, expected) == false {
+		t.Fatalf("History not correct: %v", descriptions)
+	}
+}
+
+func TestJournal_History_Wrapped(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SMaxlen = 16
+	jsbd.SStart = 5
+	jsbd.SSequence = 10
+
+	blockSize := int(jsbd.SBlocksize)
+
+	blocks := map[uint32][]byte{
+		// What's left of (6), whose data ran into (7).
+		8: getTestDescriptorBlock(blockSize, 6, []uint32{100, 101, 102}),
+		9: getTestDataBlock(blockSize, 0xaa),
+
+		10: getTestDescriptorBlock(blockSize, 7, []uint32{103}),
+		11: getTestDataBlock(blockSize, 0xbb),
+		12: getTestCommitBlock(blockSize, 7, 1000),
+
+		// Wraps to the first log block.
+		13: getTestRevokeBlock(blockSize, 8, 4, []uint64{103}),
+		14: getTestDescriptorBlock(blockSize, 8, []uint32{104}),
+		15: getTestDataBlock(blockSize, 0xcc),
+		1:  getTestCommitBlock(blockSize, 8, 1001),
+
+		2: getTestDescriptorBlock(blockSize, 9, []uint32{105}),
+		3: getTestDataBlock(blockSize, 0xdd),
+		4: getTestCommitBlock(blockSize, 9, 1002),
+
+		// The log proper.
+		5: getTestDescriptorBlock(blockSize, 10, []uint32{106}),
+		6: getTestDataBlock(blockSize, 0xee),
+		7: getTestCommitBlock(blockSize, 10, 1003),
+	}
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(getTestJournal(jsbd, blocks)))
+	log.PanicIf(err)
+
+	transactions, err := getTestTransactions(j.History())
+	log.PanicIf(err)
+
+	sequences := make([]uint32, len(transactions))
+	for i, t := range transactions {
+		sequences[i] = t.Sequence
+	}
+
+	if reflect.DeepEqual(sequences, []uint32{7, 8, 9, 10}) == false {
+		t.Fatalf("History not correct: %v", sequences)
+	} else if transactions[1].StartBlock != 13 || transactions[1].BlockCount != 4 {
+		t.Fatalf("Wrapped transaction not correct: %s", transactions[1])
+	}
+}
+
+func TestJournal_History_Empty(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SMaxlen = 16
+	jsbd.SStart = 0
+	jsbd.SSequence = 10
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(getTestJournal(jsbd, nil)))
+	log.PanicIf(err)
+
+	transactions, err := getTestTransactions(j.History())
+	log.PanicIf(err)
+
+	if len(transactions) != 0 {
+		t.Fatalf("Expected no history: %v", transactions)
+	}
+}
+
+func TestJournal_HistoryRevokes(t *testing.T) {
+	journal := getTestJournalBytes("journal_csum.ext4")
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(journal))
+	log.PanicIf(err)
+
+	rs, err := j.HistoryRevokes()
+	log.PanicIf(err)
+
+	// (3000) was written by the first transaction and revoked by the second.
+
+	if reflect.DeepEqual(rs.Blocks(), []uint64{3000}) == false {
+		t.Fatalf("Revoked blocks not correct: %v", rs.Blocks())
+	} else if sequence, _ := rs.Sequence(3000); sequence != 2 {
+		t.Fatalf("Revoking sequence not correct: (%d)", sequence)
+	} else if rs.IsRevoked(3000, 1) != true {
+		t.Fatalf("First copy should be revoked.")
+	}
+}
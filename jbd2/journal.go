@@ -0,0 +1,327 @@
+package jbd2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-ext4"
+)
+
+// Journal provides random access to the blocks of a journal, which lets us
+// follow the log around the end of the journal and back to the top. For an
+// internal journal, block (0) is the superblock. On an external journal
+// device, the journal's blocks are the device's blocks and the superblock
+// follows the device's ext4 superblock.
+type Journal struct {
+	ra  io.ReaderAt
+	jsb *JournalSuperblock
+}
+
+// NewJournalWithReaderAt returns a `Journal` for the journal at the top of
+// `ra`. For an internal journal, that's the data of the journal inode.
+func NewJournalWithReaderAt(ra io.ReaderAt) (j *Journal, err error) {
+	return newJournal(ra, 0)
+}
+
+// newJournal returns a `Journal` whose superblock is at `offset` in `ra`.
+func newJournal(ra io.ReaderAt, offset int64) (j *Journal, err error) {
+	jsb, err := NewJournalSuperblock(io.NewSectionReader(ra, offset, JournalMaxBlockSize))
+	if err != nil {
+		return nil, err
+	}
+
+	j = &Journal{
+		ra:  ra,
+		jsb: jsb,
+	}
+
+	return j, nil
+}
+
+// NewJournalWithInode returns a `Journal` for an internal journal.
+func NewJournalWithInode(inode *ext4.Inode) (j *Journal, err error) {
+	en := ext4.NewExtentNavigatorWithInode(inode)
+	ir := ext4.NewInodeReader(en)
+
+	return NewJournalWithReaderAt(ir)
+}
+
+// NewJournalWithSuperblock returns a `Journal` for the internal journal of the
+// given filesystem. If the journal inode is damaged, the backup of it in the
+// superblock (`SJnlBlocks`) is used instead. For an external journal, use
+// `NewJournalWithDevice` or `OpenJournalDevice`.
+func NewJournalWithSuperblock(sb *ext4.Superblock) (j *Journal, err error) {
+	j, _, err = internalJournal(sb)
+	return j, err
+}
+
+// internalJournal returns the internal journal of the given filesystem and the
+// extent navigator of the journal inode that it was read through. Like
+// e2fsck, this falls back to the backup of the journal inode in the
+// superblock if the inode can't be read or doesn't have a journal in it.
+func internalJournal(sb *ext4.Superblock) (j *Journal, en *ext4.ExtentNavigator, err error) {
+	if sb.HasCompatibleFeature(ext4.SbFeatureCompatHasJournal) == false {
+		return nil, nil, fmt.Errorf("filesystem does not have a journal: %w", ext4.ErrNotFound)
+	} else if sb.Data().SJournalInum == 0 {
+		return nil, nil, &ext4.ErrUnsupportedFeature{Feature: "external journal"}
+	}
+
+	open := func(inode *ext4.Inode, err error) (*Journal, *ext4.ExtentNavigator, error) {
+		if err != nil {
+			return nil, nil, err
+		}
+
+		en := ext4.NewExtentNavigatorWithInode(inode)
+
+		j, err := NewJournalWithReaderAt(ext4.NewInodeReader(en))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return j, en, nil
+	}
+
+	j, en, err = open(journalInode(sb))
+	if err == nil {
+		return j, en, nil
+	}
+
+	j, en, backupErr := open(backupJournalInode(sb))
+	if backupErr == nil {
+		return j, en, nil
+	}
+
+	return nil, nil, err
+}
+
+// journalInode returns the inode of the internal journal.
+func journalInode(sb *ext4.Superblock) (inode *ext4.Inode, err error) {
+	inodeNumber := int(sb.Data().SJournalInum)
+
+	bgdl, err := ext4.NewBlockGroupDescriptorListWithSuperblock(sb)
+	if err != nil {
+		return nil, err
+	}
+
+	bgd, err := bgdl.GetWithAbsoluteInode(inodeNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	return ext4.NewInodeWithBlockGroupDescriptor(bgd, inodeNumber)
+}
+
+// backupJournalInode returns a journal inode built from the copy of its block
+// map and size that the superblock keeps (`SJnlBlocks`).
+func backupJournalInode(sb *ext4.Superblock) (inode *ext4.Inode, err error) {
+	sbd := sb.Data()
+
+	if sbd.SJnlBackupType != ext4.SbJnlBackupBlocks {
+		return nil, fmt.Errorf("no backup of the journal inode: %w", ext4.ErrNotFound)
+	}
+
+	inodeNumber := int(sbd.SJournalInum)
+
+	bgdl, err := ext4.NewBlockGroupDescriptorListWithSuperblock(sb)
+	if err != nil {
+		return nil, err
+	}
+
+	bgd, err := bgdl.GetWithAbsoluteInode(inodeNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first fifteen words are the block map (`IBlock`), then the high and
+	// low words of the size.
+
+	id := ext4.InodeData{
+		IMode:       ext4.InodeModeRegular | 0600,
+		ISizeLo:     sbd.SJnlBlocks[16],
+		ILinksCount: 1,
+		IFlags:      ext4.InodeFlagExtents,
+		ISizeHigh:   sbd.SJnlBlocks[15],
+	}
+
+	for i := 0; i < ext4.Ext4NBlocks; i++ {
+		binary.LittleEndian.PutUint32(id.IBlock[i*4:], sbd.SJnlBlocks[i])
+	}
+
+	if binary.LittleEndian.Uint16(id.IBlock[:]) != ext4.ExtentMagic {
+		return nil, &ext4.ErrUnsupportedFeature{Feature: "block-mapped (non-extent) inodes"}
+	}
+
+	b := new(bytes.Buffer)
+
+	err = binary.Write(b, binary.LittleEndian, &id)
+	if err != nil {
+		return nil, err
+	}
+
+	return ext4.NewInodeWithBytes(bgd, inodeNumber, b.Bytes())
+}
+
+// NewJournalWithDevice returns a `Journal` for an external journal device (an
+// image made with `SbFeatureIncompatJournalDev`). If `sb` is given, the device
+// has to be that filesystem's journal: the device's UUID has to be the one
+// that the filesystem names (`SJournalUuid`), and the filesystem has to be one
+// of the journal's users.
+func NewJournalWithDevice(device io.ReaderAt, sb *ext4.Superblock) (j *Journal, err error) {
+	dsb, err := ext4.NewSuperblockWithReaderAt(device)
+	if err != nil {
+		return nil, err
+	}
+
+	if dsb.HasIncompatibleFeature(ext4.SbFeatureIncompatJournalDev) == false {
+		return nil, fmt.Errorf("not a journal device: %w", ext4.ErrNotFound)
+	}
+
+	// The journal superblock is in the block after the device's superblock.
+
+	blockSize := int64(dsb.BlockSize())
+	superblockBlock := ext4.Superblock0Offset/blockSize + 1
+
+	j, err = newJournal(device, superblockBlock*blockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(j.BlockSize()) != blockSize {
+		return nil, &ext4.ErrCorrupt{
+			Structure: "journal superblock",
+			Block:     uint64(superblockBlock),
+			Reason:    fmt.Sprintf("block-size (%d) not the same as the device's (%d)", j.BlockSize(), blockSize),
+		}
+	}
+
+	if sb == nil {
+		return j, nil
+	}
+
+	journalUuid := sb.Data().SJournalUuid
+	if dsb.Data().SUuid != journalUuid {
+		return nil, fmt.Errorf("journal device [%032x] is not the filesystem's journal [%032x]: %w", dsb.Data().SUuid, journalUuid, ext4.ErrNotFound)
+	}
+
+	for _, user := range j.jsb.Users() {
+		if user == sb.Data().SUuid {
+			return j, nil
+		}
+	}
+
+	return nil, fmt.Errorf("filesystem [%032x] is not a user of the journal: %w", sb.Data().SUuid, ext4.ErrNotFound)
+}
+
+// JournalDevicePath returns the path that the filesystem's external journal
+// device can be opened at, from its device number (`SJournalDev`). This is
+// where udev puts it, and only means something on the system that the
+// filesystem is attached to.
+func JournalDevicePath(sb *ext4.Superblock) string {
+	major, minor := sb.JournalDevice()
+	return fmt.Sprintf("/dev/block/%d:%d", major, minor)
+}
+
+// OpenJournalDevice opens the external journal of the given filesystem at
+// `JournalDevicePath` and checks that it's the right one. It's the
+// responsibility of the caller to close the file.
+func OpenJournalDevice(sb *ext4.Superblock) (f *os.File, j *Journal, err error) {
+	if sb.HasCompatibleFeature(ext4.SbFeatureCompatHasJournal) == false || sb.Data().SJournalInum != 0 {
+		return nil, nil, fmt.Errorf("filesystem does not have an external journal: %w", ext4.ErrNotFound)
+	}
+
+	f, err = os.Open(JournalDevicePath(sb))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	j, err = NewJournalWithDevice(f, sb)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, j, nil
+}
+
+func (j *Journal) Superblock() *JournalSuperblock {
+	return j.jsb
+}
+
+// BlockSize returns the size of the journal's blocks.
+func (j *Journal) BlockSize() int {
+	return int(j.jsb.data.SBlocksize)
+}
+
+// ReadBlock returns the given journal block.
+func (j *Journal) ReadBlock(n uint32) (data []byte, err error) {
+	if n >= j.jsb.data.SMaxlen {
+		return nil, fmt.Errorf("journal block (%d) beyond the end of the journal (%d): %w", n, j.jsb.data.SMaxlen, ext4.ErrNotFound)
+	}
+
+	blockSize := j.BlockSize()
+	data = make([]byte, blockSize)
+
+	err = ext4.ReadFullAt(j.ra, data, int64(n)*int64(blockSize))
+	if err != nil {
+		return nil, fmt.Errorf("read of journal block (%d) failed: %w", n, err)
+	}
+
+	return data, nil
+}
+
+// nextLogBlock returns the block following `n` in the log, wrapping from the
+// end of the log back to the first log block.
+func (j *Journal) nextLogBlock(n uint32) uint32 {
+	n++
+
+	if n >= j.jsb.LastLogBlock() {
+		n = j.jsb.data.SFirst
+	}
+
+	return n
+}
+
+// logLength returns the number of blocks in the (circular) log.
+func (j *Journal) logLength() uint32 {
+	return j.jsb.LastLogBlock() - j.jsb.data.SFirst
+}
+
+// Transactions returns an iterator over the transactions that are still in
+// the log, starting where the superblock says that the log starts. There are
+// none if the journal is clean (`SStart` is zero).
+func (j *Journal) Transactions() *TransactionIterator {
+	if j.jsb.data.SStart == 0 {
+		return &TransactionIterator{
+			journal:      j,
+			nextSequence: j.jsb.data.SSequence,
+			done:         true,
+		}
+	}
+
+	return j.TransactionsFrom(j.jsb.data.SStart, j.jsb.data.SSequence)
+}
+
+// TransactionsFrom returns an iterator over the transactions starting at the
+// given journal block and sequence. This can be used to look at transactions
+// that have already been checkpointed but not yet overwritten.
+func (j *Journal) TransactionsFrom(startBlock uint32, sequence uint32) *TransactionIterator {
+	ti := &TransactionIterator{
+		journal:      j,
+		nextBlock:    startBlock,
+		nextSequence: sequence,
+	}
+
+	if startBlock < j.jsb.data.SFirst || startBlock >= j.jsb.LastLogBlock() {
+		ti.done = true
+		ti.err = &ext4.ErrCorrupt{
+			Structure: "journal",
+			Reason:    fmt.Sprintf("log start (%d) not within the log (%d)-(%d)", startBlock, j.jsb.data.SFirst, j.jsb.LastLogBlock()),
+		}
+	}
+
+	return ti
+}
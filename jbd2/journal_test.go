@@ -0,0 +1,247 @@
+package jbd2
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"encoding/binary"
+	"io/ioutil"
+
+	"github.com/dsoprea/go-ext4"
+	"github.com/dsoprea/go-logging"
+)
+
+// getTestDescriptorBlock returns a descriptor block for a 32-bit journal
+// without checksums.
+func getTestDescriptorBlock(blockSize int, sequence uint32, targets []uint32) []byte {
+	data := make([]byte, blockSize)
+
+	binary.BigEndian.PutUint32(data[0:], JournalBlockHeaderMagicBytes)
+	binary.BigEndian.PutUint32(data[4:], BtDescriptor)
+	binary.BigEndian.PutUint32(data[8:], sequence)
+
+	offset := JournalHeaderSize
+	for i, target := range targets {
+		flags := uint16(0)
+
+		if i > 0 {
+			flags |= JbtfSameUuidAsPrevious
+		}
+
+		if i == len(targets)-1 {
+			flags |= JbtfLastTag
+		}
+
+		binary.BigEndian.PutUint32(data[offset:], target)
+		binary.BigEndian.PutUint16(data[offset+6:], flags)
+
+		offset += 8
+
+		if i == 0 {
+			// UUID
+			offset += 16
+		}
+	}
+
+	return data
+}
+
+// getTestCommitBlock returns a commit block.
+func getTestCommitBlock(blockSize int, sequence uint32, commitSec uint64) []byte {
+	data := make([]byte, blockSize)
+
+	binary.BigEndian.PutUint32(data[0:], JournalBlockHeaderMagicBytes)
+	binary.BigEndian.PutUint32(data[4:], BtBlockCommitRecord)
+	binary.BigEndian.PutUint32(data[8:], sequence)
+	binary.BigEndian.PutUint64(data[0x30:], commitSec)
+
+	return data
+}
+
+// getTestDataBlock returns a block filled with the given byte.
+func getTestDataBlock(blockSize int, value byte) []byte {
+	return bytes.Repeat([]byte{value}, blockSize)
+}
+
+// getTestJournal lays out a journal with the given superblock and blocks.
+func getTestJournal(jsbd JournalSuperblockData, blocks map[uint32][]byte) []byte {
+	blockSize := int(jsbd.SBlocksize)
+	journal := make([]byte, int(jsbd.SMaxlen)*blockSize)
+
+	copy(journal, encodeTestJournalSuperblock(jsbd))
+
+	for n, block := range blocks {
+		copy(journal[int(n)*blockSize:], block)
+	}
+
+	return journal
+}
+
+func TestNewJournalWithInode(t *testing.T) {
+	filepath := path.Join(assetsPath, "journal.ext4")
+
+	f, inode, err := GetJournalInode(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	j, err := NewJournalWithInode(inode)
+	log.PanicIf(err)
+
+	if j.BlockSize() != 1024 {
+		t.Fatalf("Block size not correct: (%d)", j.BlockSize())
+	}
+
+	// Journal block (1) is the first descriptor.
+
+	block, err := j.ReadBlock(1)
+	log.PanicIf(err)
+
+	jh, err := parseJournalHeader(block)
+	log.PanicIf(err)
+
+	if jh.HBlocktype != BtDescriptor || jh.HSequence != 2 {
+		t.Fatalf("Block not correct: %s", jh)
+	}
+
+	_, err = j.ReadBlock(1024)
+	if errors.Is(err, ext4.ErrNotFound) == false {
+		t.Fatalf("Expected not-found for block past the end: %v", err)
+	}
+}
+
+func TestNewJournalWithSuperblock_BackupInode(t *testing.T) {
+	raw, err := ioutil.ReadFile(path.Join(assetsPath, "journal.ext4"))
+	log.PanicIf(err)
+
+	sb, err := ext4.NewSuperblockWithReaderAt(bytes.NewReader(raw))
+	log.PanicIf(err)
+
+	expected, err := NewJournalWithSuperblock(sb)
+	log.PanicIf(err)
+
+	// Wipe the block map of the journal inode.
+
+	bgdl, err := ext4.NewBlockGroupDescriptorListWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bgd, err := bgdl.GetWithAbsoluteInode(ext4.InodeJournal)
+	log.PanicIf(err)
+
+	offset := int(bgd.InodeTableBlock())*1024 + (ext4.InodeJournal-1)*int(sb.InodeSize())
+	copy(raw[offset+0x28:offset+0x28+60], make([]byte, 60))
+
+	sb, err = ext4.NewSuperblockWithReaderAt(bytes.NewReader(raw))
+	log.PanicIf(err)
+
+	inode, err := journalInode(sb)
+	log.PanicIf(err)
+
+	_, err = NewJournalWithInode(inode)
+	if err == nil {
+		t.Fatalf("Expected the journal inode to be unusable.")
+	}
+
+	j, err := NewJournalWithSuperblock(sb)
+	log.PanicIf(err)
+
+	for n := uint32(0); n < expected.Superblock().Data().SMaxlen; n++ {
+		actual, err := j.ReadBlock(n)
+		log.PanicIf(err)
+
+		original, err := expected.ReadBlock(n)
+		log.PanicIf(err)
+
+		if bytes.Equal(actual, original) == false {
+			t.Fatalf("Journal block (%d) not correct.", n)
+		}
+	}
+
+	// Without the backup, there's no journal.
+
+	sb.Data().SJnlBackupType = 0
+
+	_, err = NewJournalWithSuperblock(sb)
+	if err == nil {
+		t.Fatalf("Expected an error without the backup.")
+	}
+}
+
+func TestNewJournalWithDevice(t *testing.T) {
+	f, err := os.Open(path.Join(assetsPath, "external.ext4"))
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	device, err := os.Open(path.Join(assetsPath, "external_journal.ext4"))
+	log.PanicIf(err)
+
+	defer device.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	var errUnsupported *ext4.ErrUnsupportedFeature
+
+	_, err = NewJournalWithSuperblock(sb)
+	if errors.As(err, &errUnsupported) == false {
+		t.Fatalf("Expected ErrUnsupportedFeature for the internal journal: %v", err)
+	} else if JournalDevicePath(sb) != "/dev/block/7:0" {
+		t.Fatalf("Device path not correct: [%s]", JournalDevicePath(sb))
+	}
+
+	j, err := NewJournalWithDevice(device, sb)
+	log.PanicIf(err)
+
+	// The log blocks are the device's blocks. The journal superblock is in
+	// block (2), after the device's superblock.
+
+	jsbd := j.Superblock().Data()
+	if jsbd.SFirst != 3 || jsbd.SMaxlen != 1100 || jsbd.SStart != 3 || jsbd.SSequence != 2 {
+		t.Fatalf("Journal superblock not correct: FIRST=(%d) MAXLEN=(%d) START=(%d) SEQUENCE=(%d)", jsbd.SFirst, jsbd.SMaxlen, jsbd.SStart, jsbd.SSequence)
+	}
+
+	transactions, err := getTestTransactions(j.Transactions())
+	log.PanicIf(err)
+
+	if len(transactions) != 1 {
+		t.Fatalf("Expected one transaction: (%d)", len(transactions))
+	} else if transactions[0].Sequence != 2 || transactions[0].Complete != true {
+		t.Fatalf("Transaction not correct: %s", transactions[0])
+	}
+}
+
+func TestNewJournalWithDevice_WrongFilesystem(t *testing.T) {
+	device, err := os.Open(path.Join(assetsPath, "external_journal.ext4"))
+	log.PanicIf(err)
+
+	defer device.Close()
+
+	f, err := os.Open(path.Join(assetsPath, "journal.ext4"))
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	// Any filesystem's journal can be opened without checking.
+
+	_, err = NewJournalWithDevice(device, nil)
+	log.PanicIf(err)
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	_, err = NewJournalWithDevice(device, sb)
+	if errors.Is(err, ext4.ErrNotFound) == false {
+		t.Fatalf("Expected not-found for the wrong filesystem: %v", err)
+	}
+
+	// Something that's not a journal device.
+
+	_, err = NewJournalWithDevice(f, nil)
+	if errors.Is(err, ext4.ErrNotFound) == false {
+		t.Fatalf("Expected not-found for a filesystem: %v", err)
+	}
+}
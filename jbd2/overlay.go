@@ -0,0 +1,188 @@
+package jbd2
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-ext4"
+)
+
+// overlayBlock is where the copy of a filesystem block that an overlay serves
+// is in the journal.
+type overlayBlock struct {
+	journalBlock uint32
+	sequence     uint32
+	escaped      bool
+}
+
+// JournalOverlay is an `io.ReaderAt` over a filesystem image that reads as if
+// the journal had been replayed, without writing anything: every block that
+// recovery would have written is read from the journal instead of from the
+// image. Pass it to `ext4.NewSuperblockWithReaderAt` to look at an unclean
+// filesystem the way the kernel would see it after mounting.
+//
+// Only the filesystem blocks are overlaid, so the superblock still has
+// `SbFeatureIncompatRecover` set and the journal still looks like it needs
+// recovery.
+type JournalOverlay struct {
+	ra        io.ReaderAt
+	journal   *Journal
+	blockSize int64
+
+	blocks map[uint64]overlayBlock
+	info   *RecoveryInfo
+}
+
+// NewJournalOverlay returns a `JournalOverlay` for the image in `ra`, whose
+// journal is `j`. The journal has to have the same block-size as the
+// filesystem, which is always the case for an internal journal.
+func NewJournalOverlay(ra io.ReaderAt, j *Journal) (jo *JournalOverlay, err error) {
+	return newJournalOverlay(ra, j, j.Transactions, nil)
+}
+
+// NewJournalOverlayAt returns a `JournalOverlay` that reads the filesystem as
+// of the given transaction: only the transactions in the journal's `History`
+// up to and including `sequence` are overlaid. This shows the blocks that they
+// logged (the metadata, normally) as they were at that point, e.g. a directory
+// from before a file was deleted from it. Blocks that weren't logged by any
+// of those transactions are read from the image, so they might be newer than
+// that.
+//
+// If the transaction is no longer in the journal (or never was), the error
+// wraps `ext4.ErrNotFound`.
+func NewJournalOverlayAt(ra io.ReaderAt, j *Journal, sequence uint32) (jo *JournalOverlay, err error) {
+	newIterator, err := j.historyIterators()
+	if err != nil {
+		return nil, err
+	}
+
+	jo, err = newJournalOverlay(ra, j, newIterator, func(t *Transaction) bool {
+		return SequenceAfter(t.Sequence, sequence) == true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if jo.info.Transactions == 0 || jo.info.EndSequence != sequence+1 {
+		return nil, fmt.Errorf("transaction (%d) not in the journal: %w", sequence, ext4.ErrNotFound)
+	}
+
+	return jo, nil
+}
+
+// newJournalOverlay builds an overlay from the transactions that `replay`
+// accepts.
+func newJournalOverlay(ra io.ReaderAt, j *Journal, newIterator func() *TransactionIterator, stop func(t *Transaction) bool) (jo *JournalOverlay, err error) {
+	jo = &JournalOverlay{
+		ra:        ra,
+		journal:   j,
+		blockSize: int64(j.BlockSize()),
+		blocks:    make(map[uint64]overlayBlock),
+	}
+
+	// Later copies replace earlier ones, just like later writes would.
+	jo.info, err = j.replay(newIterator, stop, func(t *Transaction, dataBlock JournalDataBlock) error {
+		jo.blocks[dataBlock.Blocknr()] = overlayBlock{
+			journalBlock: dataBlock.JournalBlock,
+			sequence:     t.Sequence,
+			escaped:      (dataBlock.Tag.TFlags & JbtfDataMatchesMagicBytes) > 0,
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return jo, nil
+}
+
+// Info describes the transactions that the overlay is made of. Nothing is
+// written, but `Replayed` counts the blocks that would have been.
+func (jo *JournalOverlay) Info() *RecoveryInfo {
+	return jo.info
+}
+
+// Blocks returns the (sorted) filesystem blocks that are read from the
+// journal.
+func (jo *JournalOverlay) Blocks() []uint64 {
+	blocks := make([]uint64, 0, len(jo.blocks))
+	for block := range jo.blocks {
+		blocks = append(blocks, block)
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i] < blocks[j]
+	})
+
+	return blocks
+}
+
+// Source returns where the given filesystem block is read from, if it's read
+// from the journal: the journal block and the transaction that logged it.
+func (jo *JournalOverlay) Source(block uint64) (journalBlock uint32, sequence uint32, found bool) {
+	ob, found := jo.blocks[block]
+	return ob.journalBlock, ob.sequence, found
+}
+
+// readBlock returns the journal's copy of the given filesystem block.
+func (jo *JournalOverlay) readBlock(ob overlayBlock) (data []byte, err error) {
+	data, err = jo.journal.ReadBlock(ob.journalBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	if ob.escaped == true {
+		binary.BigEndian.PutUint32(data, JournalBlockHeaderMagicBytes)
+	}
+
+	return data, nil
+}
+
+// ReadAt reads from the journal where it has a copy and from the image
+// everywhere else. It's safe for concurrent use as long as the image and the
+// journal are.
+func (jo *JournalOverlay) ReadAt(p []byte, offset int64) (n int, err error) {
+	if offset < 0 {
+		return 0, fmt.Errorf("offset not valid: (%d)", offset)
+	}
+
+	for n < len(p) {
+		current := offset + int64(n)
+		block := uint64(current / jo.blockSize)
+		blockOffset := current % jo.blockSize
+
+		chunk := p[n:]
+		if int64(len(chunk)) > jo.blockSize-blockOffset {
+			chunk = chunk[:jo.blockSize-blockOffset]
+		}
+
+		if ob, found := jo.blocks[block]; found == true {
+			data, err := jo.readBlock(ob)
+			if err != nil {
+				return n, fmt.Errorf("read of block (%d) from journal block (%d) failed: %w", block, ob.journalBlock, err)
+			}
+
+			n += copy(chunk, data[blockOffset:])
+			continue
+		}
+
+		m, err := jo.ra.ReadAt(chunk, current)
+		n += m
+
+		if m < len(chunk) {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return n, err
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,261 @@
+package jbd2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-ext4"
+	"github.com/dsoprea/go-logging"
+)
+
+func TestNewJournalOverlay(t *testing.T) {
+	filepath := path.Join(assetsPath, "journal_csum.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	j, err := NewJournalWithSuperblock(sb)
+	log.PanicIf(err)
+
+	jo, err := NewJournalOverlay(f, j)
+	log.PanicIf(err)
+
+	if reflect.DeepEqual(jo.Blocks(), []uint64{3001, 3002, 3003}) == false {
+		t.Fatalf("Overlaid blocks not correct: %v", jo.Blocks())
+	} else if jo.Info().Transactions != 3 || jo.Info().Replayed != 3 {
+		t.Fatalf("Info not correct: %s", jo.Info())
+	}
+
+	journalBlock, sequence, found := jo.Source(3003)
+	if found != true || journalBlock != 9 || sequence != 3 {
+		t.Fatalf("Source not correct: (%d) (%d) [%v]", journalBlock, sequence, found)
+	}
+
+	_, _, found = jo.Source(3000)
+	if found != false {
+		t.Fatalf("Revoked block should not be overlaid.")
+	}
+
+	// Everything but the two superblocks reads the same as after a real
+	// recovery.
+
+	cow, err := ext4.NewCopyOnWriteWithReaderAt(f, 1024)
+	log.PanicIf(err)
+
+	_, err = RecoverFilesystem(cow)
+	log.PanicIf(err)
+
+	actual := make([]byte, sb.BlockCount()*1024)
+
+	_, err = jo.ReadAt(actual, 0)
+	log.PanicIf(err)
+
+	expected := make([]byte, len(actual))
+
+	_, err = cow.ReadAt(expected, 0)
+	log.PanicIf(err)
+
+	for _, block := range []int{1, 48} {
+		copy(expected[block*1024:(block+1)*1024], actual[block*1024:(block+1)*1024])
+	}
+
+	if bytes.Equal(actual, expected) == false {
+		t.Fatalf("Overlay not the same as the recovered image.")
+	}
+
+	// The filesystem can be read through the overlay.
+
+	overlaidSb, err := ext4.NewSuperblockWithReaderAt(jo)
+	log.PanicIf(err)
+
+	data, err := overlaidSb.ReadPhysicalBlock(3003, 1024)
+	log.PanicIf(err)
+
+	if bytes.Equal(data, getTestJournalBytes("journal_csum.ext4")[9*1024:10*1024]) == false {
+		t.Fatalf("Block not read from the journal.")
+	}
+}
+
+func TestJournalOverlay_ReadAt_Escaped(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SMaxlen = 16
+
+	blockSize := int(jsbd.SBlocksize)
+
+	descriptor := getTestDescriptorBlock(blockSize, 1, []uint32{10})
+	flags := binary.BigEndian.Uint16(descriptor[JournalHeaderSize+6:])
+	binary.BigEndian.PutUint16(descriptor[JournalHeaderSize+6:], flags|JbtfDataMatchesMagicBytes)
+
+	blocks := map[uint32][]byte{
+		1: descriptor,
+		2: getTestDataBlock(blockSize, 0),
+		3: getTestCommitBlock(blockSize, 1, 1000),
+	}
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(getTestJournal(jsbd, blocks)))
+	log.PanicIf(err)
+
+	filesystem := bytes.Repeat([]byte{0xff}, 16*blockSize)
+
+	jo, err := NewJournalOverlay(bytes.NewReader(filesystem), j)
+	log.PanicIf(err)
+
+	// Straddle the overlaid block and the one before it.
+
+	data := make([]byte, 8)
+
+	_, err = jo.ReadAt(data, int64(10*blockSize-4))
+	log.PanicIf(err)
+
+	if bytes.Equal(data, []byte{0xff, 0xff, 0xff, 0xff, 0xc0, 0x3b, 0x39, 0x98}) == false {
+		t.Fatalf("Data not correct: %x", data)
+	}
+}
+
+// getTestInode returns the given inode from the filesystem in `ra`.
+func getTestInode(ra io.ReaderAt, inodeNumber int) *ext4.Inode {
+	sb, err := ext4.NewSuperblockWithReaderAt(ra)
+	log.PanicIf(err)
+
+	bgdl, err := ext4.NewBlockGroupDescriptorListWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bgd, err := bgdl.GetWithAbsoluteInode(inodeNumber)
+	log.PanicIf(err)
+
+	inode, err := ext4.NewInodeWithBlockGroupDescriptor(bgd, inodeNumber)
+	log.PanicIf(err)
+
+	return inode
+}
+
+// getTestDirectoryNames returns the names in the given directory of the
+// filesystem in `ra`. Unused entries (including the checksum tail) are
+// skipped.
+func getTestDirectoryNames(ra io.ReaderAt, inodeNumber int) []string {
+	db, err := ext4.NewDirectoryBrowser(getTestInode(ra, inodeNumber))
+	log.PanicIf(err)
+
+	names := make([]string, 0)
+
+	for {
+		de, err := db.Next()
+		if err == io.EOF {
+			break
+		}
+
+		log.PanicIf(err)
+
+		if de.Data().Inode == 0 {
+			continue
+		}
+
+		names = append(names, de.Name())
+	}
+
+	return names
+}
+
+func TestNewJournalOverlayAt(t *testing.T) {
+	filepath := path.Join(assetsPath, "history.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	j, err := NewJournalWithSuperblock(sb)
+	log.PanicIf(err)
+
+	// The "docs" directory is inode (12). The first transaction created it and
+	// three files, the second renamed "b.txt" to "c.txt" and grew "a.txt" (13),
+	// and the third deleted "deleted.txt".
+
+	docsInode := 12
+
+	current := getTestDirectoryNames(f, docsInode)
+	if reflect.DeepEqual(current, []string{".", "..", "a.txt", "c.txt"}) == false {
+		t.Fatalf("Current directory not correct: %v", current)
+	}
+
+	expected := map[uint32][]string{
+		1: {".", "..", "a.txt", "b.txt", "deleted.txt"},
+		2: {".", "..", "a.txt", "deleted.txt", "c.txt"},
+		3: {".", "..", "a.txt", "c.txt"},
+	}
+
+	expectedSizes := map[uint32]uint64{
+		1: 500,
+		2: 1000,
+		3: 1000,
+	}
+
+	for sequence, names := range expected {
+		jo, err := NewJournalOverlayAt(f, j, sequence)
+		log.PanicIf(err)
+
+		if jo.Info().EndSequence != sequence+1 {
+			t.Fatalf("View (%d) has the wrong transactions: %s", sequence, jo.Info())
+		}
+
+		actual := getTestDirectoryNames(jo, docsInode)
+		if reflect.DeepEqual(actual, names) == false {
+			t.Fatalf("Directory as of (%d) not correct: %v", sequence, actual)
+		}
+
+		size := getTestInode(jo, 13).Size()
+		if size != expectedSizes[sequence] {
+			t.Fatalf("Size as of (%d) not correct: (%d)", sequence, size)
+		}
+	}
+
+	for _, sequence := range []uint32{0, 4} {
+		_, err = NewJournalOverlayAt(f, j, sequence)
+		if errors.Is(err, ext4.ErrNotFound) == false {
+			t.Fatalf("Expected not-found for (%d): %v", sequence, err)
+		}
+	}
+}
+
+func ExampleNewJournalOverlay() {
+	filepath := path.Join(assetsPath, "journal_csum.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	j, err := NewJournalWithSuperblock(sb)
+	log.PanicIf(err)
+
+	jo, err := NewJournalOverlay(f, j)
+	log.PanicIf(err)
+
+	// Read the filesystem as if the journal had been replayed.
+	sb, err = ext4.NewSuperblockWithReaderAt(jo)
+	log.PanicIf(err)
+
+	fmt.Printf("%v\n", jo.Blocks())
+
+	// Output:
+	// [3001 3002 3003]
+}
@@ -0,0 +1,330 @@
+package jbd2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-ext4"
+)
+
+const (
+	// Offsets within the ext4 superblock of the fields that recovery updates.
+	ext4SuperblockIncompatOffset = 0x60
+	ext4SuperblockRoCompatOffset = 0x64
+	ext4SuperblockChecksumOffset = 0x3FC
+
+	journalSuperblockSize           = 0x400
+	journalSuperblockSequenceOffset = 0x18
+	journalSuperblockStartOffset    = 0x1C
+	journalSuperblockChecksumOffset = 0xFC
+)
+
+// ReaderWriterAt can be read and written at arbitrary offsets, like an
+// `*os.File` or an `ext4.CopyOnWrite`.
+type ReaderWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// RecoveryInfo describes what recovery found and did (struct recovery_info
+// in the kernel).
+type RecoveryInfo struct {
+	// StartSequence is the first transaction in the log.
+	StartSequence uint32
+
+	// EndSequence is the transaction after the last one that was complete.
+	EndSequence uint32
+
+	// Transactions is the number of complete transactions that were replayed.
+	Transactions int
+
+	// Replayed is the number of blocks written.
+	Replayed int
+
+	// RevokeRecords is the number of distinct blocks that were revoked.
+	RevokeRecords int
+
+	// Revoked is the number of logged blocks that were skipped because they
+	// were revoked.
+	Revoked int
+
+	// ChecksumFailures is the number of logged blocks that were skipped because
+	// their checksums didn't match.
+	ChecksumFailures int
+}
+
+func (ri *RecoveryInfo) String() string {
+	return fmt.Sprintf("RecoveryInfo<START-SEQ=(%d) END-SEQ=(%d) TRANSACTIONS=(%d) REPLAYED=(%d) REVOKE-RECORDS=(%d) REVOKED=(%d) CHECKSUM-FAILURES=(%d)>", ri.StartSequence, ri.EndSequence, ri.Transactions, ri.Replayed, ri.RevokeRecords, ri.Revoked, ri.ChecksumFailures)
+}
+
+// forEachTransaction calls `cb` with each of the first `count` transactions
+// from the iterator.
+func forEachTransaction(ti *TransactionIterator, count int, cb func(t *Transaction) error) (err error) {
+	for i := 0; i < count; i++ {
+		t, err := ti.Next()
+		if err != nil {
+			return err
+		}
+
+		err = cb(t)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanLog finds the end of the log: it counts the complete transactions from
+// `ti` up to the first one that isn't complete (or that `stop` returns true
+// for) or to a corrupt block, and returns the sequence after the last of them.
+func scanLog(ti *TransactionIterator, stop func(t *Transaction) bool) (transactions int, endSequence uint32, err error) {
+	endSequence = ti.nextSequence
+
+	for {
+		t, err := ti.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			var errCorrupt *ext4.ErrCorrupt
+			if errors.As(err, &errCorrupt) == true {
+				break
+			}
+
+			return 0, 0, err
+		}
+
+		if t.Complete == false || (stop != nil && stop(t) == true) {
+			break
+		}
+
+		transactions++
+		endSequence = t.Sequence + 1
+	}
+
+	return transactions, endSequence, nil
+}
+
+// replay runs the same three passes as the kernel's recovery over the
+// transactions from `ti`:
+//
+//  1. Scan: Find the end of the log. Only complete transactions are replayed,
+//     and the log ends at the first one that isn't. A corrupt block also ends
+//     the log, as does `stop` returning true for a transaction.
+//  2. Revoke: Collect the revoke records from every transaction.
+//  3. Replay: Call `cb` for every logged block unless it was revoked by the
+//     same or a later transaction. Blocks whose checksums don't match are
+//     skipped and counted.
+//
+// `newIterator` is called once per pass.
+func (j *Journal) replay(newIterator func() *TransactionIterator, stop func(t *Transaction) bool, cb func(t *Transaction, dataBlock JournalDataBlock) error) (ri *RecoveryInfo, err error) {
+	ti := newIterator()
+
+	ri = &RecoveryInfo{
+		StartSequence: ti.nextSequence,
+		EndSequence:   ti.nextSequence,
+	}
+
+	// Pass 1: Scan.
+
+	ri.Transactions, ri.EndSequence, err = scanLog(ti, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pass 2: Revoke.
+
+	revokes := NewRevokeSet()
+
+	err = forEachTransaction(newIterator(), ri.Transactions, func(t *Transaction) error {
+		revokes.Merge(t.Revokes)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	ri.RevokeRecords = revokes.Len()
+
+	// Pass 3: Replay.
+
+	err = forEachTransaction(newIterator(), ri.Transactions, func(t *Transaction) error {
+		for _, dataBlock := range t.DataBlocks {
+			if dataBlock.ChecksumValid == false {
+				ri.ChecksumFailures++
+				continue
+			} else if revokes.IsRevoked(dataBlock.Blocknr(), t.Sequence) == true {
+				ri.Revoked++
+				continue
+			}
+
+			err := cb(t, dataBlock)
+			if err != nil {
+				return err
+			}
+
+			ri.Replayed++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ri, nil
+}
+
+// Recover replays the log the way that the kernel does, writing each block
+// that survives (see `replay`) to its home location through `w`, at the block
+// number times the block-size of the journal.
+//
+// Nothing is written to the journal itself. If the journal is clean, there's
+// nothing to do.
+func (j *Journal) Recover(w io.WriterAt) (ri *RecoveryInfo, err error) {
+	blockSize := int64(j.BlockSize())
+
+	ri, err = j.replay(j.Transactions, nil, func(t *Transaction, dataBlock JournalDataBlock) error {
+		blocknr := dataBlock.Blocknr()
+
+		_, err := w.WriteAt(dataBlock.Data, int64(blocknr)*blockSize)
+		if err != nil {
+			return fmt.Errorf("write of block (%d) from transaction (%d) failed: %w", blocknr, t.Sequence, err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ri, nil
+}
+
+// RecoverFilesystem recovers the filesystem in `rw` if it needs recovery (has
+// `SbFeatureIncompatRecover` set). The journal is replayed into `rw`, the
+// journal is marked empty, and the flag is cleared, with the checksums of both
+// superblocks updated to match. To leave the image untouched, pass an
+// `ext4.CopyOnWrite` over it.
+//
+// Any `Superblock` that was already opened on the image will have stale data,
+// so open a new one afterward. Only internal journals are supported.
+func RecoverFilesystem(rw ReaderWriterAt) (ri *RecoveryInfo, err error) {
+	sb, err := ext4.NewSuperblockWithReaderAt(rw)
+	if err != nil {
+		return nil, err
+	}
+
+	if sb.HasIncompatibleFeature(ext4.SbFeatureIncompatRecover) == false {
+		return new(RecoveryInfo), nil
+	}
+
+	j, en, err := internalJournal(sb)
+	if err != nil {
+		return nil, err
+	}
+
+	if uint32(j.BlockSize()) != sb.BlockSize() {
+		return nil, &ext4.ErrCorrupt{
+			Structure: "journal superblock",
+			Reason:    fmt.Sprintf("block-size (%d) not the same as the filesystem's (%d)", j.BlockSize(), sb.BlockSize()),
+		}
+	}
+
+	// The journal superblock is rewritten where it is, so we need to know
+	// where that is before the replay can change anything.
+
+	pBlock, mapped, err := en.MapLogicalBlock(0)
+	if err != nil {
+		return nil, err
+	} else if mapped == false {
+		return nil, &ext4.ErrCorrupt{
+			Structure: "journal",
+			Reason:    "superblock not mapped",
+		}
+	}
+
+	ri, err = j.Recover(rw)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like the kernel, skip a sequence so that nothing left over in the log
+	// can be mistaken for a new transaction.
+	err = resetJournal(rw, int64(pBlock)*int64(sb.BlockSize()), ri.EndSequence+1, j.jsb.HasMetadataChecksums())
+	if err != nil {
+		return nil, err
+	}
+
+	err = setRecoverFlag(rw, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return ri, nil
+}
+
+// resetJournal marks the journal whose superblock is at `offset` as empty and
+// sets the sequence that the next transaction will have.
+func resetJournal(rw ReaderWriterAt, offset int64, sequence uint32, checksummed bool) (err error) {
+	return updateJournalSuperblock(rw, offset, 0, sequence, checksummed)
+}
+
+// updateJournalSuperblock sets the first block of the log (or zero if it's
+// empty) and the sequence of the transaction there in the journal superblock
+// at `offset`.
+func updateJournalSuperblock(rw ReaderWriterAt, offset int64, start uint32, sequence uint32, checksummed bool) (err error) {
+	raw := make([]byte, journalSuperblockSize)
+
+	err = ext4.ReadFullAt(rw, raw, offset)
+	if err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(raw[journalSuperblockSequenceOffset:], sequence)
+	binary.BigEndian.PutUint32(raw[journalSuperblockStartOffset:], start)
+
+	if checksummed == true {
+		binary.BigEndian.PutUint32(raw[journalSuperblockChecksumOffset:], 0)
+		binary.BigEndian.PutUint32(raw[journalSuperblockChecksumOffset:], crc32c(0xffffffff, raw))
+	}
+
+	_, err = rw.WriteAt(raw, offset)
+	return err
+}
+
+// setRecoverFlag sets or clears `SbFeatureIncompatRecover` in the primary
+// superblock. This rereads the superblock, since the replay might have
+// written it.
+func setRecoverFlag(rw ReaderWriterAt, needsRecovery bool) (err error) {
+	raw := make([]byte, ext4.SuperblockSize)
+
+	err = ext4.ReadFullAt(rw, raw, ext4.Superblock0Offset)
+	if err != nil {
+		return err
+	}
+
+	incompat := binary.LittleEndian.Uint32(raw[ext4SuperblockIncompatOffset:])
+	if needsRecovery == true {
+		incompat |= ext4.SbFeatureIncompatRecover
+	} else {
+		incompat &^= ext4.SbFeatureIncompatRecover
+	}
+
+	binary.LittleEndian.PutUint32(raw[ext4SuperblockIncompatOffset:], incompat)
+
+	roCompat := binary.LittleEndian.Uint32(raw[ext4SuperblockRoCompatOffset:])
+	if (roCompat & ext4.SbFeatureRoCompatMetadataCsum) > 0 {
+		checksum := crc32c(0xffffffff, raw[:ext4SuperblockChecksumOffset])
+		binary.LittleEndian.PutUint32(raw[ext4SuperblockChecksumOffset:], checksum)
+	}
+
+	_, err = rw.WriteAt(raw, ext4.Superblock0Offset)
+	return err
+}
@@ -0,0 +1,189 @@
+package jbd2
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-ext4"
+	"github.com/dsoprea/go-logging"
+)
+
+func TestJournal_Recover(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SMaxlen = 32
+	jsbd.SSequence = 5
+	jsbd.SFeatureIncompat = JsbFeatureIncompatRevoke
+
+	blockSize := int(jsbd.SBlocksize)
+
+	blocks := map[uint32][]byte{
+		1: getTestDescriptorBlock(blockSize, 5, []uint32{100, 101}),
+		2: getTestDataBlock(blockSize, 0xaa),
+		3: getTestDataBlock(blockSize, 0xbb),
+		4: getTestCommitBlock(blockSize, 5, 1000),
+
+		// Revokes (100), which keeps the write above from being replayed.
+		5: getTestRevokeBlock(blockSize, 6, 4, []uint64{100}),
+		6: getTestDescriptorBlock(blockSize, 6, []uint32{101}),
+		7: getTestDataBlock(blockSize, 0xcc),
+		8: getTestCommitBlock(blockSize, 6, 1001),
+
+		// Comes after the revoke, so it's replayed.
+		9:  getTestDescriptorBlock(blockSize, 7, []uint32{100}),
+		10: getTestDataBlock(blockSize, 0xdd),
+		11: getTestCommitBlock(blockSize, 7, 1002),
+
+		// Never committed.
+		12: getTestDescriptorBlock(blockSize, 8, []uint32{102}),
+		13: getTestDataBlock(blockSize, 0xee),
+	}
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(getTestJournal(jsbd, blocks)))
+	log.PanicIf(err)
+
+	filesystem := make([]byte, 128*blockSize)
+	cow, err := ext4.NewCopyOnWriteWithReaderAt(bytes.NewReader(filesystem), blockSize)
+	log.PanicIf(err)
+
+	ri, err := j.Recover(cow)
+	log.PanicIf(err)
+
+	if ri.String() != "RecoveryInfo<START-SEQ=(5) END-SEQ=(8) TRANSACTIONS=(3) REPLAYED=(3) REVOKE-RECORDS=(1) REVOKED=(1) CHECKSUM-FAILURES=(0)>" {
+		t.Fatalf("Recovery info not correct: %s", ri)
+	} else if reflect.DeepEqual(cow.ChangedBlocks(), []int64{100, 101}) == false {
+		t.Fatalf("Changed blocks not correct: %v", cow.ChangedBlocks())
+	}
+
+	data := make([]byte, 2*blockSize)
+
+	_, err = cow.ReadAt(data, int64(100*blockSize))
+	log.PanicIf(err)
+
+	if data[0] != 0xdd || data[blockSize] != 0xcc {
+		t.Fatalf("Replayed data not correct: (%02x) (%02x)", data[0], data[blockSize])
+	}
+}
+
+func TestJournal_Recover_Clean(t *testing.T) {
+	filepath := path.Join(assetsPath, "journal.ext4")
+
+	f, inode, err := GetJournalInode(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	j, err := NewJournalWithInode(inode)
+	log.PanicIf(err)
+
+	cow, err := ext4.NewCopyOnWriteWithReaderAt(f, j.BlockSize())
+	log.PanicIf(err)
+
+	ri, err := j.Recover(cow)
+	log.PanicIf(err)
+
+	if ri.Transactions != 0 || ri.Replayed != 0 {
+		t.Fatalf("Nothing should have been recovered: %s", ri)
+	} else if len(cow.ChangedBlocks()) != 0 {
+		t.Fatalf("Nothing should have been written: %v", cow.ChangedBlocks())
+	}
+}
+
+func TestRecoverFilesystem(t *testing.T) {
+	filepath := path.Join(assetsPath, "journal_csum.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	cow, err := ext4.NewCopyOnWriteWithReaderAt(f, 1024)
+	log.PanicIf(err)
+
+	ri, err := RecoverFilesystem(cow)
+	log.PanicIf(err)
+
+	// (3000) was written by the first transaction and revoked by the second.
+	if ri.String() != "RecoveryInfo<START-SEQ=(1) END-SEQ=(4) TRANSACTIONS=(3) REPLAYED=(3) REVOKE-RECORDS=(1) REVOKED=(1) CHECKSUM-FAILURES=(0)>" {
+		t.Fatalf("Recovery info not correct: %s", ri)
+	}
+
+	// The superblock, the journal superblock, and the replayed blocks. This is
+	// what e2fsck changes too, apart from its own bookkeeping.
+	if reflect.DeepEqual(cow.ChangedBlocks(), []int64{1, 48, 3001, 3002, 3003}) == false {
+		t.Fatalf("Changed blocks not correct: %v", cow.ChangedBlocks())
+	}
+
+	journal := getTestJournalBytes("journal_csum.ext4")
+
+	replayed := map[int64]int{
+		3001: 3,
+		3002: 4,
+		3003: 9,
+	}
+
+	for block, journalBlock := range replayed {
+		data := make([]byte, 1024)
+
+		_, err := cow.ReadAt(data, block*1024)
+		log.PanicIf(err)
+
+		if bytes.Equal(data, journal[journalBlock*1024:(journalBlock+1)*1024]) == false {
+			t.Fatalf("Block (%d) not replayed from journal block (%d).", block, journalBlock)
+		}
+	}
+
+	// The filesystem no longer needs recovery, and the superblock checksum was
+	// updated.
+
+	sb, err := ext4.NewSuperblockWithReaderAt(cow)
+	log.PanicIf(err)
+
+	if sb.HasIncompatibleFeature(ext4.SbFeatureIncompatRecover) == true {
+		t.Fatalf("Recover flag not cleared.")
+	}
+
+	raw := make([]byte, ext4.SuperblockSize)
+
+	err = sb.ReadAt(raw, ext4.Superblock0Offset)
+	log.PanicIf(err)
+
+	checksum := binary.LittleEndian.Uint32(raw[ext4SuperblockChecksumOffset:])
+	if checksum != crc32c(0xffffffff, raw[:ext4SuperblockChecksumOffset]) {
+		t.Fatalf("Superblock checksum not correct: (%08x)", checksum)
+	}
+
+	// The journal is empty. This also verifies its checksum, which matches
+	// what e2fsck writes.
+
+	bgdl, err := ext4.NewBlockGroupDescriptorListWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bgd, err := bgdl.GetWithAbsoluteInode(ext4.InodeJournal)
+	log.PanicIf(err)
+
+	inode, err := ext4.NewInodeWithBlockGroupDescriptor(bgd, ext4.InodeJournal)
+	log.PanicIf(err)
+
+	j, err := NewJournalWithInode(inode)
+	log.PanicIf(err)
+
+	jsbd := j.Superblock().Data()
+
+	if jsbd.SStart != 0 || jsbd.SSequence != 5 || jsbd.SChecksum != 0xb9ab2a24 {
+		t.Fatalf("Journal superblock not correct: START=(%d) SEQ=(%d) CHECKSUM=(%08x)", jsbd.SStart, jsbd.SSequence, jsbd.SChecksum)
+	}
+
+	// Doing it again does nothing.
+
+	ri, err = RecoverFilesystem(cow)
+	log.PanicIf(err)
+
+	if ri.Transactions != 0 || len(cow.ChangedBlocks()) != 5 {
+		t.Fatalf("Second recovery should have done nothing: %s", ri)
+	}
+}
@@ -0,0 +1,158 @@
+package jbd2
+
+import (
+	"fmt"
+	"sort"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-ext4"
+)
+
+// revokeRecordSize returns the width of each record in a revoke block.
+func (jsb *JournalSuperblock) revokeRecordSize() int {
+	if jsb.HasIncompatibleFeature(JsbFeatureIncompat64bit) == true {
+		return 8
+	}
+
+	return 4
+}
+
+// parseRevokeBlock parses the revoke block whose header has already been
+// read. `data` is the rest of the block, `usableSize` is how much of the
+// whole block (including the header) can hold records, and `recordSize` is
+// four or eight.
+func parseRevokeBlock(jh *JournalHeader, data []byte, usableSize int, recordSize int) (jrb *JournalRevokeBlock, err error) {
+	if len(data) < JournalRevokeHeaderSize-JournalHeaderSize {
+		return nil, &ext4.ErrCorrupt{
+			Structure: "journal revoke block",
+			Reason:    "block too small for header",
+		}
+	}
+
+	rCount := binary.BigEndian.Uint32(data)
+
+	if rCount < JournalRevokeHeaderSize || int(rCount) > usableSize || int(rCount) > len(data)+JournalHeaderSize {
+		return nil, &ext4.ErrCorrupt{
+			Structure: "journal revoke block",
+			Reason:    fmt.Sprintf("byte-count not valid: (%d)", rCount),
+		}
+	}
+
+	// The records follow the header, and `RCount` is measured from the top of
+	// the block.
+
+	records := data[JournalRevokeHeaderSize-JournalHeaderSize : int(rCount)-JournalHeaderSize]
+
+	blocks := make([]uint64, 0, len(records)/recordSize)
+	for offset := 0; offset+recordSize <= len(records); offset += recordSize {
+		var block uint64
+		if recordSize == 8 {
+			block = binary.BigEndian.Uint64(records[offset:])
+		} else {
+			block = uint64(binary.BigEndian.Uint32(records[offset:]))
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	jrbd := &JournalRevokeBlockData{
+		RCount: rCount,
+		Blocks: blocks,
+	}
+
+	jrb = &JournalRevokeBlock{
+		data: jrbd,
+	}
+
+	jrb.SetHeader(jh)
+
+	return jrb, nil
+}
+
+// SequenceAfter indicates whether sequence `a` comes after sequence `b`. The
+// sequence numbers wrap, so this is only meaningful for sequences that are
+// within half of the number-space of each other (tid_gt() in the kernel).
+func SequenceAfter(a, b uint32) bool {
+	return int32(a-b) > 0
+}
+
+// RevokeSet records which filesystem blocks have been revoked and by which
+// transaction. A transaction's own revoke blocks make up its set; recovery
+// merges the sets of every transaction in the log.
+type RevokeSet struct {
+	revoked map[uint64]uint32
+}
+
+func NewRevokeSet() *RevokeSet {
+	return &RevokeSet{
+		revoked: make(map[uint64]uint32),
+	}
+}
+
+// Add records that `block` was revoked by transaction `sequence`. If it was
+// already revoked, the later of the two transactions is kept.
+func (rs *RevokeSet) Add(block uint64, sequence uint32) {
+	if existing, found := rs.revoked[block]; found == true && SequenceAfter(existing, sequence) == true {
+		return
+	}
+
+	rs.revoked[block] = sequence
+}
+
+// AddBlock records all of the blocks revoked by the given revoke block.
+func (rs *RevokeSet) AddBlock(jrb *JournalRevokeBlock) {
+	sequence := jrb.Header().HSequence
+
+	for _, block := range jrb.Data().Blocks {
+		rs.Add(block, sequence)
+	}
+}
+
+// Merge adds everything from another set.
+func (rs *RevokeSet) Merge(other *RevokeSet) {
+	for block, sequence := range other.revoked {
+		rs.Add(block, sequence)
+	}
+}
+
+// Sequence returns the transaction that revoked the block.
+func (rs *RevokeSet) Sequence(block uint64) (sequence uint32, found bool) {
+	sequence, found = rs.revoked[block]
+	return sequence, found
+}
+
+// IsRevoked indicates whether a write of `block` by transaction `sequence`
+// should be skipped during replay. That's the case if the block was revoked by
+// that transaction or a later one.
+func (rs *RevokeSet) IsRevoked(block uint64, sequence uint32) bool {
+	revokedBy, found := rs.revoked[block]
+	if found == false {
+		return false
+	}
+
+	return revokedBy == sequence || SequenceAfter(revokedBy, sequence) == true
+}
+
+// Len returns the number of revoked blocks.
+func (rs *RevokeSet) Len() int {
+	return len(rs.revoked)
+}
+
+// Blocks returns the revoked blocks in ascending order.
+func (rs *RevokeSet) Blocks() []uint64 {
+	blocks := make([]uint64, 0, len(rs.revoked))
+	for block := range rs.revoked {
+		blocks = append(blocks, block)
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i] < blocks[j]
+	})
+
+	return blocks
+}
+
+func (rs *RevokeSet) String() string {
+	return fmt.Sprintf("RevokeSet<BLOCKS=(%d)>", len(rs.revoked))
+}
@@ -0,0 +1,179 @@
+package jbd2
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-ext4"
+	"github.com/dsoprea/go-logging"
+)
+
+// getTestRevokeBlock returns a whole revoke block for the given blocks.
+func getTestRevokeBlock(blockSize int, sequence uint32, recordSize int, blocks []uint64) []byte {
+	data := make([]byte, blockSize)
+
+	binary.BigEndian.PutUint32(data[0:], JournalBlockHeaderMagicBytes)
+	binary.BigEndian.PutUint32(data[4:], BtBlockRevocationRecord)
+	binary.BigEndian.PutUint32(data[8:], sequence)
+
+	offset := JournalRevokeHeaderSize
+	for _, block := range blocks {
+		if recordSize == 8 {
+			binary.BigEndian.PutUint64(data[offset:], block)
+		} else {
+			binary.BigEndian.PutUint32(data[offset:], uint32(block))
+		}
+
+		offset += recordSize
+	}
+
+	binary.BigEndian.PutUint32(data[JournalHeaderSize:], uint32(offset))
+
+	return data
+}
+
+func TestJournalSuperblock_NextBlock_Revoke(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SFeatureIncompat = JsbFeatureIncompatRevoke
+
+	b := bytes.NewBuffer(encodeTestJournalSuperblock(jsbd))
+	b.Write(getTestRevokeBlock(1024, 5, 4, []uint64{100, 200, 300}))
+
+	jsb, err := NewJournalSuperblock(b)
+	log.PanicIf(err)
+
+	jb, err := jsb.NextBlock(b)
+	log.PanicIf(err)
+
+	jrb, ok := jb.(*JournalRevokeBlock)
+	if ok == false {
+		t.Fatalf("Expected revoke block: [%s]", jb)
+	} else if jrb.String() != "JournalRevokeBlock<RCOUNT=(28) BLOCKS=(3)>" {
+		t.Fatalf("Revoke block not correct: [%s]", jrb)
+	} else if reflect.DeepEqual(jrb.Data().Blocks, []uint64{100, 200, 300}) == false {
+		t.Fatalf("Revoked blocks not correct: %v", jrb.Data().Blocks)
+	} else if jrb.Header().HSequence != 5 {
+		t.Fatalf("Sequence not correct: (%d)", jrb.Header().HSequence)
+	}
+}
+
+func TestParseRevokeBlock_64bit(t *testing.T) {
+	data := getTestRevokeBlock(1024, 5, 8, []uint64{100, 0x100000000})
+
+	jh := &JournalHeader{
+		HMagic:     JournalBlockHeaderMagicBytes,
+		HBlocktype: BtBlockRevocationRecord,
+		HSequence:  5,
+	}
+
+	jrb, err := parseRevokeBlock(jh, data[JournalHeaderSize:], 1024, 8)
+	log.PanicIf(err)
+
+	if reflect.DeepEqual(jrb.Data().Blocks, []uint64{100, 0x100000000}) == false {
+		t.Fatalf("Revoked blocks not correct: %v", jrb.Data().Blocks)
+	}
+}
+
+func TestParseRevokeBlock_BadCount(t *testing.T) {
+	data := getTestRevokeBlock(1024, 5, 4, []uint64{100})
+
+	jh := &JournalHeader{
+		HMagic:     JournalBlockHeaderMagicBytes,
+		HBlocktype: BtBlockRevocationRecord,
+		HSequence:  5,
+	}
+
+	for _, rCount := range []uint32{0, 8, 1025} {
+		binary.BigEndian.PutUint32(data[JournalHeaderSize:], rCount)
+
+		_, err := parseRevokeBlock(jh, data[JournalHeaderSize:], 1024, 4)
+
+		var errCorrupt *ext4.ErrCorrupt
+		if errors.As(err, &errCorrupt) == false {
+			t.Fatalf("Expected corrupt error for count (%d): %v", rCount, err)
+		}
+	}
+
+	// The checksum tail can't hold records.
+
+	binary.BigEndian.PutUint32(data[JournalHeaderSize:], 1024)
+
+	_, err := parseRevokeBlock(jh, data[JournalHeaderSize:], 1020, 4)
+
+	var errCorrupt *ext4.ErrCorrupt
+	if errors.As(err, &errCorrupt) == false {
+		t.Fatalf("Expected corrupt error for records in tail: %v", err)
+	}
+}
+
+func TestRevokeSet(t *testing.T) {
+	rs := NewRevokeSet()
+
+	rs.Add(100, 5)
+	rs.Add(100, 3)
+	rs.Add(200, 7)
+
+	if sequence, found := rs.Sequence(100); found != true || sequence != 5 {
+		t.Fatalf("Later revoke not kept: (%d) %v", sequence, found)
+	}
+
+	if rs.IsRevoked(100, 4) != true {
+		t.Fatalf("Write before the revoke should be revoked.")
+	} else if rs.IsRevoked(100, 5) != true {
+		t.Fatalf("Write in the revoking transaction should be revoked.")
+	} else if rs.IsRevoked(100, 6) != false {
+		t.Fatalf("Write after the revoke should not be revoked.")
+	} else if rs.IsRevoked(300, 1) != false {
+		t.Fatalf("Block never revoked.")
+	}
+
+	if reflect.DeepEqual(rs.Blocks(), []uint64{100, 200}) == false {
+		t.Fatalf("Blocks not correct: %v", rs.Blocks())
+	}
+}
+
+func TestRevokeSet_Wrap(t *testing.T) {
+	rs := NewRevokeSet()
+
+	// Sequence (2) comes after 0xfffffffe once the counter has wrapped.
+	rs.Add(100, 0xfffffffe)
+	rs.Add(100, 2)
+
+	if sequence, _ := rs.Sequence(100); sequence != 2 {
+		t.Fatalf("Wrapped sequence not kept: (%d)", sequence)
+	} else if rs.IsRevoked(100, 0xffffffff) != true {
+		t.Fatalf("Write before the wrap should be revoked.")
+	}
+}
+
+func TestRevokeSet_Merge(t *testing.T) {
+	jh := &JournalHeader{
+		HMagic:     JournalBlockHeaderMagicBytes,
+		HBlocktype: BtBlockRevocationRecord,
+		HSequence:  9,
+	}
+
+	data := getTestRevokeBlock(1024, 9, 4, []uint64{10, 20})
+
+	jrb, err := parseRevokeBlock(jh, data[JournalHeaderSize:], 1024, 4)
+	log.PanicIf(err)
+
+	transactionRs := NewRevokeSet()
+	transactionRs.AddBlock(jrb)
+
+	rs := NewRevokeSet()
+	rs.Add(20, 12)
+	rs.Merge(transactionRs)
+
+	if rs.Len() != 2 {
+		t.Fatalf("Merged count not correct: (%d)", rs.Len())
+	} else if sequence, _ := rs.Sequence(10); sequence != 9 {
+		t.Fatalf("Merged sequence not correct: (%d)", sequence)
+	} else if sequence, _ := rs.Sequence(20); sequence != 12 {
+		t.Fatalf("Later sequence not kept on merge: (%d)", sequence)
+	}
+}
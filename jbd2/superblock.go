@@ -6,13 +6,19 @@ import (
 	"io"
 
 	"encoding/binary"
+	"io/ioutil"
 
-	"github.com/dsoprea/go-logging"
+	"github.com/dsoprea/go-ext4"
 )
 
 const (
 	JournalBlockHeaderMagicBytes = uint32(0xc03b3998)
 	JournalHeaderSize            = 12
+
+	// JournalMinBlockSize and JournalMaxBlockSize bound the journal's block
+	// size, which is always a power of two.
+	JournalMinBlockSize = 1024
+	JournalMaxBlockSize = 65536
 )
 
 // Block types
@@ -62,6 +68,9 @@ const (
 
 	// JBD2_FEATURE_INCOMPAT_CSUM_V3
 	JsbFeatureIncompatCsumV3 = uint32(0x10) // This journal uses v3 of the checksum on-disk format. This is the same as v2, but the journal block tag size is fixed regardless of the size of block numbers.
+
+	// JBD2_FEATURE_INCOMPAT_FAST_COMMIT
+	JsbFeatureIncompatFastCommit = uint32(0x20) // Journal has a fast-commit area at the end of the log.
 )
 
 var (
@@ -71,6 +80,7 @@ var (
 		JsbFeatureIncompatAsyncCommit: "asynccommit",
 		JsbFeatureIncompatCsumV2:      "csumv2",
 		JsbFeatureIncompatCsumV3:      "csumv3",
+		JsbFeatureIncompatFastCommit:  "fastcommit",
 	}
 )
 
@@ -82,6 +92,28 @@ const (
 	JccCrc32c = uint8(4)
 )
 
+var (
+	JournalChecksumTypeLookup = map[uint8]string{
+		JccCrc32:  "crc32",
+		JccMd5:    "md5",
+		JccSha1:   "sha1",
+		JccCrc32c: "crc32c",
+	}
+)
+
+const (
+	// JBD2_USERS_MAX
+	JournalUsersMax = 48
+
+	// JBD2_DEFAULT_FAST_COMMIT_BLOCKS is the size of the fast-commit area if
+	// the feature is on but the superblock doesn't say.
+	JournalDefaultFastCommitBlocks = 256
+
+	// JournalSuperblockV1Size is the number of leading bytes of the superblock
+	// that are meaningful in a v1 superblock (up to and including `SErrno`).
+	JournalSuperblockV1Size = 0x24
+)
+
 // JournalHeader (journal_header_s) is at the beginning of every block.
 type JournalHeader struct {
 	HMagic     uint32
@@ -145,8 +177,18 @@ type JournalSuperblockData struct {
 	/* 0x0050 */
 	SChecksumType uint8 /* checksum type */
 	SPadding2     [3]uint8
-	SPadding      [42]uint32
-	SChecksum     uint32 /* crc32c(superblock) */
+
+	/* 0x0054 */
+	SNumFcBlks uint32 /* Number of fast commit blocks */
+
+	/* 0x0058 */
+	SHead uint32 /* blocknr of head of log, only uptodate while the filesystem is clean */
+
+	/* 0x005C */
+	SPadding [40]uint32
+
+	/* 0x00FC */
+	SChecksum uint32 /* crc32c(superblock) */
 
 	/* 0x0100 */
 	SUsers [16 * 48]uint8 /* ids of all fs'es sharing the log */
@@ -157,40 +199,93 @@ type JournalSuperblockData struct {
 type JournalSuperblock struct {
 	data         *JournalSuperblockData
 	currentBlock int
+
+	// checksumSeed is the checksum of the UUID, which every metadata checksum
+	// starts from.
+	checksumSeed uint32
 }
 
 func NewJournalSuperblock(r io.Reader) (jsb *JournalSuperblock, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
-
 	jsbd := new(JournalSuperblockData)
 
 	err = binary.Read(r, binary.BigEndian, jsbd)
-	log.PanicIf(err)
+	if err != nil {
+		return nil, err
+	}
 
 	if jsbd.SHeader.HMagic != JournalBlockHeaderMagicBytes {
-		log.Panicf("journal header magic-bytes not correct: %08x", jsbd.SHeader.HMagic)
+		return nil, &ext4.ErrCorrupt{
+			Structure: "journal superblock",
+			Reason:    fmt.Sprintf("magic-bytes not correct: %08x", jsbd.SHeader.HMagic),
+		}
+	}
+
+	if jsbd.SHeader.HBlocktype == BtJournalSuperblockV1 {
+		// Everything after the static and dynamic information is undefined in
+		// a v1 superblock (which has no features), so we don't expose it.
+
+		v1Jsbd := &JournalSuperblockData{
+			SHeader:    jsbd.SHeader,
+			SBlocksize: jsbd.SBlocksize,
+			SMaxlen:    jsbd.SMaxlen,
+			SFirst:     jsbd.SFirst,
+			SSequence:  jsbd.SSequence,
+			SStart:     jsbd.SStart,
+			SErrno:     jsbd.SErrno,
+		}
+
+		jsbd = v1Jsbd
 	} else if jsbd.SHeader.HBlocktype != BtJournalSuperblockV2 {
-		log.Panicf("only superblock V2 supported")
+		return nil, &ext4.ErrCorrupt{
+			Structure: "journal superblock",
+			Reason:    fmt.Sprintf("block-type not a superblock: (%d)", jsbd.SHeader.HBlocktype),
+		}
+	}
+
+	if jsbd.SBlocksize < JournalMinBlockSize || jsbd.SBlocksize > JournalMaxBlockSize || jsbd.SBlocksize&(jsbd.SBlocksize-1) != 0 {
+		return nil, &ext4.ErrCorrupt{
+			Structure: "journal superblock",
+			Reason:    fmt.Sprintf("block-size not valid: (%d)", jsbd.SBlocksize),
+		}
+	} else if jsbd.SFirst == 0 || jsbd.SFirst >= jsbd.SMaxlen {
+		return nil, &ext4.ErrCorrupt{
+			Structure: "journal superblock",
+			Reason:    fmt.Sprintf("first log block (%d) not within the journal length (%d)", jsbd.SFirst, jsbd.SMaxlen),
+		}
 	}
 
 	jsb = &JournalSuperblock{
 		data: jsbd,
 	}
 
-	if jsb.HasIncompatibleFeature(JsbFeatureIncompatRevoke) == true {
-		log.Panicf("revoke feature not supported")
-	} else if jsb.HasIncompatibleFeature(JsbFeatureIncompat64bit) == true {
-		log.Panicf("64-bit feature not supported")
-	} else if jsb.HasIncompatibleFeature(JsbFeatureIncompatAsyncCommit) == true {
-		log.Panicf("async-commit feature not supported")
-	} else if jsb.HasIncompatibleFeature(JsbFeatureIncompatCsumV2) == true {
-		log.Panicf("csum V2 feature not supported")
-	} else if jsb.HasIncompatibleFeature(JsbFeatureIncompatCsumV3) == true {
-		log.Panicf("csum V3 feature not supported")
+	if jsb.FastCommitBlockCount() >= jsbd.SMaxlen-jsbd.SFirst {
+		return nil, &ext4.ErrCorrupt{
+			Structure: "journal superblock",
+			Reason:    fmt.Sprintf("fast-commit area (%d) leaves no room for the log (%d)", jsb.FastCommitBlockCount(), jsbd.SMaxlen-jsbd.SFirst),
+		}
+	}
+
+	if jsb.HasMetadataChecksums() == true {
+		if jsbd.SChecksumType != JccCrc32c {
+			return nil, &ext4.ErrCorrupt{
+				Structure: "journal superblock",
+				Reason:    fmt.Sprintf("checksum-type not crc32c: (%d)", jsbd.SChecksumType),
+			}
+		}
+
+		checksum, err := jsb.superblockChecksum()
+		if err != nil {
+			return nil, err
+		}
+
+		if checksum != jsbd.SChecksum {
+			return nil, &ext4.ErrCorrupt{
+				Structure: "journal superblock",
+				Reason:    fmt.Sprintf("checksum not correct: (%08x) != (%08x)", jsbd.SChecksum, checksum),
+			}
+		}
+
+		jsb.checksumSeed = crc32c(0xffffffff, jsbd.SUuid[:])
 	}
 
 	return jsb, nil
@@ -200,6 +295,143 @@ func (jsb *JournalSuperblock) Data() *JournalSuperblockData {
 	return jsb.data
 }
 
+// Version returns (1) or (2), depending on the superblock format.
+func (jsb *JournalSuperblock) Version() int {
+	if jsb.data.SHeader.HBlocktype == BtJournalSuperblockV1 {
+		return 1
+	}
+
+	return 2
+}
+
+// Users returns the UUIDs of the filesystems sharing this journal. Only
+// external journals list them; an internal journal has one user and the UUID
+// is normally left zeroed.
+func (jsb *JournalSuperblock) Users() (users [][16]byte) {
+	count := int(jsb.data.SNrUsers)
+	if count > JournalUsersMax {
+		count = JournalUsersMax
+	}
+
+	users = make([][16]byte, count)
+	for i := 0; i < count; i++ {
+		copy(users[i][:], jsb.data.SUsers[i*16:(i+1)*16])
+	}
+
+	return users
+}
+
+// FastCommitBlockCount returns the number of blocks reserved for fast-commits
+// at the end of the journal, or (0) if fast-commits aren't enabled.
+func (jsb *JournalSuperblock) FastCommitBlockCount() uint32 {
+	if jsb.HasIncompatibleFeature(JsbFeatureIncompatFastCommit) == false {
+		return 0
+	} else if jsb.data.SNumFcBlks == 0 {
+		return JournalDefaultFastCommitBlocks
+	}
+
+	return jsb.data.SNumFcBlks
+}
+
+// LastLogBlock returns the block after the last one that can be used by the
+// (circular) log. It's the end of the journal unless there's a fast-commit
+// area.
+func (jsb *JournalSuperblock) LastLogBlock() uint32 {
+	return jsb.data.SMaxlen - jsb.FastCommitBlockCount()
+}
+
+// blockTailSize returns the size of the checksum tail at the end of descriptor
+// and revoke blocks, which is only present with metadata checksums.
+func (jsb *JournalSuperblock) blockTailSize() int {
+	if jsb.HasMetadataChecksums() == true {
+		return JournalBlockTailSize
+	}
+
+	return 0
+}
+
+// TagSize returns the on-disk size of a descriptor tag, not counting the UUID
+// that follows it (journal_tag_bytes() in the kernel).
+func (jsb *JournalSuperblock) TagSize() int {
+	if jsb.HasIncompatibleFeature(JsbFeatureIncompatCsumV3) == true {
+		return 16
+	}
+
+	size := 12
+
+	if jsb.HasIncompatibleFeature(JsbFeatureIncompatCsumV2) == true {
+		size += 2
+	}
+
+	if jsb.HasIncompatibleFeature(JsbFeatureIncompat64bit) == true {
+		return size
+	}
+
+	return size - 4
+}
+
+// newDataBlock pairs a data block, as read from the journal, with its tag. The
+// checksum is verified before the block is unescaped.
+func (jsb *JournalSuperblock) newDataBlock(jbt JournalBlockTag, sequence uint32, journalBlock uint32, data []byte) JournalDataBlock {
+	checksumValid := jsb.VerifyDataBlockChecksum(&jbt, sequence, data)
+
+	if (jbt.TFlags & JbtfDataMatchesMagicBytes) > 0 {
+		binary.BigEndian.PutUint32(data, JournalBlockHeaderMagicBytes)
+	}
+
+	return JournalDataBlock{
+		Tag:           jbt,
+		Data:          data,
+		JournalBlock:  journalBlock,
+		ChecksumValid: checksumValid,
+	}
+}
+
+// parseBlockTag parses the tag (and the UUID following it, if there is one) at
+// the top of `data`. `n` is the number of bytes consumed.
+func (jsb *JournalSuperblock) parseBlockTag(data []byte) (jbt JournalBlockTag, n int, err error) {
+	tagSize := jsb.TagSize()
+
+	if len(data) < tagSize {
+		return jbt, 0, io.ErrUnexpectedEOF
+	}
+
+	if jsb.HasIncompatibleFeature(JsbFeatureIncompatCsumV3) == true {
+		// journal_block_tag3_t
+
+		jbt.TBlocknr = binary.BigEndian.Uint32(data[0:])
+		jbt.TFlags = uint16(binary.BigEndian.Uint32(data[4:]))
+		jbt.TChecksum = binary.BigEndian.Uint32(data[12:])
+
+		if jsb.HasIncompatibleFeature(JsbFeatureIncompat64bit) == true {
+			jbt.TBlocknrHigh = binary.BigEndian.Uint32(data[8:])
+		}
+	} else {
+		// journal_block_tag_t
+
+		jbt.TBlocknr = binary.BigEndian.Uint32(data[0:])
+		jbt.TChecksum = uint32(binary.BigEndian.Uint16(data[4:]))
+		jbt.TFlags = binary.BigEndian.Uint16(data[6:])
+
+		if jsb.HasIncompatibleFeature(JsbFeatureIncompat64bit) == true {
+			jbt.TBlocknrHigh = binary.BigEndian.Uint32(data[8:])
+		}
+	}
+
+	n = tagSize
+
+	if (jbt.TFlags & JbtfSameUuidAsPrevious) == 0 {
+		if len(data) < n+len(jbt.Uuid) {
+			return jbt, 0, io.ErrUnexpectedEOF
+		}
+
+		copy(jbt.Uuid[:], data[n:])
+		n += len(jbt.Uuid)
+	}
+
+	return jbt, n, nil
+}
+
 func (jsb *JournalSuperblock) HasCompatibleFeature(mask uint32) bool {
 	return (jsb.data.SFeatureCompat & mask) > 0
 }
@@ -241,6 +473,8 @@ func (jsb *JournalSuperblock) Dump() {
 	fmt.Printf("==================\n")
 	fmt.Printf("\n")
 
+	fmt.Printf("Version: (%d)\n", jsb.Version())
+
 	fmt.Printf("SBlocksize: (%d)\n", jsb.data.SBlocksize)
 	fmt.Printf("SMaxLen: (%d)\n", jsb.data.SMaxlen)
 	fmt.Printf("SFirst: (%d)\n", jsb.data.SFirst)
@@ -257,8 +491,14 @@ func (jsb *JournalSuperblock) Dump() {
 	fmt.Printf("SMaxTransaction: (%d)\n", jsb.data.SMaxTransaction)
 	fmt.Printf("SMaxTransData: (%d)\n", jsb.data.SMaxTransData)
 
-	fmt.Printf("SChecksumType: (%d)\n", jsb.data.SChecksumType)
-	fmt.Printf("SChecksum: (%d)\n", jsb.data.SChecksum)
+	fmt.Printf("SChecksumType: (%d) [%s]\n", jsb.data.SChecksumType, JournalChecksumTypeLookup[jsb.data.SChecksumType])
+	fmt.Printf("SNumFcBlks: (%d)\n", jsb.data.SNumFcBlks)
+	fmt.Printf("SHead: (%d)\n", jsb.data.SHead)
+	fmt.Printf("SChecksum: (%08x)\n", jsb.data.SChecksum)
+
+	for i, uuid := range jsb.Users() {
+		fmt.Printf("SUsers(%d): (%032x)\n", i, uuid)
+	}
 
 	fmt.Printf("\n")
 }
@@ -269,34 +509,91 @@ type JournalBlock interface {
 	Header() *JournalHeader
 }
 
+// NextBlock reads the next block from `r`, which must be the same reader that
+// the superblock was read from. Descriptor blocks come back with all of their
+// data blocks. Returns `io.EOF` at the first block without the magic-bytes.
+// See `Journal` for something that follows the log properly.
 func (jsb *JournalSuperblock) NextBlock(r io.Reader) (jb JournalBlock, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
+	blockSize := int(jsb.data.SBlocksize)
 
-	// Find the next populated block. We've observed sparsity with the populated
-	// ones.
+	if jsb.currentBlock == 0 {
+		// We only read the first part of the superblock's block.
 
-	blockSize := int(jsb.data.SBlocksize)
+		_, err := io.CopyN(ioutil.Discard, r, int64(blockSize-binary.Size(jsb.data)))
+		if err != nil {
+			return nil, err
+		}
+
+		jsb.currentBlock = 1
+	}
 
 	if jsb.currentBlock >= int(jsb.data.SMaxlen) {
 		return nil, io.EOF
 	}
 
-	jh := new(JournalHeader)
+	block := make([]byte, blockSize)
 
-	err = binary.Read(r, binary.BigEndian, jh)
-	log.PanicIf(err)
+	err = ReadExactly(r, block)
+	if err != nil {
+		return nil, err
+	}
 
-	remainingBytes := blockSize - JournalHeaderSize
-	buffer := make([]byte, remainingBytes)
+	jsb.currentBlock++
 
-	err = ReadExactly(r, buffer)
-	log.PanicIf(err)
+	jb, err = jsb.parseBlock(block)
+	if err != nil {
+		return nil, err
+	}
 
-	jsb.currentBlock++
+	if jdb, ok := jb.(*JournalDescriptorBlock); ok == true {
+		// The data blocks follow, one for each tag.
+
+		jdb.DataBlocks = make([]JournalDataBlock, len(jdb.Tags))
+
+		for i, jbt := range jdb.Tags {
+			if jsb.currentBlock >= int(jsb.data.SMaxlen) {
+				return nil, &ext4.ErrCorrupt{
+					Structure: "journal descriptor block",
+					Reason:    fmt.Sprintf("data block (%d) of (%d) is past the end of the journal", i, len(jdb.Tags)),
+				}
+			}
+
+			data := make([]byte, blockSize)
+
+			err = ReadExactly(r, data)
+			if err != nil {
+				return nil, err
+			}
+
+			jdb.DataBlocks[i] = jsb.newDataBlock(jbt, jdb.Header().HSequence, uint32(jsb.currentBlock), data)
+
+			jsb.currentBlock++
+		}
+	}
+
+	return jb, nil
+}
+
+// parseJournalHeader parses the header at the top of a block.
+func parseJournalHeader(block []byte) (jh *JournalHeader, err error) {
+	jh = new(JournalHeader)
+
+	err = binary.Read(bytes.NewBuffer(block), binary.BigEndian, jh)
+	if err != nil {
+		return nil, err
+	}
+
+	return jh, nil
+}
+
+// parseBlock parses a whole journal block. Descriptor blocks come back with
+// their tags but not the data blocks that follow them in the log. Returns
+// `io.EOF` if the block doesn't have the magic-bytes.
+func (jsb *JournalSuperblock) parseBlock(block []byte) (jb JournalBlock, err error) {
+	jh, err := parseJournalHeader(block)
+	if err != nil {
+		return nil, err
+	}
 
 	if jh.HMagic != JournalBlockHeaderMagicBytes {
 		// There's no block-type connoting terminating, and the magic-bytes
@@ -304,74 +601,70 @@ func (jsb *JournalSuperblock) NextBlock(r io.Reader) (jb JournalBlock, err error
 		// good. Therefore, we're just going to iterate until the magic-bytes
 		// are no longer correct.
 
-		// log.Panicf("next block header magic-bytes not correct: %08x", jh.HMagic)
 		return nil, io.EOF
 	} else if jh.HBlocktype == BtJournalSuperblockV1 || jh.HBlocktype == BtJournalSuperblockV2 {
-		log.Panicf("encountered more than one journal superblock")
+		return nil, &ext4.ErrCorrupt{
+			Structure: "journal",
+			Reason:    "encountered more than one journal superblock",
+		}
 	}
 
-	remainingReader := bytes.NewBuffer(buffer)
+	buffer := block[JournalHeaderSize:]
+
+	if jh.HBlocktype == BtDescriptor || jh.HBlocktype == BtBlockRevocationRecord {
+		if jsb.VerifyBlockTailChecksum(block) == false {
+			return nil, &ext4.ErrCorrupt{
+				Structure: fmt.Sprintf("journal %s", BlocktypeLookup[jh.HBlocktype]),
+				Reason:    fmt.Sprintf("tail checksum not correct (sequence %d)", jh.HSequence),
+			}
+		}
+	}
 
 	if jh.HBlocktype == BtDescriptor {
 		jdb := new(JournalDescriptorBlock)
 		jdb.SetHeader(jh)
 
-		jdb.Tags = make([]JournalBlockTag32NoCsumV3, 0)
-
-		hasLast := false
-		i := 0
-		for remainingBytes > 0 {
-			jbtnc3 := JournalBlockTag32NoCsumV3{}
-
-			err = binary.Read(remainingReader, binary.BigEndian, &jbtnc3.TBlocknr)
-			log.PanicIf(err)
+		jdb.Tags = make([]JournalBlockTag, 0)
 
-			err = binary.Read(remainingReader, binary.BigEndian, &jbtnc3.TChecksum)
-			log.PanicIf(err)
+		// The tags can use everything but the tail. Running out of room
+		// means that the last-tag flag was never set, which we report below.
 
-			err = binary.Read(remainingReader, binary.BigEndian, &jbtnc3.TFlags)
-			log.PanicIf(err)
+		tags := buffer[:len(buffer)-jsb.blockTailSize()]
 
-			remainingBytes -= 8
-
-			if (jbtnc3.TFlags & JbtfSameUuidAsPrevious) == 0 {
-				err = binary.Read(remainingReader, binary.BigEndian, &jbtnc3.Uuid)
-				log.PanicIf(err)
-
-				remainingBytes -= 4
+		hasLast := false
+		for len(tags) > 0 {
+			jbt, n, err := jsb.parseBlockTag(tags)
+			if err == io.ErrUnexpectedEOF {
+				break
+			} else if err != nil {
+				return nil, err
 			}
 
-			jdb.Tags = append(jdb.Tags, jbtnc3)
+			tags = tags[n:]
+
+			jdb.Tags = append(jdb.Tags, jbt)
 
-			// TODO(dustin): !! We're not sure why the commit-tag record has a target block-number on it.
-			// TODO(dustin): !! Why don't we see the associated data?
-			if (jbtnc3.TFlags & JbtfLastTag) > 0 {
+			if (jbt.TFlags & JbtfLastTag) > 0 {
 				hasLast = true
 				break
 			}
-
-			i++
 		}
 
 		if hasLast == false {
-			log.Panicf("journal descriptor tag blocks not terminated")
+			return nil, &ext4.ErrCorrupt{
+				Structure: "journal descriptor block",
+				Reason:    "tags not terminated",
+			}
 		}
 
-		// The next block will have the actual data.
-
-		transactionData := make([]byte, blockSize)
-
-		err = ReadExactly(r, transactionData)
-		log.PanicIf(err)
-
-		jdb.SetTransactionData(transactionData)
-
 		return jdb, nil
 	} else if jh.HBlocktype == BtBlockCommitRecord {
 		jcbd := new(JournalCommitBlockData)
 
-		err := binary.Read(remainingReader, binary.BigEndian, jcbd)
-		log.PanicIf(err)
+		err := binary.Read(bytes.NewBuffer(buffer), binary.BigEndian, jcbd)
+		if err != nil {
+			return nil, err
+		}
 
 		jcb := &JournalCommitBlock{
 			data: jcbd,
@@ -381,20 +674,11 @@ func (jsb *JournalSuperblock) NextBlock(r io.Reader) (jb JournalBlock, err error
 
 		return jcb, nil
 	} else if jh.HBlocktype == BtBlockRevocationRecord {
-		jrbd := new(JournalRevokeBlock32Data)
-
-		err := binary.Read(remainingReader, binary.BigEndian, jrbd)
-		log.PanicIf(err)
-
-		jrb := &JournalRevokeBlock{
-			data: jrbd,
-		}
-
-		jrb.SetHeader(jh)
-
-		return jrb, nil
+		return parseRevokeBlock(jh, buffer, len(block)-jsb.blockTailSize(), jsb.revokeRecordSize())
 	}
 
-	log.Panicf("block-type (%d) not handled", jh.HBlocktype)
-	return nil, nil
+	return nil, &ext4.ErrCorrupt{
+		Structure: "journal",
+		Reason:    fmt.Sprintf("block-type (%d) not handled", jh.HBlocktype),
+	}
 }
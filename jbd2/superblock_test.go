@@ -1,24 +1,152 @@
 package jbd2
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"path"
 	"testing"
 
+	"encoding/binary"
+
 	"github.com/dsoprea/go-ext4"
 	"github.com/dsoprea/go-logging"
 )
 
+// getTestJournalSuperblockData returns the fields of a minimal, valid v2
+// superblock for a 1024-block journal.
+func getTestJournalSuperblockData() JournalSuperblockData {
+	return JournalSuperblockData{
+		SHeader: JournalHeader{
+			HMagic:     JournalBlockHeaderMagicBytes,
+			HBlocktype: BtJournalSuperblockV2,
+		},
+		SBlocksize: 1024,
+		SMaxlen:    1024,
+		SFirst:     1,
+		SSequence:  1,
+		SStart:     1,
+	}
+}
+
+func encodeTestJournalSuperblock(jsbd JournalSuperblockData) []byte {
+	b := new(bytes.Buffer)
+
+	err := binary.Write(b, binary.BigEndian, jsbd)
+	log.PanicIf(err)
+
+	return b.Bytes()
+}
+
+func TestNewJournalSuperblock(t *testing.T) {
+	filepath := path.Join(assetsPath, "journal.ext4")
+
+	f, inode, err := GetJournalInode(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	en := ext4.NewExtentNavigatorWithInode(inode)
+	ir := ext4.NewInodeReader(en)
+
+	jsb, err := NewJournalSuperblock(ir)
+	log.PanicIf(err)
+
+	jsbd := jsb.Data()
+
+	if jsb.Version() != 2 {
+		t.Fatalf("Version not correct: (%d)", jsb.Version())
+	} else if jsbd.SBlocksize != 1024 || jsbd.SMaxlen != 1024 || jsbd.SFirst != 1 {
+		t.Fatalf("Geometry not correct: BLOCKSIZE=(%d) MAXLEN=(%d) FIRST=(%d)", jsbd.SBlocksize, jsbd.SMaxlen, jsbd.SFirst)
+	} else if jsbd.SSequence != 4 || jsbd.SStart != 0 {
+		t.Fatalf("State not correct: SEQUENCE=(%d) START=(%d)", jsbd.SSequence, jsbd.SStart)
+	} else if fmt.Sprintf("%032x", jsbd.SUuid) != "b6cc1c35751945eb89bafdf7e87a2ee4" {
+		t.Fatalf("UUID not correct: [%032x]", jsbd.SUuid)
+	} else if len(jsb.Users()) != 1 {
+		t.Fatalf("Expected one user: (%d)", len(jsb.Users()))
+	} else if jsb.FastCommitBlockCount() != 0 || jsb.LastLogBlock() != 1024 {
+		t.Fatalf("Log extent not correct: FC=(%d) LAST=(%d)", jsb.FastCommitBlockCount(), jsb.LastLogBlock())
+	}
+}
+
+func TestNewJournalSuperblock_V1(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SHeader.HBlocktype = BtJournalSuperblockV1
+
+	// Not valid in v1, so these should be ignored.
+	jsbd.SFeatureIncompat = JsbFeatureIncompatCsumV3
+	jsbd.SNrUsers = 99
+
+	jsb, err := NewJournalSuperblock(bytes.NewReader(encodeTestJournalSuperblock(jsbd)))
+	log.PanicIf(err)
+
+	if jsb.Version() != 1 {
+		t.Fatalf("Version not correct: (%d)", jsb.Version())
+	} else if jsb.HasIncompatibleFeature(JsbFeatureIncompatCsumV3) != false {
+		t.Fatalf("v1 superblock should not have features.")
+	} else if len(jsb.Users()) != 0 {
+		t.Fatalf("v1 superblock should not have users.")
+	} else if jsb.Data().SMaxlen != 1024 {
+		t.Fatalf("Static fields not read: MAXLEN=(%d)", jsb.Data().SMaxlen)
+	}
+}
+
+func TestNewJournalSuperblock_NotSuperblock(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SHeader.HBlocktype = BtDescriptor
+
+	_, err := NewJournalSuperblock(bytes.NewReader(encodeTestJournalSuperblock(jsbd)))
+
+	var errCorrupt *ext4.ErrCorrupt
+	if errors.As(err, &errCorrupt) == false {
+		t.Fatalf("Expected corrupt error: %v", err)
+	}
+}
+
+func TestJournalSuperblock_FastCommitBlockCount(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SFeatureIncompat = JsbFeatureIncompatFastCommit
+
+	jsb, err := NewJournalSuperblock(bytes.NewReader(encodeTestJournalSuperblock(jsbd)))
+	log.PanicIf(err)
+
+	if jsb.FastCommitBlockCount() != JournalDefaultFastCommitBlocks {
+		t.Fatalf("Default fast-commit block-count not correct: (%d)", jsb.FastCommitBlockCount())
+	} else if jsb.LastLogBlock() != 1024-JournalDefaultFastCommitBlocks {
+		t.Fatalf("Last log block not correct: (%d)", jsb.LastLogBlock())
+	}
+
+	jsbd.SNumFcBlks = 64
+
+	jsb, err = NewJournalSuperblock(bytes.NewReader(encodeTestJournalSuperblock(jsbd)))
+	log.PanicIf(err)
+
+	if jsb.FastCommitBlockCount() != 64 {
+		t.Fatalf("Fast-commit block-count not correct: (%d)", jsb.FastCommitBlockCount())
+	}
+
+	jsbd.SNumFcBlks = 2000
+
+	_, err = NewJournalSuperblock(bytes.NewReader(encodeTestJournalSuperblock(jsbd)))
+
+	var errCorrupt *ext4.ErrCorrupt
+	if errors.As(err, &errCorrupt) == false {
+		t.Fatalf("Expected corrupt error for oversized fast-commit area: %v", err)
+	}
+}
+
 func TestNewJournalSuperblock_NextBlock(t *testing.T) {
 	filepath := path.Join(assetsPath, "journal.ext4")
 
 	f, inode, err := GetJournalInode(filepath)
 	log.PanicIf(err)
 
+	defer f.Close()
+
 	// Read the journal data.
 
-	en := ext4.NewExtentNavigatorWithReadSeeker(f, inode)
+	en := ext4.NewExtentNavigatorWithInode(inode)
 	ir := ext4.NewInodeReader(en)
 
 	jsb, err := NewJournalSuperblock(ir)
@@ -43,7 +171,7 @@ func TestNewJournalSuperblock_NextBlock(t *testing.T) {
 
 	tag := jdb.Tags[0]
 
-	if tag.String() != "JournalBlockTag32<TBLOCKNR=(74) TCHECKSUM=(0) TFLAGS=(8) UUID=[00000000000000000000000000000000]>" {
+	if tag.String() != "JournalBlockTag<TBLOCKNR=(74) TCHECKSUM=(0) TFLAGS=(8) UUID=[00000000000000000000000000000000]>" {
 		t.Fatalf("descriptor tag not as expected: [%s]", tag.String())
 	}
 
@@ -54,7 +182,7 @@ func TestNewJournalSuperblock_NextBlock(t *testing.T) {
 
 	if jb.Type() != BtBlockCommitRecord {
 		t.Fatalf("Expected commit-block for second block.")
-	} else if jb.String() != "CommitBlock<HChksumType=(0) HChksumSize=(0) CommitTime=[2018-09-18 03:34:36.58801818 +0000 UTC]>" {
+	} else if jb.String() != "CommitBlock<HChksumType=(0) HChksumSize=(0) CommitTime=[2018-09-17 10:39:17.57814915 +0000 UTC]>" {
 		t.Fatalf("commit-block not correct in second block: [%s]", jb.String())
 	}
 
@@ -65,10 +193,21 @@ func TestNewJournalSuperblock_NextBlock(t *testing.T) {
 
 	if jb.Type() != BtDescriptor {
 		t.Fatalf("Expected descriptor for third block.")
-	} else if jb.String() != "DescriptorBlock<TAGS=(6) DATA-LENGTH=(1024)>" {
+	} else if jb.String() != "DescriptorBlock<TAGS=(6) DATA-LENGTH=(6144)>" {
 		t.Fatalf("Descriptor not correct in third block: [%s]", jb.String())
 	}
 
+	// Check the fourth block, which follows all six data blocks.
+
+	jb, err = jsb.NextBlock(ir)
+	log.PanicIf(err)
+
+	if jb.Type() != BtBlockCommitRecord {
+		t.Fatalf("Expected commit-block for fourth block.")
+	} else if jb.Header().HSequence != 3 {
+		t.Fatalf("Commit-block for the wrong transaction: (%d)", jb.Header().HSequence)
+	}
+
 	// Check that there are no more blocks.
 
 	_, err = jsb.NextBlock(ir)
@@ -79,13 +218,15 @@ func TestNewJournalSuperblock_NextBlock(t *testing.T) {
 	}
 }
 
-func ExampleNewJournalSuperblock_NextBlock_Blocks() {
+func ExampleJournalSuperblock_NextBlock_blocks() {
 	filepath := path.Join(assetsPath, "journal.ext4")
 
 	f, inode, err := GetJournalInode(filepath)
 	log.PanicIf(err)
 
-	en := ext4.NewExtentNavigatorWithReadSeeker(f, inode)
+	defer f.Close()
+
+	en := ext4.NewExtentNavigatorWithInode(inode)
 	ir := ext4.NewInodeReader(en)
 
 	jsb, err := NewJournalSuperblock(ir)
@@ -107,17 +248,20 @@ func ExampleNewJournalSuperblock_NextBlock_Blocks() {
 	// Output:
 	//
 	// DescriptorBlock<TAGS=(1) DATA-LENGTH=(1024)>
-	// CommitBlock<HChksumType=(0) HChksumSize=(0) CommitTime=[2018-09-18 03:34:36.58801818 +0000 UTC]>
-	// DescriptorBlock<TAGS=(6) DATA-LENGTH=(1024)>
+	// CommitBlock<HChksumType=(0) HChksumSize=(0) CommitTime=[2018-09-17 10:39:17.57814915 +0000 UTC]>
+	// DescriptorBlock<TAGS=(6) DATA-LENGTH=(6144)>
+	// CommitBlock<HChksumType=(0) HChksumSize=(0) CommitTime=[2018-09-17 10:39:27.566149011 +0000 UTC]>
 }
 
-func ExampleNewJournalSuperblock_NextBlock_Descriptors() {
+func ExampleJournalSuperblock_NextBlock_descriptors() {
 	filepath := path.Join(assetsPath, "journal.ext4")
 
 	f, inode, err := GetJournalInode(filepath)
 	log.PanicIf(err)
 
-	en := ext4.NewExtentNavigatorWithReadSeeker(f, inode)
+	defer f.Close()
+
+	en := ext4.NewExtentNavigatorWithInode(inode)
 	ir := ext4.NewInodeReader(en)
 
 	jsb, err := NewJournalSuperblock(ir)
@@ -145,14 +289,66 @@ func ExampleNewJournalSuperblock_NextBlock_Descriptors() {
 	//
 	// DescriptorBlock<TAGS=(1) DATA-LENGTH=(1024)>
 	//
-	//   TAG(0): JournalBlockTag32<TBLOCKNR=(74) TCHECKSUM=(0) TFLAGS=(8) UUID=[00000000000000000000000000000000]>
+	//   TAG(0): JournalBlockTag<TBLOCKNR=(74) TCHECKSUM=(0) TFLAGS=(8) UUID=[00000000000000000000000000000000]>
 	//
-	// DescriptorBlock<TAGS=(6) DATA-LENGTH=(1024)>
+	// DescriptorBlock<TAGS=(6) DATA-LENGTH=(6144)>
 	//
-	//   TAG(0): JournalBlockTag32<TBLOCKNR=(58) TCHECKSUM=(0) TFLAGS=(0) UUID=[00000000000000000000000000000000]>
-	//   TAG(1): JournalBlockTag32<TBLOCKNR=(2) TCHECKSUM=(0) TFLAGS=(2) UUID=[00000000000000000000000000000000]>
-	//   TAG(2): JournalBlockTag32<TBLOCKNR=(75) TCHECKSUM=(0) TFLAGS=(2) UUID=[00000000000000000000000000000000]>
-	//   TAG(3): JournalBlockTag32<TBLOCKNR=(74) TCHECKSUM=(0) TFLAGS=(2) UUID=[00000000000000000000000000000000]>
-	//   TAG(4): JournalBlockTag32<TBLOCKNR=(44) TCHECKSUM=(0) TFLAGS=(2) UUID=[00000000000000000000000000000000]>
-	//   TAG(5): JournalBlockTag32<TBLOCKNR=(43) TCHECKSUM=(0) TFLAGS=(10) UUID=[00000000000000000000000000000000]>
+	//   TAG(0): JournalBlockTag<TBLOCKNR=(58) TCHECKSUM=(0) TFLAGS=(0) UUID=[00000000000000000000000000000000]>
+	//   TAG(1): JournalBlockTag<TBLOCKNR=(2) TCHECKSUM=(0) TFLAGS=(2) UUID=[00000000000000000000000000000000]>
+	//   TAG(2): JournalBlockTag<TBLOCKNR=(75) TCHECKSUM=(0) TFLAGS=(2) UUID=[00000000000000000000000000000000]>
+	//   TAG(3): JournalBlockTag<TBLOCKNR=(74) TCHECKSUM=(0) TFLAGS=(2) UUID=[00000000000000000000000000000000]>
+	//   TAG(4): JournalBlockTag<TBLOCKNR=(44) TCHECKSUM=(0) TFLAGS=(2) UUID=[00000000000000000000000000000000]>
+	//   TAG(5): JournalBlockTag<TBLOCKNR=(43) TCHECKSUM=(0) TFLAGS=(10) UUID=[00000000000000000000000000000000]>
+}
+
+func TestJournalSuperblock_NextBlock_DataBlocks(t *testing.T) {
+	journal := getTestJournalBytes("journal_csum.ext4")
+
+	blockSize := 1024
+
+	// The first data block (journal block 2) was escaped, so it's stored with
+	// its first four bytes zeroed.
+	if bytes.Equal(journal[2*blockSize:2*blockSize+4], []byte{0, 0, 0, 0}) == false {
+		t.Fatalf("Expected escaped data block in journal.")
+	}
+
+	// Break the third data block.
+	journal[4*blockSize+100] ^= 0xff
+
+	r := bytes.NewReader(journal)
+
+	jsb, err := NewJournalSuperblock(r)
+	log.PanicIf(err)
+
+	jb, err := jsb.NextBlock(r)
+	log.PanicIf(err)
+
+	jdb := jb.(*JournalDescriptorBlock)
+
+	if len(jdb.DataBlocks) != 3 {
+		t.Fatalf("Data-block count not correct: (%d)", len(jdb.DataBlocks))
+	}
+
+	for i, dataBlock := range jdb.DataBlocks {
+		if dataBlock.Blocknr() != uint64(3000+i) {
+			t.Fatalf("Data block (%d) target not correct: (%d)", i, dataBlock.Blocknr())
+		} else if dataBlock.ChecksumValid != (i != 2) {
+			t.Fatalf("Data block (%d) checksum validity not correct: [%v]", i, dataBlock.ChecksumValid)
+		}
+	}
+
+	if binary.BigEndian.Uint32(jdb.DataBlocks[0].Data) != JournalBlockHeaderMagicBytes {
+		t.Fatalf("Escaped data block not restored.")
+	} else if bytes.Equal(jdb.DataBlocks[1].Data, journal[3*blockSize:4*blockSize]) == false {
+		t.Fatalf("Unescaped data block not correct.")
+	}
+
+	// We should be right after the data.
+
+	jb, err = jsb.NextBlock(r)
+	log.PanicIf(err)
+
+	if jb.Type() != BtBlockCommitRecord {
+		t.Fatalf("Expected commit block after the data: [%s]", jb)
+	}
 }
@@ -1,12 +1,12 @@
 package jbd2
 
 import (
-	"io"
+	"errors"
+	"fmt"
 	"os"
 	"path"
 
 	"github.com/dsoprea/go-ext4"
-	"github.com/dsoprea/go-logging"
 )
 
 var (
@@ -16,41 +16,50 @@ var (
 // GetJournalInode returns inode and file structs. It's the responsibility of
 // the caller to close it.
 func GetJournalInode(filepath string) (f *os.File, inode *ext4.Inode, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
-
 	// Load the filesystem.
 
 	f, err = os.Open(filepath)
-	log.PanicIf(err)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	_, err = f.Seek(ext4.Superblock0Offset, io.SeekStart)
-	log.PanicIf(err)
+	// Make sure that we don't leak the file if we fail.
+	defer func() {
+		if err != nil {
+			f.Close()
+			f = nil
+		}
+	}()
 
-	sb, err := ext4.NewSuperblockWithReader(f)
-	log.PanicIf(err)
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	if sb.HasCompatibleFeature(ext4.SbFeatureCompatHasJournal) == false {
-		log.Panicf("filesystem does not have a journal.")
+		return nil, nil, errors.New("filesystem does not have a journal")
 	}
 
 	inodeNumber := int(sb.Data().SJournalInum)
 
 	if inodeNumber != ext4.InodeJournal {
-		log.Panicf("inode number different than expected: (%d) != (%d)", inodeNumber, ext4.InodeJournal)
+		return nil, nil, fmt.Errorf("inode number different than expected: (%d) != (%d)", inodeNumber, ext4.InodeJournal)
 	}
 
-	bgdl, err := ext4.NewBlockGroupDescriptorListWithReadSeeker(f, sb)
-	log.PanicIf(err)
+	bgdl, err := ext4.NewBlockGroupDescriptorListWithSuperblock(sb)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	bgd, err := bgdl.GetWithAbsoluteInode(inodeNumber)
-	log.PanicIf(err)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	inode, err = ext4.NewInodeWithReadSeeker(bgd, f, inodeNumber)
-	log.PanicIf(err)
+	inode, err = ext4.NewInodeWithBlockGroupDescriptor(bgd, inodeNumber)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	return f, inode, nil
 }
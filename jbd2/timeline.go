@@ -0,0 +1,466 @@
+package jbd2
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"encoding/json"
+
+	"github.com/dsoprea/go-ext4"
+)
+
+// Timeline event types.
+const (
+	TimelineEventCreated          = "created"
+	TimelineEventRenamed          = "renamed"
+	TimelineEventLinked           = "linked"
+	TimelineEventUnlinked         = "unlinked"
+	TimelineEventDeleted          = "deleted"
+	TimelineEventSizeChanged      = "size-changed"
+	TimelineEventTimestampChanged = "timestamp-changed"
+	TimelineEventUndecodable      = "undecodable"
+)
+
+// TimelineEvent is something that happened to a file, as far as can be told
+// from the copies of its inode and directory blocks in the journal. `Time` is
+// when the transaction that recorded it was committed.
+//
+// Names and paths are only known if the directory block that has the entry was
+// logged by that point, so they may be missing. `Path` is relative to the
+// root directory.
+type TimelineEvent struct {
+	Time     time.Time `json:"time"`
+	Sequence uint32    `json:"sequence"`
+	Event    string    `json:"event"`
+	Inode    int       `json:"inode"`
+
+	Directory int    `json:"directory,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Path      string `json:"path,omitempty"`
+
+	// OldDirectory, OldName, and OldPath are where a renamed file used to be.
+	OldDirectory int    `json:"old_directory,omitempty"`
+	OldName      string `json:"old_name,omitempty"`
+	OldPath      string `json:"old_path,omitempty"`
+
+	// Field is the timestamp that changed ("atime", "ctime", or "mtime"). Old
+	// and New are the values before and after, for size and timestamp
+	// changes.
+	Field string      `json:"field,omitempty"`
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new,omitempty"`
+
+	// Block and Error are the copy of an inode-table or directory block that
+	// couldn't be decoded, and why. `Inode` is the directory, for a directory
+	// block.
+	Block uint64 `json:"block,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// timelineEntry is a directory entry in use.
+type timelineEntry struct {
+	directory int
+	name      string
+	inode     int
+}
+
+// Timeline turns successive transactions into events by comparing each copy
+// of an inode-table or directory block with the copy before it. The first
+// copy of each block is only a baseline, so nothing in it is reported.
+type Timeline struct {
+	decoder *BlockDecoder
+
+	// names has where each inode was last seen in a directory.
+	names map[int]timelineEntry
+}
+
+// NewTimeline returns a `Timeline` for the filesystem described by `bm`.
+func NewTimeline(bm *ext4.BlockMap) *Timeline {
+	return &Timeline{
+		decoder: NewBlockDecoder(bm),
+		names:   make(map[int]timelineEntry),
+	}
+}
+
+// AddRevokes adds revokes from transactions that haven't been added yet (e.g.
+// from `HistoryRevokes`), so that the copies that they revoke are skipped.
+func (tl *Timeline) AddRevokes(rs *RevokeSet) {
+	tl.decoder.AddRevokes(rs)
+}
+
+// path returns the path of the given directory entry, if every directory
+// above it is known.
+func (tl *Timeline) path(te timelineEntry) string {
+	parts := []string{te.name}
+
+	// A deep enough chain can only be a loop between stale entries.
+	for i := 0; te.directory != ext4.InodeRootDirectory; i++ {
+		parent, found := tl.names[te.directory]
+		if found == false || i >= 256 {
+			return ""
+		}
+
+		parts = append([]string{parent.name}, parts...)
+		te = parent
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// describe fills in where the given entry is.
+func (tl *Timeline) describe(e *TimelineEvent, te timelineEntry) {
+	e.Directory = te.directory
+	e.Name = te.name
+	e.Path = tl.path(te)
+}
+
+// usedEntries returns the entries of a directory block that are in use, other
+// than "." and "..".
+func usedEntries(db *DecodedBlock) (entries []timelineEntry) {
+	entries = make([]timelineEntry, 0)
+
+	for _, de := range db.DirectoryEntries {
+		name := de.Name()
+		if de.Data().Inode == 0 || name == "." || name == ".." {
+			continue
+		}
+
+		entries = append(entries, timelineEntry{
+			directory: db.Info.Inode,
+			name:      name,
+			inode:     int(de.Data().Inode),
+		})
+	}
+
+	return entries
+}
+
+// diffEntries returns the entries that are only in `previous` and the ones
+// that are only in `current`.
+func diffEntries(previous, current []timelineEntry) (removed, added []timelineEntry) {
+	index := func(entries []timelineEntry) map[timelineEntry]struct{} {
+		set := make(map[timelineEntry]struct{})
+		for _, te := range entries {
+			set[te] = struct{}{}
+		}
+
+		return set
+	}
+
+	previousSet := index(previous)
+	currentSet := index(current)
+
+	removed = make([]timelineEntry, 0)
+	for _, te := range previous {
+		if _, found := currentSet[te]; found == false {
+			removed = append(removed, te)
+		}
+	}
+
+	added = make([]timelineEntry, 0)
+	for _, te := range current {
+		if _, found := previousSet[te]; found == false {
+			added = append(added, te)
+		}
+	}
+
+	return removed, added
+}
+
+// Add decodes the given transaction and returns its events. The transactions
+// have to be given in order (e.g. from `History`).
+//
+// Within a transaction, a name that disappears and one that appears for the
+// same inode is a rename, a name that appears for a newly allocated inode is a
+// creation, and a name that disappears for an inode that was freed is a
+// deletion. Other names that appear or disappear are hard links being added
+// or removed. Sizes and timestamps are only reported for inodes that weren't
+// created or deleted.
+//
+// A copy of an inode-table or directory block that can't be decoded is
+// reported as such, and the copy after it is only a baseline.
+func (tl *Timeline) Add(t *Transaction) (events []TimelineEvent, err error) {
+	updates, err := tl.decoder.Decode(t)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]timelineEntry, 0)
+	added := make([]timelineEntry, 0)
+
+	allocated := make(map[int]bool)
+	freed := make(map[int]bool)
+
+	inodeChanges := make(map[int][]TimelineEvent)
+
+	undecodable := make([]BlockUpdate, 0)
+
+	for _, bu := range updates {
+		if bu.Err != nil {
+			class := bu.Current.Info.Class
+			if class == ext4.BlockClassDirectory || class == ext4.BlockClassInodeTable {
+				undecodable = append(undecodable, bu)
+			}
+
+			continue
+		}
+
+		switch bu.Current.Info.Class {
+		case ext4.BlockClassDirectory:
+			current := usedEntries(bu.Current)
+
+			if bu.Previous == nil {
+				for _, te := range current {
+					tl.names[te.inode] = te
+				}
+
+				continue
+			}
+
+			r, a := diffEntries(usedEntries(bu.Previous), current)
+
+			removed = append(removed, r...)
+			added = append(added, a...)
+		case ext4.BlockClassInodeTable:
+			if bu.Previous == nil {
+				continue
+			}
+
+			for i := 0; i < len(bu.Previous.Inodes) && i < len(bu.Current.Inodes); i++ {
+				previous := bu.Previous.Inodes[i]
+				current := bu.Current.Inodes[i]
+				inodeNumber := current.Number()
+
+				if previous.IsInUse() == false && current.IsInUse() == true {
+					allocated[inodeNumber] = true
+				} else if previous.IsInUse() == true && current.IsInUse() == false {
+					freed[inodeNumber] = true
+				} else if current.IsInUse() == true {
+					inodeChanges[inodeNumber] = append(inodeChanges[inodeNumber], inodeEvents(previous, current)...)
+				}
+			}
+		}
+	}
+
+	events = make([]TimelineEvent, 0)
+
+	commitTime := eventTime(t)
+
+	newEvent := func(event string, inodeNumber int) TimelineEvent {
+		return TimelineEvent{
+			Time:     commitTime,
+			Sequence: t.Sequence,
+			Event:    event,
+			Inode:    inodeNumber,
+		}
+	}
+
+	for _, bu := range undecodable {
+		e := newEvent(TimelineEventUndecodable, bu.Current.Info.Inode)
+		e.Block = bu.Current.Info.Block
+		e.Error = bu.Err.Error()
+
+		events = append(events, e)
+	}
+
+	// Removals first, so that the paths of deleted files are still known.
+
+	removedByInode := make(map[int]timelineEntry)
+	for _, te := range removed {
+		removedByInode[te.inode] = te
+	}
+
+	renamed := make(map[int]bool)
+	for _, te := range added {
+		if _, found := removedByInode[te.inode]; found == true {
+			renamed[te.inode] = true
+		}
+	}
+
+	for _, te := range removed {
+		if renamed[te.inode] == true {
+			continue
+		}
+
+		event := TimelineEventUnlinked
+		if freed[te.inode] == true {
+			event = TimelineEventDeleted
+			delete(freed, te.inode)
+		}
+
+		e := newEvent(event, te.inode)
+		tl.describe(&e, te)
+
+		events = append(events, e)
+	}
+
+	for _, inodeNumber := range sortedInodes(freed) {
+		e := newEvent(TimelineEventDeleted, inodeNumber)
+
+		if te, found := tl.names[inodeNumber]; found == true {
+			tl.describe(&e, te)
+		}
+
+		events = append(events, e)
+	}
+
+	for _, e := range events {
+		if e.Event == TimelineEventDeleted {
+			delete(tl.names, e.Inode)
+		}
+	}
+
+	for _, te := range added {
+		var e TimelineEvent
+
+		if old, found := removedByInode[te.inode]; found == true {
+			e = newEvent(TimelineEventRenamed, te.inode)
+			e.OldDirectory = old.directory
+			e.OldName = old.name
+			e.OldPath = tl.path(old)
+		} else if allocated[te.inode] == true {
+			e = newEvent(TimelineEventCreated, te.inode)
+			delete(allocated, te.inode)
+		} else {
+			e = newEvent(TimelineEventLinked, te.inode)
+		}
+
+		tl.names[te.inode] = te
+		tl.describe(&e, te)
+
+		events = append(events, e)
+	}
+
+	for _, inodeNumber := range sortedInodes(allocated) {
+		events = append(events, newEvent(TimelineEventCreated, inodeNumber))
+	}
+
+	changed := make(map[int]bool)
+	for inodeNumber := range inodeChanges {
+		changed[inodeNumber] = true
+	}
+
+	for _, inodeNumber := range sortedInodes(changed) {
+		for _, e := range inodeChanges[inodeNumber] {
+			e.Time = commitTime
+			e.Sequence = t.Sequence
+
+			if te, found := tl.names[inodeNumber]; found == true {
+				tl.describe(&e, te)
+			}
+
+			events = append(events, e)
+		}
+	}
+
+	return events, nil
+}
+
+// eventTime returns the commit time of the transaction, or the zero time if
+// it's too far out to be written as JSON (which only a corrupt commit block
+// would have).
+func eventTime(t *Transaction) time.Time {
+	commitTime := t.CommitTime().UTC()
+	if commitTime.Year() < 0 || commitTime.Year() > 9999 {
+		return time.Time{}
+	}
+
+	return commitTime
+}
+
+func sortedInodes(set map[int]bool) (inodes []int) {
+	inodes = make([]int, 0, len(set))
+	for inodeNumber := range set {
+		inodes = append(inodes, inodeNumber)
+	}
+
+	sort.Ints(inodes)
+
+	return inodes
+}
+
+// inodeEvents returns the size and timestamp changes of an inode that's in
+// use before and after.
+func inodeEvents(previous, current *ext4.Inode) (events []TimelineEvent) {
+	inodeNumber := current.Number()
+
+	events = make([]TimelineEvent, 0)
+
+	if previous.Size() != current.Size() {
+		events = append(events, TimelineEvent{
+			Event: TimelineEventSizeChanged,
+			Inode: inodeNumber,
+			Old:   previous.Size(),
+			New:   current.Size(),
+		})
+	}
+
+	times := []struct {
+		field             string
+		previous, current time.Time
+	}{
+		{"atime", previous.AccessTime(), current.AccessTime()},
+		{"ctime", previous.InodeChangeTime(), current.InodeChangeTime()},
+		{"mtime", previous.ModificationTime(), current.ModificationTime()},
+	}
+
+	for _, t := range times {
+		if t.previous.Equal(t.current) == false {
+			events = append(events, TimelineEvent{
+				Event: TimelineEventTimestampChanged,
+				Inode: inodeNumber,
+				Field: t.field,
+				Old:   t.previous.UTC(),
+				New:   t.current.UTC(),
+			})
+		}
+	}
+
+	return events
+}
+
+// WriteTimeline writes the events of every transaction in the journal's
+// `History` to `w`, as JSON, one event per line. Blocks that can't be decoded
+// are written as events of their own, and don't stop the rest.
+func WriteTimeline(w io.Writer, j *Journal, bm *ext4.BlockMap) (err error) {
+	rs, err := j.HistoryRevokes()
+	if err != nil {
+		return err
+	}
+
+	tl := NewTimeline(bm)
+	tl.AddRevokes(rs)
+
+	encoder := json.NewEncoder(w)
+
+	ti := j.History()
+	for {
+		t, err := ti.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		// Anything that was never committed never happened.
+		if t.Complete == false {
+			break
+		}
+
+		events, err := tl.Add(t)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range events {
+			err := encoder.Encode(e)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
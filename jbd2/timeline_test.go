@@ -0,0 +1,238 @@
+package jbd2
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-ext4"
+	"github.com/dsoprea/go-logging"
+)
+
+func TestTimeline_Add(t *testing.T) {
+	f, err := os.Open(path.Join(assetsPath, "history.ext4"))
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	bm, err := ext4.NewBlockMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	tl := NewTimeline(bm)
+
+	// Block (69) has inodes (13) through (16), and (1330) is the "docs"
+	// directory (12). Nothing is reported for the first copies.
+
+	inodeTable, err := sb.ReadPhysicalBlock(69, 1024)
+	log.PanicIf(err)
+
+	names := []string{".", "..", "a.txt", "c.txt"}
+	inodes := []uint32{12, 2, 13, 14}
+
+	events, err := tl.Add(getTestTransaction(10, map[uint64][]byte{
+		69:   inodeTable,
+		1330: getTestDirectoryBlock(1024, names, inodes),
+	}))
+
+	log.PanicIf(err)
+
+	if len(events) != 0 {
+		t.Fatalf("Expected no events for the baseline: %v", events)
+	}
+
+	// Create (16) and add a second name for (13).
+
+	allocated := make([]byte, len(inodeTable))
+	copy(allocated, inodeTable)
+
+	binary.LittleEndian.PutUint16(allocated[3*256:], ext4.InodeModeRegular|0644)
+	binary.LittleEndian.PutUint16(allocated[3*256+0x1a:], 1)
+
+	names = append(names, "new.txt", "link.txt")
+	inodes = append(inodes, 16, 13)
+
+	events, err = tl.Add(getTestTransaction(11, map[uint64][]byte{
+		69:   allocated,
+		1330: getTestDirectoryBlock(1024, names, inodes),
+	}))
+
+	log.PanicIf(err)
+
+	actual := make([]string, len(events))
+	for i, e := range events {
+		actual[i] = fmt.Sprintf("%s (%d) [%s]", e.Event, e.Inode, e.Name)
+	}
+
+	expected := []string{
+		"created (16) [new.txt]",
+		"linked (13) [link.txt]",
+	}
+
+	if reflect.DeepEqual(actual, expected) == false {
+		t.Fatalf("Events not correct: %v", actual)
+	}
+
+	// Remove the original name of (13) and delete (16) without its directory
+	// entry having been logged.
+
+	events, err = tl.Add(getTestTransaction(12, map[uint64][]byte{
+		69:   inodeTable,
+		1330: getTestDirectoryBlock(1024, []string{".", "..", "c.txt", "new.txt", "link.txt"}, []uint32{12, 2, 14, 16, 13}),
+	}))
+
+	log.PanicIf(err)
+
+	actual = make([]string, len(events))
+	for i, e := range events {
+		actual[i] = fmt.Sprintf("%s (%d) [%s]", e.Event, e.Inode, e.Name)
+	}
+
+	expected = []string{
+		"unlinked (13) [a.txt]",
+		"deleted (16) [new.txt]",
+	}
+
+	if reflect.DeepEqual(actual, expected) == false {
+		t.Fatalf("Events not correct: %v", actual)
+	}
+}
+
+func TestTimeline_Add_Undecodable(t *testing.T) {
+	f, err := os.Open(path.Join(assetsPath, "history.ext4"))
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	bm, err := ext4.NewBlockMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	tl := NewTimeline(bm)
+
+	inodeTable, err := sb.ReadPhysicalBlock(69, 1024)
+	log.PanicIf(err)
+
+	names := []string{".", "..", "a.txt", "c.txt"}
+	inodes := []uint32{12, 2, 13, 14}
+
+	_, err = tl.Add(getTestTransaction(10, map[uint64][]byte{
+		69:   inodeTable,
+		1330: getTestDirectoryBlock(1024, names, inodes),
+	}))
+
+	log.PanicIf(err)
+
+	// The next copy of the directory no longer parses (a block of zeroes has
+	// a record-length of zero), but the inode-table still counts.
+
+	allocated := make([]byte, len(inodeTable))
+	copy(allocated, inodeTable)
+
+	binary.LittleEndian.PutUint16(allocated[3*256:], ext4.InodeModeRegular|0644)
+	binary.LittleEndian.PutUint16(allocated[3*256+0x1a:], 1)
+
+	events, err := tl.Add(getTestTransaction(11, map[uint64][]byte{
+		69:   allocated,
+		1330: make([]byte, 1024),
+	}))
+
+	log.PanicIf(err)
+
+	if len(events) != 2 {
+		t.Fatalf("Expected two events: %v", events)
+	}
+
+	e := events[0]
+	if e.Event != TimelineEventUndecodable || e.Inode != 12 || e.Block != 1330 || e.Error == "" {
+		t.Fatalf("Undecodable event not correct: %v", e)
+	} else if events[1].Event != TimelineEventCreated || events[1].Inode != 16 {
+		t.Fatalf("Created event not correct: %v", events[1])
+	}
+
+	// The copy after that is a new baseline, and the one after that is
+	// compared with it.
+
+	names = append(names, "new.txt")
+	inodes = append(inodes, 16)
+
+	events, err = tl.Add(getTestTransaction(12, map[uint64][]byte{
+		1330: getTestDirectoryBlock(1024, names, inodes),
+	}))
+
+	log.PanicIf(err)
+
+	if len(events) != 0 {
+		t.Fatalf("Expected no events for the new baseline: %v", events)
+	}
+
+	events, err = tl.Add(getTestTransaction(13, map[uint64][]byte{
+		1330: getTestDirectoryBlock(1024, []string{".", "..", "a.txt", "new.txt"}, []uint32{12, 2, 13, 16}),
+	}))
+
+	log.PanicIf(err)
+
+	if len(events) != 1 || events[0].Event != TimelineEventUnlinked || events[0].Inode != 14 || events[0].Name != "c.txt" {
+		t.Fatalf("Events after the undecodable copy not correct: %v", events)
+	}
+}
+
+func TestWriteTimeline_NoMetadata(t *testing.T) {
+	f, err := os.Open(path.Join(assetsPath, "journal_csum.ext4"))
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	j, err := NewJournalWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bm, err := ext4.NewBlockMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	b := new(bytes.Buffer)
+
+	err = WriteTimeline(b, j, bm)
+	log.PanicIf(err)
+
+	// Its transactions only log data blocks, so there are no events.
+	if b.Len() != 0 {
+		t.Fatalf("Expected no events: %s", b.String())
+	}
+}
+
+func ExampleWriteTimeline() {
+	f, err := os.Open(path.Join(assetsPath, "history.ext4"))
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := ext4.NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	j, err := NewJournalWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bm, err := ext4.NewBlockMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	err = WriteTimeline(os.Stdout, j, bm)
+	log.PanicIf(err)
+
+	// Output:
+	// {"time":"2024-01-02T03:04:05Z","sequence":2,"event":"renamed","inode":14,"directory":12,"name":"c.txt","path":"docs/c.txt","old_directory":12,"old_name":"b.txt","old_path":"docs/b.txt"}
+	// {"time":"2024-01-02T03:04:05Z","sequence":2,"event":"size-changed","inode":13,"directory":12,"name":"a.txt","path":"docs/a.txt","old":500,"new":1000}
+	// {"time":"2024-01-02T03:04:05Z","sequence":2,"event":"timestamp-changed","inode":13,"directory":12,"name":"a.txt","path":"docs/a.txt","field":"mtime","old":"2026-10-18T20:30:23Z","new":"2024-01-02T03:04:05Z"}
+	// {"time":"2024-01-03T00:00:00Z","sequence":3,"event":"deleted","inode":15,"directory":12,"name":"deleted.txt","path":"docs/deleted.txt"}
+}
@@ -0,0 +1,249 @@
+package jbd2
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dsoprea/go-ext4"
+)
+
+// Transaction is everything that the log has for one sequence number.
+type Transaction struct {
+	Sequence uint32
+
+	// StartBlock is the journal block that the transaction starts at.
+	StartBlock uint32
+
+	// BlockCount is the number of journal blocks that the transaction uses,
+	// including the descriptor, revoke, and commit blocks.
+	BlockCount int
+
+	// DataBlocks are the blocks to be written to the filesystem, in the order
+	// that they were logged. The same filesystem block may appear more than
+	// once, in which case the last one wins.
+	DataBlocks []JournalDataBlock
+
+	// Revokes has the blocks revoked by this transaction.
+	Revokes *RevokeSet
+
+	// Commit is the commit block, or nil if the log ended first.
+	Commit *JournalCommitBlock
+
+	// Complete indicates that the transaction was committed. Recovery ignores
+	// incomplete transactions.
+	Complete bool
+
+	// Torn indicates that the transaction has a commit block but that its
+	// checksum doesn't match (either of the commit block itself or, with
+	// `JsbFeatureCompatChecksum`, of the rest of the transaction), or that one
+	// of its descriptor or revoke blocks fails its tail checksum, so the
+	// transaction might not have been written in full. This is expected of the
+	// last transaction with `JsbFeatureIncompatAsyncCommit`, which doesn't
+	// wait for the rest of the transaction before writing the commit block. A
+	// torn transaction isn't complete, and it ends the log.
+	Torn bool
+}
+
+// CommitTime returns the time that the transaction was committed, or the zero
+// time if it never was.
+func (t *Transaction) CommitTime() time.Time {
+	if t.Commit == nil {
+		return time.Time{}
+	}
+
+	return t.Commit.CommitTime()
+}
+
+// TargetBlocks returns the filesystem blocks written by the transaction, in
+// the order that they were logged and without repeats.
+func (t *Transaction) TargetBlocks() []uint64 {
+	seen := make(map[uint64]struct{})
+	blocks := make([]uint64, 0, len(t.DataBlocks))
+
+	for _, dataBlock := range t.DataBlocks {
+		blocknr := dataBlock.Blocknr()
+
+		if _, found := seen[blocknr]; found == true {
+			continue
+		}
+
+		seen[blocknr] = struct{}{}
+		blocks = append(blocks, blocknr)
+	}
+
+	return blocks
+}
+
+func (t *Transaction) String() string {
+	return fmt.Sprintf("Transaction<SEQ=(%d) START=(%d) BLOCKS=(%d) DATA-BLOCKS=(%d) REVOKES=(%d) COMPLETE=[%v]>", t.Sequence, t.StartBlock, t.BlockCount, len(t.DataBlocks), t.Revokes.Len(), t.Complete)
+}
+
+// TransactionIterator steps through the transactions in the log.
+type TransactionIterator struct {
+	journal *Journal
+
+	nextBlock    uint32
+	nextSequence uint32
+
+	// scanned is the number of blocks that we've consumed. The log can't be
+	// any longer than the space for it.
+	scanned uint32
+
+	done bool
+	err  error
+}
+
+// advance moves to the next block in the log.
+func (ti *TransactionIterator) advance(t *Transaction) error {
+	ti.nextBlock = ti.journal.nextLogBlock(ti.nextBlock)
+	ti.scanned++
+
+	t.BlockCount++
+
+	if ti.scanned > ti.journal.logLength() {
+		return &ext4.ErrCorrupt{
+			Structure: "journal",
+			Reason:    fmt.Sprintf("transaction (%d) runs past the length of the log (%d)", t.Sequence, ti.journal.logLength()),
+		}
+	}
+
+	return nil
+}
+
+// finish ends the iteration, returning whatever we had of the current
+// transaction (which is then necessarily incomplete). An error is returned
+// now if there's no transaction, or by the next call otherwise.
+func (ti *TransactionIterator) finish(t *Transaction, err error) (*Transaction, error) {
+	ti.done = true
+
+	if t == nil {
+		if err == nil {
+			err = io.EOF
+		}
+
+		return nil, err
+	}
+
+	ti.err = err
+
+	return t, nil
+}
+
+// Next returns the next transaction. The log ends at the first block that
+// isn't part of the next transaction in sequence, after which `io.EOF` is
+// returned. The last transaction returned might not be complete.
+func (ti *TransactionIterator) Next() (t *Transaction, err error) {
+	if ti.done == true {
+		if ti.err != nil {
+			err := ti.err
+			ti.err = nil
+
+			return nil, err
+		}
+
+		return nil, io.EOF
+	}
+
+	jsb := ti.journal.jsb
+
+	var checksums *transactionChecksums
+	if jsb.HasCompatibleFeature(JsbFeatureCompatChecksum) == true {
+		checksums = newTransactionChecksums()
+	}
+
+	for {
+		block, err := ti.journal.ReadBlock(ti.nextBlock)
+		if err != nil {
+			return ti.finish(t, err)
+		}
+
+		jh, err := parseJournalHeader(block)
+		if err != nil {
+			return ti.finish(t, err)
+		}
+
+		if jh.HMagic != JournalBlockHeaderMagicBytes || jh.HSequence != ti.nextSequence {
+			// The end of the log.
+			return ti.finish(t, nil)
+		}
+
+		// A descriptor or revoke block that fails its checksum might just be
+		// stale (e.g. left from before the journal was lazily initialized), so,
+		// like the kernel's scan, we don't fail the whole journal over it. It
+		// ends the log, and what we have of its transaction is torn.
+		if (jh.HBlocktype == BtDescriptor || jh.HBlocktype == BtBlockRevocationRecord) && jsb.VerifyBlockTailChecksum(block) == false {
+			if t != nil {
+				t.Torn = true
+			}
+
+			return ti.finish(t, nil)
+		}
+
+		jb, err := jsb.parseBlock(block)
+		if err != nil {
+			return ti.finish(t, err)
+		}
+
+		if t == nil {
+			t = &Transaction{
+				Sequence:   ti.nextSequence,
+				StartBlock: ti.nextBlock,
+				DataBlocks: make([]JournalDataBlock, 0),
+				Revokes:    NewRevokeSet(),
+			}
+		}
+
+		err = ti.advance(t)
+		if err != nil {
+			return ti.finish(t, err)
+		}
+
+		switch jb.Type() {
+		case BtDescriptor:
+			jdb := jb.(*JournalDescriptorBlock)
+
+			if checksums != nil {
+				checksums.update(block)
+			}
+
+			for _, jbt := range jdb.Tags {
+				journalBlock := ti.nextBlock
+
+				data, err := ti.journal.ReadBlock(journalBlock)
+				if err != nil {
+					return ti.finish(t, err)
+				}
+
+				if checksums != nil {
+					checksums.update(data)
+				}
+
+				dataBlock := jsb.newDataBlock(jbt, t.Sequence, journalBlock, data)
+				t.DataBlocks = append(t.DataBlocks, dataBlock)
+
+				err = ti.advance(t)
+				if err != nil {
+					return ti.finish(t, err)
+				}
+			}
+
+		case BtBlockRevocationRecord:
+			t.Revokes.AddBlock(jb.(*JournalRevokeBlock))
+
+		case BtBlockCommitRecord:
+			t.Commit = jb.(*JournalCommitBlock)
+
+			if jsb.VerifyCommitBlockChecksum(block) == false || (checksums != nil && checksums.verify(t.Commit.Data()) == false) {
+				t.Torn = true
+				return ti.finish(t, nil)
+			}
+
+			t.Complete = true
+
+			ti.nextSequence++
+
+			return t, nil
+		}
+	}
+}
@@ -0,0 +1,526 @@
+package jbd2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"testing"
+
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+
+	"github.com/dsoprea/go-ext4"
+	"github.com/dsoprea/go-logging"
+)
+
+// getTestTransactions collects everything from the iterator.
+func getTestTransactions(ti *TransactionIterator) (transactions []*Transaction, err error) {
+	transactions = make([]*Transaction, 0)
+
+	for {
+		t, err := ti.Next()
+		if err == io.EOF {
+			return transactions, nil
+		} else if err != nil {
+			return transactions, err
+		}
+
+		transactions = append(transactions, t)
+	}
+}
+
+func TestJournal_Transactions(t *testing.T) {
+	journal := getTestJournalBytes("journal_csum.ext4")
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(journal))
+	log.PanicIf(err)
+
+	transactions, err := getTestTransactions(j.Transactions())
+	log.PanicIf(err)
+
+	if len(transactions) != 3 {
+		t.Fatalf("Transaction count not correct: (%d)", len(transactions))
+	}
+
+	t1 := transactions[0]
+
+	if t1.String() != "Transaction<SEQ=(1) START=(1) BLOCKS=(5) DATA-BLOCKS=(3) REVOKES=(0) COMPLETE=[true]>" {
+		t.Fatalf("First transaction not correct: %s", t1)
+	} else if reflect.DeepEqual(t1.TargetBlocks(), []uint64{3000, 3001, 3002}) == false {
+		t.Fatalf("First transaction targets not correct: %v", t1.TargetBlocks())
+	} else if t1.CommitTime().IsZero() == true {
+		t.Fatalf("First transaction has no commit time.")
+	}
+
+	for i, dataBlock := range t1.DataBlocks {
+		if dataBlock.JournalBlock != uint32(2+i) {
+			t.Fatalf("Data block (%d) journal block not correct: (%d)", i, dataBlock.JournalBlock)
+		} else if dataBlock.ChecksumValid != true {
+			t.Fatalf("Data block (%d) checksum not valid.", i)
+		}
+	}
+
+	t2 := transactions[1]
+
+	if t2.String() != "Transaction<SEQ=(2) START=(6) BLOCKS=(2) DATA-BLOCKS=(0) REVOKES=(1) COMPLETE=[true]>" {
+		t.Fatalf("Second transaction not correct: %s", t2)
+	} else if reflect.DeepEqual(t2.Revokes.Blocks(), []uint64{3000}) == false {
+		t.Fatalf("Second transaction revokes not correct: %v", t2.Revokes.Blocks())
+	}
+
+	t3 := transactions[2]
+
+	if t3.String() != "Transaction<SEQ=(3) START=(8) BLOCKS=(3) DATA-BLOCKS=(1) REVOKES=(0) COMPLETE=[true]>" {
+		t.Fatalf("Third transaction not correct: %s", t3)
+	} else if reflect.DeepEqual(t3.TargetBlocks(), []uint64{3003}) == false {
+		t.Fatalf("Third transaction targets not correct: %v", t3.TargetBlocks())
+	}
+}
+
+func TestJournal_Transactions_Clean(t *testing.T) {
+	filepath := path.Join(assetsPath, "journal.ext4")
+
+	f, inode, err := GetJournalInode(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	j, err := NewJournalWithInode(inode)
+	log.PanicIf(err)
+
+	// The journal is clean, so there's nothing to recover.
+
+	_, err = j.Transactions().Next()
+	if err != io.EOF {
+		t.Fatalf("Expected EOF for clean journal: %v", err)
+	}
+
+	// But the old transactions are still there.
+
+	transactions, err := getTestTransactions(j.TransactionsFrom(1, 2))
+	log.PanicIf(err)
+
+	if len(transactions) != 2 {
+		t.Fatalf("Transaction count not correct: (%d)", len(transactions))
+	} else if reflect.DeepEqual(transactions[0].TargetBlocks(), []uint64{74}) == false {
+		t.Fatalf("First transaction targets not correct: %v", transactions[0].TargetBlocks())
+	} else if reflect.DeepEqual(transactions[1].TargetBlocks(), []uint64{58, 2, 75, 74, 44, 43}) == false {
+		t.Fatalf("Second transaction targets not correct: %v", transactions[1].TargetBlocks())
+	}
+}
+
+func TestJournal_Transactions_Wrap(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SMaxlen = 16
+	jsbd.SStart = 13
+	jsbd.SSequence = 5
+
+	blockSize := int(jsbd.SBlocksize)
+
+	blocks := map[uint32][]byte{
+		13: getTestDescriptorBlock(blockSize, 5, []uint32{100, 101}),
+		14: getTestDataBlock(blockSize, 0xaa),
+		15: getTestDataBlock(blockSize, 0xbb),
+
+		// Wraps to the first log block.
+		1: getTestCommitBlock(blockSize, 5, 1000),
+		2: getTestDescriptorBlock(blockSize, 6, []uint32{102}),
+		3: getTestDataBlock(blockSize, 0xcc),
+		4: getTestCommitBlock(blockSize, 6, 1001),
+	}
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(getTestJournal(jsbd, blocks)))
+	log.PanicIf(err)
+
+	transactions, err := getTestTransactions(j.Transactions())
+	log.PanicIf(err)
+
+	if len(transactions) != 2 {
+		t.Fatalf("Transaction count not correct: (%d)", len(transactions))
+	}
+
+	t1 := transactions[0]
+
+	if t1.Complete != true || t1.BlockCount != 4 {
+		t.Fatalf("First transaction not correct: %s", t1)
+	} else if t1.DataBlocks[0].JournalBlock != 14 || t1.DataBlocks[1].JournalBlock != 15 {
+		t.Fatalf("Data block locations not correct.")
+	} else if t1.DataBlocks[1].Data[0] != 0xbb {
+		t.Fatalf("Data not correct.")
+	} else if t1.CommitTime().Unix() != 1000 {
+		t.Fatalf("Commit time not correct: [%s]", t1.CommitTime())
+	}
+
+	if transactions[1].Sequence != 6 || transactions[1].StartBlock != 2 {
+		t.Fatalf("Second transaction not correct: %s", transactions[1])
+	}
+}
+
+func TestJournal_Transactions_Gap(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SMaxlen = 16
+	jsbd.SSequence = 5
+
+	blockSize := int(jsbd.SBlocksize)
+
+	blocks := map[uint32][]byte{
+		1: getTestDescriptorBlock(blockSize, 5, []uint32{100}),
+		2: getTestDataBlock(blockSize, 0xaa),
+		3: getTestCommitBlock(blockSize, 5, 1000),
+
+		// Skips a sequence, so this is left over from something older.
+		4: getTestDescriptorBlock(blockSize, 7, []uint32{101}),
+		5: getTestDataBlock(blockSize, 0xbb),
+		6: getTestCommitBlock(blockSize, 7, 1001),
+	}
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(getTestJournal(jsbd, blocks)))
+	log.PanicIf(err)
+
+	transactions, err := getTestTransactions(j.Transactions())
+	log.PanicIf(err)
+
+	if len(transactions) != 1 || transactions[0].Sequence != 5 {
+		t.Fatalf("Expected only the first transaction: %v", transactions)
+	}
+}
+
+func TestJournal_Transactions_Incomplete(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SMaxlen = 16
+	jsbd.SSequence = 5
+
+	blockSize := int(jsbd.SBlocksize)
+
+	blocks := map[uint32][]byte{
+		1: getTestDescriptorBlock(blockSize, 5, []uint32{100}),
+		2: getTestDataBlock(blockSize, 0xaa),
+		3: getTestCommitBlock(blockSize, 5, 1000),
+
+		// Never committed.
+		4: getTestDescriptorBlock(blockSize, 6, []uint32{101}),
+		5: getTestDataBlock(blockSize, 0xbb),
+	}
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(getTestJournal(jsbd, blocks)))
+	log.PanicIf(err)
+
+	transactions, err := getTestTransactions(j.Transactions())
+	log.PanicIf(err)
+
+	if len(transactions) != 2 {
+		t.Fatalf("Transaction count not correct: (%d)", len(transactions))
+	}
+
+	t2 := transactions[1]
+
+	if t2.Complete != false || t2.Commit != nil || t2.CommitTime().IsZero() != true {
+		t.Fatalf("Second transaction should be incomplete: %s", t2)
+	} else if reflect.DeepEqual(t2.TargetBlocks(), []uint64{101}) == false {
+		t.Fatalf("Second transaction targets not correct: %v", t2.TargetBlocks())
+	}
+}
+
+func TestJournal_Transactions_Corrupt(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SMaxlen = 16
+	jsbd.SSequence = 5
+
+	blockSize := int(jsbd.SBlocksize)
+
+	// A descriptor with no last tag.
+	descriptor := getTestDescriptorBlock(blockSize, 5, []uint32{100})
+	descriptor[JournalHeaderSize+7] = 0
+
+	blocks := map[uint32][]byte{
+		1: getTestDescriptorBlock(blockSize, 5, []uint32{100}),
+		2: getTestDataBlock(blockSize, 0xaa),
+		3: descriptor,
+	}
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(getTestJournal(jsbd, blocks)))
+	log.PanicIf(err)
+
+	ti := j.Transactions()
+
+	// We get what we had and then the error.
+
+	transaction, err := ti.Next()
+	log.PanicIf(err)
+
+	if transaction.Complete != false || len(transaction.DataBlocks) != 1 {
+		t.Fatalf("Transaction not correct: %s", transaction)
+	}
+
+	_, err = ti.Next()
+
+	var errCorrupt *ext4.ErrCorrupt
+	if errors.As(err, &errCorrupt) == false {
+		t.Fatalf("Expected corrupt error: %v", err)
+	}
+
+	_, err = ti.Next()
+	if err != io.EOF {
+		t.Fatalf("Expected EOF after the error: %v", err)
+	}
+}
+
+func TestJournal_Transactions_BadTailChecksum(t *testing.T) {
+	blockSize := 1024
+
+	// Change the unused space after the tags of the descriptor of the third
+	// transaction (journal block 8), which only its checksum would notice. The
+	// log ends there, without an error.
+
+	journal := getTestJournalBytes("journal_csum.ext4")
+	journal[8*blockSize+500] ^= 0xff
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(journal))
+	log.PanicIf(err)
+
+	transactions, err := getTestTransactions(j.Transactions())
+	log.PanicIf(err)
+
+	if len(transactions) != 2 {
+		t.Fatalf("Transaction count not correct: (%d)", len(transactions))
+	} else if transactions[1].Complete != true {
+		t.Fatalf("Second transaction should be complete: %s", transactions[1])
+	}
+
+	// Likewise for the revoke block of the second transaction (journal block
+	// 6).
+
+	journal = getTestJournalBytes("journal_csum.ext4")
+	journal[6*blockSize+900] ^= 0xff
+
+	j, err = NewJournalWithReaderAt(bytes.NewReader(journal))
+	log.PanicIf(err)
+
+	transactions, err = getTestTransactions(j.Transactions())
+	log.PanicIf(err)
+
+	if len(transactions) != 1 || transactions[0].Complete != true {
+		t.Fatalf("Transactions not correct: %v", transactions)
+	}
+
+	cow, err := ext4.NewCopyOnWriteWithReaderAt(bytes.NewReader(make([]byte, 4096*blockSize)), blockSize)
+	log.PanicIf(err)
+
+	ri, err := j.Recover(cow)
+	log.PanicIf(err)
+
+	if ri.Transactions != 1 {
+		t.Fatalf("Recovery not correct: %s", ri)
+	}
+}
+
+// getTestChecksummedTransaction returns the blocks of a transaction (at
+// journal block (1)) with the given transaction checksum in its commit block.
+// `checksum` returns the checksum type and value for the descriptor and data
+// blocks.
+func getTestChecksummedTransaction(blockSize int, sequence uint32, checksum func(blocks ...[]byte) (uint8, []byte)) map[uint32][]byte {
+	descriptor := getTestDescriptorBlock(blockSize, sequence, []uint32{100})
+	data := getTestDataBlock(blockSize, 0xaa)
+	commit := getTestCommitBlock(blockSize, sequence, 1000)
+
+	checksumType, sum := checksum(descriptor, data)
+
+	commit[JournalHeaderSize] = checksumType
+	commit[JournalHeaderSize+1] = uint8(len(sum))
+	copy(commit[commitBlockChecksumOffset:], sum)
+
+	return map[uint32][]byte{
+		1: descriptor,
+		2: data,
+		3: commit,
+	}
+}
+
+func TestJournal_Transactions_CompatChecksum(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SMaxlen = 16
+	jsbd.SFeatureCompat = JsbFeatureCompatChecksum
+
+	blockSize := int(jsbd.SBlocksize)
+
+	checksums := map[string]func(blocks ...[]byte) (uint8, []byte){
+		"none": func(blocks ...[]byte) (uint8, []byte) {
+			return 0, []byte{}
+		},
+		"crc32": func(blocks ...[]byte) (uint8, []byte) {
+			crc := uint32(0xffffffff)
+			for _, block := range blocks {
+				crc = crc32Be(crc, block)
+			}
+
+			sum := make([]byte, 4)
+			binary.BigEndian.PutUint32(sum, crc)
+
+			return JccCrc32, sum
+		},
+		"md5": func(blocks ...[]byte) (uint8, []byte) {
+			h := md5.New()
+			for _, block := range blocks {
+				h.Write(block)
+			}
+
+			return JccMd5, h.Sum(nil)
+		},
+		"sha1": func(blocks ...[]byte) (uint8, []byte) {
+			h := sha1.New()
+			for _, block := range blocks {
+				h.Write(block)
+			}
+
+			return JccSha1, h.Sum(nil)
+		},
+	}
+
+	for name, checksum := range checksums {
+		blocks := getTestChecksummedTransaction(blockSize, 1, checksum)
+
+		j, err := NewJournalWithReaderAt(bytes.NewReader(getTestJournal(jsbd, blocks)))
+		log.PanicIf(err)
+
+		transactions, err := getTestTransactions(j.Transactions())
+		log.PanicIf(err)
+
+		if len(transactions) != 1 || transactions[0].Complete != true || transactions[0].Torn != false {
+			t.Fatalf("Transaction with [%s] checksum not complete: %v", name, transactions)
+		}
+
+		// Change the data after the checksum was taken.
+
+		blocks[2][100] ^= 0xff
+
+		j, err = NewJournalWithReaderAt(bytes.NewReader(getTestJournal(jsbd, blocks)))
+		log.PanicIf(err)
+
+		transactions, err = getTestTransactions(j.Transactions())
+		log.PanicIf(err)
+
+		if name == "none" {
+			if transactions[0].Complete != true {
+				t.Fatalf("Transaction without a checksum should still be complete.")
+			}
+
+			continue
+		}
+
+		if len(transactions) != 1 || transactions[0].Complete != false || transactions[0].Torn != true || transactions[0].Commit == nil {
+			t.Fatalf("Transaction with bad [%s] checksum should be torn: %v", name, transactions)
+		}
+	}
+}
+
+func TestJournal_Transactions_Torn(t *testing.T) {
+	jsbd := getTestJournalSuperblockData()
+	jsbd.SMaxlen = 16
+	jsbd.SFeatureIncompat = JsbFeatureIncompatAsyncCommit | JsbFeatureIncompatCsumV3
+	jsbd.SChecksumType = JccCrc32c
+
+	encoded := encodeTestJournalSuperblock(jsbd)
+	binary.BigEndian.PutUint32(encoded[journalSuperblockChecksumOffset:], crc32c(0xffffffff, encoded))
+
+	jsb, err := NewJournalSuperblock(bytes.NewReader(encoded))
+	log.PanicIf(err)
+
+	blockSize := int(jsbd.SBlocksize)
+
+	// Only the commit blocks have proper checksums, and the second one is
+	// for different contents than it has (as if it was written before the
+	// rest of the commit block made it).
+
+	commit := func(sequence uint32) []byte {
+		block := getTestCommitBlock(blockSize, sequence, 1000)
+
+		checksum := crc32c(jsb.checksumSeed, block)
+		binary.BigEndian.PutUint32(block[commitBlockChecksumOffset:], checksum)
+
+		return block
+	}
+
+	revoke := func(sequence uint32) []byte {
+		block := getTestRevokeBlock(blockSize, sequence, 4, []uint64{100})
+
+		tailOffset := blockSize - JournalBlockTailSize
+		checksum := crc32c(jsb.checksumSeed, block[:tailOffset])
+		checksum = crc32c(checksum, []byte{0, 0, 0, 0})
+		binary.BigEndian.PutUint32(block[tailOffset:], checksum)
+
+		return block
+	}
+
+	torn := commit(2)
+	torn[0x30] ^= 0xff
+
+	blocks := map[uint32][]byte{
+		1: revoke(1),
+		2: commit(1),
+		3: revoke(2),
+		4: torn,
+		5: revoke(3),
+		6: commit(3),
+	}
+
+	journal := getTestJournal(jsbd, blocks)
+	copy(journal, encoded)
+
+	j, err := NewJournalWithReaderAt(bytes.NewReader(journal))
+	log.PanicIf(err)
+
+	transactions, err := getTestTransactions(j.Transactions())
+	log.PanicIf(err)
+
+	// Nothing after the torn transaction is used.
+
+	if len(transactions) != 2 {
+		t.Fatalf("Transaction count not correct: (%d)", len(transactions))
+	} else if transactions[0].Complete != true || transactions[0].Torn != false {
+		t.Fatalf("First transaction not correct: %s", transactions[0])
+	} else if transactions[1].Complete != false || transactions[1].Torn != true {
+		t.Fatalf("Second transaction should be torn: %s", transactions[1])
+	}
+
+	_, endSequence, err := scanLog(j.Transactions(), nil)
+	log.PanicIf(err)
+
+	if endSequence != 2 {
+		t.Fatalf("Log should end at the torn transaction: (%d)", endSequence)
+	}
+}
+
+func ExampleJournal_Transactions() {
+	filepath := path.Join(assetsPath, "journal.ext4")
+
+	f, inode, err := GetJournalInode(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	j, err := NewJournalWithInode(inode)
+	log.PanicIf(err)
+
+	// The journal in this image is clean, so we look at what's left over from
+	// before instead of calling `Transactions()`.
+	ti := j.TransactionsFrom(1, 2)
+
+	for {
+		t, err := ti.Next()
+		if err == io.EOF {
+			break
+		}
+
+		log.PanicIf(err)
+
+		fmt.Printf("%s %v\n", t, t.TargetBlocks())
+	}
+
+	// Output:
+	// Transaction<SEQ=(2) START=(1) BLOCKS=(3) DATA-BLOCKS=(1) REVOKES=(0) COMPLETE=[true]> [74]
+	// Transaction<SEQ=(3) START=(4) BLOCKS=(8) DATA-BLOCKS=(6) REVOKES=(0) COMPLETE=[true]> [58 2 75 74 44 43]
+}
@@ -3,8 +3,6 @@ package jbd2
 import (
 	"fmt"
 	"io"
-
-	"github.com/dsoprea/go-logging"
 )
 
 func DumpBytes(data []byte) {
@@ -16,19 +14,8 @@ func DumpBytes(data []byte) {
 	fmt.Printf("\n")
 }
 
+// ReadExactly fills the buffer. Running out of data before then is an error.
 func ReadExactly(r io.Reader, buffer []byte) (err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
-
-	for offset := 0; offset < len(buffer); {
-		n, err := r.Read(buffer[offset:])
-		log.PanicIf(err)
-
-		offset += n
-	}
-
-	return nil
+	_, err = io.ReadFull(r, buffer)
+	return err
 }
@@ -0,0 +1,462 @@
+package jbd2
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-ext4"
+)
+
+var (
+	// ErrJournalFull is returned when a transaction doesn't fit in what's left
+	// of the log.
+	ErrJournalFull = errors.New("journal full")
+)
+
+// LoggedBlock is a filesystem block to be written by a transaction.
+type LoggedBlock struct {
+	Block uint64
+	Data  []byte
+}
+
+// syncer is implemented by anything that can flush what's been written to it
+// to stable storage, like `*os.File`.
+type syncer interface {
+	Sync() error
+}
+
+// JournalWriter appends transactions to the log of a filesystem's internal
+// journal, the way that the kernel commits them, so that they're replayed by
+// the next recovery (ours, the kernel's, or e2fsck's). Nothing is written to
+// the filesystem itself other than setting `SbFeatureIncompatRecover`, which
+// is what tells everyone that there's something to replay.
+//
+// If `rw` can sync (like an `*os.File`), every step is synced before the next
+// one, so that a crash at any point leaves either the whole transaction or
+// none of it.
+type JournalWriter struct {
+	rw      ReaderWriterAt
+	journal *Journal
+	en      *ext4.ExtentNavigator
+
+	// nextBlock and nextSequence are where the next transaction goes and what
+	// it's called. free is the number of blocks from there to the start of the
+	// log.
+	nextBlock    uint32
+	nextSequence uint32
+	free         uint32
+
+	// empty indicates that the journal superblock still says that the log is
+	// empty, so it has to be pointed at the first transaction.
+	empty bool
+}
+
+// NewJournalWriter returns a `JournalWriter` for the internal journal of the
+// filesystem in `rw`. If the log already has transactions (the filesystem
+// needs recovery), new ones are appended after the last complete one.
+func NewJournalWriter(rw ReaderWriterAt) (jw *JournalWriter, err error) {
+	sb, err := ext4.NewSuperblockWithReaderAt(rw)
+	if err != nil {
+		return nil, err
+	}
+
+	// We're going to be writing underneath it.
+	sb.SetBlockCache(nil)
+
+	j, en, err := internalJournal(sb)
+	if err != nil {
+		return nil, err
+	}
+
+	if uint32(j.BlockSize()) != sb.BlockSize() {
+		return nil, &ext4.ErrCorrupt{
+			Structure: "journal superblock",
+			Reason:    fmt.Sprintf("block-size (%d) not the same as the filesystem's (%d)", j.BlockSize(), sb.BlockSize()),
+		}
+	}
+
+	jsbd := j.jsb.data
+
+	jw = &JournalWriter{
+		rw:           rw,
+		journal:      j,
+		en:           en,
+		nextBlock:    jsbd.SFirst,
+		nextSequence: jsbd.SSequence,
+		free:         j.logLength(),
+		empty:        jsbd.SStart == 0,
+	}
+
+	if jw.empty == true {
+		return jw, nil
+	}
+
+	// Anything after the last complete transaction will never be replayed, so
+	// it can be overwritten.
+
+	jw.nextBlock = jsbd.SStart
+
+	_, jw.nextSequence, err = scanLog(j.Transactions(), func(t *Transaction) bool {
+		for i := 0; i < t.BlockCount; i++ {
+			jw.nextBlock = j.nextLogBlock(jw.nextBlock)
+		}
+
+		jw.free -= uint32(t.BlockCount)
+
+		return false
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return jw, nil
+}
+
+// NextSequence returns the sequence that the next transaction will have.
+func (jw *JournalWriter) NextSequence() uint32 {
+	return jw.nextSequence
+}
+
+// Free returns the number of log blocks left for new transactions.
+func (jw *JournalWriter) Free() uint32 {
+	return jw.free
+}
+
+// sync syncs `rw` if it can be.
+func (jw *JournalWriter) sync() error {
+	if s, ok := jw.rw.(syncer); ok == true {
+		return s.Sync()
+	}
+
+	return nil
+}
+
+// blockOffset returns where the given journal block is in `rw`.
+func (jw *JournalWriter) blockOffset(n uint32) (offset int64, err error) {
+	pBlock, mapped, err := jw.en.MapLogicalBlock(uint64(n))
+	if err != nil {
+		return 0, err
+	} else if mapped == false {
+		return 0, &ext4.ErrCorrupt{
+			Structure: "journal",
+			Reason:    fmt.Sprintf("block (%d) not mapped", n),
+		}
+	}
+
+	return int64(pBlock) * int64(jw.journal.BlockSize()), nil
+}
+
+// writeBlock writes the given journal block.
+func (jw *JournalWriter) writeBlock(n uint32, data []byte) (err error) {
+	offset, err := jw.blockOffset(n)
+	if err != nil {
+		return err
+	}
+
+	_, err = jw.rw.WriteAt(data, offset)
+	return err
+}
+
+// newBlock returns a block with a journal header.
+func (jw *JournalWriter) newBlock(blockType uint32, sequence uint32) []byte {
+	block := make([]byte, jw.journal.BlockSize())
+
+	binary.BigEndian.PutUint32(block[0:], JournalBlockHeaderMagicBytes)
+	binary.BigEndian.PutUint32(block[4:], blockType)
+	binary.BigEndian.PutUint32(block[8:], sequence)
+
+	return block
+}
+
+// setBlockTail sets the tail checksum of a descriptor or revoke block, if
+// there is one.
+func (jw *JournalWriter) setBlockTail(block []byte) {
+	jsb := jw.journal.jsb
+
+	if jsb.HasMetadataChecksums() == true {
+		binary.BigEndian.PutUint32(block[len(block)-JournalBlockTailSize:], jsb.blockTailChecksum(block))
+	}
+}
+
+// encodeBlockTag writes the given tag at the top of `data`, the inverse of
+// `parseBlockTag`. `n` is the number of bytes used.
+func (jsb *JournalSuperblock) encodeBlockTag(jbt JournalBlockTag, data []byte) (n int) {
+	if jsb.HasIncompatibleFeature(JsbFeatureIncompatCsumV3) == true {
+		// journal_block_tag3_t
+
+		binary.BigEndian.PutUint32(data[0:], jbt.TBlocknr)
+		binary.BigEndian.PutUint32(data[4:], uint32(jbt.TFlags))
+		binary.BigEndian.PutUint32(data[12:], jbt.TChecksum)
+
+		if jsb.HasIncompatibleFeature(JsbFeatureIncompat64bit) == true {
+			binary.BigEndian.PutUint32(data[8:], jbt.TBlocknrHigh)
+		}
+	} else {
+		// journal_block_tag_t
+
+		binary.BigEndian.PutUint32(data[0:], jbt.TBlocknr)
+		binary.BigEndian.PutUint16(data[4:], uint16(jbt.TChecksum))
+		binary.BigEndian.PutUint16(data[6:], jbt.TFlags)
+
+		if jsb.HasIncompatibleFeature(JsbFeatureIncompat64bit) == true {
+			binary.BigEndian.PutUint32(data[8:], jbt.TBlocknrHigh)
+		}
+	}
+
+	n = jsb.TagSize()
+
+	if (jbt.TFlags & JbtfSameUuidAsPrevious) == 0 {
+		copy(data[n:], jbt.Uuid[:])
+		n += len(jbt.Uuid)
+	}
+
+	return n
+}
+
+// revokeBlocks returns the revoke blocks for the given filesystem blocks.
+func (jw *JournalWriter) revokeBlocks(sequence uint32, revokes []uint64) (blocks [][]byte) {
+	jsb := jw.journal.jsb
+
+	recordSize := jsb.revokeRecordSize()
+	usableSize := jw.journal.BlockSize() - jsb.blockTailSize()
+
+	blocks = make([][]byte, 0)
+
+	var block []byte
+	offset := 0
+
+	for _, revoked := range revokes {
+		if block == nil {
+			block = jw.newBlock(BtBlockRevocationRecord, sequence)
+			offset = JournalRevokeHeaderSize
+		}
+
+		if recordSize == 8 {
+			binary.BigEndian.PutUint64(block[offset:], revoked)
+		} else {
+			binary.BigEndian.PutUint32(block[offset:], uint32(revoked))
+		}
+
+		offset += recordSize
+
+		if offset+recordSize > usableSize {
+			binary.BigEndian.PutUint32(block[JournalHeaderSize:], uint32(offset))
+			blocks = append(blocks, block)
+
+			block = nil
+		}
+	}
+
+	if block != nil {
+		binary.BigEndian.PutUint32(block[JournalHeaderSize:], uint32(offset))
+		blocks = append(blocks, block)
+	}
+
+	for _, block := range blocks {
+		jw.setBlockTail(block)
+	}
+
+	return blocks
+}
+
+// descriptorBlocks returns the descriptor blocks, each followed by the
+// (escaped) data blocks that it describes.
+func (jw *JournalWriter) descriptorBlocks(sequence uint32, loggedBlocks []LoggedBlock) (blocks [][]byte) {
+	jsb := jw.journal.jsb
+
+	tagSize := jsb.TagSize()
+	usableSize := jw.journal.BlockSize() - jsb.blockTailSize()
+
+	blocks = make([][]byte, 0)
+
+	for len(loggedBlocks) > 0 {
+		descriptor := jw.newBlock(BtDescriptor, sequence)
+		blocks = append(blocks, descriptor)
+
+		offset := JournalHeaderSize
+		lastTagOffset := 0
+
+		for len(loggedBlocks) > 0 {
+			// Only the first tag has the UUID after it.
+			size := tagSize
+			if lastTagOffset == 0 {
+				size += len(jsb.data.SUuid)
+			}
+
+			if offset+size > usableSize {
+				break
+			}
+
+			lb := loggedBlocks[0]
+			loggedBlocks = loggedBlocks[1:]
+
+			data := make([]byte, len(lb.Data))
+			copy(data, lb.Data)
+
+			jbt := JournalBlockTag{
+				TBlocknr:     uint32(lb.Block),
+				TBlocknrHigh: uint32(lb.Block >> 32),
+				Uuid:         jsb.data.SUuid,
+			}
+
+			if lastTagOffset != 0 {
+				jbt.TFlags |= JbtfSameUuidAsPrevious
+			}
+
+			if binary.BigEndian.Uint32(data) == JournalBlockHeaderMagicBytes {
+				binary.BigEndian.PutUint32(data, 0)
+				jbt.TFlags |= JbtfDataMatchesMagicBytes
+			}
+
+			if jsb.HasMetadataChecksums() == true {
+				jbt.TChecksum = jsb.dataBlockChecksum(sequence, data)
+			}
+
+			lastTagOffset = offset
+			offset += jsb.encodeBlockTag(jbt, descriptor[offset:])
+
+			blocks = append(blocks, data)
+		}
+
+		// The flags are in the same place in both kinds of tag, as far as
+		// their lower sixteen bits go.
+		flagsOffset := lastTagOffset + 6
+		flags := binary.BigEndian.Uint16(descriptor[flagsOffset:])
+		binary.BigEndian.PutUint16(descriptor[flagsOffset:], flags|JbtfLastTag)
+
+		jw.setBlockTail(descriptor)
+	}
+
+	return blocks
+}
+
+// commitBlock returns the commit block, with whatever checksums the journal
+// uses. `logged` are the descriptor and data blocks of the transaction.
+func (jw *JournalWriter) commitBlock(sequence uint32, logged [][]byte, commitTime time.Time) []byte {
+	jsb := jw.journal.jsb
+
+	block := jw.newBlock(BtBlockCommitRecord, sequence)
+
+	if jsb.HasCompatibleFeature(JsbFeatureCompatChecksum) == true {
+		checksums := newTransactionChecksums()
+		for _, data := range logged {
+			checksums.update(data)
+		}
+
+		block[JournalHeaderSize] = JccCrc32
+		block[JournalHeaderSize+1] = 4
+		binary.BigEndian.PutUint32(block[commitBlockChecksumOffset:], checksums.crc32)
+	}
+
+	binary.BigEndian.PutUint64(block[0x30:], uint64(commitTime.Unix()))
+	binary.BigEndian.PutUint32(block[0x38:], uint32(commitTime.Nanosecond()))
+
+	if jsb.HasMetadataChecksums() == true {
+		binary.BigEndian.PutUint32(block[commitBlockChecksumOffset:], jsb.commitBlockChecksum(block))
+	}
+
+	return block
+}
+
+// Commit appends a transaction that writes the given blocks and revokes the
+// given ones (so that earlier copies of them in the log aren't replayed), and
+// returns its sequence. Each block has to be a whole filesystem block.
+//
+// The revoke blocks and the descriptor and data blocks are written first,
+// then the commit block, and then the journal superblock if the log was
+// empty. `SbFeatureIncompatRecover` is set before any of it.
+func (jw *JournalWriter) Commit(loggedBlocks []LoggedBlock, revokes []uint64, commitTime time.Time) (sequence uint32, err error) {
+	jsb := jw.journal.jsb
+	blockSize := jw.journal.BlockSize()
+
+	for _, lb := range loggedBlocks {
+		if len(lb.Data) != blockSize {
+			return 0, fmt.Errorf("data for block (%d) is (%d) bytes but the block-size is (%d)", lb.Block, len(lb.Data), blockSize)
+		} else if lb.Block > 0xffffffff && jsb.HasIncompatibleFeature(JsbFeatureIncompat64bit) == false {
+			return 0, &ext4.ErrUnsupportedFeature{Feature: fmt.Sprintf("block (%d) in a journal without 64bit", lb.Block)}
+		}
+	}
+
+	for _, revoked := range revokes {
+		if revoked > 0xffffffff && jsb.HasIncompatibleFeature(JsbFeatureIncompat64bit) == false {
+			return 0, &ext4.ErrUnsupportedFeature{Feature: fmt.Sprintf("block (%d) in a journal without 64bit", revoked)}
+		}
+	}
+
+	sequence = jw.nextSequence
+
+	revokeBlocks := jw.revokeBlocks(sequence, revokes)
+	logged := jw.descriptorBlocks(sequence, loggedBlocks)
+	commit := jw.commitBlock(sequence, logged, commitTime)
+
+	count := uint32(len(revokeBlocks) + len(logged) + 1)
+	if count > jw.free {
+		return 0, fmt.Errorf("transaction needs (%d) blocks but only (%d) are free: %w", count, jw.free, ErrJournalFull)
+	}
+
+	err = setRecoverFlag(jw.rw, true)
+	if err != nil {
+		return 0, err
+	}
+
+	err = jw.sync()
+	if err != nil {
+		return 0, err
+	}
+
+	startBlock := jw.nextBlock
+	n := startBlock
+
+	for _, block := range append(revokeBlocks, logged...) {
+		err := jw.writeBlock(n, block)
+		if err != nil {
+			return 0, err
+		}
+
+		n = jw.journal.nextLogBlock(n)
+	}
+
+	err = jw.sync()
+	if err != nil {
+		return 0, err
+	}
+
+	err = jw.writeBlock(n, commit)
+	if err != nil {
+		return 0, err
+	}
+
+	err = jw.sync()
+	if err != nil {
+		return 0, err
+	}
+
+	if jw.empty == true {
+		offset, err := jw.blockOffset(0)
+		if err != nil {
+			return 0, err
+		}
+
+		err = updateJournalSuperblock(jw.rw, offset, startBlock, sequence, jsb.HasMetadataChecksums())
+		if err != nil {
+			return 0, err
+		}
+
+		err = jw.sync()
+		if err != nil {
+			return 0, err
+		}
+
+		jw.empty = false
+	}
+
+	jw.nextBlock = jw.journal.nextLogBlock(n)
+	jw.nextSequence++
+	jw.free -= count
+
+	return sequence, nil
+}
@@ -0,0 +1,328 @@
+package jbd2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"encoding/binary"
+
+	"github.com/dsoprea/go-ext4"
+	"github.com/dsoprea/go-logging"
+)
+
+// getTestLoggedBlocks returns `count` blocks, starting at `first`, each filled
+// with its offset from `first`. The second one starts with the journal magic,
+// so that it has to be escaped.
+func getTestLoggedBlocks(first uint64, count int) []LoggedBlock {
+	blocks := make([]LoggedBlock, count)
+
+	for i := range blocks {
+		blocks[i] = LoggedBlock{
+			Block: first + uint64(i),
+			Data:  bytes.Repeat([]byte{byte(i)}, 1024),
+		}
+	}
+
+	if count > 1 {
+		binary.BigEndian.PutUint32(blocks[1].Data, JournalBlockHeaderMagicBytes)
+	}
+
+	return blocks
+}
+
+// getTestBlock reads the given filesystem block.
+func getTestBlock(rw ReaderWriterAt, block uint64) []byte {
+	data := make([]byte, 1024)
+
+	err := ext4.ReadFullAt(rw, data, int64(block)*1024)
+	log.PanicIf(err)
+
+	return data
+}
+
+func TestJournalWriter_Commit(t *testing.T) {
+	filepath := path.Join(assetsPath, "journal.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	cow, err := ext4.NewCopyOnWriteWithReaderAt(f, 1024)
+	log.PanicIf(err)
+
+	jw, err := NewJournalWriter(cow)
+	log.PanicIf(err)
+
+	// The log is empty, so the first transaction is the one that the
+	// superblock says is next.
+
+	if jw.NextSequence() != 4 || jw.Free() != 1023 {
+		t.Fatalf("Writer not correct: SEQUENCE=(%d) FREE=(%d)", jw.NextSequence(), jw.Free())
+	}
+
+	loggedBlocks := getTestLoggedBlocks(4000, 3)
+	commitTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	sequence, err := jw.Commit(loggedBlocks, []uint64{3990}, commitTime)
+	log.PanicIf(err)
+
+	// A revoke block, a descriptor, three data blocks, and a commit block.
+
+	if sequence != 4 || jw.NextSequence() != 5 || jw.Free() != 1017 {
+		t.Fatalf("Writer not correct after commit: SEQUENCE=(%d) NEXT=(%d) FREE=(%d)", sequence, jw.NextSequence(), jw.Free())
+	}
+
+	sb, err := ext4.NewSuperblockWithReaderAt(cow)
+	log.PanicIf(err)
+
+	if sb.HasIncompatibleFeature(ext4.SbFeatureIncompatRecover) != true {
+		t.Fatalf("Filesystem should need recovery.")
+	}
+
+	j, err := NewJournalWithSuperblock(sb)
+	log.PanicIf(err)
+
+	if j.Superblock().Data().SStart != 1 || j.Superblock().Data().SSequence != 4 {
+		t.Fatalf("Journal superblock not correct: START=(%d) SEQUENCE=(%d)", j.Superblock().Data().SStart, j.Superblock().Data().SSequence)
+	}
+
+	transactions, err := getTestTransactions(j.Transactions())
+	log.PanicIf(err)
+
+	if len(transactions) != 1 {
+		t.Fatalf("Expected one transaction: (%d)", len(transactions))
+	}
+
+	transaction := transactions[0]
+
+	if transaction.String() != "Transaction<SEQ=(4) START=(1) BLOCKS=(6) DATA-BLOCKS=(3) REVOKES=(1) COMPLETE=[true]>" {
+		t.Fatalf("Transaction not correct: %s", transaction)
+	} else if transaction.CommitTime().Equal(commitTime) == false {
+		t.Fatalf("Commit time not correct: [%s]", transaction.CommitTime())
+	} else if transaction.Revokes.IsRevoked(3990, 3) != true {
+		t.Fatalf("Revoke not correct: %s", transaction.Revokes)
+	}
+
+	for i, dataBlock := range transaction.DataBlocks {
+		if dataBlock.Blocknr() != loggedBlocks[i].Block || bytes.Equal(dataBlock.Data, loggedBlocks[i].Data) == false {
+			t.Fatalf("Data block (%d) not correct.", i)
+		}
+	}
+
+	// Replay it.
+
+	ri, err := RecoverFilesystem(cow)
+	log.PanicIf(err)
+
+	if ri.Transactions != 1 || ri.Replayed != 3 {
+		t.Fatalf("Recovery not correct: %s", ri)
+	}
+
+	for _, lb := range loggedBlocks {
+		if bytes.Equal(getTestBlock(cow, lb.Block), lb.Data) == false {
+			t.Fatalf("Block (%d) not replayed.", lb.Block)
+		}
+	}
+}
+
+func TestJournalWriter_Commit_Append(t *testing.T) {
+	filepath := path.Join(assetsPath, "journal_csum.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	cow, err := ext4.NewCopyOnWriteWithReaderAt(f, 1024)
+	log.PanicIf(err)
+
+	original := getTestBlock(cow, 3003)
+
+	jw, err := NewJournalWriter(cow)
+	log.PanicIf(err)
+
+	// There are already three transactions, in blocks (1) through (10).
+
+	if jw.NextSequence() != 4 || jw.Free() != 1013 {
+		t.Fatalf("Writer not correct: SEQUENCE=(%d) FREE=(%d)", jw.NextSequence(), jw.Free())
+	}
+
+	// Enough blocks to need a second descriptor and enough revokes to need
+	// three revoke blocks. (3003) was written by the third transaction, which
+	// this one revokes.
+
+	loggedBlocks := getTestLoggedBlocks(3500, 100)
+
+	revokes := []uint64{3003}
+	for i := 0; i < 300; i++ {
+		revokes = append(revokes, uint64(3700+i))
+	}
+
+	_, err = jw.Commit(loggedBlocks, revokes, time.Now())
+	log.PanicIf(err)
+
+	j, err := NewJournalWithSuperblock(getTestSuperblock(cow))
+	log.PanicIf(err)
+
+	transactions, err := getTestTransactions(j.Transactions())
+	log.PanicIf(err)
+
+	if len(transactions) != 4 {
+		t.Fatalf("Expected four transactions: (%d)", len(transactions))
+	}
+
+	last := transactions[3]
+
+	if last.String() != "Transaction<SEQ=(4) START=(11) BLOCKS=(106) DATA-BLOCKS=(100) REVOKES=(301) COMPLETE=[true]>" {
+		t.Fatalf("Transaction not correct: %s", last)
+	}
+
+	for _, dataBlock := range last.DataBlocks {
+		if dataBlock.ChecksumValid != true {
+			t.Fatalf("Data block checksum not valid: %v", dataBlock.Tag)
+		}
+	}
+
+	ri, err := RecoverFilesystem(cow)
+	log.PanicIf(err)
+
+	if ri.Transactions != 4 {
+		t.Fatalf("Recovery not correct: %s", ri)
+	}
+
+	for _, lb := range loggedBlocks {
+		if bytes.Equal(getTestBlock(cow, lb.Block), lb.Data) == false {
+			t.Fatalf("Block (%d) not replayed.", lb.Block)
+		}
+	}
+
+	if bytes.Equal(getTestBlock(cow, 3003), original) == false {
+		t.Fatalf("Revoked block should not have been replayed.")
+	}
+}
+
+// getTestSuperblock opens the filesystem in `ra`.
+func getTestSuperblock(ra ReaderWriterAt) *ext4.Superblock {
+	sb, err := ext4.NewSuperblockWithReaderAt(ra)
+	log.PanicIf(err)
+
+	return sb
+}
+
+func TestJournalWriter_Commit_Full(t *testing.T) {
+	filepath := path.Join(assetsPath, "journal.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	cow, err := ext4.NewCopyOnWriteWithReaderAt(f, 1024)
+	log.PanicIf(err)
+
+	jw, err := NewJournalWriter(cow)
+	log.PanicIf(err)
+
+	_, err = jw.Commit(getTestLoggedBlocks(2000, 1020), nil, time.Now())
+	if errors.Is(err, ErrJournalFull) == false {
+		t.Fatalf("Expected ErrJournalFull: %v", err)
+	}
+
+	// Nothing was written.
+
+	if len(cow.ChangedBlocks()) != 0 {
+		t.Fatalf("Blocks were changed: %v", cow.ChangedBlocks())
+	}
+
+	_, err = jw.Commit([]LoggedBlock{{Block: 2000, Data: make([]byte, 10)}}, nil, time.Now())
+	if err == nil {
+		t.Fatalf("Expected an error for a partial block.")
+	}
+}
+
+func ExampleJournalWriter_Commit() {
+	filepath := path.Join(assetsPath, "journal.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	// Keep the changes in memory.
+	cow, err := ext4.NewCopyOnWriteWithReaderAt(f, 1024)
+	log.PanicIf(err)
+
+	jw, err := NewJournalWriter(cow)
+	log.PanicIf(err)
+
+	data := bytes.Repeat([]byte{0xaa}, 1024)
+
+	sequence, err := jw.Commit([]LoggedBlock{{Block: 4000, Data: data}}, nil, time.Now())
+	log.PanicIf(err)
+
+	// The transaction is replayed by the next recovery.
+
+	ri, err := RecoverFilesystem(cow)
+	log.PanicIf(err)
+
+	fmt.Printf("(%d) %s\n", sequence, ri)
+
+	// Output:
+	// (4) RecoveryInfo<START-SEQ=(4) END-SEQ=(5) TRANSACTIONS=(1) REPLAYED=(1) REVOKE-RECORDS=(0) REVOKED=(0) CHECKSUM-FAILURES=(0)>
+}
+
+func TestJournalSuperblock_encodeBlockTag(t *testing.T) {
+	features := []uint32{
+		0,
+		JsbFeatureIncompat64bit,
+		JsbFeatureIncompatCsumV2,
+		JsbFeatureIncompatCsumV2 | JsbFeatureIncompat64bit,
+		JsbFeatureIncompatCsumV3,
+		JsbFeatureIncompatCsumV3 | JsbFeatureIncompat64bit,
+	}
+
+	for _, incompat := range features {
+		jsb := &JournalSuperblock{
+			data: &JournalSuperblockData{
+				SHeader: JournalHeader{
+					HBlocktype: BtJournalSuperblockV2,
+				},
+				SFeatureIncompat: incompat,
+			},
+		}
+
+		expected := JournalBlockTag{
+			TBlocknr:  0x12345678,
+			TFlags:    JbtfDataMatchesMagicBytes,
+			TChecksum: 0xabcd,
+			Uuid:      [16]byte{1, 2, 3},
+		}
+
+		if jsb.HasIncompatibleFeature(JsbFeatureIncompat64bit) == true {
+			expected.TBlocknrHigh = 0x9
+		}
+
+		if jsb.HasMetadataChecksums() == false {
+			expected.TChecksum = 0
+		}
+
+		data := make([]byte, 64)
+		n := jsb.encodeBlockTag(expected, data)
+
+		actual, m, err := jsb.parseBlockTag(data)
+		log.PanicIf(err)
+
+		if n != m || n != jsb.TagSize()+16 {
+			t.Fatalf("Size not correct for features (%x): (%d) (%d)", incompat, n, m)
+		} else if reflect.DeepEqual(actual, expected) == false {
+			t.Fatalf("Tag not correct for features (%x): %v", incompat, actual)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package ext4
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+
+	"encoding/binary"
+	"hash/crc32"
+	"unicode/utf16"
+)
+
+const (
+	// MbrSectorSize is the sector-size that MBR addresses are in.
+	MbrSectorSize = 512
+
+	mbrSignatureOffset = 510
+	mbrEntriesOffset   = 446
+	mbrEntrySize       = 16
+
+	// mbrMaxLogicalPartitions is how far we follow the chain of extended
+	// boot-records before deciding that it loops.
+	mbrMaxLogicalPartitions = 128
+
+	// gptHeaderMinSize is the size of the GPT header as of UEFI 2.x. The rest of
+	// the block is reserved.
+	gptHeaderMinSize = 92
+
+	// gptMaxEntriesSize caps how big a partition-entry array we'll read. The
+	// spec minimum is 16K.
+	gptMaxEntriesSize = 1024 * 1024
+)
+
+const (
+	// MbrTypeLinux is the MBR partition type for Linux filesystems.
+	MbrTypeLinux = byte(0x83)
+
+	// MbrTypeProtective is the MBR partition type that covers the whole disk
+	// when it actually has a GPT.
+	MbrTypeProtective = byte(0xee)
+)
+
+var (
+	// mbrExtendedTypes are the MBR partition types that contain logical
+	// partitions.
+	mbrExtendedTypes = map[byte]struct{}{
+		0x05: {}, // DOS (CHS)
+		0x0f: {}, // Windows (LBA)
+		0x85: {}, // Linux
+	}
+
+	gptSignature = []byte("EFI PART")
+
+	// gptLinuxTypes are the GPT partition types that hold Linux filesystems:
+	// the generic one and those of the Discoverable Partitions Specification
+	// for the common mount points.
+	gptLinuxTypes = map[string]struct{}{
+		"0fc63daf-8483-4772-8e79-3d69d8477de4": {}, // Linux filesystem data
+		"44479540-f297-41b2-9af7-d131d5f0458a": {}, // root (x86)
+		"4f68bce3-e8cd-4db1-96e7-fbcaf984b709": {}, // root (x86-64)
+		"b921b045-1df0-41c3-af44-4c6f280d3fae": {}, // root (arm64)
+		"933ac7e1-2eb4-4f13-b844-0e14e2aef915": {}, // /home
+		"3b8f8425-20e0-4f3b-907f-1a25a76f98e8": {}, // /srv
+		"4d21b016-b534-45c2-a9fb-5c16e091fd2d": {}, // /var
+	}
+)
+
+// Partition is one partition of a disk image.
+type Partition struct {
+	// Number is what Linux numbers it (e.g. the "5" in "sda5"). MBR primary
+	// partitions are (1) through (4) according to their slot, and logical
+	// partitions start at (5). GPT partitions are numbered by their entry.
+	Number int
+
+	// Start and Size are in bytes.
+	Start int64
+	Size  int64
+
+	// MbrType is the MBR partition-type, or zero for GPT partitions.
+	MbrType byte
+
+	// GptType is the GPT partition-type GUID and GptName is the name of the
+	// partition. Both are empty for MBR partitions.
+	GptType string
+	GptName string
+}
+
+func (p Partition) String() string {
+	if p.GptType != "" {
+		return fmt.Sprintf("Partition<NUMBER=(%d) START=(%d) SIZE=(%d) TYPE=[%s] NAME=[%s]>", p.Number, p.Start, p.Size, p.GptType, p.GptName)
+	}
+
+	return fmt.Sprintf("Partition<NUMBER=(%d) START=(%d) SIZE=(%d) TYPE=[0x%02x]>", p.Number, p.Start, p.Size, p.MbrType)
+}
+
+// IsLinux indicates whether the partition-type is one for Linux filesystems.
+// Nothing enforces the type, so this is only a hint.
+func (p Partition) IsLinux() bool {
+	if p.GptType != "" {
+		_, found := gptLinuxTypes[p.GptType]
+		return found
+	}
+
+	return p.MbrType == MbrTypeLinux
+}
+
+// SectionReader returns a reader over just this partition of `ra`.
+func (p Partition) SectionReader(ra io.ReaderAt) *io.SectionReader {
+	return io.NewSectionReader(ra, p.Start, p.Size)
+}
+
+// ReadPartitions returns the partitions of a whole-disk image, from its GPT
+// if it has one and otherwise from its MBR (including the logical partitions
+// in extended partitions, though not the extended partitions themselves). It
+// returns `ErrNotFound` if there's no partition table at all (e.g. the image is
+// just a filesystem).
+func ReadPartitions(ra io.ReaderAt) (partitions []Partition, err error) {
+	sector := make([]byte, MbrSectorSize)
+
+	err = ReadFullAt(ra, sector, 0)
+	if err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("partition table: %w", ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+
+	entries, found := parseMbrSector(sector)
+	if found == false {
+		return nil, fmt.Errorf("partition table: %w", ErrNotFound)
+	}
+
+	for _, entry := range entries {
+		if entry.partitionType == MbrTypeProtective {
+			return readGpt(ra, entry)
+		}
+	}
+
+	return readMbr(ra, entries)
+}
+
+// NewSuperblockWithPartition opens the ext4 filesystem in the given partition
+// (by `Number`) of a whole-disk image. The `Superblock` reads through an
+// `io.SectionReader` over the partition, so everything built on it works like
+// it would on an image of just that partition.
+func NewSuperblockWithPartition(ra io.ReaderAt, number int) (sb *Superblock, err error) {
+	partitions, err := ReadPartitions(ra)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range partitions {
+		if p.Number == number {
+			return NewSuperblockWithReaderAt(p.SectionReader(ra))
+		}
+	}
+
+	return nil, fmt.Errorf("partition (%d): %w", number, ErrNotFound)
+}
+
+// Open opens the ext4 filesystem in an image: the partition with the given
+// `Number` (see `ReadPartitions`) of a whole-disk image, or, if `number` is
+// zero, the image itself.
+func Open(ra io.ReaderAt, number int) (sb *Superblock, err error) {
+	if number == 0 {
+		return NewSuperblockWithReaderAt(ra)
+	}
+
+	return NewSuperblockWithPartition(ra, number)
+}
+
+// mbrEntry is one of the four entries of an MBR (or extended boot-record).
+type mbrEntry struct {
+	status        byte
+	partitionType byte
+	lba           uint32
+	sectors       uint32
+}
+
+// parseMbrSector returns the entries of an MBR or extended boot-record. It
+// returns false if the sector doesn't look like one: it has to have the
+// signature, and (since other boot-sectors have the signature too) every
+// status has to be either bootable or not.
+func parseMbrSector(sector []byte) (entries []mbrEntry, found bool) {
+	if sector[mbrSignatureOffset] != 0x55 || sector[mbrSignatureOffset+1] != 0xaa {
+		return nil, false
+	}
+
+	entries = make([]mbrEntry, 4)
+
+	for i := range entries {
+		raw := sector[mbrEntriesOffset+i*mbrEntrySize:]
+
+		entries[i] = mbrEntry{
+			status:        raw[0],
+			partitionType: raw[4],
+			lba:           binary.LittleEndian.Uint32(raw[8:]),
+			sectors:       binary.LittleEndian.Uint32(raw[12:]),
+		}
+
+		if entries[i].status != 0x00 && entries[i].status != 0x80 {
+			return nil, false
+		}
+	}
+
+	return entries, true
+}
+
+// isUsed indicates whether the entry describes anything.
+func (entry mbrEntry) isUsed() bool {
+	return entry.partitionType != 0 && entry.sectors != 0
+}
+
+// isExtended indicates whether the entry is an extended partition.
+func (entry mbrEntry) isExtended() bool {
+	_, found := mbrExtendedTypes[entry.partitionType]
+	return found
+}
+
+// readMbr returns the primary partitions of the MBR and the logical partitions
+// of any extended partitions.
+func readMbr(ra io.ReaderAt, entries []mbrEntry) (partitions []Partition, err error) {
+	partitions = make([]Partition, 0)
+	extended := make([]mbrEntry, 0)
+
+	for i, entry := range entries {
+		if entry.isUsed() == false {
+			continue
+		} else if entry.isExtended() == true {
+			extended = append(extended, entry)
+			continue
+		}
+
+		// MBR addresses are 32-bit sector numbers, so the offsets in bytes
+		// can't overflow (and the same goes for logical partitions, which add
+		// two of them).
+		p := Partition{
+			Number:  i + 1,
+			Start:   int64(entry.lba) * MbrSectorSize,
+			Size:    int64(entry.sectors) * MbrSectorSize,
+			MbrType: entry.partitionType,
+		}
+
+		partitions = append(partitions, p)
+	}
+
+	number := 5
+
+	for _, entry := range extended {
+		logical, err := readMbrLogical(ra, entry, number)
+		if err != nil {
+			return nil, err
+		}
+
+		partitions = append(partitions, logical...)
+		number += len(logical)
+	}
+
+	return partitions, nil
+}
+
+// readMbrLogical follows the chain of extended boot-records in an extended
+// partition. Each has the logical partition (relative to itself) and the next
+// extended boot-record (relative to the extended partition).
+func readMbrLogical(ra io.ReaderAt, extended mbrEntry, number int) (partitions []Partition, err error) {
+	partitions = make([]Partition, 0)
+	sector := make([]byte, MbrSectorSize)
+
+	lba := uint64(extended.lba)
+
+	for i := 0; ; i++ {
+		if i >= mbrMaxLogicalPartitions {
+			return nil, newErrCorrupt("extended boot-record", lba, "chain doesn't end after (%d) records", i)
+		}
+
+		err := ReadFullAt(ra, sector, int64(lba)*MbrSectorSize)
+		if err != nil {
+			return nil, err
+		}
+
+		entries, found := parseMbrSector(sector)
+		if found == false {
+			return nil, newErrCorrupt("extended boot-record", lba, "not valid")
+		}
+
+		if entries[0].isUsed() == true && entries[0].isExtended() == false {
+			p := Partition{
+				Number:  number,
+				Start:   int64(lba+uint64(entries[0].lba)) * MbrSectorSize,
+				Size:    int64(entries[0].sectors) * MbrSectorSize,
+				MbrType: entries[0].partitionType,
+			}
+
+			partitions = append(partitions, p)
+			number++
+		}
+
+		if entries[1].isUsed() == false || entries[1].isExtended() == false {
+			break
+		}
+
+		lba = uint64(extended.lba) + uint64(entries[1].lba)
+	}
+
+	return partitions, nil
+}
+
+// gptHeader is the part of the GPT header that we use.
+type gptHeader struct {
+	// firstUsableLba and lastUsableLba are the sectors that partitions can
+	// be in.
+	firstUsableLba uint64
+	lastUsableLba  uint64
+
+	entriesLba uint64
+	entryCount uint32
+	entrySize  uint32
+	entriesCrc uint32
+}
+
+// readGpt returns the partitions of the GPT. The sector-size isn't recorded
+// anywhere, so we look for the header where it would be for 512-byte and then
+// 4K sectors. If the primary header is damaged, we use the backup at the end
+// of the disk (which the protective MBR tells us).
+func readGpt(ra io.ReaderAt, protective mbrEntry) (partitions []Partition, err error) {
+	var primaryErr error
+
+	for _, sectorSize := range []int64{512, 4096} {
+		header, err := readGptHeader(ra, sectorSize, 1)
+		if err == nil {
+			return readGptEntries(ra, sectorSize, header)
+		}
+
+		if primaryErr == nil {
+			primaryErr = err
+		}
+
+		if protective.sectors == 0xffffffff {
+			continue
+		}
+
+		lastLba := uint64(protective.lba) + uint64(protective.sectors) - 1
+
+		header, err = readGptHeader(ra, sectorSize, lastLba)
+		if err == nil {
+			return readGptEntries(ra, sectorSize, header)
+		}
+	}
+
+	return nil, primaryErr
+}
+
+// readGptHeader reads and checks the GPT header at the given LBA.
+func readGptHeader(ra io.ReaderAt, sectorSize int64, lba uint64) (header gptHeader, err error) {
+	raw := make([]byte, sectorSize)
+
+	err = ReadFullAt(ra, raw, int64(lba)*sectorSize)
+	if err != nil {
+		return header, err
+	}
+
+	if bytes.Equal(raw[:8], gptSignature) == false {
+		return header, newErrCorrupt("GPT header", lba, "signature not found")
+	}
+
+	headerSize := binary.LittleEndian.Uint32(raw[12:])
+	if headerSize < gptHeaderMinSize || int64(headerSize) > sectorSize {
+		return header, newErrCorrupt("GPT header", lba, "header-size not valid: (%d)", headerSize)
+	}
+
+	// The checksum is calculated with its own field zeroed.
+	expectedCrc := binary.LittleEndian.Uint32(raw[16:])
+	binary.LittleEndian.PutUint32(raw[16:], 0)
+
+	actualCrc := crc32.ChecksumIEEE(raw[:headerSize])
+	if actualCrc != expectedCrc {
+		return header, newErrCorrupt("GPT header", lba, "checksum (0x%08x) doesn't match (0x%08x)", actualCrc, expectedCrc)
+	}
+
+	if myLba := binary.LittleEndian.Uint64(raw[24:]); myLba != lba {
+		return header, newErrCorrupt("GPT header", lba, "header says that it's at LBA (%d)", myLba)
+	}
+
+	header = gptHeader{
+		firstUsableLba: binary.LittleEndian.Uint64(raw[40:]),
+		lastUsableLba:  binary.LittleEndian.Uint64(raw[48:]),
+		entriesLba:     binary.LittleEndian.Uint64(raw[72:]),
+		entryCount:     binary.LittleEndian.Uint32(raw[80:]),
+		entrySize:      binary.LittleEndian.Uint32(raw[84:]),
+		entriesCrc:     binary.LittleEndian.Uint32(raw[88:]),
+	}
+
+	// Every partition has to be within the usable sectors, so keeping them
+	// addressable in bytes keeps the partitions so, too.
+	if header.firstUsableLba > header.lastUsableLba || header.lastUsableLba >= uint64(math.MaxInt64/sectorSize) {
+		return header, newErrCorrupt("GPT header", lba, "usable LBAs not valid: (%d)-(%d)", header.firstUsableLba, header.lastUsableLba)
+	}
+
+	if header.entrySize < 128 || header.entrySize%128 != 0 {
+		return header, newErrCorrupt("GPT header", lba, "entry-size not valid: (%d)", header.entrySize)
+	} else if uint64(header.entryCount)*uint64(header.entrySize) > gptMaxEntriesSize {
+		return header, newErrCorrupt("GPT header", lba, "too many entries: (%d) of (%d) bytes", header.entryCount, header.entrySize)
+	}
+
+	return header, nil
+}
+
+// readGptEntries reads and checks the partition-entry array, and returns the
+// partitions that are used.
+func readGptEntries(ra io.ReaderAt, sectorSize int64, header gptHeader) (partitions []Partition, err error) {
+	raw := make([]byte, uint64(header.entryCount)*uint64(header.entrySize))
+
+	err = ReadFullAt(ra, raw, int64(header.entriesLba)*sectorSize)
+	if err != nil {
+		return nil, err
+	}
+
+	actualCrc := crc32.ChecksumIEEE(raw)
+	if actualCrc != header.entriesCrc {
+		return nil, newErrCorrupt("GPT partition entries", header.entriesLba, "checksum (0x%08x) doesn't match (0x%08x)", actualCrc, header.entriesCrc)
+	}
+
+	partitions = make([]Partition, 0)
+	unused := make([]byte, 16)
+
+	for i := uint32(0); i < header.entryCount; i++ {
+		entry := raw[uint64(i)*uint64(header.entrySize):]
+
+		if bytes.Equal(entry[:16], unused) == true {
+			continue
+		}
+
+		firstLba := binary.LittleEndian.Uint64(entry[32:])
+		lastLba := binary.LittleEndian.Uint64(entry[40:])
+
+		if lastLba < firstLba {
+			return nil, newErrCorrupt("GPT partition entries", header.entriesLba, "entry (%d) ends before it starts: (%d) < (%d)", i, lastLba, firstLba)
+		} else if firstLba < header.firstUsableLba || lastLba > header.lastUsableLba {
+			return nil, newErrCorrupt("GPT partition entries", header.entriesLba, "entry (%d) at LBAs (%d)-(%d) isn't within the usable LBAs (%d)-(%d)", i, firstLba, lastLba, header.firstUsableLba, header.lastUsableLba)
+		}
+
+		p := Partition{
+			Number:  int(i) + 1,
+			Start:   int64(firstLba) * sectorSize,
+			Size:    int64(lastLba-firstLba+1) * sectorSize,
+			GptType: formatGuid(entry[:16]),
+			GptName: decodeGptName(entry[56:128]),
+		}
+
+		partitions = append(partitions, p)
+	}
+
+	return partitions, nil
+}
+
+// formatGuid formats a GUID as it's written in the usual notation. The first
+// three fields are stored little-endian.
+func formatGuid(raw []byte) string {
+	return fmt.Sprintf(
+		"%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(raw[0:]),
+		binary.LittleEndian.Uint16(raw[4:]),
+		binary.LittleEndian.Uint16(raw[6:]),
+		raw[8:10],
+		raw[10:16])
+}
+
+// decodeGptName decodes the UTF-16LE name of a GPT partition, which is
+// NUL-terminated if it's shorter than the field.
+func decodeGptName(raw []byte) string {
+	units := make([]uint16, len(raw)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(raw[i*2:])
+	}
+
+	for i, unit := range units {
+		if unit == 0 {
+			units = units[:i]
+			break
+		}
+	}
+
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,432 @@
+package ext4
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io/ioutil"
+	"unicode/utf16"
+
+	"github.com/dsoprea/go-logging"
+)
+
+// getTestPartitionImages returns the tiny (1M) and bitmaps (2M) test images.
+func getTestPartitionImages() (tiny, bitmaps []byte) {
+	tiny, err := ioutil.ReadFile(path.Join(assetsPath, "tiny.ext4"))
+	log.PanicIf(err)
+
+	bitmaps, err = ioutil.ReadFile(path.Join(assetsPath, "bitmaps.ext4"))
+	log.PanicIf(err)
+
+	return tiny, bitmaps
+}
+
+// putTestMbrEntry writes one entry of an MBR or extended boot-record.
+func putTestMbrEntry(sector []byte, i int, partitionType byte, lba, sectors uint32) {
+	raw := sector[mbrEntriesOffset+i*mbrEntrySize:]
+
+	raw[4] = partitionType
+	binary.LittleEndian.PutUint32(raw[8:], lba)
+	binary.LittleEndian.PutUint32(raw[12:], sectors)
+
+	sector[mbrSignatureOffset] = 0x55
+	sector[mbrSignatureOffset+1] = 0xaa
+}
+
+// getTestMbrDisk returns a disk with an MBR. Partition (1) has the tiny image,
+// (4) is FAT, and the extended partition in slot (2) has two logical
+// partitions: (5) has the bitmaps image and (6) has the tiny image again.
+func getTestMbrDisk() []byte {
+	tiny, bitmaps := getTestPartitionImages()
+
+	// Extended partition: EBR, bitmaps, EBR, tiny.
+	extendedLba := uint32(2056)
+	secondEbr := uint32(1 + 4096)
+	extendedSectors := secondEbr + 1 + 2048
+
+	disk := make([]byte, (extendedLba+extendedSectors)*MbrSectorSize)
+
+	putTestMbrEntry(disk, 0, MbrTypeLinux, 8, 2048)
+	putTestMbrEntry(disk, 1, 0x05, extendedLba, extendedSectors)
+	putTestMbrEntry(disk, 3, 0x0c, 1, 7)
+	copy(disk[8*MbrSectorSize:], tiny)
+
+	ebr := disk[extendedLba*MbrSectorSize:]
+	putTestMbrEntry(ebr, 0, MbrTypeLinux, 1, 4096)
+	putTestMbrEntry(ebr, 1, 0x05, secondEbr, 1+2048)
+	copy(ebr[MbrSectorSize:], bitmaps)
+
+	ebr = disk[(extendedLba+secondEbr)*MbrSectorSize:]
+	putTestMbrEntry(ebr, 0, MbrTypeLinux, 1, 2048)
+	copy(ebr[MbrSectorSize:], tiny)
+
+	return disk
+}
+
+// encodeTestGuid encodes a GUID the way it's stored, with the first three
+// fields little-endian.
+func encodeTestGuid(guid string) []byte {
+	raw, err := hex.DecodeString(strings.Replace(guid, "-", "", -1))
+	log.PanicIf(err)
+
+	binary.LittleEndian.PutUint32(raw[0:], binary.BigEndian.Uint32(raw[0:]))
+	binary.LittleEndian.PutUint16(raw[4:], binary.BigEndian.Uint16(raw[4:]))
+	binary.LittleEndian.PutUint16(raw[6:], binary.BigEndian.Uint16(raw[6:]))
+
+	return raw
+}
+
+// putTestGptHeader writes a GPT header at the given LBA.
+func putTestGptHeader(disk []byte, sectorSize int64, lba, alternateLba, firstUsableLba, lastUsableLba, entriesLba uint64, entriesCrc uint32) {
+	raw := disk[int64(lba)*sectorSize:]
+
+	copy(raw, gptSignature)
+	binary.LittleEndian.PutUint32(raw[8:], 0x00010000)
+	binary.LittleEndian.PutUint32(raw[12:], gptHeaderMinSize)
+	binary.LittleEndian.PutUint64(raw[24:], lba)
+	binary.LittleEndian.PutUint64(raw[32:], alternateLba)
+	binary.LittleEndian.PutUint64(raw[40:], firstUsableLba)
+	binary.LittleEndian.PutUint64(raw[48:], lastUsableLba)
+	binary.LittleEndian.PutUint64(raw[72:], entriesLba)
+	binary.LittleEndian.PutUint32(raw[80:], 128)
+	binary.LittleEndian.PutUint32(raw[84:], 128)
+	binary.LittleEndian.PutUint32(raw[88:], entriesCrc)
+
+	binary.LittleEndian.PutUint32(raw[16:], 0)
+	binary.LittleEndian.PutUint32(raw[16:], crc32.ChecksumIEEE(raw[:gptHeaderMinSize]))
+}
+
+// getTestGptDisk returns a disk with a GPT (and its backup) and the given
+// sector-size. Partition (1) has the tiny image, (2) has the bitmaps image,
+// and (4) is an (empty) EFI system partition.
+func getTestGptDisk(sectorSize int64) []byte {
+	tiny, bitmaps := getTestPartitionImages()
+
+	mib := uint64(1024*1024) / uint64(sectorSize)
+	lastLba := 5*mib - 1
+
+	disk := make([]byte, (lastLba+1)*uint64(sectorSize))
+
+	// The protective MBR.
+	putTestMbrEntry(disk, 0, MbrTypeProtective, 1, uint32(lastLba))
+
+	entries := make([]byte, 128*128)
+
+	putEntry := func(i int, guid string, firstLba, lastLba uint64, name string) {
+		entry := entries[i*128:]
+
+		copy(entry[0:], encodeTestGuid(guid))
+		copy(entry[16:], encodeTestGuid("01234567-89ab-cdef-0123-456789abcdef"))
+		binary.LittleEndian.PutUint64(entry[32:], firstLba)
+		binary.LittleEndian.PutUint64(entry[40:], lastLba)
+
+		for j, unit := range utf16.Encode([]rune(name)) {
+			binary.LittleEndian.PutUint16(entry[56+j*2:], unit)
+		}
+	}
+
+	putEntry(0, "0fc63daf-8483-4772-8e79-3d69d8477de4", mib, 2*mib-1, "tiny")
+	putEntry(1, "933ac7e1-2eb4-4f13-b844-0e14e2aef915", 2*mib, 4*mib-1, "home")
+	putEntry(3, "c12a7328-f81f-11d2-ba4b-00a0c93ec93b", 4*mib, 4*mib, "EFI")
+
+	copy(disk[mib*uint64(sectorSize):], tiny)
+	copy(disk[2*mib*uint64(sectorSize):], bitmaps)
+
+	entriesCrc := crc32.ChecksumIEEE(entries)
+	entriesSectors := uint64(len(entries)) / uint64(sectorSize)
+
+	firstUsableLba := 2 + entriesSectors
+	lastUsableLba := lastLba - entriesSectors - 1
+
+	copy(disk[2*sectorSize:], entries)
+	putTestGptHeader(disk, sectorSize, 1, lastLba, firstUsableLba, lastUsableLba, 2, entriesCrc)
+
+	copy(disk[(lastLba-entriesSectors)*uint64(sectorSize):], entries)
+	putTestGptHeader(disk, sectorSize, lastLba, 1, firstUsableLba, lastUsableLba, lastLba-entriesSectors, entriesCrc)
+
+	return disk
+}
+
+func partitionStrings(partitions []Partition) []string {
+	actual := make([]string, len(partitions))
+	for i, p := range partitions {
+		actual[i] = p.String()
+	}
+
+	return actual
+}
+
+func TestReadPartitions_Mbr(t *testing.T) {
+	disk := getTestMbrDisk()
+
+	partitions, err := ReadPartitions(bytes.NewReader(disk))
+	log.PanicIf(err)
+
+	expected := []string{
+		"Partition<NUMBER=(1) START=(4096) SIZE=(1048576) TYPE=[0x83]>",
+		"Partition<NUMBER=(4) START=(512) SIZE=(3584) TYPE=[0x0c]>",
+		"Partition<NUMBER=(5) START=(1053184) SIZE=(2097152) TYPE=[0x83]>",
+		"Partition<NUMBER=(6) START=(3150848) SIZE=(1048576) TYPE=[0x83]>",
+	}
+
+	actual := partitionStrings(partitions)
+	if reflect.DeepEqual(actual, expected) == false {
+		for _, s := range actual {
+			fmt.Println(s)
+		}
+
+		t.Fatalf("Partitions not correct.")
+	}
+
+	linux := make([]int, 0)
+	for _, p := range partitions {
+		if p.IsLinux() == true {
+			linux = append(linux, p.Number)
+		}
+	}
+
+	if reflect.DeepEqual(linux, []int{1, 5, 6}) == false {
+		t.Fatalf("Linux partitions not correct: %v", linux)
+	}
+}
+
+func TestReadPartitions_Mbr_Loop(t *testing.T) {
+	disk := getTestMbrDisk()
+
+	// Point the second EBR back at itself.
+	ebr := disk[(2056+4097)*MbrSectorSize:]
+	putTestMbrEntry(ebr, 1, 0x05, 4097, 1+2048)
+
+	_, err := ReadPartitions(bytes.NewReader(disk))
+
+	var ec *ErrCorrupt
+	if errors.As(err, &ec) == false {
+		t.Fatalf("Expected ErrCorrupt: %v", err)
+	} else if ec.Structure != "extended boot-record" {
+		t.Fatalf("Structure not correct: [%s]", ec.Structure)
+	}
+}
+
+func TestReadPartitions_Gpt(t *testing.T) {
+	for _, sectorSize := range []int64{512, 4096} {
+		disk := getTestGptDisk(sectorSize)
+
+		partitions, err := ReadPartitions(bytes.NewReader(disk))
+		log.PanicIf(err)
+
+		expected := []string{
+			"Partition<NUMBER=(1) START=(1048576) SIZE=(1048576) TYPE=[0fc63daf-8483-4772-8e79-3d69d8477de4] NAME=[tiny]>",
+			"Partition<NUMBER=(2) START=(2097152) SIZE=(2097152) TYPE=[933ac7e1-2eb4-4f13-b844-0e14e2aef915] NAME=[home]>",
+			fmt.Sprintf("Partition<NUMBER=(4) START=(4194304) SIZE=(%d) TYPE=[c12a7328-f81f-11d2-ba4b-00a0c93ec93b] NAME=[EFI]>", sectorSize),
+		}
+
+		actual := partitionStrings(partitions)
+		if reflect.DeepEqual(actual, expected) == false {
+			for _, s := range actual {
+				fmt.Println(s)
+			}
+
+			t.Fatalf("Partitions not correct for sector-size (%d).", sectorSize)
+		}
+
+		if partitions[0].IsLinux() != true || partitions[1].IsLinux() != true || partitions[2].IsLinux() != false {
+			t.Fatalf("Linux partitions not correct for sector-size (%d).", sectorSize)
+		}
+	}
+}
+
+func TestReadPartitions_Gpt_Backup(t *testing.T) {
+	disk := getTestGptDisk(512)
+
+	// Damage the primary header. The backup is still good.
+	disk[512+0x30] ^= 0xff
+
+	partitions, err := ReadPartitions(bytes.NewReader(disk))
+	log.PanicIf(err)
+
+	if len(partitions) != 3 || partitions[1].Start != 2097152 {
+		t.Fatalf("Partitions not correct: %v", partitions)
+	}
+
+	// Damage the backup too.
+	disk[len(disk)-512+0x30] ^= 0xff
+
+	_, err = ReadPartitions(bytes.NewReader(disk))
+
+	var ec *ErrCorrupt
+	if errors.As(err, &ec) == false {
+		t.Fatalf("Expected ErrCorrupt: %v", err)
+	} else if ec.Structure != "GPT header" || ec.Block != 1 {
+		t.Fatalf("Error not correct: %v", err)
+	}
+}
+
+func TestReadPartitions_Gpt_EntriesCorrupt(t *testing.T) {
+	disk := getTestGptDisk(512)
+
+	disk[2*512+40] ^= 0xff
+
+	_, err := ReadPartitions(bytes.NewReader(disk))
+
+	var ec *ErrCorrupt
+	if errors.As(err, &ec) == false {
+		t.Fatalf("Expected ErrCorrupt: %v", err)
+	} else if ec.Structure != "GPT partition entries" {
+		t.Fatalf("Structure not correct: [%s]", ec.Structure)
+	}
+}
+
+func TestReadPartitions_Gpt_EntryOutOfRange(t *testing.T) {
+	disk := getTestGptDisk(512)
+
+	// An entry that's past the usable sectors (and whose size in bytes would
+	// overflow), with the checksums fixed up so that only the range is wrong.
+
+	entries := disk[2*512 : 2*512+128*128]
+	binary.LittleEndian.PutUint64(entries[40:], 1<<62)
+
+	entriesCrc := crc32.ChecksumIEEE(entries)
+
+	lastLba := uint64(len(disk)/512 - 1)
+	putTestGptHeader(disk, 512, 1, lastLba, 34, lastLba-33, 2, entriesCrc)
+
+	_, err := ReadPartitions(bytes.NewReader(disk))
+
+	var ec *ErrCorrupt
+	if errors.As(err, &ec) == false {
+		t.Fatalf("Expected ErrCorrupt: %v", err)
+	} else if ec.Structure != "GPT partition entries" || strings.Contains(ec.Reason, "usable") == false {
+		t.Fatalf("Error not correct: %v", err)
+	}
+}
+
+func TestReadPartitions_NotPartitioned(t *testing.T) {
+	tiny, _ := getTestPartitionImages()
+
+	_, err := ReadPartitions(bytes.NewReader(tiny))
+	if errors.Is(err, ErrNotFound) == false {
+		t.Fatalf("Expected ErrNotFound: %v", err)
+	}
+}
+
+func TestNewSuperblockWithPartition(t *testing.T) {
+	disk := getTestMbrDisk()
+	r := bytes.NewReader(disk)
+
+	sb, err := NewSuperblockWithPartition(r, 5)
+	log.PanicIf(err)
+
+	fsm, err := NewFreeSpaceMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	if fsm.FreeBlocks() != 1123 {
+		t.Fatalf("Free blocks not correct: (%d)", fsm.FreeBlocks())
+	}
+
+	sb, err = NewSuperblockWithPartition(r, 6)
+	log.PanicIf(err)
+
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bgd, err := bgdl.GetWithAbsoluteInode(TestFileInodeNumber)
+	log.PanicIf(err)
+
+	inode, err := NewInodeWithBlockGroupDescriptor(bgd, TestFileInodeNumber)
+	log.PanicIf(err)
+
+	if inode.Size() != 849597 {
+		t.Fatalf("Inode not read correctly: (%d)", inode.Size())
+	}
+
+	_, err = NewSuperblockWithPartition(r, 4)
+	if errors.Is(err, ErrNotExt4) == false {
+		t.Fatalf("Expected ErrNotExt4: %v", err)
+	}
+
+	// Slot (3) is empty and (2) is the extended partition.
+
+	for _, number := range []int{2, 3} {
+		_, err = NewSuperblockWithPartition(r, number)
+		if errors.Is(err, ErrNotFound) == false {
+			t.Fatalf("Expected ErrNotFound for partition (%d): %v", number, err)
+		}
+	}
+}
+
+func TestNewSuperblockWithPartition_Gpt(t *testing.T) {
+	disk := getTestGptDisk(4096)
+
+	sb, err := NewSuperblockWithPartition(bytes.NewReader(disk), 2)
+	log.PanicIf(err)
+
+	if sb.BlockCount() != 2048 {
+		t.Fatalf("Wrong filesystem: (%d) blocks", sb.BlockCount())
+	}
+
+	fsm, err := NewFreeSpaceMapWithSuperblock(sb)
+	log.PanicIf(err)
+
+	if fsm.FreeBlocks() != 1123 {
+		t.Fatalf("Free blocks not correct: (%d)", fsm.FreeBlocks())
+	}
+}
+
+func TestOpen(t *testing.T) {
+	disk := getTestMbrDisk()
+
+	sb, err := Open(bytes.NewReader(disk), 5)
+	log.PanicIf(err)
+
+	if sb.BlockCount() != 2048 {
+		t.Fatalf("Wrong filesystem: (%d) blocks", sb.BlockCount())
+	}
+
+	// Zero is for an image that's just the filesystem.
+
+	tiny, _ := getTestPartitionImages()
+
+	sb, err = Open(bytes.NewReader(tiny), 0)
+	log.PanicIf(err)
+
+	if sb.BlockCount() != 1024 {
+		t.Fatalf("Wrong filesystem: (%d) blocks", sb.BlockCount())
+	}
+
+	_, err = Open(bytes.NewReader(tiny), 1)
+	if errors.Is(err, ErrNotFound) == false {
+		t.Fatalf("Expected ErrNotFound: %v", err)
+	}
+}
+
+func ExampleReadPartitions() {
+	disk := getTestMbrDisk()
+	r := bytes.NewReader(disk)
+
+	partitions, err := ReadPartitions(r)
+	log.PanicIf(err)
+
+	for _, p := range partitions {
+		if p.IsLinux() == false {
+			continue
+		}
+
+		sb, err := NewSuperblockWithReaderAt(p.SectionReader(r))
+		log.PanicIf(err)
+
+		fmt.Printf("(%d): %d blocks\n", p.Number, sb.BlockCount())
+	}
+
+	// Output:
+	// (1): 1024 blocks
+	// (5): 2048 blocks
+	// (6): 1024 blocks
+}
@@ -0,0 +1,290 @@
+package ext4
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"encoding/binary"
+)
+
+const (
+	// DefaultProbeAlignment is the alignment of superblocks that
+	// `ProbeSuperblocks` uses if none is given: a sector. Filesystems start on
+	// a sector boundary, and every copy of the superblock is at a multiple of
+	// the block-size from there, so this finds them all wherever the
+	// filesystem is.
+	DefaultProbeAlignment = int64(512)
+
+	// probeChunkSize is how much `ProbeSuperblocks` reads at a time.
+	probeChunkSize = int64(1024 * 1024)
+
+	// superblockMagicOffset is where `SMagic` is in the superblock.
+	superblockMagicOffset = 0x38
+
+	// superblockUuidOffset is where `SUuid` is in the superblock.
+	superblockUuidOffset = 0x68
+)
+
+// ProbeResult is a filesystem that `ProbeSuperblocks` found.
+type ProbeResult struct {
+	// Offset is where the filesystem starts in the blob (where its block (0)
+	// is).
+	Offset int64
+
+	// SuperblockOffset is where, in the blob, the superblock that it was found
+	// with is.
+	SuperblockOffset int64
+
+	// Superblock is the superblock that it was found with. It reads through
+	// an `io.SectionReader` that starts at `Offset`, so it can be used like
+	// any other.
+	Superblock *Superblock
+
+	// Backup is true if the primary superblock didn't check out, so
+	// `Superblock` is a backup.
+	Backup bool
+
+	// Truncated is true if the filesystem runs past the end of the blob.
+	Truncated bool
+}
+
+func (pr ProbeResult) String() string {
+	return fmt.Sprintf("ProbeResult<OFFSET=(%d) SUPERBLOCK-OFFSET=(%d) BLOCK-SIZE=(%d) BLOCKS=(%d) BACKUP=[%v] TRUNCATED=[%v]>", pr.Offset, pr.SuperblockOffset, pr.Superblock.BlockSize(), pr.Superblock.BlockCount(), pr.Backup, pr.Truncated)
+}
+
+// ProbeSuperblocks scans the first `size` bytes of `ra` (e.g. a disk or memory
+// dump, or carved data) for ext4 filesystems. It looks for the superblock
+// magic at every multiple of `alignment` (`DefaultProbeAlignment` if zero), and
+// then checks each candidate's checksum (if it has one) and geometry (see
+// `probeCheck`) since the magic alone is only two bytes.
+//
+// Backups are recognized by the group number that they record, so a filesystem
+// whose primary superblock is damaged is still found, at the right offset.
+// Older versions of mke2fs left that at zero, in which case a backup is only
+// recognized if the damaged primary still has the magic and the same UUID
+// (see `probeBackupGroup`); otherwise it's taken for a primary.
+// Each filesystem is returned once, with the first superblock that checked
+// out, in order of offset.
+func ProbeSuperblocks(ra io.ReaderAt, size int64, alignment int64) (results []ProbeResult, err error) {
+	if alignment <= 0 {
+		alignment = DefaultProbeAlignment
+	}
+
+	results = make([]ProbeResult, 0)
+
+	buffer := make([]byte, probeChunkSize)
+	magic := make([]byte, 2)
+
+	for chunk := int64(0); chunk < size; chunk += probeChunkSize {
+		length := probeChunkSize
+		if size-chunk < length {
+			length = size - chunk
+		}
+
+		err := ReadFullAt(ra, buffer[:length], chunk)
+		if err != nil {
+			return nil, err
+		}
+
+		first := (chunk + alignment - 1) / alignment * alignment
+
+		for offset := first; offset < chunk+length; offset += alignment {
+			if offset+SuperblockSize > size {
+				break
+			}
+
+			// The magic can be past the end of the chunk.
+			i := offset - chunk + superblockMagicOffset
+			if i+2 <= length {
+				copy(magic, buffer[i:i+2])
+			} else {
+				err := ReadFullAt(ra, magic, offset+superblockMagicOffset)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if binary.LittleEndian.Uint16(magic) != Ext4Magic {
+				continue
+			}
+
+			// We'll also run into the backups of filesystems that we've already
+			// found (which, from older versions of mke2fs, might not even
+			// have their group number).
+			seen := false
+			for _, result := range results {
+				if result.hasSuperblockAt(offset) == true {
+					seen = true
+					break
+				}
+			}
+
+			if seen == true {
+				continue
+			}
+
+			result, err := probeSuperblock(ra, size, offset)
+			if err != nil {
+				continue
+			}
+
+			results = append(results, result)
+		}
+	}
+
+	// A filesystem that was found by a backup starts before ones that might
+	// have been found already.
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Offset < results[j].Offset
+	})
+
+	return results, nil
+}
+
+// hasSuperblockAt indicates whether there's a copy of the superblock of this
+// filesystem at the given offset in the blob.
+func (pr ProbeResult) hasSuperblockAt(offset int64) bool {
+	sb := pr.Superblock
+
+	if offset == pr.Offset+Superblock0Offset {
+		return true
+	} else if sb.HasIncompatibleFeature(SbFeatureIncompatJournalDev) == true {
+		// There are no groups, so no backups.
+		return false
+	}
+
+	blockSize := int64(sb.blockSize)
+	if offset < pr.Offset || (offset-pr.Offset)%blockSize != 0 {
+		return false
+	}
+
+	block := uint64((offset - pr.Offset) / blockSize)
+	if block < uint64(sb.data.SFirstDataBlock) {
+		return false
+	}
+
+	block -= uint64(sb.data.SFirstDataBlock)
+	if block%uint64(sb.data.SBlocksPerGroup) != 0 {
+		return false
+	}
+
+	group := block / uint64(sb.data.SBlocksPerGroup)
+
+	return group < sb.BlockGroupCount() && sb.HasSuperblockBackup(group) == true
+}
+
+// probeSuperblock checks the candidate superblock at the given offset and
+// works out where its filesystem starts.
+func probeSuperblock(ra io.ReaderAt, size int64, offset int64) (result ProbeResult, err error) {
+	sb, err := newSuperblockWithReaderAtOffset(ra, offset)
+	if err != nil {
+		return result, err
+	}
+
+	// The group number says which copy this is, so where the filesystem has
+	// to start.
+	group := uint64(sb.data.SBlockGroupNr)
+	if group == 0 {
+		group = sb.probeBackupGroup(ra, offset)
+	}
+
+	start := offset - sb.SuperblockOffset(group)
+	if start < 0 {
+		return result, fmt.Errorf("superblock at (%d) for group (%d) would start before the blob: %w", offset, group, ErrNotExt4)
+	}
+
+	// Read it again, relative to the filesystem.
+	sr := io.NewSectionReader(ra, start, size-start)
+
+	sb, err = newSuperblockWithReaderAtOffset(sr, offset-start)
+	if err != nil {
+		return result, err
+	}
+
+	err = sb.probeCheck(group)
+	if err != nil {
+		return result, err
+	}
+
+	result = ProbeResult{
+		Offset:           start,
+		SuperblockOffset: offset,
+		Superblock:       sb,
+		Backup:           group != 0,
+		Truncated:        start+int64(sb.BlockCount())*int64(sb.blockSize) > size,
+	}
+
+	return result, nil
+}
+
+// probeBackupGroup returns the group of the backup that the copy of the
+// superblock at the given offset is, for one that claims to be the primary
+// (which older versions of mke2fs wrote into every backup). It's the first
+// group for which what would be the primary of the same filesystem has the
+// magic and the same UUID; that primary didn't check out, or it would have
+// been found first. Otherwise, it's (0).
+func (sb *Superblock) probeBackupGroup(ra io.ReaderAt, offset int64) uint64 {
+	// A journal device has no groups, so no backups.
+	if sb.HasIncompatibleFeature(SbFeatureIncompatJournalDev) == true || sb.data.SBlocksPerGroup == 0 {
+		return 0
+	}
+
+	raw := make([]byte, SuperblockSize)
+
+	for _, group := range sb.SuperblockBackupGroups() {
+		start := offset - sb.SuperblockOffset(group)
+		if start < 0 {
+			break
+		}
+
+		err := ReadFullAt(ra, raw, start+Superblock0Offset)
+		if err != nil {
+			continue
+		}
+
+		if binary.LittleEndian.Uint16(raw[superblockMagicOffset:]) == Ext4Magic && bytes.Equal(raw[superblockUuidOffset:superblockUuidOffset+16], sb.data.SUuid[:]) == true {
+			return group
+		}
+	}
+
+	return 0
+}
+
+// probeCheck does the checks, beyond what parsing already does, that something
+// with the superblock magic has to pass to be taken for a filesystem: the
+// checksum matches, the revision is one we know of, and the counts agree with
+// each other.
+func (sb *Superblock) probeCheck(group uint64) error {
+	if sb.HasValidChecksum() == false {
+		return newErrCorrupt("superblock", 0, "checksum doesn't match")
+	} else if sb.data.SRevLevel > SbRevlevelDynamicRev {
+		return newErrCorrupt("superblock", 0, "revision not valid: (%d)", sb.data.SRevLevel)
+	}
+
+	// A journal device has no groups.
+	if sb.HasIncompatibleFeature(SbFeatureIncompatJournalDev) == true {
+		if group != 0 {
+			return newErrCorrupt("superblock", 0, "journal device with a group number: (%d)", group)
+		}
+
+		return nil
+	}
+
+	if sb.blockSize > 1024 && sb.data.SFirstDataBlock != 0 {
+		return newErrCorrupt("superblock", 0, "first data-block (%d) not valid for block-size (%d)", sb.data.SFirstDataBlock, sb.blockSize)
+	} else if sb.data.SFirstDataBlock > 1 {
+		return newErrCorrupt("superblock", 0, "first data-block not valid: (%d)", sb.data.SFirstDataBlock)
+	}
+
+	groups := sb.BlockGroupCount()
+
+	if group >= groups {
+		return newErrCorrupt("superblock", 0, "group (%d) is beyond the group-count (%d)", group, groups)
+	} else if uint64(sb.data.SInodesCount) != groups*uint64(sb.data.SInodesPerGroup) {
+		return newErrCorrupt("superblock", 0, "inode-count (%d) isn't the group-count (%d) times inodes-per-group (%d)", sb.data.SInodesCount, groups, sb.data.SInodesPerGroup)
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package ext4
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"testing"
+
+	"encoding/binary"
+	"io/ioutil"
+
+	"github.com/dsoprea/go-logging"
+)
+
+// getTestProbeBlob returns a blob with some junk (including something that
+// only looks like a superblock) followed by the tiny image at (4096) and then
+// the bitmaps image at the next sector after that (which isn't on a block
+// boundary).
+func getTestProbeBlob() (raw []byte, tinyOffset, bitmapsOffset int64) {
+	tiny, err := ioutil.ReadFile(path.Join(assetsPath, "tiny.ext4"))
+	log.PanicIf(err)
+
+	bitmaps, err := ioutil.ReadFile(path.Join(assetsPath, "bitmaps.ext4"))
+	log.PanicIf(err)
+
+	junk := make([]byte, 4096)
+	for i := range junk {
+		junk[i] = byte(i * 7)
+	}
+
+	binary.LittleEndian.PutUint16(junk[512+superblockMagicOffset:], Ext4Magic)
+
+	tinyOffset = int64(len(junk))
+	bitmapsOffset = tinyOffset + int64(len(tiny)) + 512
+
+	raw = make([]byte, 0)
+	raw = append(raw, junk...)
+	raw = append(raw, tiny...)
+	raw = append(raw, make([]byte, 512)...)
+	raw = append(raw, bitmaps...)
+	raw = append(raw, junk...)
+
+	return raw, tinyOffset, bitmapsOffset
+}
+
+func TestProbeSuperblocks(t *testing.T) {
+	raw, tinyOffset, bitmapsOffset := getTestProbeBlob()
+
+	results, err := ProbeSuperblocks(bytes.NewReader(raw), int64(len(raw)), 0)
+	log.PanicIf(err)
+
+	if len(results) != 2 {
+		t.Fatalf("Expected two filesystems: %v", results)
+	}
+
+	if results[0].Offset != tinyOffset || results[0].SuperblockOffset != tinyOffset+Superblock0Offset {
+		t.Fatalf("First filesystem not correct: %s", results[0])
+	} else if results[1].Offset != bitmapsOffset || results[1].SuperblockOffset != bitmapsOffset+Superblock0Offset {
+		t.Fatalf("Second filesystem not correct: %s", results[1])
+	}
+
+	for _, result := range results {
+		if result.Backup == true || result.Truncated == true {
+			t.Fatalf("Filesystem should be whole: %s", result)
+		}
+	}
+
+	// The superblocks read relative to their filesystems.
+
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(results[0].Superblock)
+	log.PanicIf(err)
+
+	bgd, err := bgdl.GetWithAbsoluteInode(TestFileInodeNumber)
+	log.PanicIf(err)
+
+	inode, err := NewInodeWithBlockGroupDescriptor(bgd, TestFileInodeNumber)
+	log.PanicIf(err)
+
+	if inode.Size() != 849597 {
+		t.Fatalf("Inode not read correctly: (%d)", inode.Size())
+	}
+
+	fsm, err := NewFreeSpaceMapWithSuperblock(results[1].Superblock)
+	log.PanicIf(err)
+
+	if fsm.FreeBlocks() != 1123 {
+		t.Fatalf("Free blocks not correct: (%d)", fsm.FreeBlocks())
+	}
+}
+
+func TestProbeSuperblocks_Backup(t *testing.T) {
+	raw, _, bitmapsOffset := getTestProbeBlob()
+
+	// Change the volume-name of the primary of the bitmaps image, so that
+	// its checksum no longer matches.
+	raw[bitmapsOffset+Superblock0Offset+0x78] ^= 0xff
+
+	results, err := ProbeSuperblocks(bytes.NewReader(raw), int64(len(raw)), 0)
+	log.PanicIf(err)
+
+	if len(results) != 2 {
+		t.Fatalf("Expected two filesystems: %v", results)
+	}
+
+	result := results[1]
+
+	if result.Offset != bitmapsOffset {
+		t.Fatalf("Offset not correct: %s", result)
+	} else if result.SuperblockOffset != bitmapsOffset+513*1024 {
+		t.Fatalf("Superblock offset not correct: %s", result)
+	} else if result.Backup != true {
+		t.Fatalf("Should have been found by a backup: %s", result)
+	} else if result.Superblock.Group() != 1 {
+		t.Fatalf("Group not correct: (%d)", result.Superblock.Group())
+	}
+
+	fsm, err := NewFreeSpaceMapWithSuperblock(result.Superblock)
+	log.PanicIf(err)
+
+	if fsm.FreeBlocks() != 1123 {
+		t.Fatalf("Free blocks not correct: (%d)", fsm.FreeBlocks())
+	}
+}
+
+func TestProbeSuperblocks_Backup_NoGroupNumber(t *testing.T) {
+	raw, _, bitmapsOffset := getTestProbeBlob()
+
+	// Damage the primary of the bitmaps image, and clear the group number of
+	// its first backup like older versions of mke2fs did (fixing up its
+	// checksum).
+
+	raw[bitmapsOffset+Superblock0Offset+0x78] ^= 0xff
+
+	backup := raw[bitmapsOffset+513*1024 : bitmapsOffset+514*1024]
+	binary.LittleEndian.PutUint16(backup[0x5a:], 0)
+	binary.LittleEndian.PutUint32(backup[superblockChecksumOffset:], crc32c(0xffffffff, backup[:superblockChecksumOffset]))
+
+	results, err := ProbeSuperblocks(bytes.NewReader(raw), int64(len(raw)), 0)
+	log.PanicIf(err)
+
+	if len(results) != 2 {
+		t.Fatalf("Expected two filesystems: %v", results)
+	}
+
+	result := results[1]
+
+	if result.Offset != bitmapsOffset || result.SuperblockOffset != bitmapsOffset+513*1024 {
+		t.Fatalf("Filesystem not correct: %s", result)
+	} else if result.Backup != true || result.Superblock.Group() != 1 {
+		t.Fatalf("Should have been found by the backup in group (1): %s", result)
+	}
+}
+
+func TestProbeSuperblocks_Truncated(t *testing.T) {
+	raw, tinyOffset, _ := getTestProbeBlob()
+
+	raw = raw[:tinyOffset+100*1024]
+
+	results, err := ProbeSuperblocks(bytes.NewReader(raw), int64(len(raw)), 0)
+	log.PanicIf(err)
+
+	if len(results) != 1 {
+		t.Fatalf("Expected one filesystem: %v", results)
+	} else if results[0].Offset != tinyOffset || results[0].Truncated != true {
+		t.Fatalf("Filesystem not correct: %s", results[0])
+	}
+}
+
+func TestProbeSuperblocks_Alignment(t *testing.T) {
+	raw, tinyOffset, _ := getTestProbeBlob()
+
+	// The alignment is of the superblocks, and the ones of the bitmaps image
+	// aren't on a 1K boundary.
+
+	results, err := ProbeSuperblocks(bytes.NewReader(raw), int64(len(raw)), 1024)
+	log.PanicIf(err)
+
+	if len(results) != 1 {
+		t.Fatalf("Expected one filesystem: %v", results)
+	} else if results[0].Offset != tinyOffset {
+		t.Fatalf("Filesystem not correct: %s", results[0])
+	}
+}
+
+func TestSuperblock_HasValidChecksum(t *testing.T) {
+	raw, err := ioutil.ReadFile(path.Join(assetsPath, "bitmaps.ext4"))
+	log.PanicIf(err)
+
+	sb, err := NewSuperblockWithReaderAt(bytes.NewReader(raw))
+	log.PanicIf(err)
+
+	if sb.HasValidChecksum() != true {
+		t.Fatalf("Checksum should be valid.")
+	}
+
+	raw[Superblock0Offset+0x78] ^= 0xff
+
+	sb, err = NewSuperblockWithReaderAt(bytes.NewReader(raw))
+	log.PanicIf(err)
+
+	if sb.HasValidChecksum() != false {
+		t.Fatalf("Checksum should not be valid.")
+	}
+}
+
+func ExampleProbeSuperblocks() {
+	raw, _, _ := getTestProbeBlob()
+
+	results, err := ProbeSuperblocks(bytes.NewReader(raw), int64(len(raw)), 0)
+	log.PanicIf(err)
+
+	for _, result := range results {
+		fmt.Println(result)
+	}
+
+	// Output:
+	// ProbeResult<OFFSET=(4096) SUPERBLOCK-OFFSET=(5120) BLOCK-SIZE=(1024) BLOCKS=(1024) BACKUP=[false] TRUNCATED=[false]>
+	// ProbeResult<OFFSET=(1053184) SUPERBLOCK-OFFSET=(1054208) BLOCK-SIZE=(1024) BLOCKS=(2048) BACKUP=[false] TRUNCATED=[false]>
+}
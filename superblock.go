@@ -1,6 +1,7 @@
 package ext4
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -9,8 +10,6 @@ import (
 	"time"
 
 	"encoding/binary"
-
-	"github.com/dsoprea/go-logging"
 )
 
 const (
@@ -20,6 +19,14 @@ const (
 
 	// The first superblock is after the bootloader code.
 	Superblock0Offset = int64(1024)
+
+	// BlockGroupDescriptorMinSize is the size of a block-group descriptor
+	// without the 64bit feature (EXT4_MIN_DESC_SIZE).
+	BlockGroupDescriptorMinSize = 32
+
+	// BlockGroupDescriptorMaxSize is the largest that `SDescSize` can be
+	// (EXT4_MAX_DESC_SIZE).
+	BlockGroupDescriptorMaxSize = 1024
 )
 
 var (
@@ -51,6 +58,13 @@ const (
 	SbRevlevelDynamicRev = 1
 )
 
+const (
+	// SbJnlBackupBlocks is the value of `SJnlBackupType` when `SJnlBlocks`
+	// has a copy of the journal inode's block map and size
+	// (EXT3_JNL_BACKUP_BLOCKS).
+	SbJnlBackupBlocks = 1
+)
+
 const (
 	SbDefHashVersionLegacy          = 0x0
 	SbDefHashVersionHalfMd4         = 0x1
@@ -257,27 +271,69 @@ type Superblock struct {
 	data      *SuperblockData
 	blockSize uint32
 	is64Bit   bool
-	rs        io.ReadSeeker
+	ra        io.ReaderAt
+	cache     BlockCache
+	policy    SupportPolicy
+
+	// offset is where this copy of the superblock was read from.
+	offset int64
 }
 
 func (sb *Superblock) Data() *SuperblockData {
 	return sb.data
 }
 
+// NewSuperblockWithReaderAt parses the primary superblock (at
+// `Superblock0Offset`) and returns a `Superblock` that does all further I/O
+// through positional reads on `ra`. No shared seek-position is involved, so
+// one `Superblock` (and everything built on it) can be used from multiple
+// goroutines at the same time as long as `ra` supports concurrent `ReadAt`
+// calls (e.g. `*os.File`).
+//
+// Block reads are cached with an `LruBlockCache` of `DefaultBlockCacheSize`
+// blocks. Use `SetBlockCache` to replace or disable it.
+//
+// The superblock always loads as long as it's actually an ext4 superblock, even
+// if the filesystem uses features that we can't read. See `CheckSupport` and
+// `SetSupportPolicy`.
+func NewSuperblockWithReaderAt(ra io.ReaderAt) (sb *Superblock, err error) {
+	return newSuperblockWithReaderAtOffset(ra, Superblock0Offset)
+}
+
+// NewSuperblockWithReader parses the primary superblock through `rs`.
+//
+// Deprecated: Use `NewSuperblockWithReaderAt`. The superblock is read from
+// `Superblock0Offset` regardless of where `rs` is positioned, and every read
+// seeks, so the result can't be used from multiple goroutines.
 func NewSuperblockWithReader(rs io.ReadSeeker) (sb *Superblock, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
+	return NewSuperblockWithReaderAt(newReadSeekerAt(rs))
+}
+
+// newSuperblockWithReaderAtOffset parses the copy of the superblock at the
+// given offset. Block numbers are still relative to the start of `ra`.
+func newSuperblockWithReaderAtOffset(ra io.ReaderAt, offset int64) (sb *Superblock, err error) {
+	raw := make([]byte, SuperblockSize)
+
+	err = ReadFullAt(ra, raw, offset)
+	if err != nil {
+		return nil, err
+	}
 
 	sbd := new(SuperblockData)
 
-	err = binary.Read(rs, binary.LittleEndian, sbd)
-	log.PanicIf(err)
+	err = binary.Read(bytes.NewBuffer(raw), binary.LittleEndian, sbd)
+	if err != nil {
+		return nil, err
+	}
 
 	if sbd.SMagic != Ext4Magic {
-		log.Panic(ErrNotExt4)
+		return nil, ErrNotExt4
+	}
+
+	// Nothing larger than 64K is valid. Checking this first also keeps the
+	// shift below sane.
+	if sbd.SLogBlockSize > 6 {
+		return nil, newErrCorrupt("superblock", 0, "block-size exponent out of range: (%d)", sbd.SLogBlockSize)
 	}
 
 	blockSize := uint32(math.Pow(2, (10 + float64(sbd.SLogBlockSize))))
@@ -285,50 +341,210 @@ func NewSuperblockWithReader(rs io.ReadSeeker) (sb *Superblock, err error) {
 	sb = &Superblock{
 		data:      sbd,
 		blockSize: blockSize,
-		rs:        rs,
+		ra:        ra,
+		cache:     NewLruBlockCache(DefaultBlockCacheSize),
+		offset:    offset,
 	}
 
 	sb.is64Bit = sb.HasIncompatibleFeature(SbFeatureIncompat64bit)
 
-	// Assert our present operating assumptions in order to stabilize development.
+	err = sb.validate()
+	if err != nil {
+		return nil, err
+	}
 
-	if sb.HasIncompatibleFeature(SbFeatureIncompatMetaBg) == true {
-		log.Panicf("meta_bg feature not supported")
-	} else if sb.HasIncompatibleFeature(SbFeatureIncompatFlexBg) == false {
-		log.Panicf("only filesystems with flex_bg are supported")
-	} else if sb.HasIncompatibleFeature(SbFeatureIncompatCompression) == true {
-		log.Panicf("only uncompressed filesystems are supported")
-	} else if sb.HasIncompatibleFeature(SbFeatureIncompatFiletype) == false {
-		log.Panicf("only directory-entries with a filetype are supported")
-	} else if sb.HasIncompatibleFeature(SbFeatureIncompatExtents) == false {
-		log.Panicf("only filesystems using extents are supported")
-	} else if sb.HasIncompatibleFeature(SbFeatureIncompatDirData) == true {
-		log.Panicf("dir-data is obscure and not supported")
-	} else if sb.HasIncompatibleFeature(SbFeatureIncompatJournalDev) == true {
-		log.Panicf("external journal devices are not supported")
-	} else if sb.HasIncompatibleFeature(SbFeatureIncompatLargeDir) == true {
-		log.Panicf("large-dirs are not supported")
-	} else if sb.HasIncompatibleFeature(SbFeatureIncompatInlineData) == true {
-		// Data may be stored directly in the inode for small files.
+	return sb, nil
+}
 
-		log.Panicf("inline-data not supported")
-	} else if sb.HasIncompatibleFeature(SbFeatureIncompatEncrypt) == true {
-		log.Panicf("encrypted filesystems not supported")
+// validate sanity-checks the geometry so that none of the arithmetic that the
+// rest of the package does with it can divide by zero or run away.
+func (sb *Superblock) validate() error {
+	// A journal device only has a superblock and the journal, not groups, so
+	// there's nothing else to check. We won't read past the superblock anyway.
+	if sb.HasIncompatibleFeature(SbFeatureIncompatJournalDev) == true {
+		return nil
 	}
 
-	// SbFeatureIncompatRecover: Ignoring because it presumably doesn't matter
-	// when not writing.
+	bitsPerBlock := sb.blockSize * 8
+
+	if sb.data.SBlocksPerGroup == 0 {
+		return newErrCorrupt("superblock", 0, "blocks-per-group is zero")
+	} else if sb.data.SInodesPerGroup == 0 {
+		return newErrCorrupt("superblock", 0, "inodes-per-group is zero")
+	} else if sb.data.SInodesPerGroup > bitsPerBlock {
+		// The inode bitmap has to fit in one block.
+		return newErrCorrupt("superblock", 0, "inodes-per-group (%d) exceeds what one bitmap block can describe (%d)", sb.data.SInodesPerGroup, bitsPerBlock)
+	}
 
-	// SbFeatureIncompatMmp: Ignoring because we're not involved in mounting
-	// (and not writing, besides).
+	if sb.HasReadonlyCompatibleFeature(SbFeatureRoCompatBigAlloc) == true {
+		// The block bitmap has one bit per cluster, and has to fit in one
+		// block.
+		if sb.data.SLogClusterSize < sb.data.SLogBlockSize || sb.data.SLogClusterSize-sb.data.SLogBlockSize > 16 {
+			return newErrCorrupt("superblock", 0, "cluster-size exponent (%d) not valid for block-size exponent (%d)", sb.data.SLogClusterSize, sb.data.SLogBlockSize)
+		} else if sb.data.SClustersPerGroup == 0 || sb.data.SClustersPerGroup > bitsPerBlock {
+			return newErrCorrupt("superblock", 0, "clusters-per-group (%d) not valid for a bitmap block of (%d) bits", sb.data.SClustersPerGroup, bitsPerBlock)
+		} else if uint64(sb.data.SBlocksPerGroup) != uint64(sb.data.SClustersPerGroup)*sb.ClusterRatio() {
+			return newErrCorrupt("superblock", 0, "blocks-per-group (%d) isn't clusters-per-group (%d) times the cluster ratio (%d)", sb.data.SBlocksPerGroup, sb.data.SClustersPerGroup, sb.ClusterRatio())
+		}
+	} else if sb.data.SBlocksPerGroup > bitsPerBlock {
+		// The block bitmap has to fit in one block.
+		return newErrCorrupt("superblock", 0, "blocks-per-group (%d) exceeds what one bitmap block can describe (%d)", sb.data.SBlocksPerGroup, bitsPerBlock)
+	}
 
-	// SbFeatureIncompatLargeExtendedAttributeValues: Ignoring because we don't
-	// currently read xattr's.
+	inodeSize := sb.InodeSize()
+	if inodeSize < 128 || uint32(inodeSize) > sb.blockSize || (inodeSize&(inodeSize-1)) != 0 {
+		return newErrCorrupt("superblock", 0, "inode-size not valid: (%d)", inodeSize)
+	}
 
-	// SbFeatureIncompatCsumSeed: Ignoring because we're not concerned with
-	// mount semantics.
+	if uint64(sb.data.SFirstDataBlock) >= sb.BlockCount() {
+		return newErrCorrupt("superblock", 0, "first data-block (%d) is beyond the block-count (%d)", sb.data.SFirstDataBlock, sb.BlockCount())
+	}
 
-	return sb, nil
+	if sb.is64Bit == true {
+		descSize := uint32(sb.data.SDescSize)
+		if descSize < BlockGroupDescriptorSize || descSize > BlockGroupDescriptorMaxSize || descSize > sb.blockSize || (descSize&(descSize-1)) != 0 {
+			return newErrCorrupt("superblock", 0, "descriptor-size not valid: (%d)", descSize)
+		}
+	}
+
+	return nil
+}
+
+// DescriptorSize returns the on-disk size of each block-group descriptor.
+func (sb *Superblock) DescriptorSize() int {
+	if sb.is64Bit == true {
+		return int(sb.data.SDescSize)
+	}
+
+	return BlockGroupDescriptorMinSize
+}
+
+// GroupFirstBlock returns the first block of the given block-group.
+func (sb *Superblock) GroupFirstBlock(group uint64) uint64 {
+	return uint64(sb.data.SFirstDataBlock) + group*uint64(sb.data.SBlocksPerGroup)
+}
+
+// GroupBlockCount returns the number of blocks in the given block-group. Only
+// the last group can be short.
+func (sb *Superblock) GroupBlockCount(group uint64) uint64 {
+	first := sb.GroupFirstBlock(group)
+	if first >= sb.BlockCount() {
+		return 0
+	}
+
+	remaining := sb.BlockCount() - first
+	if remaining < uint64(sb.data.SBlocksPerGroup) {
+		return remaining
+	}
+
+	return uint64(sb.data.SBlocksPerGroup)
+}
+
+// ClusterRatio returns the number of blocks in each allocation cluster. This is
+// (1) unless the filesystem has bigalloc.
+func (sb *Superblock) ClusterRatio() uint64 {
+	if sb.HasReadonlyCompatibleFeature(SbFeatureRoCompatBigAlloc) == false {
+		return 1
+	}
+
+	return uint64(1) << (sb.data.SLogClusterSize - sb.data.SLogBlockSize)
+}
+
+// ClusterSize returns the size of an allocation cluster in bytes. This is the
+// block-size unless the filesystem has bigalloc.
+func (sb *Superblock) ClusterSize() uint64 {
+	return uint64(sb.blockSize) * sb.ClusterRatio()
+}
+
+// ClustersPerGroup returns the number of clusters in each block-group (which
+// is the number of bits in its block-bitmap).
+func (sb *Superblock) ClustersPerGroup() uint64 {
+	return uint64(sb.data.SBlocksPerGroup) / sb.ClusterRatio()
+}
+
+// BlockCluster returns the cluster that the given block is in.
+func (sb *Superblock) BlockCluster(block uint64) uint64 {
+	return block / sb.ClusterRatio()
+}
+
+// GroupClusterCount returns the number of clusters in the given block-group.
+// Only the last group can be short.
+func (sb *Superblock) GroupClusterCount(group uint64) uint64 {
+	ratio := sb.ClusterRatio()
+	return (sb.GroupBlockCount(group) + ratio - 1) / ratio
+}
+
+// FreeBlockCount returns the number of free blocks according to the
+// superblock. With bigalloc, this is still in blocks (unlike the counts in
+// the group-descriptors, which are in clusters).
+func (sb *Superblock) FreeBlockCount() uint64 {
+	if sb.is64Bit == true {
+		return (uint64(sb.data.SFreeBlocksCountHi) << 32) | uint64(sb.data.SFreeBlocksCountLo)
+	} else {
+		return uint64(sb.data.SFreeBlocksCountLo)
+	}
+}
+
+// HasSuperblockBackup indicates whether the given block-group starts with a
+// copy of the superblock (and of the group-descriptors). Group (0) always has
+// the primary. With sparse_super, only groups (1) and powers of three, five,
+// and seven have backups; with sparse_super2, only the (up to) two groups
+// that the superblock names.
+func (sb *Superblock) HasSuperblockBackup(group uint64) bool {
+	if group == 0 {
+		return true
+	}
+
+	if sb.HasCompatibleFeature(SbFeatureCompatSparseSuperblockV2) == true {
+		return group == uint64(sb.data.SBackupBgs[0]) || group == uint64(sb.data.SBackupBgs[1])
+	}
+
+	if sb.HasReadonlyCompatibleFeature(SbFeatureRoCompatSparseSuper) == false || group == 1 {
+		return true
+	}
+
+	for _, base := range []uint64{3, 5, 7} {
+		n := base
+		for n < group {
+			n *= base
+		}
+
+		if n == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GroupDescriptorBlockCount returns the number of blocks that the table of
+// block-group descriptors takes up (not counting reserved blocks).
+func (sb *Superblock) GroupDescriptorBlockCount() uint64 {
+	descriptorsPerBlock := uint64(sb.blockSize) / uint64(sb.DescriptorSize())
+	return (sb.BlockGroupCount() + descriptorsPerBlock - 1) / descriptorsPerBlock
+}
+
+// superblockChecksumOffset is where `SChecksum` is in the superblock. The
+// checksum covers everything before it.
+const superblockChecksumOffset = 0x3fc
+
+// HasValidChecksum indicates whether the superblock matches its checksum. It's
+// always true if the filesystem doesn't have metadata checksums.
+func (sb *Superblock) HasValidChecksum() bool {
+	if sb.HasReadonlyCompatibleFeature(SbFeatureRoCompatMetadataCsum) == false {
+		return true
+	}
+
+	b := new(bytes.Buffer)
+
+	err := binary.Write(b, binary.LittleEndian, sb.data)
+	if err != nil {
+		return false
+	}
+
+	checksum := crc32c(0xffffffff, b.Bytes()[:superblockChecksumOffset])
+
+	return checksum == uint32(sb.data.SChecksum)
 }
 
 func (sb *Superblock) HasExtended() bool {
@@ -358,10 +574,31 @@ func (sb *Superblock) VolumeName() string {
 	return volumeName
 }
 
+// InodeSize returns the size of the on-disk inode records. Original-revision
+// filesystems don't store it and always use 128 bytes.
+func (sb *Superblock) InodeSize() uint16 {
+	if sb.HasExtended() == false {
+		return 128
+	}
+
+	return sb.data.SInodeSize
+}
+
 func (sb *Superblock) Is64Bit() bool {
 	return sb.is64Bit
 }
 
+// JournalDevice returns the device number of an external journal (from
+// `SJournalDev`), decoded the way that Linux encodes it.
+func (sb *Superblock) JournalDevice() (major, minor uint32) {
+	dev := sb.data.SJournalDev
+
+	major = (dev & 0xfff00) >> 8
+	minor = (dev & 0xff) | ((dev >> 12) & 0xfff00)
+
+	return major, minor
+}
+
 func (sb *Superblock) HasCompatibleFeature(mask uint32) bool {
 	return (sb.data.SFeatureCompat & mask) > 0
 }
@@ -384,24 +621,64 @@ func (sb *Superblock) BlockGroupInodeNumberWithAbsoluteInodeNumber(absoluteInode
 	return (absoluteInodeNumber - 1) % int(sb.data.SInodesPerGroup)
 }
 
+// SetBlockCache replaces the block cache used by `ReadPhysicalBlock`. Pass nil
+// to disable caching. This is not safe to call while reads are in progress.
+func (sb *Superblock) SetBlockCache(cache BlockCache) {
+	sb.cache = cache
+}
+
+// BlockCache returns the current block cache or nil if caching is disabled.
+func (sb *Superblock) BlockCache() BlockCache {
+	return sb.cache
+}
+
+// ReadPhysicalBlock returns the first `length` bytes of the given block. A
+// short read is an error. The returned slice belongs to the caller.
 func (sb *Superblock) ReadPhysicalBlock(absoluteBlockNumber uint64, length uint64) (data []byte, err error) {
 	if length > uint64(sb.blockSize) {
-		log.Panicf("can't read more bytes (%d) than block-size (%d)", length, sb.blockSize)
+		return nil, fmt.Errorf("can't read more bytes (%d) than block-size (%d)", length, sb.blockSize)
 	}
 
-	offset := absoluteBlockNumber * uint64(sb.blockSize)
+	if sb.cache == nil {
+		offset := absoluteBlockNumber * uint64(sb.blockSize)
 
-	_, err = sb.rs.Seek(int64(offset), io.SeekStart)
-	log.PanicIf(err)
+		data = make([]byte, length)
 
-	data = make([]byte, length)
+		err = ReadFullAt(sb.ra, data, int64(offset))
+		if err != nil {
+			return nil, fmt.Errorf("read of block (%d) failed: %w", absoluteBlockNumber, err)
+		}
+
+		return data, nil
+	}
+
+	block, found := sb.cache.Get(absoluteBlockNumber)
+	if found == false {
+		offset := absoluteBlockNumber * uint64(sb.blockSize)
 
-	_, err = sb.rs.Read(data)
-	log.PanicIf(err)
+		// Always cache whole blocks, regardless of how much was asked for.
+		block = make([]byte, sb.blockSize)
+
+		err = ReadFullAt(sb.ra, block, int64(offset))
+		if err != nil {
+			return nil, fmt.Errorf("read of block (%d) failed: %w", absoluteBlockNumber, err)
+		}
+
+		sb.cache.Put(absoluteBlockNumber, block)
+	}
+
+	data = make([]byte, length)
+	copy(data, block)
 
 	return data, nil
 }
 
+// ReadAt reads `len(p)` bytes at the given absolute filesystem offset,
+// bypassing the block cache. A short read is an error.
+func (sb *Superblock) ReadAt(p []byte, offset int64) (err error) {
+	return ReadFullAt(sb.ra, p, offset)
+}
+
 func (sb *Superblock) BlockCount() uint64 {
 	if sb.is64Bit == true {
 		return (uint64(sb.data.SBlocksCountHi) << 32) | uint64(sb.data.SBlocksCountLo)
@@ -411,7 +688,11 @@ func (sb *Superblock) BlockCount() uint64 {
 }
 
 func (sb *Superblock) BlockGroupCount() (blockGroups uint64) {
-	blockGroups = sb.BlockCount() / uint64(sb.data.SBlocksPerGroup)
+	// The last group may be partial.
+	dataBlocks := sb.BlockCount() - uint64(sb.data.SFirstDataBlock)
+	blocksPerGroup := uint64(sb.data.SBlocksPerGroup)
+
+	blockGroups = (dataBlocks + blocksPerGroup - 1) / blocksPerGroup
 
 	// If we have less than one block-group's worth of blocks.
 	if blockGroups == 0 {
@@ -0,0 +1,486 @@
+package ext4
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// superblockVolatileFields are the fields that the kernel only keeps up to
+// date in the primary superblock. The backups have whatever they had when
+// they were last written (at mkfs or resize time), so differences in these
+// aren't discrepancies.
+var superblockVolatileFields = map[string]struct{}{
+	"SFreeBlocksCountLo": {},
+	"SFreeBlocksCountHi": {},
+	"SFreeInodesCount":   {},
+	"SMtime":             {},
+	"SMtimeHi":           {},
+	"SWtime":             {},
+	"SWtimeHi":           {},
+	"SMntCount":          {},
+	"SState":             {},
+	"SLastcheck":         {},
+	"SLastcheckHi":       {},
+	"SBlockGroupNr":      {},
+	"SLastMounted":       {},
+	"SLastOrphan":        {},
+	"SKbytesWritten":     {},
+	"SErrorCount":        {},
+	"SFirstErrorTime":    {},
+	"SFirstErrorTimeHi":  {},
+	"SFirstErrorIno":     {},
+	"SFirstErrorBlock":   {},
+	"SFirstErrorFunc":    {},
+	"SFirstErrorLine":    {},
+	"SLastErrorTime":     {},
+	"SLastErrorTimeHi":   {},
+	"SLastErrorIno":      {},
+	"SLastErrorLine":     {},
+	"SLastErrorBlock":    {},
+	"SLastErrorFunc":     {},
+	"SChecksum":          {},
+}
+
+// descriptorLocationFields are the only descriptor fields that backups keep
+// up to date; the counts, flags, and checksums are only maintained in the
+// primary table.
+var descriptorLocationFields = []string{
+	"BgBlockBitmapLo",
+	"BgBlockBitmapHi",
+	"BgInodeBitmapLo",
+	"BgInodeBitmapHi",
+	"BgInodeTableLo",
+	"BgInodeTableHi",
+}
+
+// Discrepancy is a difference between the primary superblock or group
+// descriptors and one of their backups.
+type Discrepancy struct {
+	// Structure is "superblock" or "group descriptor".
+	Structure string
+
+	// Group is the block-group that the backup is in. BlockGroup is the group
+	// that a group descriptor describes.
+	Group      uint64
+	BlockGroup uint64
+
+	// Field is the name of the field in `SuperblockData` or
+	// `BlockGroupDescriptorData`. It's empty if the backup couldn't be read at
+	// all, in which case `Backup` is the error.
+	Field   string
+	Primary string
+	Backup  string
+}
+
+func (d Discrepancy) String() string {
+	if d.Field == "" {
+		return fmt.Sprintf("Discrepancy<STRUCTURE=[%s] GROUP=(%d) ERROR=[%s]>", d.Structure, d.Group, d.Backup)
+	} else if d.Structure == "group descriptor" {
+		return fmt.Sprintf("Discrepancy<STRUCTURE=[%s] GROUP=(%d) BLOCK-GROUP=(%d) FIELD=[%s] PRIMARY=[%s] BACKUP=[%s]>", d.Structure, d.Group, d.BlockGroup, d.Field, d.Primary, d.Backup)
+	}
+
+	return fmt.Sprintf("Discrepancy<STRUCTURE=[%s] GROUP=(%d) FIELD=[%s] PRIMARY=[%s] BACKUP=[%s]>", d.Structure, d.Group, d.Field, d.Primary, d.Backup)
+}
+
+// SuperblockBackupGroups returns the block-groups, other than (0), that have a
+// backup of the superblock (see `HasSuperblockBackup`), in order.
+func (sb *Superblock) SuperblockBackupGroups() (groups []uint64) {
+	groups = make([]uint64, 0)
+	count := sb.BlockGroupCount()
+
+	if sb.HasCompatibleFeature(SbFeatureCompatSparseSuperblockV2) == true {
+		for _, group := range sb.data.SBackupBgs {
+			if group != 0 && uint64(group) < count {
+				groups = append(groups, uint64(group))
+			}
+		}
+
+		sort.Slice(groups, func(i, j int) bool {
+			return groups[i] < groups[j]
+		})
+
+		// Both can name the same group.
+		if len(groups) == 2 && groups[0] == groups[1] {
+			groups = groups[:1]
+		}
+
+		return groups
+	}
+
+	if sb.HasReadonlyCompatibleFeature(SbFeatureRoCompatSparseSuper) == false {
+		for group := uint64(1); group < count; group++ {
+			groups = append(groups, group)
+		}
+
+		return groups
+	}
+
+	// Group (1) and the powers of three, five, and seven.
+
+	if count > 1 {
+		groups = append(groups, 1)
+	}
+
+	for _, base := range []uint64{3, 5, 7} {
+		for n := base; n < count; n *= base {
+			groups = append(groups, n)
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i] < groups[j]
+	})
+
+	return groups
+}
+
+// SuperblockOffset returns the offset of the copy of the superblock in the
+// given group.
+func (sb *Superblock) SuperblockOffset(group uint64) int64 {
+	if group == 0 {
+		return Superblock0Offset
+	}
+
+	return int64(sb.GroupFirstBlock(group)) * int64(sb.blockSize)
+}
+
+// Offset returns the offset that this copy of the superblock was read from.
+func (sb *Superblock) Offset() int64 {
+	return sb.offset
+}
+
+// IsBackup indicates whether this superblock was read from a backup rather
+// than the primary.
+func (sb *Superblock) IsBackup() bool {
+	return sb.offset != Superblock0Offset
+}
+
+// Group returns the block-group that this copy of the superblock is in.
+func (sb *Superblock) Group() uint64 {
+	if sb.IsBackup() == false {
+		return 0
+	}
+
+	return (uint64(sb.offset)/uint64(sb.blockSize) - uint64(sb.data.SFirstDataBlock)) / uint64(sb.data.SBlocksPerGroup)
+}
+
+// descriptorCopy is where one copy of a block of the group-descriptor table
+// is.
+type descriptorCopy struct {
+	group uint64
+	block uint64
+}
+
+// groupDescriptorCopies returns every copy of the given block of the
+// group-descriptor table, primary first. Without meta_bg (or before the first
+// meta-group), the table follows every copy of the superblock. With meta_bg,
+// each block of descriptors (a "meta-group") is in the first, second, and
+// last group that it describes.
+func (sb *Superblock) groupDescriptorCopies(index uint64) (copies []descriptorCopy) {
+	copies = make([]descriptorCopy, 0)
+
+	if sb.HasIncompatibleFeature(SbFeatureIncompatMetaBg) == false || index < uint64(sb.data.SFirstMetaBg) {
+		groups := append([]uint64{0}, sb.SuperblockBackupGroups()...)
+
+		for _, group := range groups {
+			block := uint64(sb.SuperblockOffset(group))/uint64(sb.blockSize) + 1 + index
+			copies = append(copies, descriptorCopy{group: group, block: block})
+		}
+
+		return copies
+	}
+
+	descriptorsPerBlock := uint64(sb.blockSize) / uint64(sb.DescriptorSize())
+	first := index * descriptorsPerBlock
+
+	for _, group := range []uint64{first, first + 1, first + descriptorsPerBlock - 1} {
+		if group >= sb.BlockGroupCount() {
+			continue
+		}
+
+		block := sb.GroupFirstBlock(group)
+		if sb.HasSuperblockBackup(group) == true {
+			block = uint64(sb.SuperblockOffset(group))/uint64(sb.blockSize) + 1
+		}
+
+		copies = append(copies, descriptorCopy{group: group, block: block})
+	}
+
+	return copies
+}
+
+// GroupDescriptorBlocks returns the blocks with a copy of the given block of
+// the group-descriptor table, primary first.
+func (sb *Superblock) GroupDescriptorBlocks(index uint64) (blocks []uint64) {
+	copies := sb.groupDescriptorCopies(index)
+
+	blocks = make([]uint64, len(copies))
+	for i, dc := range copies {
+		blocks[i] = dc.block
+	}
+
+	return blocks
+}
+
+// groupDescriptorBlock returns the block of the descriptor table that goes
+// with this copy of the superblock: the one in the same group if there is
+// one, otherwise the first backup, and the primary only if this is the
+// primary superblock (or there's nothing else).
+func (sb *Superblock) groupDescriptorBlock(index uint64) uint64 {
+	copies := sb.groupDescriptorCopies(index)
+
+	if sb.IsBackup() == false || len(copies) == 1 {
+		return copies[0].block
+	}
+
+	group := sb.Group()
+	for _, dc := range copies {
+		if dc.group == group {
+			return dc.block
+		}
+	}
+
+	return copies[1].block
+}
+
+// NewSuperblockWithBackup parses the copy of the superblock at the given
+// offset (see `SuperblockOffset`) rather than the primary. Group descriptors
+// are then read from the backup table next to it. Everything else is the same
+// as `NewSuperblockWithReaderAt`.
+func NewSuperblockWithBackup(ra io.ReaderAt, offset int64) (sb *Superblock, err error) {
+	return newSuperblockWithReaderAtOffset(ra, offset)
+}
+
+// backupCandidates returns where the copy of the superblock in group (1)
+// could be, most likely first: the default geometry for each block-size
+// (eight times as many blocks per group as there are bytes in a block, which
+// is what `e2fsck` assumes), and then the other power-of-two group sizes.
+func backupCandidates() (offsets []int64) {
+	offsets = make([]int64, 0)
+	others := make([]int64, 0)
+
+	for logBlockSize := uint32(0); logBlockSize <= 6; logBlockSize++ {
+		blockSize := int64(1024) << logBlockSize
+		defaultBlocksPerGroup := blockSize * 8
+
+		// With bigalloc, the first data-block is (0) even for 1k blocks.
+		firstDataBlocks := []int64{0}
+		if blockSize == 1024 {
+			firstDataBlocks = []int64{1, 0}
+		}
+
+		for _, firstDataBlock := range firstDataBlocks {
+			for blocksPerGroup := int64(256); blocksPerGroup <= defaultBlocksPerGroup*256; blocksPerGroup *= 2 {
+				offset := (blocksPerGroup + firstDataBlock) * blockSize
+
+				if blocksPerGroup == defaultBlocksPerGroup && firstDataBlock == firstDataBlocks[0] {
+					offsets = append(offsets, offset)
+				} else {
+					others = append(others, offset)
+				}
+			}
+		}
+	}
+
+	return append(offsets, others...)
+}
+
+// FindBackupSuperblocks looks for the backups of the superblock without
+// relying on the primary. It looks for the copy in group (1) wherever it would
+// be for each block-size and power-of-two group size, and the first one that
+// it finds describes where the rest are. It returns every backup that could be
+// read, in order, or `ErrNotFound` if there aren't any.
+//
+// A filesystem with some other group size, or whose first backup isn't in
+// group (1) (e.g. sparse_super2), can still be opened with
+// `NewSuperblockWithBackup` if the offset is known.
+func FindBackupSuperblocks(ra io.ReaderAt) (backups []*Superblock, err error) {
+	for _, offset := range backupCandidates() {
+		sb, err := newSuperblockWithReaderAtOffset(ra, offset)
+		if err != nil {
+			continue
+		}
+
+		// Make sure that it's actually the copy in group (1) of a filesystem
+		// with this geometry, and not just something that looks like one.
+		if sb.SuperblockOffset(1) != offset || (sb.data.SBlockGroupNr != 0 && sb.data.SBlockGroupNr != 1) {
+			continue
+		}
+
+		backups = make([]*Superblock, 0)
+
+		for _, group := range sb.SuperblockBackupGroups() {
+			backup, err := newSuperblockWithReaderAtOffset(ra, sb.SuperblockOffset(group))
+			if err != nil {
+				continue
+			}
+
+			backups = append(backups, backup)
+		}
+
+		if len(backups) > 0 {
+			return backups, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no backup superblocks: %w", ErrNotFound)
+}
+
+// NewSuperblockWithReaderAtOrBackup parses the primary superblock like
+// `NewSuperblockWithReaderAt`, but falls back to the first backup that can be
+// read (see `FindBackupSuperblocks`) if the primary is damaged. Use
+// `IsBackup` to tell which one was used. If there's no backup either, the
+// error from the primary is returned.
+func NewSuperblockWithReaderAtOrBackup(ra io.ReaderAt) (sb *Superblock, err error) {
+	sb, err = NewSuperblockWithReaderAt(ra)
+	if err == nil {
+		return sb, nil
+	}
+
+	backups, findErr := FindBackupSuperblocks(ra)
+	if findErr != nil {
+		return nil, err
+	}
+
+	return backups[0], nil
+}
+
+// CompareBackups reads every backup of the superblock and of the
+// group-descriptor table and returns how each differs from this copy
+// (normally the primary). Fields that only the primary keeps up to date
+// (free counts, mount and write times, etc..) aren't compared. Backups that
+// can't be read at all are reported as such.
+func (sb *Superblock) CompareBackups() (discrepancies []Discrepancy, err error) {
+	discrepancies = make([]Discrepancy, 0)
+
+	for _, group := range sb.SuperblockBackupGroups() {
+		if group == sb.Group() {
+			continue
+		}
+
+		backup, err := newSuperblockWithReaderAtOffset(sb.ra, sb.SuperblockOffset(group))
+		if err != nil {
+			discrepancies = append(discrepancies, Discrepancy{
+				Structure: "superblock",
+				Group:     group,
+				Backup:    err.Error(),
+			})
+
+			continue
+		}
+
+		discrepancies = append(discrepancies, compareSuperblocks(sb, backup, group)...)
+	}
+
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize := uint64(sb.blockSize)
+	descriptorSize := uint64(sb.DescriptorSize())
+	descriptorsPerBlock := blockSize / descriptorSize
+
+	for index := uint64(0); index*descriptorsPerBlock < uint64(bgdl.Count()); index++ {
+		primaryBlock := sb.groupDescriptorBlock(index)
+
+		for _, dc := range sb.groupDescriptorCopies(index) {
+			if dc.block == primaryBlock {
+				continue
+			}
+
+			data, err := sb.ReadPhysicalBlock(dc.block, blockSize)
+			if err != nil {
+				discrepancies = append(discrepancies, Discrepancy{
+					Structure: "group descriptor",
+					Group:     dc.group,
+					Backup:    err.Error(),
+				})
+
+				continue
+			}
+
+			for j := uint64(0); j < descriptorsPerBlock && index*descriptorsPerBlock+j < uint64(bgdl.Count()); j++ {
+				blockGroup := index*descriptorsPerBlock + j
+
+				backup, err := NewBlockGroupDescriptorWithBytes(data[j*descriptorSize:], sb)
+				if err != nil {
+					return nil, err
+				}
+
+				primary, _ := bgdl.Get(int(blockGroup))
+
+				discrepancies = append(discrepancies, compareDescriptors(primary, backup, dc.group, blockGroup)...)
+			}
+		}
+	}
+
+	return discrepancies, nil
+}
+
+// compareSuperblocks returns the fields that differ between two copies of the
+// superblock, other than the volatile ones.
+func compareSuperblocks(primary, backup *Superblock, group uint64) (discrepancies []Discrepancy) {
+	discrepancies = make([]Discrepancy, 0)
+
+	pv := reflect.ValueOf(*primary.data)
+	bv := reflect.ValueOf(*backup.data)
+
+	for i := 0; i < pv.NumField(); i++ {
+		name := pv.Type().Field(i).Name
+		if _, found := superblockVolatileFields[name]; found == true {
+			continue
+		}
+
+		p := pv.Field(i).Interface()
+		b := bv.Field(i).Interface()
+
+		// Whether the journal needs to be replayed is only recorded in the
+		// primary.
+		if name == "SFeatureIncompat" {
+			p = p.(uint32) &^ SbFeatureIncompatRecover
+			b = b.(uint32) &^ SbFeatureIncompatRecover
+		}
+
+		if reflect.DeepEqual(p, b) == false {
+			discrepancies = append(discrepancies, Discrepancy{
+				Structure: "superblock",
+				Group:     group,
+				Field:     name,
+				Primary:   fmt.Sprintf("%v", p),
+				Backup:    fmt.Sprintf("%v", b),
+			})
+		}
+	}
+
+	return discrepancies
+}
+
+// compareDescriptors returns the location fields that differ between two
+// copies of a group descriptor.
+func compareDescriptors(primary, backup *BlockGroupDescriptor, group, blockGroup uint64) (discrepancies []Discrepancy) {
+	discrepancies = make([]Discrepancy, 0)
+
+	pv := reflect.ValueOf(*primary.data)
+	bv := reflect.ValueOf(*backup.data)
+
+	for _, name := range descriptorLocationFields {
+		p := pv.FieldByName(name).Interface()
+		b := bv.FieldByName(name).Interface()
+
+		if p != b {
+			discrepancies = append(discrepancies, Discrepancy{
+				Structure:  "group descriptor",
+				Group:      group,
+				BlockGroup: blockGroup,
+				Field:      name,
+				Primary:    fmt.Sprintf("%v", p),
+				Backup:     fmt.Sprintf("%v", b),
+			})
+		}
+	}
+
+	return discrepancies
+}
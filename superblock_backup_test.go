@@ -0,0 +1,298 @@
+package ext4
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"encoding/binary"
+	"io/ioutil"
+
+	"github.com/dsoprea/go-logging"
+)
+
+// getTestBitmapsImage returns the bitmaps test image in memory, so that tests
+// can damage it.
+func getTestBitmapsImage() []byte {
+	filepath := path.Join(assetsPath, "bitmaps.ext4")
+
+	raw, err := ioutil.ReadFile(filepath)
+	log.PanicIf(err)
+
+	return raw
+}
+
+func TestSuperblock_SuperblockBackupGroups(t *testing.T) {
+	raw := getTestBitmapsImage()
+
+	sb, err := NewSuperblockWithReaderAt(bytes.NewReader(raw))
+	log.PanicIf(err)
+
+	groups := sb.SuperblockBackupGroups()
+	if reflect.DeepEqual(groups, []uint64{1, 3}) == false {
+		t.Fatalf("Backup groups not correct: %v", groups)
+	}
+
+	// With sparse_super2, only the groups in SBackupBgs (at 0x24C) have
+	// backups.
+
+	offset := Superblock0Offset + 0x5c
+	value := binary.LittleEndian.Uint32(raw[offset:])
+	binary.LittleEndian.PutUint32(raw[offset:], value|SbFeatureCompatSparseSuperblockV2)
+
+	binary.LittleEndian.PutUint32(raw[Superblock0Offset+0x24c:], 3)
+	binary.LittleEndian.PutUint32(raw[Superblock0Offset+0x250:], 0)
+
+	sb, err = NewSuperblockWithReaderAt(bytes.NewReader(raw))
+	log.PanicIf(err)
+
+	groups = sb.SuperblockBackupGroups()
+	if reflect.DeepEqual(groups, []uint64{3}) == false {
+		t.Fatalf("sparse_super2 backup groups not correct: %v", groups)
+	}
+}
+
+func TestSuperblock_GroupDescriptorBlocks(t *testing.T) {
+	raw := getTestBitmapsImage()
+
+	sb, err := NewSuperblockWithReaderAt(bytes.NewReader(raw))
+	log.PanicIf(err)
+
+	blocks := sb.GroupDescriptorBlocks(0)
+	if reflect.DeepEqual(blocks, []uint64{2, 514, 1538}) == false {
+		t.Fatalf("Descriptor blocks not correct: %v", blocks)
+	}
+}
+
+func TestSuperblock_GroupDescriptorBlocks_MetaBg(t *testing.T) {
+	filepath := path.Join(assetsPath, "metabg.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	// Sixteen descriptors per block, so the first meta-group is in groups
+	// (0), (1), and (15) and the second in (16) and (17) (there's no group
+	// (31)).
+
+	blocks := sb.GroupDescriptorBlocks(0)
+	if reflect.DeepEqual(blocks, []uint64{2, 258, 3841}) == false {
+		t.Fatalf("Descriptor blocks for meta-group (0) not correct: %v", blocks)
+	}
+
+	blocks = sb.GroupDescriptorBlocks(1)
+	if reflect.DeepEqual(blocks, []uint64{4097, 4353}) == false {
+		t.Fatalf("Descriptor blocks for meta-group (1) not correct: %v", blocks)
+	}
+
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
+	log.PanicIf(err)
+
+	if bgdl.Count() != 18 {
+		t.Fatalf("Group count not correct: (%d)", bgdl.Count())
+	}
+
+	bgd, err := bgdl.Get(17)
+	log.PanicIf(err)
+
+	if bgd.InodeTableBlock() != 4104 {
+		t.Fatalf("Inode-table of last group not correct: (%d)", bgd.InodeTableBlock())
+	}
+
+	discrepancies, err := sb.CompareBackups()
+	log.PanicIf(err)
+
+	if len(discrepancies) != 0 {
+		t.Fatalf("There should be no discrepancies: %v", discrepancies)
+	}
+}
+
+func TestNewSuperblockWithReaderAtOrBackup(t *testing.T) {
+	raw := getTestBitmapsImage()
+
+	// Wipe the magic of the primary.
+	binary.LittleEndian.PutUint16(raw[Superblock0Offset+0x38:], 0)
+
+	r := bytes.NewReader(raw)
+
+	_, err := NewSuperblockWithReaderAt(r)
+	if errors.Is(err, ErrNotExt4) == false {
+		t.Fatalf("Expected ErrNotExt4: %v", err)
+	}
+
+	sb, err := NewSuperblockWithReaderAtOrBackup(r)
+	log.PanicIf(err)
+
+	if sb.IsBackup() != true {
+		t.Fatalf("Expected the backup.")
+	} else if sb.Group() != 1 {
+		t.Fatalf("Group not correct: (%d)", sb.Group())
+	} else if sb.Offset() != 513*1024 {
+		t.Fatalf("Offset not correct: (%d)", sb.Offset())
+	}
+
+	// The descriptors come from the backup table in the same group. Damage
+	// the primary table so that we'd notice if they didn't.
+
+	copy(raw[2*1024:3*1024], make([]byte, 1024))
+
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bgd, err := bgdl.Get(3)
+	log.PanicIf(err)
+
+	_, _, expectedBgdl := getTestBitmapsFilesystem()
+
+	expectedBgd, err := expectedBgdl.Get(3)
+	log.PanicIf(err)
+
+	if bgd.InodeTableBlock() != expectedBgd.InodeTableBlock() {
+		t.Fatalf("Inode-table not correct: (%d) != (%d)", bgd.InodeTableBlock(), expectedBgd.InodeTableBlock())
+	}
+}
+
+func TestNewSuperblockWithReaderAtOrBackup_NotExt4(t *testing.T) {
+	r := bytes.NewReader(make([]byte, 1024*1024))
+
+	_, err := NewSuperblockWithReaderAtOrBackup(r)
+	if errors.Is(err, ErrNotExt4) == false {
+		t.Fatalf("Expected ErrNotExt4: %v", err)
+	}
+}
+
+func TestFindBackupSuperblocks(t *testing.T) {
+	raw := getTestBitmapsImage()
+
+	backups, err := FindBackupSuperblocks(bytes.NewReader(raw))
+	log.PanicIf(err)
+
+	groups := make([]uint64, len(backups))
+	for i, backup := range backups {
+		groups[i] = backup.Group()
+	}
+
+	if reflect.DeepEqual(groups, []uint64{1, 3}) == false {
+		t.Fatalf("Backup groups not correct: %v", groups)
+	}
+
+	_, err = FindBackupSuperblocks(bytes.NewReader(make([]byte, 1024*1024)))
+	if errors.Is(err, ErrNotFound) == false {
+		t.Fatalf("Expected ErrNotFound: %v", err)
+	}
+}
+
+func TestFindBackupSuperblocks_Bigalloc(t *testing.T) {
+	filepath := path.Join(assetsPath, "bigalloc.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	backups, err := FindBackupSuperblocks(f)
+	log.PanicIf(err)
+
+	if len(backups) != 1 {
+		t.Fatalf("Expected one backup: (%d)", len(backups))
+	} else if backups[0].Group() != 1 {
+		t.Fatalf("Group not correct: (%d)", backups[0].Group())
+	} else if backups[0].Offset() != 1024*1024 {
+		t.Fatalf("Offset not correct: (%d)", backups[0].Offset())
+	}
+}
+
+func TestSuperblock_CompareBackups(t *testing.T) {
+	raw := getTestBitmapsImage()
+
+	sb, err := NewSuperblockWithReaderAt(bytes.NewReader(raw))
+	log.PanicIf(err)
+
+	discrepancies, err := sb.CompareBackups()
+	log.PanicIf(err)
+
+	if len(discrepancies) != 0 {
+		t.Fatalf("There should be no discrepancies: %v", discrepancies)
+	}
+
+	// Change SInodesCount in the backup in group (3) and the inode-table of
+	// group (0) in the backup table in group (1).
+
+	binary.LittleEndian.PutUint32(raw[1537*1024:], 999)
+	binary.LittleEndian.PutUint32(raw[514*1024+0x8:], 12345)
+
+	// Blocks are cached, so start over.
+	sb, err = NewSuperblockWithReaderAt(bytes.NewReader(raw))
+	log.PanicIf(err)
+
+	discrepancies, err = sb.CompareBackups()
+	log.PanicIf(err)
+
+	actual := make([]string, len(discrepancies))
+	for i, d := range discrepancies {
+		actual[i] = d.String()
+	}
+
+	expected := []string{
+		"Discrepancy<STRUCTURE=[superblock] GROUP=(3) FIELD=[SInodesCount] PRIMARY=[128] BACKUP=[999]>",
+		fmt.Sprintf("Discrepancy<STRUCTURE=[group descriptor] GROUP=(1) BLOCK-GROUP=(0) FIELD=[BgInodeTableLo] PRIMARY=[%d] BACKUP=[12345]>", binary.LittleEndian.Uint32(raw[2*1024+0x8:])),
+	}
+
+	if reflect.DeepEqual(actual, expected) == false {
+		for _, s := range actual {
+			fmt.Println(s)
+		}
+
+		t.Fatalf("Discrepancies not correct.")
+	}
+
+	// A backup that can't be read at all.
+
+	binary.LittleEndian.PutUint16(raw[1537*1024+0x38:], 0)
+
+	sb, err = NewSuperblockWithReaderAt(bytes.NewReader(raw))
+	log.PanicIf(err)
+
+	discrepancies, err = sb.CompareBackups()
+	log.PanicIf(err)
+
+	if len(discrepancies) != 2 {
+		t.Fatalf("Expected two discrepancies: %v", discrepancies)
+	}
+
+	d := discrepancies[0]
+	if d.Structure != "superblock" || d.Group != 3 || d.Field != "" || d.Backup == "" {
+		t.Fatalf("Unreadable backup not reported correctly: %s", d)
+	}
+}
+
+func ExampleSuperblock_CompareBackups() {
+	filepath := path.Join(assetsPath, "metabg.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	fmt.Printf("Backups: %v\n", sb.SuperblockBackupGroups())
+
+	discrepancies, err := sb.CompareBackups()
+	log.PanicIf(err)
+
+	fmt.Printf("Discrepancies: (%d)\n", len(discrepancies))
+
+	// Output:
+	// Backups: [1 3 5 7 9]
+	// Discrepancies: (0)
+}
@@ -2,6 +2,7 @@ package ext4
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -10,11 +11,12 @@ import (
 	"testing"
 
 	"encoding/binary"
+	"io/ioutil"
 
 	"github.com/dsoprea/go-logging"
 )
 
-func TestNewSuperblockWithReader(t *testing.T) {
+func TestNewSuperblockWithReaderAt(t *testing.T) {
 	filepath := path.Join(assetsPath, "tiny.ext4")
 
 	f, err := os.Open(filepath)
@@ -22,23 +24,18 @@ func TestNewSuperblockWithReader(t *testing.T) {
 
 	defer f.Close()
 
-	// Skip over the boot-code at the front of the filesystem.
-	_, err = f.Seek(Superblock0Offset, io.SeekStart)
-	log.PanicIf(err)
-
 	originalPosition, err := f.Seek(0, io.SeekCurrent)
 	log.PanicIf(err)
 
-	sb, err := NewSuperblockWithReader(f)
+	sb, err := NewSuperblockWithReaderAt(f)
 	log.PanicIf(err)
 
 	currentPosition, err := f.Seek(0, io.SeekCurrent)
 	log.PanicIf(err)
 
-	actualConsumedBytes := currentPosition - originalPosition
-
-	if actualConsumedBytes != int64(SuperblockSize) {
-		t.Fatalf("Superblock parse did not consume the right amount of data: (%d) != (%d)", actualConsumedBytes, SuperblockSize)
+	// All reads are positional, so the file position must not have moved.
+	if currentPosition != originalPosition {
+		t.Fatalf("Superblock parse moved the file position: (%d) != (%d)", currentPosition, originalPosition)
 	}
 
 	if sb.Data().SInodesCount != 128 {
@@ -60,7 +57,7 @@ func TestNewSuperblockWithReader(t *testing.T) {
 	}
 }
 
-func ExampleNewSuperblockWithReader() {
+func ExampleNewSuperblockWithReaderAt() {
 	filepath := path.Join(assetsPath, "tiny.ext4")
 
 	f, err := os.Open(filepath)
@@ -68,11 +65,7 @@ func ExampleNewSuperblockWithReader() {
 
 	defer f.Close()
 
-	// Skip over the boot-code at the front of the filesystem.
-	_, err = f.Seek(Superblock0Offset, io.SeekStart)
-	log.PanicIf(err)
-
-	sb, err := NewSuperblockWithReader(f)
+	sb, err := NewSuperblockWithReaderAt(f)
 	log.PanicIf(err)
 
 	fmt.Println(sb.VolumeName())
@@ -81,7 +74,7 @@ func ExampleNewSuperblockWithReader() {
 	// tinyimage
 }
 
-func TestSuperblock_ReadPhysicalBlock(t *testing.T) {
+func TestNewSuperblockWithReader(t *testing.T) {
 	filepath := path.Join(assetsPath, "tiny.ext4")
 
 	f, err := os.Open(filepath)
@@ -89,12 +82,49 @@ func TestSuperblock_ReadPhysicalBlock(t *testing.T) {
 
 	defer f.Close()
 
-	_, err = f.Seek(Superblock0Offset, io.SeekStart)
+	// The deprecated constructors still work for callers with an
+	// `io.ReadSeeker`, wherever it's positioned.
+
+	_, err = f.Seek(0, io.SeekEnd)
 	log.PanicIf(err)
 
 	sb, err := NewSuperblockWithReader(f)
 	log.PanicIf(err)
 
+	bgdl, err := NewBlockGroupDescriptorListWithReadSeeker(f, sb)
+	log.PanicIf(err)
+
+	bgd, err := bgdl.GetWithAbsoluteInode(TestFileInodeNumber)
+	log.PanicIf(err)
+
+	inode, err := NewInodeWithReadSeeker(bgd, f, TestFileInodeNumber)
+	log.PanicIf(err)
+
+	if inode.Size() != 849597 {
+		t.Fatalf("Inode not read correctly: (%d)", inode.Size())
+	}
+
+	en := NewExtentNavigatorWithReadSeeker(f, inode)
+
+	data, err := en.Read(0)
+	log.PanicIf(err)
+
+	if len(data) != int(sb.BlockSize()) {
+		t.Fatalf("Data not read correctly: (%d)", len(data))
+	}
+}
+
+func TestSuperblock_ReadPhysicalBlock(t *testing.T) {
+	filepath := path.Join(assetsPath, "tiny.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
 	pBlock := uint64(sb.Data().SFirstDataBlock)
 	data, err := sb.ReadPhysicalBlock(pBlock, uint64(SuperblockSize))
 	log.PanicIf(err)
@@ -128,17 +158,160 @@ func ExampleSuperblock_ReadPhysicalBlock() {
 
 	defer f.Close()
 
-	_, err = f.Seek(Superblock0Offset, io.SeekStart)
-	log.PanicIf(err)
-
-	sb, err := NewSuperblockWithReader(f)
+	sb, err := NewSuperblockWithReaderAt(f)
 	log.PanicIf(err)
 
 	pBlock := uint64(sb.Data().SFirstDataBlock)
 	data, err := sb.ReadPhysicalBlock(pBlock, uint64(SuperblockSize))
 	log.PanicIf(err)
 
-	data = data
+	_ = data
 
 	// Output:
 }
+
+func TestNewSuperblockWithReaderAt_NotExt4(t *testing.T) {
+	r := bytes.NewReader(make([]byte, 4096))
+
+	_, err := NewSuperblockWithReaderAt(r)
+	if errors.Is(err, ErrNotExt4) == false {
+		t.Fatalf("Expected ErrNotExt4: %v", err)
+	}
+}
+
+func TestNewSuperblockWithReaderAt_Truncated(t *testing.T) {
+	r := bytes.NewReader(make([]byte, 1500))
+
+	_, err := NewSuperblockWithReaderAt(r)
+	if errors.Is(err, io.ErrUnexpectedEOF) == false {
+		t.Fatalf("Expected io.ErrUnexpectedEOF: %v", err)
+	}
+}
+
+func TestSuperblock_HasSuperblockBackup(t *testing.T) {
+	filepath := path.Join(assetsPath, "tiny.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	// sparse_super
+	backups := make([]uint64, 0)
+	for group := uint64(0); group < 100; group++ {
+		if sb.HasSuperblockBackup(group) == true {
+			backups = append(backups, group)
+		}
+	}
+
+	if reflect.DeepEqual(backups, []uint64{0, 1, 3, 5, 7, 9, 25, 27, 49, 81}) == false {
+		t.Fatalf("Backup groups not correct: %v", backups)
+	}
+
+	sb.Data().SFeatureCompat |= SbFeatureCompatSparseSuperblockV2
+	sb.Data().SBackupBgs = [2]uint32{1, 40}
+
+	if sb.HasSuperblockBackup(40) != true || sb.HasSuperblockBackup(3) != false {
+		t.Fatalf("sparse_super2 backups not correct.")
+	}
+}
+
+func TestNewSuperblockWithReaderAt_JournalDevice(t *testing.T) {
+	raw := make([]byte, 4096)
+
+	filepath := path.Join(assetsPath, "tiny.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	_, err = f.ReadAt(raw, 0)
+	log.PanicIf(err)
+
+	// A journal device has no inodes. The device number is (7, 300).
+
+	incompat := binary.LittleEndian.Uint32(raw[1024+0x60:])
+	binary.LittleEndian.PutUint32(raw[1024+0x60:], incompat|SbFeatureIncompatJournalDev)
+	binary.LittleEndian.PutUint32(raw[1024+0x28:], 0)
+	binary.LittleEndian.PutUint32(raw[1024+0xe4:], 0x10072c)
+
+	sb, err := NewSuperblockWithReaderAt(bytes.NewReader(raw))
+	log.PanicIf(err)
+
+	major, minor := sb.JournalDevice()
+	if major != 7 || minor != 300 {
+		t.Fatalf("Journal device not correct: (%d, %d)", major, minor)
+	}
+
+	// Nothing past the superblock can be read.
+
+	_, err = NewBlockGroupDescriptorListWithSuperblock(sb)
+
+	var errUnsupported *ErrUnsupportedFeature
+	if errors.As(err, &errUnsupported) == false {
+		t.Fatalf("Expected ErrUnsupportedFeature: %v", err)
+	}
+}
+
+func TestSuperblock_ClusterRatio(t *testing.T) {
+	filepath := path.Join(assetsPath, "bigalloc.ext4")
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	sb, err := NewSuperblockWithReaderAt(f)
+	log.PanicIf(err)
+
+	// 4k clusters of 1k blocks, in three groups of (256) clusters, the last
+	// of which is short.
+
+	if sb.ClusterRatio() != 4 || sb.ClusterSize() != 4096 || sb.ClustersPerGroup() != 256 {
+		t.Fatalf("Cluster geometry not correct: RATIO=(%d) SIZE=(%d) PER-GROUP=(%d)", sb.ClusterRatio(), sb.ClusterSize(), sb.ClustersPerGroup())
+	} else if sb.GroupClusterCount(1) != 256 || sb.GroupClusterCount(2) != 237 {
+		t.Fatalf("Group cluster-counts not correct: (%d) (%d)", sb.GroupClusterCount(1), sb.GroupClusterCount(2))
+	} else if sb.BlockCluster(1023) != 255 || sb.BlockCluster(1024) != 256 {
+		t.Fatalf("Block clusters not correct.")
+	}
+
+	// The superblock counts free space in blocks, not clusters.
+	if sb.FreeBlockCount() != 2884 {
+		t.Fatalf("Free block-count not correct: (%d)", sb.FreeBlockCount())
+	}
+
+	// Without bigalloc, clusters are blocks.
+
+	g, err := os.Open(path.Join(assetsPath, "tiny.ext4"))
+	log.PanicIf(err)
+
+	defer g.Close()
+
+	sb, err = NewSuperblockWithReaderAt(g)
+	log.PanicIf(err)
+
+	if sb.ClusterRatio() != 1 || sb.ClusterSize() != 1024 || sb.ClustersPerGroup() != 8192 || sb.GroupClusterCount(0) != 1023 {
+		t.Fatalf("Cluster geometry without bigalloc not correct.")
+	}
+}
+
+func TestNewSuperblockWithReaderAt_BigallocCorrupt(t *testing.T) {
+	filepath := path.Join(assetsPath, "bigalloc.ext4")
+
+	raw, err := ioutil.ReadFile(filepath)
+	log.PanicIf(err)
+
+	// Clusters-per-group no longer agrees with blocks-per-group.
+	binary.LittleEndian.PutUint32(raw[1024+0x24:], 512)
+
+	_, err = NewSuperblockWithReaderAt(bytes.NewReader(raw))
+
+	var ec *ErrCorrupt
+	if errors.As(err, &ec) == false {
+		t.Fatalf("Expected a corruption error: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package ext4
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrRefusedByPolicy is returned when the current `SupportPolicy` doesn't
+	// allow us to go any further.
+	ErrRefusedByPolicy = errors.New("refused by support policy")
+)
+
+// SupportPolicy governs what we're willing to read beyond the superblock when
+// the filesystem has features that we don't fully support.
+type SupportPolicy int
+
+const (
+	// SupportPolicyStrict refuses to read anything beyond the superblock if
+	// `CheckSupport` reports anything. This is the default.
+	SupportPolicyStrict SupportPolicy = iota
+
+	// SupportPolicyBestEffort reads whatever it can and only fails when we
+	// actually run into a structure that we can't interpret (e.g. an inode
+	// with inline data).
+	SupportPolicyBestEffort
+
+	// SupportPolicySuperblockOnly never reads beyond the superblock.
+	SupportPolicySuperblockOnly
+)
+
+var (
+	SupportPolicyNames = map[SupportPolicy]string{
+		SupportPolicyStrict:         "strict",
+		SupportPolicyBestEffort:     "best-effort",
+		SupportPolicySuperblockOnly: "superblock-only",
+	}
+)
+
+func (sp SupportPolicy) String() string {
+	name, found := SupportPolicyNames[sp]
+	if found == false {
+		return fmt.Sprintf("SupportPolicy(%d)", int(sp))
+	}
+
+	return name
+}
+
+// SetSupportPolicy sets the policy that the other constructors will honor.
+// This should be set before building anything else on this superblock.
+func (sb *Superblock) SetSupportPolicy(policy SupportPolicy) {
+	sb.policy = policy
+}
+
+func (sb *Superblock) SupportPolicy() SupportPolicy {
+	return sb.policy
+}
+
+// CheckSupport returns the names of all of the features used by this
+// filesystem that we can't fully read, or an empty list if there aren't any.
+func (sb *Superblock) CheckSupport() (unsupported []string) {
+	unsupported = make([]string, 0)
+
+	if sb.HasIncompatibleFeature(SbFeatureIncompatFlexBg) == false {
+		unsupported = append(unsupported, "no flex_bg")
+	}
+
+	if sb.HasIncompatibleFeature(SbFeatureIncompatCompression) == true {
+		unsupported = append(unsupported, "compression")
+	}
+
+	if sb.HasIncompatibleFeature(SbFeatureIncompatFiletype) == false {
+		unsupported = append(unsupported, "no filetype")
+	}
+
+	if sb.HasIncompatibleFeature(SbFeatureIncompatExtents) == false {
+		unsupported = append(unsupported, "no extents")
+	}
+
+	// dir-data is obscure.
+	if sb.HasIncompatibleFeature(SbFeatureIncompatDirData) == true {
+		unsupported = append(unsupported, "dirdata")
+	}
+
+	if sb.HasIncompatibleFeature(SbFeatureIncompatJournalDev) == true {
+		unsupported = append(unsupported, "journal_dev")
+	}
+
+	if sb.HasIncompatibleFeature(SbFeatureIncompatLargeDir) == true {
+		unsupported = append(unsupported, "large_dir")
+	}
+
+	// Data may be stored directly in the inode for small files.
+	if sb.HasIncompatibleFeature(SbFeatureIncompatInlineData) == true {
+		unsupported = append(unsupported, "inline_data")
+	}
+
+	if sb.HasIncompatibleFeature(SbFeatureIncompatEncrypt) == true {
+		unsupported = append(unsupported, "encrypt")
+	}
+
+	// SbFeatureIncompatRecover: Ignoring because it presumably doesn't matter
+	// when not writing.
+
+	// SbFeatureIncompatMmp: Ignoring because we're not involved in mounting
+	// (and not writing, besides).
+
+	// SbFeatureIncompatLargeExtendedAttributeValues: Ignoring because we don't
+	// currently read xattr's.
+
+	// SbFeatureIncompatCsumSeed: Ignoring because we're not concerned with
+	// mount semantics.
+
+	return unsupported
+}
+
+// checkBeyondSuperblock returns an error if the policy doesn't allow us to
+// read anything other than the superblock.
+func (sb *Superblock) checkBeyondSuperblock() error {
+	switch sb.policy {
+	case SupportPolicySuperblockOnly:
+		return fmt.Errorf("policy is [%s]: %w", sb.policy, ErrRefusedByPolicy)
+	case SupportPolicyStrict:
+		unsupported := sb.CheckSupport()
+		if len(unsupported) > 0 {
+			return &ErrUnsupportedFeature{Feature: strings.Join(unsupported, ", ")}
+		}
+	}
+
+	// A journal device doesn't have any group-descriptors to read, even if
+	// we're just trying our best.
+
+	if sb.HasIncompatibleFeature(SbFeatureIncompatJournalDev) == true {
+		// This is a journal device, not a filesystem.
+		return &ErrUnsupportedFeature{Feature: "journal_dev"}
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package ext4
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"reflect"
+	"testing"
+
+	"encoding/binary"
+	"io/ioutil"
+
+	"github.com/dsoprea/go-logging"
+)
+
+// getTinyWithIncompatFeatures returns the tiny test image with the given
+// incompat flags turned on.
+func getTinyWithIncompatFeatures(mask uint32) *bytes.Reader {
+	filepath := path.Join(assetsPath, "tiny.ext4")
+
+	raw, err := ioutil.ReadFile(filepath)
+	log.PanicIf(err)
+
+	// SFeatureIncompat is at 0x60 in the superblock.
+	offset := Superblock0Offset + 0x60
+	value := binary.LittleEndian.Uint32(raw[offset:])
+	binary.LittleEndian.PutUint32(raw[offset:], value|mask)
+
+	return bytes.NewReader(raw)
+}
+
+func TestSuperblock_CheckSupport(t *testing.T) {
+	r := getTinyWithIncompatFeatures(SbFeatureIncompatCompression | SbFeatureIncompatEncrypt)
+
+	sb, err := NewSuperblockWithReaderAt(r)
+	log.PanicIf(err)
+
+	unsupported := sb.CheckSupport()
+
+	if reflect.DeepEqual(unsupported, []string{"compression", "encrypt"}) == false {
+		t.Fatalf("Unsupported features not correct: %v", unsupported)
+	}
+
+	_, err = NewBlockGroupDescriptorListWithSuperblock(sb)
+
+	var euf *ErrUnsupportedFeature
+	if errors.As(err, &euf) == false {
+		t.Fatalf("Expected ErrUnsupportedFeature: %v", err)
+	} else if euf.Feature != "compression, encrypt" {
+		t.Fatalf("Feature not correct: [%s]", euf.Feature)
+	}
+}
+
+func TestSuperblock_CheckSupport_Supported(t *testing.T) {
+	r := getTinyWithIncompatFeatures(0)
+
+	sb, err := NewSuperblockWithReaderAt(r)
+	log.PanicIf(err)
+
+	unsupported := sb.CheckSupport()
+	if len(unsupported) != 0 {
+		t.Fatalf("No features should be unsupported: %v", unsupported)
+	}
+}
+
+func TestSuperblock_SetSupportPolicy_BestEffort(t *testing.T) {
+	r := getTinyWithIncompatFeatures(SbFeatureIncompatInlineData)
+
+	sb, err := NewSuperblockWithReaderAt(r)
+	log.PanicIf(err)
+
+	// Strict (the default) refuses.
+
+	_, err = NewBlockGroupDescriptorListWithSuperblock(sb)
+
+	var euf *ErrUnsupportedFeature
+	if errors.As(err, &euf) == false {
+		t.Fatalf("Expected ErrUnsupportedFeature: %v", err)
+	}
+
+	// Best-effort lets us at everything that doesn't actually use inline data.
+
+	sb.SetSupportPolicy(SupportPolicyBestEffort)
+
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bgd, err := bgdl.GetWithAbsoluteInode(TestFileInodeNumber)
+	log.PanicIf(err)
+
+	inode, err := NewInodeWithBlockGroupDescriptor(bgd, TestFileInodeNumber)
+	log.PanicIf(err)
+
+	if inode.Size() == 0 {
+		t.Fatalf("Inode not read correctly.")
+	}
+}
+
+func TestSuperblock_CheckSupport_MetaBg(t *testing.T) {
+	r := getTinyWithIncompatFeatures(SbFeatureIncompatMetaBg)
+
+	sb, err := NewSuperblockWithReaderAt(r)
+	log.PanicIf(err)
+
+	// The descriptors of the one meta-group are right after the superblock,
+	// as they'd be without meta_bg.
+
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
+	log.PanicIf(err)
+
+	bgd, err := bgdl.GetWithAbsoluteInode(TestFileInodeNumber)
+	log.PanicIf(err)
+
+	inode, err := NewInodeWithBlockGroupDescriptor(bgd, TestFileInodeNumber)
+	log.PanicIf(err)
+
+	if inode.Size() == 0 {
+		t.Fatalf("Inode not read correctly.")
+	}
+}
+
+func TestSuperblock_SetSupportPolicy_SuperblockOnly(t *testing.T) {
+	r := getTinyWithIncompatFeatures(0)
+
+	sb, err := NewSuperblockWithReaderAt(r)
+	log.PanicIf(err)
+
+	sb.SetSupportPolicy(SupportPolicySuperblockOnly)
+
+	_, err = NewBlockGroupDescriptorListWithSuperblock(sb)
+	if errors.Is(err, ErrRefusedByPolicy) == false {
+		t.Fatalf("Expected ErrRefusedByPolicy: %v", err)
+	}
+}
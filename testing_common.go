@@ -1,11 +1,8 @@
 package ext4
 
 import (
-	"io"
 	"os"
 	"path"
-
-	"github.com/dsoprea/go-logging"
 )
 
 const (
@@ -20,59 +17,44 @@ var (
 // GetTestInode returns a test inode struct and `os.File` for the file. It's
 // the responsibility of the caller to close it.
 func GetTestInode(inodeNumber int) (f *os.File, inode *Inode, err error) {
-	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
-		}
-	}()
-
 	filepath := path.Join(assetsPath, "tiny.ext4")
 
-	f, err = os.Open(filepath)
-	log.PanicIf(err)
-
-	_, err = f.Seek(Superblock0Offset, io.SeekStart)
-	log.PanicIf(err)
-
-	sb, err := NewSuperblockWithReader(f)
-	log.PanicIf(err)
-
-	bgdl, err := NewBlockGroupDescriptorListWithReadSeeker(f, sb)
-	log.PanicIf(err)
-
-	bgd, err := bgdl.GetWithAbsoluteInode(inodeNumber)
-	log.PanicIf(err)
-
-	inode, err = NewInodeWithReadSeeker(bgd, f, inodeNumber)
-	log.PanicIf(err)
-
-	return f, inode, nil
+	return GetInode(filepath, inodeNumber)
 }
 
 func GetInode(filesystemPath string, inodeNumber int) (f *os.File, inode *Inode, err error) {
+	f, err = os.Open(filesystemPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Make sure that we don't leak the file if we fail.
 	defer func() {
-		if state := recover(); state != nil {
-			err = log.Wrap(state.(error))
+		if err != nil {
+			f.Close()
+			f = nil
 		}
 	}()
 
-	f, err = os.Open(filesystemPath)
-	log.PanicIf(err)
-
-	_, err = f.Seek(Superblock0Offset, io.SeekStart)
-	log.PanicIf(err)
+	sb, err := NewSuperblockWithReaderAt(f)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	sb, err := NewSuperblockWithReader(f)
-	log.PanicIf(err)
-
-	bgdl, err := NewBlockGroupDescriptorListWithReadSeeker(f, sb)
-	log.PanicIf(err)
+	bgdl, err := NewBlockGroupDescriptorListWithSuperblock(sb)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	bgd, err := bgdl.GetWithAbsoluteInode(inodeNumber)
-	log.PanicIf(err)
-
-	inode, err = NewInodeWithReadSeeker(bgd, f, inodeNumber)
-	log.PanicIf(err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inode, err = NewInodeWithBlockGroupDescriptor(bgd, inodeNumber)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	return f, inode, nil
 }
@@ -0,0 +1,50 @@
+package ext4
+
+import (
+	"io"
+	"sync"
+)
+
+// ReadFullAt fills `buffer` from `ra` at the given offset. Unlike a bare
+// `ReadAt`, a short read is always reported as an error (`io.ErrUnexpectedEOF`
+// if the reader didn't otherwise complain).
+func ReadFullAt(ra io.ReaderAt, buffer []byte, offset int64) (err error) {
+	n, err := ra.ReadAt(buffer, offset)
+
+	// `ReadAt` is allowed to return `io.EOF` alongside a complete read when the
+	// data ends right at the end of the source.
+	if n == len(buffer) {
+		return nil
+	}
+
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// readSeekerAt adapts an `io.ReadSeeker` to `io.ReaderAt` for the deprecated
+// constructors. Reads are serialized, since they share the seek-position.
+type readSeekerAt struct {
+	rs    io.ReadSeeker
+	mutex sync.Mutex
+}
+
+func newReadSeekerAt(rs io.ReadSeeker) *readSeekerAt {
+	return &readSeekerAt{
+		rs: rs,
+	}
+}
+
+func (rsa *readSeekerAt) ReadAt(buffer []byte, offset int64) (n int, err error) {
+	rsa.mutex.Lock()
+	defer rsa.mutex.Unlock()
+
+	_, err = rsa.rs.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	return io.ReadFull(rsa.rs, buffer)
+}
@@ -0,0 +1,31 @@
+package ext4
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadFullAt(t *testing.T) {
+	r := bytes.NewReader([]byte{1, 2, 3, 4})
+
+	buffer := make([]byte, 2)
+
+	err := ReadFullAt(r, buffer, 2)
+	if err != nil {
+		t.Fatalf("Full read failed: %s", err)
+	} else if bytes.Equal(buffer, []byte{3, 4}) == false {
+		t.Fatalf("Read data not correct: %v", buffer)
+	}
+}
+
+func TestReadFullAt_Short(t *testing.T) {
+	r := bytes.NewReader([]byte{1, 2, 3, 4})
+
+	buffer := make([]byte, 3)
+
+	err := ReadFullAt(r, buffer, 2)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("Short read not reported: %v", err)
+	}
+}